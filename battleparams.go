@@ -0,0 +1,196 @@
+/*
+* Archon PSO Server
+* Copyright (C) 2014 Andrew Rodman
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+* ---------------------------------------------------------------------
+* Parser for the BattleParamEntry files, which hold the stats, attacks,
+* resistances, and movement of every enemy for each difficulty. There's a
+* file for each episode, with the _on files used in multiplayer games and
+* the others in single player mode. Each file is four tables (one for each
+* kind of data), each with an entry per enemy per difficulty; the index of
+* an enemy's entry is given by its EnemyType.
+ */
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+)
+
+type Episode uint8
+
+const (
+	Episode1 Episode = 1
+	Episode2 Episode = 2
+	Episode4 Episode = 3
+)
+
+const (
+	NumDifficulties = 4
+	// Number of entries for each difficulty in the tables.
+	NumBattleParams = 0x60
+)
+
+// Stats of an enemy on one difficulty.
+type EnemyStats struct {
+	ATP        uint16
+	MST        uint16
+	EVP        uint16
+	HP         uint16
+	DFP        uint16
+	ATA        uint16
+	LCK        uint16
+	ESP        uint16
+	Unknown    [3]uint32
+	Experience uint32
+	Difficulty uint32
+}
+
+// One of an enemy's attacks.
+type EnemyAttackData struct {
+	Unknown   int16
+	ATP       int16
+	ATABonus  int16
+	Unknown2  uint16
+	DistanceX float32
+	AngleX    uint32
+	DistanceY float32
+	Unknown3  [4]uint16
+	Unknown4  [5]uint32
+}
+
+// An enemy's resistances to each of the elements.
+type EnemyResistData struct {
+	EVPBonus int16
+	EFR      uint16
+	EIC      uint16
+	ETH      uint16
+	ELT      uint16
+	EDK      uint16
+	Unknown  [4]uint32
+	DFPBonus int32
+}
+
+type EnemyMovementData struct {
+	IdleMoveSpeed      float32
+	IdleAnimationSpeed float32
+	MoveSpeed          float32
+	AnimationSpeed     float32
+	Unknown            [4]float32
+	Unknown2           [4]uint32
+}
+
+type BattleParamTable struct {
+	Stats    [NumDifficulties][NumBattleParams]EnemyStats
+	Attacks  [NumDifficulties][NumBattleParams]EnemyAttackData
+	Resists  [NumDifficulties][NumBattleParams]EnemyResistData
+	Movement [NumDifficulties][NumBattleParams]EnemyMovementData
+}
+
+// Everything in the battle parameters for an enemy on one difficulty.
+type EnemyParams struct {
+	Stats    *EnemyStats
+	Attack   *EnemyAttackData
+	Resist   *EnemyResistData
+	Movement *EnemyMovementData
+}
+
+type battleParamKey struct {
+	episode Episode
+	online  bool
+}
+
+// Tables loaded from the parameters directory at startup.
+var battleParams map[battleParamKey]*BattleParamTable
+
+// Name of the file with the battle parameters for episode.
+func battleParamFile(episode Episode, online bool) string {
+	name := "BattleParamEntry"
+	switch episode {
+	case Episode2:
+		name += "_lab"
+	case Episode4:
+		name += "_ep4"
+	}
+	if online {
+		name += "_on"
+	}
+	return name + ".dat"
+}
+
+func LoadBattleParamTable(path string) (*BattleParamTable, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseBattleParamTable(data)
+}
+
+func parseBattleParamTable(data []byte) (*BattleParamTable, error) {
+	t := new(BattleParamTable)
+	if binary.Size(t) > len(data) {
+		return nil, fmt.Errorf("battle parameters too short (%d bytes)", len(data))
+	}
+	if err := binary.Read(bytes.NewReader(data), binary.LittleEndian, t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Load the battle parameters for every episode from dir.
+func loadBattleParams(dir string) error {
+	params := make(map[battleParamKey]*BattleParamTable)
+	for _, episode := range []Episode{Episode1, Episode2, Episode4} {
+		for _, online := range []bool{false, true} {
+			filename := battleParamFile(episode, online)
+			t, err := LoadBattleParamTable(dir + "/" + filename)
+			if err != nil {
+				return fmt.Errorf("%s: %s", filename, err)
+			}
+			params[battleParamKey{episode, online}] = t
+		}
+	}
+	battleParams = params
+	return nil
+}
+
+// Returns the battle parameters used for games in episode.
+func battleParamTable(episode Episode, online bool) (*BattleParamTable, error) {
+	t := battleParams[battleParamKey{episode, online}]
+	if t == nil {
+		return nil, fmt.Errorf("no battle parameters for episode %d", episode)
+	}
+	return t, nil
+}
+
+// Returns the parameters for enemy on difficulty.
+func lookupEnemy(episode Episode, online bool, difficulty uint8, enemy EnemyType) (EnemyParams, error) {
+	t, err := battleParamTable(episode, online)
+	if err != nil {
+		return EnemyParams{}, err
+	}
+	i := enemy.BPIndex
+	if difficulty >= NumDifficulties || i >= NumBattleParams {
+		return EnemyParams{}, fmt.Errorf("no battle parameters for %s on difficulty %d", enemy.Name, difficulty)
+	}
+	return EnemyParams{
+		Stats:    &t.Stats[difficulty][i],
+		Attack:   &t.Attacks[difficulty][i],
+		Resist:   &t.Resists[difficulty][i],
+		Movement: &t.Movement[difficulty][i],
+	}, nil
+}
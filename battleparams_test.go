@@ -0,0 +1,198 @@
+/*
+* Archon PSO Server
+* Copyright (C) 2014 Andrew Rodman
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLookupEnemy(t *testing.T) {
+	tests := []struct {
+		episode    Episode
+		enemy      EnemyType
+		hp, exp    uint32
+		difficulty uint8
+	}{
+		{Episode1, Booma, 92, 5, 0},
+		{Episode1, Hildeblue, 399, 100, 0},
+		{Episode1, DarkFalz, 6500, 3000, 0},
+		{Episode2, OlgaFlow, 8500, 3300, 0},
+		{Episode4, Girtablulu, 4638, 74, 0},
+	}
+	for _, test := range tests {
+		params, err := lookupEnemy(test.episode, true, test.difficulty, test.enemy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if uint32(params.Stats.HP) != test.hp || params.Stats.Experience != test.exp {
+			t.Errorf("%s has %d HP and %d experience, want %d and %d", test.enemy.Name,
+				params.Stats.HP, params.Stats.Experience, test.hp, test.exp)
+		}
+	}
+	// Enemies get tougher on the harder difficulties.
+	normal, _ := lookupEnemy(Episode1, true, 0, Booma)
+	ultimate, _ := lookupEnemy(Episode1, true, 3, Booma)
+	if ultimate.Stats.HP <= normal.Stats.HP || ultimate.Resist.EFR <= normal.Resist.EFR {
+		t.Error("expected Ultimate Booma to be stronger than Normal")
+	}
+	if _, err := lookupEnemy(Episode1, true, NumDifficulties, Booma); err == nil {
+		t.Error("expected invalid difficulty to be rejected")
+	}
+}
+
+func TestExpandMapEnemies(t *testing.T) {
+	enemies, err := expandMapEnemies(Episode1, []MapEnemy{
+		{Type: 0x44, Skin: 2},
+		{Type: 0x42},
+		{Type: 0x43, Skin: 0x800000},
+		{Type: 0x40, Rare: true},
+		{Type: 0x84},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(enemies) != 1+31+1+1+9 {
+		t.Fatalf("got %d enemies, want %d", len(enemies), 43)
+	}
+	want := map[int]EnemyType{0: Gigobooma, 1: Monest, 2: Mothmant, 31: Mothmant,
+		32: BarbarousWolf, 33: Hildeblue, 34: Canane, 35: CanadineGroup}
+	for i, enemy := range want {
+		if enemies[i] != enemy {
+			t.Errorf("enemy %d is %s, want %s", i, enemies[i].Name, enemy.Name)
+		}
+	}
+
+	enemies, err = expandMapEnemies(Episode2, []MapEnemy{{Type: 0xDF, Children: 3}})
+	if err != nil || len(enemies) != 4 || enemies[3] != Recon {
+		t.Errorf("expected a Recobox with 3 Recons, got %v, %v", enemies, err)
+	}
+	if _, err := expandMapEnemies(Episode1, []MapEnemy{{Type: 0xDF}}); err == nil {
+		t.Error("expected an Episode 2 enemy in Episode 1 to be rejected")
+	}
+}
+
+func TestRollRareEnemies(t *testing.T) {
+	placed := []MapEnemy{{Type: 0x43}, {Type: 0x40}, {Type: 0x42}, {Type: 0x41}}
+	var odds []int
+	rolled := rollRareEnemies(Episode1, placed, func(n int) bool {
+		odds = append(odds, n)
+		return true
+	})
+	if placed[1].Rare {
+		t.Error("expected the placed enemies to be left alone")
+	}
+	if len(odds) != 2 || odds[0] != rareEnemyOdds {
+		t.Errorf("rolled with odds %v, want one in %d for each enemy with a rare variant", odds, rareEnemyOdds)
+	}
+	enemies, err := expandMapEnemies(Episode1, rolled)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if enemies[0] != SavageWolf || enemies[1] != Hildeblue || enemies[33] != AlRappy {
+		t.Errorf("got %s, %s, and %s, want Savage Wolf, Hildeblue, and Al Rappy",
+			enemies[0].Name, enemies[1].Name, enemies[33].Name)
+	}
+
+	// Only as many as the client can be told about are rare.
+	many := make([]MapEnemy, 2*maxRareEnemies)
+	for i := range many {
+		many[i].Type = 0x40
+	}
+	rares := 0
+	for _, e := range rollRareEnemies(Episode1, many, func(int) bool { return true }) {
+		if e.Rare {
+			rares++
+		}
+	}
+	if rares != maxRareEnemies {
+		t.Errorf("got %d rare enemies, want %d", rares, maxRareEnemies)
+	}
+
+	rollRareEnemies(Episode4, []MapEnemy{{Type: 0x119}}, func(n int) bool {
+		if n != 10 {
+			t.Errorf("Kondrieu rolled with one in %d odds, want one in 10", n)
+		}
+		return false
+	})
+}
+
+func TestRareEnemyList(t *testing.T) {
+	c, conn := newPipeClient(1)
+	defer c.Close()
+	packets := readPackets(c, conn)
+
+	g, err := NewGame(Episode1, 0, false, []MapEnemy{{Type: 0x42}, {Type: 0x40, Rare: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.enemies[31].enemy != Hildeblue {
+		t.Errorf("enemy 31 is %s, want Hildeblue", g.enemies[31].enemy.Name)
+	}
+
+	c.SendRareEnemyList(g)
+	var pkt RareEnemyListPacket
+	nextPacket(t, packets, &pkt)
+	if pkt.Header.Type != RareEnemyListType || pkt.Enemies[0] != 31 || pkt.Enemies[1] != 0xFFFF {
+		t.Errorf("got rare enemies %v, want just 31", pkt.Enemies)
+	}
+}
+
+// Map file entry for an enemy.
+func mapEntry(enemyType, children uint16, skin uint32) []byte {
+	entry := make([]byte, mapEnemySize)
+	binary.LittleEndian.PutUint16(entry, enemyType)
+	binary.LittleEndian.PutUint16(entry[mapEnemyChildren:], children)
+	binary.LittleEndian.PutUint32(entry[mapEnemySkin:], skin)
+	return entry
+}
+
+func TestLoadGameMaps(t *testing.T) {
+	dir := t.TempDir()
+	os.Mkdir(filepath.Join(dir, "ep1"), 0755)
+	// The areas are read in the order of their names.
+	ioutil.WriteFile(filepath.Join(dir, "ep1", "02_cave.dat"), mapEntry(0x84, 0, 0), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "ep1", "01_forest.dat"),
+		append(mapEntry(0x44, 0, 2), mapEntry(0x43, 0, 0)...), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "ep1", "notes.txt"), []byte("not a map"), 0644)
+
+	maps, err := loadGameMaps(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []MapEnemy{{Type: 0x44, Skin: 2}, {Type: 0x43}, {Type: 0x84}}
+	if len(maps[Episode1]) != len(want) {
+		t.Fatalf("got enemies %+v, want %+v", maps[Episode1], want)
+	}
+	for i := range want {
+		if maps[Episode1][i] != want[i] {
+			t.Errorf("enemy %d is %+v, want %+v", i, maps[Episode1][i], want[i])
+		}
+	}
+	if maps[Episode2] != nil {
+		t.Errorf("got Episode 2 enemies %+v without any maps", maps[Episode2])
+	}
+
+	ioutil.WriteFile(filepath.Join(dir, "ep1", "03_broken.dat"), []byte{1, 2, 3}, 0644)
+	if _, err := loadGameMaps(dir); err == nil {
+		t.Error("expected a truncated map to be rejected")
+	}
+}
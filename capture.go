@@ -0,0 +1,240 @@
+/*
+* Archon PSO Server
+* Copyright (C) 2014 Andrew Rodman
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+* ---------------------------------------------------------------------
+* Packet capture for reverse engineering and reproducing bug reports.
+* Each captured connection gets its own file of JSON records, one per
+* line, starting with an "open" record describing the connection and
+* followed by the decrypted packets in the order they were sent and
+* received, starting with the unencrypted welcome packet. tools/replay.go
+* can play the client side back at a server.
+ */
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Values for CaptureRecord.Dir.
+const (
+	captureOpen = "open"
+	captureIn   = "in"
+	captureOut  = "out"
+)
+
+// One line in a capture file. Packets sent by the client are "in" and those
+// sent by the server are "out". Data is the hex encoded packet, header included.
+type CaptureRecord struct {
+	Time       time.Time `json:"time"`
+	Dir        string    `json:"dir"`
+	Server     string    `json:"server,omitempty"`
+	Port       string    `json:"port,omitempty"`
+	Client     string    `json:"client,omitempty"`
+	Account    string    `json:"account,omitempty"`
+	HeaderSize uint16    `json:"header_size,omitempty"`
+	Version    string    `json:"version,omitempty"`
+	Type       uint16    `json:"type,omitempty"`
+	Size       int       `json:"size,omitempty"`
+	// Set for the packets sent before encryption starts.
+	Unencrypted bool   `json:"unencrypted,omitempty"`
+	Data        string `json:"data,omitempty"`
+}
+
+// Most packets kept from before capturing starts, which is enough for the
+// welcome packet and anything sent along with it.
+const maxEarlyCaptureRecords = 4
+
+// Open capture file for a client.
+type packetCapture struct {
+	file *os.File
+	enc  *json.Encoder
+	sync.Mutex
+}
+
+func (pc *packetCapture) write(rec *CaptureRecord) {
+	pc.Lock()
+	if err := pc.enc.Encode(rec); err != nil {
+		log.Warnf("Failed to write packet capture %s: %s", pc.file.Name(), err)
+	}
+	pc.Unlock()
+}
+
+func (pc *packetCapture) close() {
+	pc.Lock()
+	pc.file.Close()
+	pc.Unlock()
+}
+
+// Returns true if value is one of the entries in list.
+func captureListContains(list []string, value string) bool {
+	for _, entry := range list {
+		if strings.EqualFold(entry, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// Start capturing the client's packets if they're connecting from one of
+// the configured addresses.
+func (c *Client) captureAddress() {
+	if captureListContains(config.CaptureAddresses, c.ipAddr) {
+		c.startCapture("")
+	}
+}
+
+// Start capturing the client's packets if they've logged into one of the
+// configured accounts. The login packet has already been received at this
+// point so it's recorded as the first packet.
+func (c *Client) captureAccount(username string) {
+	if c.capture == nil && captureListContains(config.CaptureAccounts, username) {
+		c.startCapture(username)
+		c.capturePacket(captureIn, c.Data(), false)
+	}
+}
+
+func (c *Client) startCapture(account string) {
+	if config.CaptureDir == "" || c.capture != nil {
+		return
+	}
+	var serverName, port string
+	if c.server != nil {
+		serverName, port = c.server.Name(), c.server.Port()
+	}
+	now := time.Now()
+	filename := fmt.Sprintf("%s_%s_%s_%s.jsonl", now.Format("20060102-150405.000"),
+		serverName, strings.Replace(c.ipAddr, ":", "-", -1), c.port)
+	if err := os.MkdirAll(config.CaptureDir, 0755); err != nil {
+		log.Warnf("Failed to create capture directory: %s", err)
+		return
+	}
+	file, err := os.Create(filepath.Join(config.CaptureDir, filename))
+	if err != nil {
+		log.Warnf("Failed to start packet capture for %s: %s", c.ipAddr, err)
+		return
+	}
+	capture := &packetCapture{file: file, enc: json.NewEncoder(file)}
+	open := &CaptureRecord{
+		Time:       now,
+		Dir:        captureOpen,
+		Server:     serverName,
+		Port:       port,
+		Client:     c.ipAddr + ":" + c.port,
+		Account:    account,
+		HeaderSize: c.hdrSize,
+	}
+	// Only set for the classic versions so that Blue Burst captures are unchanged.
+	if c.version != VersionBB {
+		open.Version = c.version.String()
+	}
+	capture.write(open)
+	c.captureLock.Lock()
+	for _, rec := range c.earlyCapture {
+		capture.write(rec)
+	}
+	c.earlyCapture = nil
+	c.capture = capture
+	c.captureLock.Unlock()
+	log.Infof("Capturing packets for %s to %s", c.ipAddr, file.Name())
+}
+
+// Record a packet before it's encrypted or after it's been decrypted if the
+// client is being captured. Capturing can't start until after the client's
+// been sent the welcome packet, so the first few packets sent to each client
+// are kept in case it does.
+func (c *Client) capturePacket(dir string, data []byte, unencrypted bool) {
+	if len(data) < int(c.hdrSize) || config.CaptureDir == "" {
+		return
+	}
+	rec := &CaptureRecord{
+		Time:        time.Now(),
+		Dir:         dir,
+		Type:        c.packetType(data),
+		Size:        len(data),
+		Unencrypted: unencrypted,
+	}
+	if dir == captureIn {
+		data = redactCredentials(c.version, rec.Type, c.hdrSize, data)
+	}
+	rec.Data = hex.EncodeToString(data)
+	c.captureLock.Lock()
+	capture := c.capture
+	if capture == nil && dir == captureOut && len(c.earlyCapture) < maxEarlyCaptureRecords {
+		c.earlyCapture = append(c.earlyCapture, rec)
+	}
+	c.captureLock.Unlock()
+	if capture != nil {
+		capture.write(rec)
+	}
+}
+
+func (c *Client) stopCapture() {
+	c.captureLock.Lock()
+	capture := c.capture
+	c.capture = nil
+	c.captureLock.Unlock()
+	if capture != nil {
+		capture.close()
+	}
+}
+
+// Location of a credential within a packet.
+type credentialField struct {
+	offset, length int
+}
+
+var (
+	// Password fields in the patch and BB login packets.
+	patchLoginCredentials = []credentialField{{0x20, 16}}
+	loginCredentials      = []credentialField{{0x4C, 16}}
+	// Access keys (and the password, for the Gamecube) in the classic license
+	// check and login packets.
+	classicVerifyCredentials = []credentialField{{0x34, 0x10}, {0x54, 0x10}, {0xA0, 0x30}, {0xD0, 0x30}}
+	classicLoginCredentials  = []credentialField{{0x2C, 0x10}, {0x6C, 0x30}}
+)
+
+// Returns a copy of a login packet with the credentials zeroed out so that
+// captures can be shared. Other packets are returned as is.
+func redactCredentials(version ClientVersion, pktType, hdrSize uint16, data []byte) []byte {
+	var fields []credentialField
+	switch {
+	case version != VersionBB && (pktType == ClassicVerifyType || pktType == GCVerifyType):
+		fields = classicVerifyCredentials
+	case version != VersionBB && (pktType == ClassicLoginType || pktType == GCLoginType):
+		fields = classicLoginCredentials
+	case version == VersionBB && hdrSize == PCHeaderSize && pktType == PatchLoginType:
+		fields = patchLoginCredentials
+	case version == VersionBB && hdrSize == BBHeaderSize && pktType == LoginType:
+		fields = loginCredentials
+	}
+	if fields == nil {
+		return data
+	}
+	redacted := append([]byte(nil), data...)
+	for _, f := range fields {
+		for i := f.offset; i < f.offset+f.length && i < len(redacted); i++ {
+			redacted[i] = 0
+		}
+	}
+	return redacted
+}
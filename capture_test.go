@@ -0,0 +1,70 @@
+/*
+* Archon PSO Server
+* Copyright (C) 2014 Andrew Rodman
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// The welcome packet is sent before the dispatcher decides whether to capture
+// a connection, but it should still be the first packet in the capture.
+func TestCaptureIncludesWelcome(t *testing.T) {
+	defer func(dir string) { config.CaptureDir = dir }(config.CaptureDir)
+	config.CaptureDir = t.TempDir()
+
+	c, client := newPipeClient(16)
+	go io.Copy(ioutil.Discard, client)
+	c.SendWelcome()
+	c.startCapture("")
+	c.sendPCHeader(PingType)
+	c.Close()
+
+	files, _ := filepath.Glob(filepath.Join(config.CaptureDir, "*.jsonl"))
+	if len(files) != 1 {
+		t.Fatalf("expected one capture file, got %v", files)
+	}
+	f, err := os.Open(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var records []CaptureRecord
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var rec CaptureRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, rec)
+	}
+	if len(records) != 3 || records[0].Dir != captureOpen {
+		t.Fatalf("unexpected capture %+v", records)
+	}
+	if welcome := records[1]; welcome.Type != LoginWelcomeType || !welcome.Unencrypted {
+		t.Errorf("expected the unencrypted welcome packet first, got %+v", welcome)
+	}
+	if records[2].Unencrypted {
+		t.Error("packet sent after the handshake marked unencrypted")
+	}
+}
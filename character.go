@@ -37,6 +37,9 @@ const (
 	Ramarl              = 0x0B
 )
 
+// Section ids run from Viridia (0) to Whitill (9).
+const NumSectionIds = 10
+
 // Per-player friend guildcard entries.
 type GuildcardEntry struct {
 	Guildcard   uint32
@@ -47,7 +50,7 @@ type GuildcardEntry struct {
 	Language    uint8
 	SectionID   uint8
 	CharClass   uint8
-	padding     uint32
+	Padding     uint32
 	Comment     [88]uint16
 }
 
@@ -212,3 +215,12 @@ var baseSymbolChats = [1248]byte{
 	0xff, 0x00, 0x00, 0x00, 0xff, 0x00, 0x00, 0x00, 0xff, 0x00, 0x00, 0x00, 0xff, 0x00, 0x00, 0x00,
 	0xff, 0x00, 0x00, 0x00, 0xff, 0x00, 0x00, 0x00, 0xff, 0x00, 0x00, 0x00, 0xff, 0x00, 0x00, 0x00,
 }
+
+// Item as it's kept in inventories and sent to the client. The first three
+// bytes of Data identify the kind of item (its class, group, and index
+// within the group) and the rest depend on the class.
+type ItemData struct {
+	Data  [12]uint8
+	Id    uint32
+	Data2 [4]uint8
+}
@@ -0,0 +1,179 @@
+/*
+* Archon PSO Server
+* Copyright (C) 2014 Andrew Rodman
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+* ---------------------------------------------------------------------
+* Character deletion and restoration. Players delete characters from the
+* character select screen, or by creating a new character over one. Deleted
+* characters are kept in the characters table with deleted_at set to the
+* time they were deleted for CharacterRetentionDays, during which an admin
+* can restore them with --restore-character as long as the slot hasn't
+* been reused.
+ */
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Anything that can run queries, so that these work inside of transactions.
+type queryer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// Returns the oldest deletion time (as a unix timestamp) of the characters
+// that can still be restored.
+func characterRetentionCutoff(now time.Time) int64 {
+	return now.AddDate(0, 0, -config.CharacterRetentionDays).Unix()
+}
+
+// Soft delete the character in slot, if there is one. Returns true if a
+// character was deleted.
+func deleteCharacter(db queryer, guildcard, slot uint32) (bool, error) {
+	if slot >= MaxCharacterSlots {
+		return false, fmt.Errorf("Invalid character slot %d", slot)
+	}
+	// Each character deleted from a slot needs its own deletion time so that
+	// restoring one brings back exactly one of them, so a slot replaced more
+	// than once a second is given the next second.
+	var last int64
+	err := db.QueryRow("SELECT COALESCE(MAX(deleted_at), 0) FROM characters WHERE "+
+		"guildcard = ? AND slot_num = ?", guildcard, slot).Scan(&last)
+	if err != nil {
+		return false, err
+	}
+	deletedAt := time.Now().Unix()
+	if deletedAt <= last {
+		deletedAt = last + 1
+	}
+	res, err := db.Exec("UPDATE characters SET deleted_at = ?, unique_name_key = NULL WHERE "+
+		"guildcard = ? AND slot_num = ? AND deleted_at = 0",
+		deletedAt, guildcard, slot)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+var errCharacterNameTaken = errors.New("The character's name has been taken by someone else")
+
+// Undo the most recent deletion of a character from slot.
+func restoreCharacter(db queryer, guildcard, slot uint32) error {
+	if slot >= MaxCharacterSlots {
+		return fmt.Errorf("Invalid character slot %d", slot)
+	}
+	var live int
+	err := db.QueryRow("SELECT COUNT(*) FROM characters WHERE "+
+		"guildcard = ? AND slot_num = ? AND deleted_at = 0", guildcard, slot).Scan(&live)
+	if err != nil {
+		return err
+	}
+	if live > 0 {
+		return fmt.Errorf("Slot %d for guildcard %d is in use", slot, guildcard)
+	}
+
+	var deletedAt sql.NullInt64
+	err = db.QueryRow("SELECT MAX(deleted_at) FROM characters WHERE "+
+		"guildcard = ? AND slot_num = ? AND deleted_at >= ?",
+		guildcard, slot, characterRetentionCutoff(time.Now())).Scan(&deletedAt)
+	if err != nil {
+		return err
+	}
+	if !deletedAt.Valid || deletedAt.Int64 == 0 {
+		return fmt.Errorf("No deleted character in slot %d for guildcard %d", slot, guildcard)
+	}
+	var uniqueKey interface{}
+	if config.UniqueCharacterNames {
+		var key sql.NullString
+		err = db.QueryRow("SELECT name_key FROM characters WHERE guildcard = ? AND "+
+			"slot_num = ? AND deleted_at = ?", guildcard, slot, deletedAt.Int64).Scan(&key)
+		if err != nil {
+			return err
+		}
+		taken, err := characterNameTaken(db, key.String, guildcard, slot)
+		if err != nil {
+			return err
+		}
+		if taken {
+			return errCharacterNameTaken
+		}
+		uniqueKey = key.String
+	}
+	_, err = db.Exec("UPDATE characters SET deleted_at = 0, unique_name_key = ? WHERE "+
+		"guildcard = ? AND slot_num = ? AND deleted_at = ?",
+		uniqueKey, guildcard, slot, deletedAt.Int64)
+	if isDuplicateKey(err) {
+		return errCharacterNameTaken
+	}
+	return err
+}
+
+// Permanently remove characters that were deleted longer ago than the retention period.
+func purgeDeletedCharacters(db queryer) (int64, error) {
+	res, err := db.Exec("DELETE FROM characters WHERE deleted_at > 0 AND deleted_at < ?",
+		characterRetentionCutoff(time.Now()))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// Number of characters the account has created in the last day, including
+// any that have since been deleted.
+func charactersCreatedToday(db queryer, guildcard uint32) (int, error) {
+	var n int
+	err := db.QueryRow("SELECT COUNT(*) FROM characters WHERE guildcard = ? AND created_at > ?",
+		guildcard, time.Now().AddDate(0, 0, -1).Unix()).Scan(&n)
+	return n, err
+}
+
+// Parse a character given as GUILDCARD:SLOT on the command line.
+func parseCharacterRef(ref string) (uint32, uint32, error) {
+	parts := strings.Split(ref, ":")
+	if len(parts) != 2 {
+		return 0, 0, errors.New("Expected GUILDCARD:SLOT, got " + ref)
+	}
+	guildcard, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil {
+		return 0, 0, errors.New("Invalid guildcard: " + parts[0])
+	}
+	slot, err := strconv.ParseUint(parts[1], 10, 32)
+	if err != nil || slot >= MaxCharacterSlots {
+		return 0, 0, fmt.Errorf("Slot must be between 0 and %d, got %s", MaxCharacterSlots-1, parts[1])
+	}
+	return uint32(guildcard), uint32(slot), nil
+}
+
+// Save a character's level and experience along with the stats for that
+// level, refusing any combination that isn't possible for its class.
+func saveCharacterProgress(db queryer, guildcard, slot uint32, class uint8, level, experience uint32) error {
+	if err := levelTable.ValidateProgress(class, level, experience); err != nil {
+		return fmt.Errorf("Not saving character in slot %d for guildcard %d: %s", slot, guildcard, err)
+	}
+	stats := levelTable.StatsAtLevel(class, level)
+	_, err := db.Exec("UPDATE characters SET level = ?, experience = ?, atp = ?, "+
+		"mst = ?, evp = ?, hp = ?, dfp = ?, ata = ?, lck = ? "+
+		"WHERE guildcard = ? AND slot_num = ? AND deleted_at = 0",
+		level, experience, stats.ATP, stats.MST, stats.EVP, stats.HP, stats.DFP,
+		stats.ATA, stats.LCK, guildcard, slot)
+	return err
+}
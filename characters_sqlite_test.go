@@ -0,0 +1,359 @@
+//go:build sqlite
+// +build sqlite
+
+/*
+* Archon PSO Server
+* Copyright (C) 2014 Andrew Rodman
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/dcrodman/archon/util"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Number of characters on the account that haven't been deleted.
+func liveCharacters(t *testing.T, db *sql.DB, guildcard uint32) int {
+	t.Helper()
+	var n int
+	err := db.QueryRow("SELECT COUNT(*) FROM characters WHERE guildcard = ? "+
+		"AND deleted_at = 0", guildcard).Scan(&n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestDeleteAndRestoreCharacter(t *testing.T) {
+	db := newTestDB(t)
+	insertTestCharacter(t, db, 1, 0, "\tEAlice", time.Now().Unix(), 0)
+
+	if deleted, err := deleteCharacter(db, 1, 0); !deleted || err != nil {
+		t.Fatalf("deleting character: %v, %v", deleted, err)
+	}
+	if deleted, err := deleteCharacter(db, 1, 0); deleted || err != nil {
+		t.Errorf("deleted an empty slot: %v, %v", deleted, err)
+	}
+	if _, err := deleteCharacter(db, 1, MaxCharacterSlots); err == nil {
+		t.Error("deleted from an invalid slot")
+	}
+	if n := liveCharacters(t, db, 1); n != 0 {
+		t.Errorf("%d live characters after deleting the only one", n)
+	}
+
+	if err := restoreCharacter(db, 1, 0); err != nil {
+		t.Fatalf("restoring character: %v", err)
+	}
+	if n := liveCharacters(t, db, 1); n != 1 {
+		t.Errorf("%d live characters after restoring one", n)
+	}
+	if err := restoreCharacter(db, 1, 0); err == nil {
+		t.Error("restored a character over a live one")
+	}
+	if err := restoreCharacter(db, 1, 1); err == nil {
+		t.Error("restored a character into a slot that never had one")
+	}
+}
+
+// A slot can be replaced several times in the same second, but restoring it
+// should still bring back only the last character deleted from it.
+func TestRestoreCharacterReplacedTwice(t *testing.T) {
+	db := newTestDB(t)
+	now := time.Now().Unix()
+	for _, name := range []string{"\tEAlice", "\tEBob"} {
+		insertTestCharacter(t, db, 1, 0, name, now, 0)
+		if deleted, err := deleteCharacter(db, 1, 0); !deleted || err != nil {
+			t.Fatalf("deleting %s: %v, %v", name, deleted, err)
+		}
+	}
+	if err := restoreCharacter(db, 1, 0); err != nil {
+		t.Fatalf("restoring character: %v", err)
+	}
+	if n := liveCharacters(t, db, 1); n != 1 {
+		t.Fatalf("%d live characters after restoring one", n)
+	}
+	var key string
+	if err := db.QueryRow("SELECT name_key FROM characters WHERE guildcard = 1 AND "+
+		"deleted_at = 0").Scan(&key); err != nil || key != characterNameKey("\tEBob") {
+		t.Errorf("restored %q (%v), expected the last character deleted", key, err)
+	}
+}
+
+// The database won't let a slot hold two live characters.
+func TestCharacterIndexUnique(t *testing.T) {
+	db := newTestDB(t)
+	insertTestCharacter(t, db, 1, 0, "\tEAlice", 0, 0)
+	_, err := db.Exec("INSERT INTO characters (guildcard, slot_num, name_key, created_at, deleted_at) "+
+		"VALUES (1, 0, ?, 0, 0)", characterNameKey("\tEBob"))
+	if !isDuplicateKey(err) {
+		t.Errorf("second live character in a slot got %v", err)
+	}
+}
+
+func TestRestoreCharacterReusedSlot(t *testing.T) {
+	db := newTestDB(t)
+	insertTestCharacter(t, db, 1, 0, "\tEAlice", 0, time.Now().Unix())
+	insertTestCharacter(t, db, 1, 0, "\tEBob", 0, 0)
+	if err := restoreCharacter(db, 1, 0); err == nil {
+		t.Error("restored a character into a slot that's been reused")
+	}
+}
+
+func TestRestoreCharacterExpired(t *testing.T) {
+	db := newTestDB(t)
+	saved := config.CharacterRetentionDays
+	config.CharacterRetentionDays = 7
+	defer func() { config.CharacterRetentionDays = saved }()
+
+	insertTestCharacter(t, db, 1, 0, "\tEAlice", 0, time.Now().AddDate(0, 0, -8).Unix())
+	if err := restoreCharacter(db, 1, 0); err == nil {
+		t.Error("restored a character deleted before the retention period")
+	}
+}
+
+func TestRestoreCharacterNameTaken(t *testing.T) {
+	db := newTestDB(t)
+	saved := config.UniqueCharacterNames
+	defer func() { config.UniqueCharacterNames = saved }()
+
+	insertTestCharacter(t, db, 1, 0, "\tEAlice", 0, time.Now().Unix())
+	insertTestCharacter(t, db, 2, 0, "\tJALICE", 0, 0)
+
+	config.UniqueCharacterNames = true
+	if err := restoreCharacter(db, 1, 0); err == nil {
+		t.Error("restored a character whose name someone else is using")
+	}
+	config.UniqueCharacterNames = false
+	if err := restoreCharacter(db, 1, 0); err != nil {
+		t.Errorf("restoring with unique names off: %v", err)
+	}
+}
+
+func TestPurgeDeletedCharacters(t *testing.T) {
+	db := newTestDB(t)
+	saved := config.CharacterRetentionDays
+	config.CharacterRetentionDays = 7
+	defer func() { config.CharacterRetentionDays = saved }()
+
+	now := time.Now()
+	insertTestCharacter(t, db, 1, 0, "\tELive", 0, 0)
+	insertTestCharacter(t, db, 1, 1, "\tERecent", 0, now.AddDate(0, 0, -6).Unix())
+	insertTestCharacter(t, db, 1, 2, "\tEOld", 0, now.AddDate(0, 0, -8).Unix())
+	insertTestCharacter(t, db, 1, 3, "\tEOlder", 0, now.AddDate(0, 0, -30).Unix())
+
+	if n, err := purgeDeletedCharacters(db); n != 2 || err != nil {
+		t.Fatalf("purged %d characters, %v; expected 2", n, err)
+	}
+	var left int
+	if err := db.QueryRow("SELECT COUNT(*) FROM characters").Scan(&left); err != nil {
+		t.Fatal(err)
+	}
+	if left != 2 {
+		t.Errorf("%d characters left after purging, expected 2", left)
+	}
+	if err := restoreCharacter(db, 1, 1); err != nil {
+		t.Errorf("recently deleted character was purged: %v", err)
+	}
+}
+
+func TestCharactersCreatedToday(t *testing.T) {
+	db := newTestDB(t)
+	now := time.Now()
+	insertTestCharacter(t, db, 1, 0, "\tEOne", now.Unix(), 0)
+	// Characters that have been deleted still count.
+	insertTestCharacter(t, db, 1, 1, "\tETwo", now.Add(-time.Hour).Unix(), now.Unix())
+	insertTestCharacter(t, db, 1, 2, "\tEThree", now.AddDate(0, 0, -2).Unix(), 0)
+	insertTestCharacter(t, db, 2, 0, "\tEOther", now.Unix(), 0)
+
+	if n, err := charactersCreatedToday(db, 1); n != 2 || err != nil {
+		t.Errorf("got %d characters created today, %v; expected 2", n, err)
+	}
+	if n, err := charactersCreatedToday(db, 3); n != 0 || err != nil {
+		t.Errorf("got %d characters created today for an empty account, %v", n, err)
+	}
+}
+
+func TestUniqueNameKeyIndex(t *testing.T) {
+	db := newTestDB(t)
+	saved := config.UniqueCharacterNames
+	config.UniqueCharacterNames = true
+	defer func() { config.UniqueCharacterNames = saved }()
+
+	insert := func(guildcard uint32, name string) error {
+		_, err := db.Exec("INSERT INTO characters (guildcard, slot_num, name_key, "+
+			"unique_name_key) VALUES (?, 0, ?, ?)",
+			guildcard, characterNameKey(name), uniqueNameKey(name))
+		return err
+	}
+	if err := insert(1, "\tEAlice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := insert(2, "\tJALICE"); !isDuplicateKey(err) {
+		t.Fatalf("expected a duplicate key error, got %v", err)
+	}
+
+	// Deleting the character frees up the name, after which the deleted
+	// one can't be restored.
+	if _, err := deleteCharacter(db, 1, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := insert(2, "\tJALICE"); err != nil {
+		t.Fatalf("name wasn't freed by deleting the character: %v", err)
+	}
+	if err := restoreCharacter(db, 1, 0); err != errCharacterNameTaken {
+		t.Errorf("expected the name to be taken, got %v", err)
+	}
+
+	// Without unique names the key isn't saved at all.
+	config.UniqueCharacterNames = false
+	if err := insert(3, "\tEAlice"); err != nil {
+		t.Errorf("duplicate name refused with unique names off: %v", err)
+	}
+}
+
+// A name taken between checking it and saving the character should get the
+// same response as one that was already taken.
+func TestCreateCharacterNameRace(t *testing.T) {
+	db := newTestDB(t)
+	saved := config.UniqueCharacterNames
+	config.UniqueCharacterNames = true
+	defer func() { config.UniqueCharacterNames = saved }()
+
+	// Stand in for a character saved right after ours was checked, which
+	// checkCharacterName can't see since name_key isn't set.
+	_, err := db.Exec("INSERT INTO characters (guildcard, slot_num, unique_name_key) "+
+		"VALUES (2, 0, 'alice')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, conn := newPipeClient(8)
+	defer c.Close()
+	go io.Copy(ioutil.Discard, conn)
+	c.guildcard = 1
+	p := &CharacterPreview{Class: 1}
+	copy(p.Name[:], util.ConvertToUtf16("\tEAlice"))
+	err = createCharacter(c, 0, p)
+	if err == nil || !strings.Contains(err.Error(), errNameTaken.Error()) {
+		t.Errorf("expected the name to be rejected, got %v", err)
+	}
+	if n := liveCharacters(t, db, 1); n != 0 {
+		t.Errorf("%d characters were created", n)
+	}
+}
+
+// Scripts can change the section id of a new character, but only to one
+// that exists.
+func TestCreateCharacterScriptSectionId(t *testing.T) {
+	db := newTestDB(t)
+	dir := t.TempDir()
+	writeScript(t, dir, `
+archon.on("character_create", function(ev)
+	ev.section_id = ev.slot + 9
+end)
+`)
+	engine, err := LoadScripts(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(saved *ScriptEngine) { scripts = saved }(scripts)
+	scripts = engine
+	defer func(saved *PlayerLevelTable) { levelTable = saved }(levelTable)
+	if levelTable, err = LoadPlayerLevelTable("config/parameters/PlyLevelTbl.prs"); err != nil {
+		t.Fatal(err)
+	}
+
+	c, conn := newPipeClient(8)
+	defer c.Close()
+	go io.Copy(ioutil.Discard, conn)
+	c.guildcard = 1
+	for slot, want := range []uint8{9, 2} {
+		p := &CharacterPreview{SectionId: 2}
+		copy(p.Name[:], util.ConvertToUtf16(fmt.Sprintf("\tEAlice%d", slot)))
+		if err := createCharacter(c, uint32(slot), p); err != nil {
+			t.Fatal(err)
+		}
+		var id uint8
+		err := db.QueryRow("SELECT section_id FROM characters WHERE guildcard = 1 "+
+			"AND slot_num = ?", slot).Scan(&id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id != want {
+			t.Errorf("slot %d has section id %d, want %d", slot, id, want)
+		}
+	}
+}
+
+// Deleting the selected character should re-sign the session without it,
+// and an old session that still has it selected shouldn't get past the
+// character select screen.
+func TestDeleteSelectedCharacter(t *testing.T) {
+	db := newTestDB(t)
+	insertTestAccount(t, db, 1, "player", "secret", false)
+	defer func(saved *PlayerLevelTable) { levelTable = saved }(levelTable)
+	var err error
+	if levelTable, err = LoadPlayerLevelTable("config/parameters/PlyLevelTbl.prs"); err != nil {
+		t.Fatal(err)
+	}
+
+	c, conn := newPipeClient(8)
+	defer c.Close()
+	packets := readPackets(c, conn)
+	c.guildcard = 1
+	p := &CharacterPreview{Class: 1}
+	copy(p.Name[:], util.ConvertToUtf16("\tEAlice"))
+	if err := createCharacter(c, 0, p); err != nil {
+		t.Fatal(err)
+	}
+	c.config = ClientConfig{Magic: clientConfigMagic, CharSelected: 1, SlotNum: 0}
+	stale := signedSecurity(1, c.config)
+
+	c.flag = CharFlagDelete
+	handlePacket(t, c, handleCharacterUpdate, &CharPreviewPacket{
+		Header:    BBHeader{Type: LoginCharPreviewType},
+		Character: p,
+	})
+	security := SecurityPacket{Config: new(ClientConfig)}
+	nextPacket(t, packets, &security)
+	if security.ErrorCode != uint32(BBLoginErrorNone) || security.Config.CharSelected != 0 {
+		t.Errorf("got security %+v with config %+v, want no character selected", security, security.Config)
+	}
+	var ack CharAckPacket
+	nextPacket(t, packets, &ack)
+	if ack.Flag != 2 {
+		t.Errorf("got ack flag %d, want the slot to be empty", ack.Flag)
+	}
+
+	login := &LoginPkt{Header: BBHeader{Type: LoginType}, Phase: 4}
+	copy(login.Username[:], "player")
+	copy(login.Password[:], "secret")
+	copy(login.Security[:], stale)
+	data, _ := util.BytesFromStruct(login)
+	c.packetSize = uint16(copy(c.buffer, data))
+	if err := handleCharLogin(c); err == nil {
+		t.Error("expected ship select with a deleted character to be refused")
+	}
+	nextPacket(t, packets, &security)
+	if security.ErrorCode == uint32(BBLoginErrorNone) {
+		t.Error("expected the login to fail")
+	}
+}
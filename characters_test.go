@@ -0,0 +1,32 @@
+/*
+* Archon PSO Server
+* Copyright (C) 2014 Andrew Rodman
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import "testing"
+
+func TestParseCharacterRef(t *testing.T) {
+	guildcard, slot, err := parseCharacterRef("10000042:3")
+	if err != nil || guildcard != 10000042 || slot != 3 {
+		t.Errorf("got %d:%d, %v", guildcard, slot, err)
+	}
+	for _, ref := range []string{"", "42", "42:4", "42:-1", "x:1", "42:1:2"} {
+		if _, _, err := parseCharacterRef(ref); err == nil {
+			t.Errorf("expected %q to be rejected", ref)
+		}
+	}
+}
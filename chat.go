@@ -0,0 +1,149 @@
+/*
+* Archon PSO Server
+* Copyright (C) 2014 Andrew Rodman
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+* ---------------------------------------------------------------------
+* Chat in the lobbies and games. Messages starting with a slash are chat
+* commands, which are run by the server (or the scripts) instead of being
+* shown to the other players.
+ */
+package main
+
+import (
+	"fmt"
+	"github.com/dcrodman/archon/util"
+	"strconv"
+	"strings"
+)
+
+const chatCommandPrefix = "/"
+
+// Commands built into the server, which take precedence over the scripts'.
+// Each is passed the text after the command name and returns the reply.
+var chatCommands = map[string]func(server *BlockServer, c *Client, args string) string{
+	"ban": (*BlockServer).banCommand,
+}
+
+// Name of the player's character without the language prefix.
+func (c *Client) characterName() string {
+	if c.character == nil {
+		return ""
+	}
+	return stripNamePrefix(util.ConvertFromUtf16(c.character.Name[:]))
+}
+
+// Fields describing the player that are passed to hooks and commands.
+func (c *Client) scriptPlayer() map[string]interface{} {
+	return map[string]interface{}{
+		"guildcard": c.guildcard,
+		"name":      c.characterName(),
+		"gm":        c.isGm,
+	}
+}
+
+func (server *BlockServer) handleChat(c *Client) error {
+	var pkt ChatPacket
+	if err := c.ReadPacket(&pkt); err != nil {
+		return err
+	}
+	room := c.room()
+	if room == nil || c.character == nil {
+		return c.Misbehave("chatted from outside of a lobby")
+	}
+	// The client puts a language marker (e.g. \tE) in front of the text.
+	message := util.ConvertFromUtf16(pkt.Message)
+	text := stripNamePrefix(message)
+	lang := message[:len(message)-len(text)]
+
+	if strings.HasPrefix(text, chatCommandPrefix) {
+		return server.runChatCommand(c, text[len(chatCommandPrefix):])
+	}
+	args := c.scriptPlayer()
+	args["text"] = text
+	if err := scripts.RunHook(HookChat, args); err != nil {
+		c.SendChat(0, lang+vetoMessage(err))
+		return nil
+	}
+	// Everyone sees the message, including the player that sent it.
+	data := chatPacket(c.guildcard, c.characterName()+"\t"+lang+args["text"].(string))
+	room.broadcast(nil, data)
+	return nil
+}
+
+// Run the chat command in text (without the prefix) and show the player
+// what it returned.
+func (server *BlockServer) runChatCommand(c *Client, text string) error {
+	name, args := text, ""
+	if i := strings.IndexByte(text, ' '); i >= 0 {
+		name, args = text[:i], strings.TrimSpace(text[i+1:])
+	}
+	if command, ok := chatCommands[name]; ok {
+		c.SendChat(0, "\tE"+command(server, c, args))
+		return nil
+	}
+	reply, ok, err := scripts.RunCommand(name, c.scriptPlayer(), args)
+	switch {
+	case err != nil:
+		log.Error(err.Error())
+		reply = "The command failed."
+	case !ok:
+		reply = "Unknown command: " + name
+	}
+	if reply != "" {
+		c.SendChat(0, "\tE"+reply)
+	}
+	return nil
+}
+
+// /ban <guildcard> [reason]: keep the player from logging in again and
+// disconnect them if they're on this block. Only GMs can ban players.
+func (server *BlockServer) banCommand(c *Client, args string) string {
+	if !c.isGm {
+		return "Only GMs can ban players."
+	}
+	fields := strings.SplitN(args, " ", 2)
+	guildcard, err := strconv.ParseUint(fields[0], 10, 32)
+	if err != nil {
+		return "Usage: /ban <guildcard> [reason]"
+	}
+	reason := ""
+	if len(fields) > 1 {
+		reason = strings.TrimSpace(fields[1])
+	}
+	switch err := banAccount(config.DB(), uint32(guildcard)); err {
+	case nil:
+	case errAccountNotFound:
+		return fmt.Sprintf("No account has guildcard %d.", guildcard)
+	default:
+		log.Error(err.Error())
+		return "The command failed."
+	}
+	log.Infof("Guildcard %d banned guildcard %d: %s", c.guildcard, guildcard, reason)
+	events.Publish(Event{Type: EventBanIssued, Server: server.Name(), Ship: shipList[0].id,
+		Guildcard: uint32(guildcard), Id: c.guildcard, Text: reason})
+	if p := server.findPlayer(uint32(guildcard)); p != nil {
+		p.Close()
+	}
+	return fmt.Sprintf("Banned guildcard %d.", guildcard)
+}
+
+func chatPacket(guildcard uint32, message string) []byte {
+	data, _ := util.BytesFromStruct(&ChatPacket{
+		Header:    BBHeader{Type: ChatType},
+		Guildcard: guildcard,
+		Message:   append(util.ConvertToUtf16(message), 0, 0),
+	})
+	return data
+}
@@ -0,0 +1,495 @@
+/*
+* Archon PSO Server
+* Copyright (C) 2014 Andrew Rodman
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+* ---------------------------------------------------------------------
+* Login, ship, and block servers for the PC, Dreamcast, and Gamecube
+* versions of the game. These clients don't have a separate character
+* server; they go straight from the login server's ship menu to a ship,
+* which sends them on to a block. Each version gets its own set of ports
+* since the encryption and header layout have to be known before the
+* client sends us anything.
+ */
+package main
+
+import (
+	"errors"
+	"fmt"
+	crypto "github.com/dcrodman/archon/encryption"
+	"github.com/dcrodman/archon/util"
+	"net"
+	"strconv"
+)
+
+const (
+	// Menu id sent with selections from the block menu.
+	classicBlockMenuId = 0x12
+	// Menu id for entries in the lobby list.
+	classicLobbyMenuId = 0x1A0001
+	// Episode III clients have lobbies beyond the ones everyone else gets.
+	ep3ExtraLobbies = 5
+)
+
+// An entry in one of the menus sent to the classic clients.
+type classicMenuItem struct {
+	id   uint32
+	text string
+}
+
+// Returns the login port for a version.
+func classicLoginPort(v ClientVersion) string {
+	switch v {
+	case VersionPC:
+		return config.PCLoginPort
+	case VersionDC:
+		return config.DCLoginPort
+	case VersionEp3:
+		return config.Ep3LoginPort
+	}
+	return config.GCLoginPort
+}
+
+// Returns the ship port for a version; Episode III shares the Gamecube ship.
+func classicShipPort(v ClientVersion) string {
+	switch v {
+	case VersionPC:
+		return config.PCShipPort
+	case VersionDC:
+		return config.DCShipPort
+	}
+	return config.GCShipPort
+}
+
+// Create a client for one of the classic versions and send the welcome
+// packet to begin encryption. welcomeType determines which of the two
+// welcome packets is sent.
+func newClassicClient(conn net.Conn, version ClientVersion, welcomeType uint8) (*Client, error) {
+	var cCrypt, sCrypt *crypto.PSOCrypt
+	if version.isGC() {
+		cCrypt, sCrypt = crypto.NewGCCrypt(), crypto.NewGCCrypt()
+	} else {
+		cCrypt, sCrypt = crypto.NewPCCrypt(), crypto.NewPCCrypt()
+	}
+	c := NewClient(conn, PCHeaderSize, cCrypt, sCrypt)
+	c.version = version
+
+	err := error(nil)
+	if c.SendClassicWelcome(welcomeType) != 0 {
+		err = errors.New("Error sending welcome packet to: " + c.IPAddr())
+		c = nil
+	}
+	return c, err
+}
+
+// Populate pkt from the body of the most recently received packet, since the
+// classic packets are defined without their header.
+func (c *Client) readClassicPacket(pkt interface{}) error {
+	if err := util.StructFromBytes(c.Data()[c.hdrSize:], pkt); err != nil {
+		return c.Misbehave(err.Error())
+	}
+	return nil
+}
+
+// Check the serial number and access key the client sent, which we treat as
+// the username and password for their account. Failures are reported to the
+// client before the error is returned.
+func classicAuthenticate(client *Client, serial, accessKey string) error {
+	switch err := authenticate(client, serial, accessKey); err {
+	case nil:
+		return nil
+	case errAccountNotFound:
+		client.SendClassicSecurity(ClassicLoginBadAccount, 0)
+		return errors.New("Account does not exist for serial number: " + serial)
+	case errAccountBanned:
+		client.SendClassicMessage("This account has been banned.")
+		return errors.New("Account banned: " + serial)
+	case errAccountInactive:
+		client.SendClassicMessage("This account has not been activated.")
+		return errors.New("Account must be activated for serial number: " + serial)
+	default:
+		client.SendClassicMessage("Encountered an unexpected error while accessing the " +
+			"database.\n\nPlease contact your server administrator.")
+		log.Error(err.Error())
+		return err
+	}
+}
+
+// Handle the license check the client performs before logging in.
+func handleClassicVerify(client *Client, serverVersion ClientVersion) error {
+	var pkt ClassicVerifyPkt
+	if err := client.readClassicPacket(&pkt); err != nil {
+		return err
+	}
+	client.version = detectVersion(serverVersion, pkt.SubVersion)
+	serial := string(util.StripPadding(pkt.SerialNumber[:]))
+	accessKey := string(util.StripPadding(pkt.AccessKey[:]))
+	if err := classicAuthenticate(client, serial, accessKey); err != nil {
+		return err
+	}
+	client.SendClassicVerify(ClassicVerifyOK)
+	return nil
+}
+
+// Handle the login packet sent to each of the classic servers.
+func handleClassicLogin(client *Client, serverVersion ClientVersion) error {
+	var pkt ClassicLoginPkt
+	if err := client.readClassicPacket(&pkt); err != nil {
+		return err
+	}
+	client.version = detectVersion(serverVersion, pkt.SubVersion)
+	serial := string(util.StripPadding(pkt.SerialNumber[:]))
+	accessKey := string(util.StripPadding(pkt.AccessKey[:]))
+	if err := classicAuthenticate(client, serial, accessKey); err != nil {
+		return err
+	}
+	if client.onLoginServer() {
+		if err := runLoginHook(client, serial); err != nil {
+			client.SendClassicMessage(vetoMessage(err))
+			return err
+		}
+	}
+	copy(client.classicConfig[:], pkt.Config)
+	client.CompleteHandshake()
+	client.captureAccount(serial)
+	if client.onLoginServer() {
+		client.publishEvent(EventPlayerLogin, 0, "")
+	}
+	client.SendClassicSecurity(ClassicLoginOK, client.guildcard)
+	return nil
+}
+
+// Send the menu listing the connected ships.
+func sendClassicShipMenu(client *Client) int {
+	items := make([]classicMenuItem, len(shipList))
+	for i, ship := range shipList {
+		items[i] = classicMenuItem{
+			id:   ship.id,
+			text: string(util.StripPadding(ship.name[:])),
+		}
+	}
+	return client.SendClassicMenu(uint32(ShipSelectionMenuId), "Ship Select", items)
+}
+
+// Player selected one of the items on the ship menu. Only our own ships
+// have servers for the classic versions, so others just get a message.
+func handleClassicShipSelection(client *Client, pkt ClassicMenuSelectionPacket) error {
+	selectedShip := pkt.ItemId - 1
+	if pkt.ItemId < 1 || selectedShip >= uint32(len(shipList)) {
+		return client.Misbehave(fmt.Sprintf("invalid ship selection %d", pkt.ItemId))
+	}
+	if !shipList[selectedShip].local {
+		client.SendClassicMessage("This ship is only available to Blue Burst players.")
+		return nil
+	}
+	port, _ := strconv.ParseUint(classicShipPort(client.version), 10, 16)
+	client.SendClassicRedirect(uint16(port), client.RedirectAddr())
+	return nil
+}
+
+// Login sub-server for one of the classic versions.
+type ClassicLoginServer struct {
+	version  ClientVersion
+	handlers *HandlerRegistry
+}
+
+func (server ClassicLoginServer) Name() string { return server.version.String() + "LOGIN" }
+
+func (server ClassicLoginServer) Port() string { return classicLoginPort(server.version) }
+
+// Classic servers share the connection settings of their Blue Burst counterparts.
+func (server ClassicLoginServer) ServerType() string { return "LOGIN" }
+
+func (server *ClassicLoginServer) Init() {
+	server.handlers = newServerHandlers(server.Name())
+	verify := func(c *Client) error { return handleClassicVerify(c, server.version) }
+	server.handlers.Handle(ClassicVerifyType, verify)
+	server.handlers.Handle(GCVerifyType, verify)
+	login := func(c *Client) error {
+		if err := handleClassicLogin(c, server.version); err != nil {
+			return err
+		}
+		sendClassicShipMenu(c)
+		return nil
+	}
+	server.handlers.Handle(ClassicLoginType, login)
+	server.handlers.Handle(GCLoginType, login)
+	server.handlers.Handle(MenuSelectType, func(c *Client) error {
+		var pkt ClassicMenuSelectionPacket
+		if err := c.readClassicPacket(&pkt); err != nil {
+			return err
+		}
+		return handleClassicShipSelection(c, pkt)
+	}, RequireLogin)
+	server.handlers.Handle(PingType, ignorePacket)
+	server.handlers.Handle(DisconnectType, ignorePacket)
+}
+
+func (server ClassicLoginServer) NewClient(conn net.Conn) (*Client, error) {
+	return newClassicClient(conn, server.version, ClassicLoginWelcomeType)
+}
+
+func (server ClassicLoginServer) Handle(c *Client) error {
+	return server.handlers.Dispatch(c)
+}
+
+// Ship sub-server for one of the classic versions.
+type ClassicShipServer struct {
+	version ClientVersion
+	// Precomputed block menu items.
+	blocks   []classicMenuItem
+	handlers *HandlerRegistry
+}
+
+func (server ClassicShipServer) Name() string { return server.version.String() + "SHIP" }
+
+func (server ClassicShipServer) Port() string { return classicShipPort(server.version) }
+
+func (server ClassicShipServer) ServerType() string { return "SHIP" }
+
+func (server *ClassicShipServer) Init() {
+	server.blocks = make([]classicMenuItem, config.NumBlocks+1)
+	for i := 0; i < config.NumBlocks; i++ {
+		server.blocks[i] = classicMenuItem{id: uint32(i + 1), text: fmt.Sprintf("BLOCK %02d", i+1)}
+	}
+	// Always append a menu item for returning to the ship select screen.
+	server.blocks[config.NumBlocks] = classicMenuItem{id: BackMenuItem, text: "Ship Selection"}
+
+	server.handlers = newServerHandlers(server.Name())
+	login := func(c *Client) error {
+		if err := handleClassicLogin(c, server.version); err != nil {
+			return err
+		}
+		server.sendBlockMenu(c)
+		return nil
+	}
+	server.handlers.Handle(ClassicLoginType, login)
+	server.handlers.Handle(GCLoginType, login)
+	server.handlers.Handle(MenuSelectType, server.handleMenuSelection, RequireLogin)
+	server.handlers.Handle(PingType, ignorePacket)
+	server.handlers.Handle(DisconnectType, ignorePacket)
+}
+
+func (server ClassicShipServer) NewClient(conn net.Conn) (*Client, error) {
+	return newClassicClient(conn, server.version, ClassicWelcomeType)
+}
+
+func (server ClassicShipServer) sendBlockMenu(c *Client) int {
+	ship := shipList[0]
+	shipName := fmt.Sprintf("%d:%s", ship.id, util.StripPadding(ship.name[:]))
+	return c.SendClassicMenu(classicBlockMenuId, shipName, server.blocks)
+}
+
+func (server ClassicShipServer) handleMenuSelection(c *Client) error {
+	var pkt ClassicMenuSelectionPacket
+	if err := c.readClassicPacket(&pkt); err != nil {
+		return err
+	}
+	// They can be at either the ship or block selection menu.
+	if pkt.MenuId == uint32(ShipSelectionMenuId) {
+		return handleClassicShipSelection(c, pkt)
+	} else if pkt.ItemId == BackMenuItem {
+		sendClassicShipMenu(c)
+	} else if pkt.ItemId < 1 || int(pkt.ItemId) > config.NumBlocks {
+		return c.Misbehave(fmt.Sprintf("block selection %v out of range %v", pkt.ItemId, config.NumBlocks))
+	} else {
+		port, _ := strconv.ParseUint(server.Port(), 10, 16)
+		c.SendClassicRedirect(uint16(uint32(port)+pkt.ItemId), c.RedirectAddr())
+	}
+	return nil
+}
+
+func (server ClassicShipServer) Handle(c *Client) error {
+	return server.handlers.Dispatch(c)
+}
+
+// Block sub-server for one of the classic versions.
+type ClassicBlockServer struct {
+	version ClientVersion
+	name    string
+	port    string
+	// Block number, starting from 1.
+	num uint16
+
+	// Precomputed lobby lists and the lobbies for each version served by the
+	// block. Each version has its own lobbies since they can't see each
+	// other's players.
+	lobbyPkts map[ClientVersion]*ClassicLobbyListPacket
+	lobbies   map[ClientVersion][]*Lobby
+	handlers  *HandlerRegistry
+}
+
+func (server ClassicBlockServer) Name() string { return server.name }
+
+func (server ClassicBlockServer) Port() string { return server.port }
+
+func (server ClassicBlockServer) ServerType() string { return "BLOCK" }
+
+func (server *ClassicBlockServer) Init() {
+	server.lobbyPkts = map[ClientVersion]*ClassicLobbyListPacket{
+		server.version: newClassicLobbyList(config.NumLobbies),
+	}
+	server.lobbies = map[ClientVersion][]*Lobby{
+		server.version: newLobbies(config.NumLobbies),
+	}
+	// Gamecube blocks also serve Episode III players.
+	if server.version.isGC() {
+		server.lobbyPkts[VersionEp3] = newClassicLobbyList(config.NumLobbies + ep3ExtraLobbies)
+		server.lobbies[VersionEp3] = newLobbies(config.NumLobbies + ep3ExtraLobbies)
+	}
+
+	server.handlers = newServerHandlers(server.Name())
+	login := func(c *Client) error {
+		if err := handleClassicLogin(c, server.version); err != nil {
+			return err
+		}
+		c.SendClassicLobbyList(server.lobbyPkts[c.version])
+		// They're put in a lobby once they've sent us their character.
+		c.SendClassicCharDataRequest()
+		return nil
+	}
+	server.handlers.Handle(ClassicLoginType, login)
+	server.handlers.Handle(GCLoginType, login)
+	server.handlers.Handle(ClassicCharDataType, server.handleCharData, RequireLogin)
+	server.handlers.Handle(PingType, ignorePacket)
+	server.handlers.Handle(DisconnectType, ignorePacket)
+}
+
+func newClassicLobbyList(numLobbies int) *ClassicLobbyListPacket {
+	pkt := new(ClassicLobbyListPacket)
+	for i := 0; i <= numLobbies; i++ {
+		pkt.Lobbies = append(pkt.Lobbies, LobbyListEntry{
+			MenuId:  classicLobbyMenuId,
+			LobbyId: uint32(i),
+		})
+	}
+	return pkt
+}
+
+// Character the player sent after logging in to the block. They're put in
+// the first lobby for their version that will take them, or disconnected if
+// none will since there's nowhere else for them to go.
+func (server *ClassicBlockServer) handleCharData(c *Client) error {
+	var pkt ClassicCharDataPacket
+	if err := c.readClassicPacket(&pkt); err != nil {
+		return err
+	}
+	// The others in the lobby have already been sent their character.
+	if c.lobby != nil {
+		return nil
+	}
+	c.classicChar = &pkt
+	c.character = &CharacterPreview{
+		Level:      pkt.Disp.Level,
+		Experience: pkt.Disp.Experience,
+		SectionId:  pkt.Disp.SectionId,
+		Class:      pkt.Disp.Class,
+	}
+	copy(c.character.Name[:], util.ConvertToUtf16(string(util.StripPadding(pkt.Disp.Name[:]))))
+
+	err := findLobby(c, nil, server.lobbies[c.version], server.enterLobby)
+	if _, vetoed := err.(*VetoError); vetoed {
+		c.SendClassicMessage(vetoMessage(err))
+	} else if err != nil {
+		c.SendClassicMessage("The lobbies are full.")
+	}
+	return err
+}
+
+// Put c in lobby l, sending them everyone's characters and the others theirs.
+// Scripts can keep them out of it.
+func (server *ClassicBlockServer) enterLobby(c *Client, l *Lobby) error {
+	if err := addToLobby(c, l); err != nil {
+		return err
+	}
+	c.SendClassicLobbyJoin(l, server.num)
+	l.broadcast(c, classicMemberJoinPacket(c, l, server.num))
+	c.publishEvent(EventLobbyJoined, uint32(l.id)+1, c.characterName())
+	return nil
+}
+
+// Take c out of their lobby and tell the others that they left.
+func (server *ClassicBlockServer) leaveLobby(c *Client) {
+	l := c.lobby
+	if l == nil {
+		return
+	}
+	id := c.clientId
+	l.remove(c)
+	c.lobby = nil
+	body, _ := util.BytesFromStruct(&ClassicLeaveNoticePacket{ClientId: id, LeaderId: l.leader(), DisableUdp: 1})
+	l.broadcast(c, append(c.classicHeader(LobbyRemoveMemberType, id), body...))
+}
+
+func (server *ClassicBlockServer) Disconnected(c *Client) {
+	server.leaveLobby(c)
+}
+
+// Header and character for player p in a lobby join sent to a client of
+// the given version. id is the player's client id.
+func classicPlayerEntry(version ClientVersion, p *Client, id uint8) []byte {
+	var hdr interface{}
+	if version == VersionPC {
+		pc := &PCPlayerHeader{Tag: 0x00010000, Guildcard: p.guildcard, ClientId: uint32(id)}
+		copy(pc.Name[:], util.ConvertToUtf16(string(util.StripPadding(p.classicChar.Disp.Name[:]))))
+		hdr = pc
+	} else {
+		hdr = &ClassicPlayerHeader{Tag: 0x00010000, Guildcard: p.guildcard, ClientId: uint32(id),
+			Name: p.classicChar.Disp.Name}
+	}
+	data, _ := util.BytesFromStruct(hdr)
+	char, _ := util.BytesFromStruct(p.classicChar)
+	return append(data, char...)
+}
+
+// Packet telling the others in a classic lobby that c has joined.
+func classicMemberJoinPacket(c *Client, l *Lobby, block uint16) []byte {
+	body, _ := util.BytesFromStruct(&ClassicLobbyJoinPacket{
+		ClientId:   c.clientId,
+		LeaderId:   l.leader(),
+		DisableUdp: 1,
+		LobbyNum:   l.id,
+		BlockNum:   block,
+		Players:    classicPlayerEntry(c.version, c, c.clientId),
+	})
+	return append(c.classicHeader(LobbyAddMemberType, 1), body...)
+}
+
+func (server ClassicBlockServer) NewClient(conn net.Conn) (*Client, error) {
+	return newClassicClient(conn, server.version, ClassicWelcomeType)
+}
+
+func (server ClassicBlockServer) Handle(c *Client) error {
+	return server.handlers.Dispatch(c)
+}
+
+// Register the login, ship, and block servers for a version. The Gamecube
+// servers also handle Episode III, which has its own login port.
+func registerClassicServers(d *Dispatcher, version ClientVersion) {
+	d.register(&ClassicLoginServer{version: version})
+	if version == VersionGC {
+		d.register(&ClassicLoginServer{version: VersionEp3})
+	}
+	d.register(&ClassicShipServer{version: version})
+
+	shipPort, _ := strconv.ParseInt(classicShipPort(version), 10, 16)
+	for i := 1; i <= config.NumBlocks; i++ {
+		d.register(&ClassicBlockServer{
+			version: version,
+			name:    fmt.Sprintf("%sBLOCK%d", version, i),
+			num:     uint16(i),
+			port:    strconv.FormatInt(shipPort+int64(i), 10),
+		})
+	}
+}
@@ -0,0 +1,203 @@
+/*
+* Archon PSO Server
+* Copyright (C) 2014 Andrew Rodman
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	crypto "github.com/dcrodman/archon/encryption"
+	"github.com/dcrodman/archon/util"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// Create a Dreamcast or Gamecube client for one end of a pipe and pass along
+// the packets it's sent, decrypted.
+func newClassicPipeClient(version ClientVersion) (*Client, <-chan []byte) {
+	server, conn := net.Pipe()
+	c := NewClient(server, PCHeaderSize, crypto.NewGCCrypt(), crypto.NewGCCrypt())
+	c.version = version
+	packets := make(chan []byte, 16)
+	go func() {
+		defer close(packets)
+		crypt := crypto.NewGCCryptWithVector(c.ServerVector())
+		sizeOff := version.sizeOffset()
+		for {
+			data := make([]byte, PCHeaderSize)
+			if _, err := io.ReadFull(conn, data); err != nil {
+				return
+			}
+			crypt.Decrypt(data, PCHeaderSize)
+			size := int(binary.LittleEndian.Uint16(data[sizeOff:]))
+			data = append(data, make([]byte, size-PCHeaderSize)...)
+			if _, err := io.ReadFull(conn, data[PCHeaderSize:]); err != nil {
+				return
+			}
+			crypt.Decrypt(data[PCHeaderSize:], uint32(size-PCHeaderSize))
+			packets <- data
+		}
+	}()
+	return c, packets
+}
+
+func TestDetectVersion(t *testing.T) {
+	tests := []struct {
+		server     ClientVersion
+		subVersion uint32
+		expected   ClientVersion
+	}{
+		{VersionPC, 0x29, VersionPC},
+		{VersionDC, 0x21, VersionDC},
+		{VersionGC, 0x30, VersionGC},
+		{VersionGC, 0x41, VersionEp3},
+		// Episode I&II clients can connect to the Episode III login port.
+		{VersionEp3, 0x33, VersionGC},
+	}
+	for _, test := range tests {
+		if v := detectVersion(test.server, test.subVersion); v != test.expected {
+			t.Errorf("detectVersion(%v, %#x) = %v, expected %v",
+				test.server, test.subVersion, v, test.expected)
+		}
+	}
+}
+
+func TestClassicPacketHeader(t *testing.T) {
+	pc := &Client{version: VersionPC}
+	if data := seedClassic(VersionPC, GCLoginType, nil); pc.packetType(data) != GCLoginType {
+		t.Errorf("got PC packet type %#x from %x", pc.packetType(data), data)
+	}
+	gc := &Client{version: VersionGC}
+	data := seedClassic(VersionGC, GCLoginType, nil)
+	if gc.packetType(data) != GCLoginType || data[2] != 4 {
+		t.Errorf("got GC packet type %#x and size %d from %x", gc.packetType(data), data[2], data)
+	}
+}
+
+func TestRedactClassicCredentials(t *testing.T) {
+	pkt := new(ClassicLoginPkt)
+	copy(pkt.SerialNumber[:], "12345678")
+	copy(pkt.AccessKey[:], "secretkey")
+	copy(pkt.V1AccessKey[:], "oldkey")
+	data := seedClassic(VersionGC, GCLoginType, pkt)
+
+	redacted := redactCredentials(VersionGC, GCLoginType, PCHeaderSize, data)
+	if bytes.Contains(redacted, []byte("secretkey")) || bytes.Contains(redacted, []byte("oldkey")) {
+		t.Errorf("access keys weren't redacted: %x", redacted)
+	}
+	if !bytes.Contains(redacted, []byte("12345678")) {
+		t.Errorf("serial number was redacted: %x", redacted)
+	}
+	if !bytes.Contains(data, []byte("secretkey")) {
+		t.Error("original packet was modified")
+	}
+}
+
+func TestReadClassicPacket(t *testing.T) {
+	c := &Client{version: VersionGC, hdrSize: PCHeaderSize}
+	c.buffer = seedClassic(VersionGC, GCLoginType, &ClassicLoginPkt{PlayerTag: 0x00010000, SubVersion: 0x42})
+	c.packetSize = uint16(len(c.buffer))
+	var pkt ClassicLoginPkt
+	if err := c.readClassicPacket(&pkt); err != nil {
+		t.Fatal(err)
+	}
+	if pkt.PlayerTag != 0x00010000 || pkt.SubVersion != 0x42 {
+		t.Errorf("read %+v from the body of %x", pkt, c.Data())
+	}
+}
+
+// Players are put in a lobby once they've sent their character, with their
+// own lobbies for each version served by the block.
+func TestClassicLobbies(t *testing.T) {
+	server := &ClassicBlockServer{version: VersionGC, name: "GCBLOCK1", num: 1}
+	server.Init()
+
+	var players []*Client
+	var packets []<-chan []byte
+	for i, version := range []ClientVersion{VersionGC, VersionGC, VersionEp3} {
+		c, pkts := newClassicPipeClient(version)
+		defer c.Close()
+		c.server = server
+		c.guildcard = uint32(i + 1)
+		c.CompleteHandshake()
+		char := new(ClassicCharDataPacket)
+		copy(char.Disp.Name[:], fmt.Sprintf("player%d", i+1))
+		c.buffer = seedClassic(version, ClassicCharDataType, char)
+		c.packetSize = uint16(len(c.buffer))
+		if err := server.Handle(c); err != nil {
+			t.Fatal(err)
+		}
+		if c.characterName() != fmt.Sprintf("player%d", i+1) {
+			t.Errorf("expected the name from the character data, got %q", c.characterName())
+		}
+		players = append(players, c)
+		packets = append(packets, pkts)
+	}
+
+	const entrySize = 0x20 + classicInventorySize + classicDispDataSize
+	// Returns the guildcards of the players in a lobby join.
+	nextJoin := func(pkts <-chan []byte, pktType uint8) []uint32 {
+		t.Helper()
+		var data []byte
+		select {
+		case data = <-pkts:
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for a packet")
+		}
+		var pkt ClassicLobbyJoinPacket
+		util.StructFromBytes(data[PCHeaderSize:], &pkt)
+		if data[0] != pktType || int(data[1])*entrySize != len(pkt.Players) {
+			t.Fatalf("got packet %02x with %d players and %d bytes of them", data[0], data[1], len(pkt.Players))
+		}
+		var guildcards []uint32
+		for offset := 0; offset < len(pkt.Players); offset += entrySize {
+			var hdr ClassicPlayerHeader
+			util.StructFromBytes(pkt.Players[offset:], &hdr)
+			guildcards = append(guildcards, hdr.Guildcard)
+		}
+		return guildcards
+	}
+	if joined := nextJoin(packets[0], LobbyJoinType); fmt.Sprint(joined) != "[1]" {
+		t.Errorf("first player joined a lobby with %v", joined)
+	}
+	if joined := nextJoin(packets[1], LobbyJoinType); fmt.Sprint(joined) != "[1 2]" {
+		t.Errorf("second player joined a lobby with %v", joined)
+	}
+	if joined := nextJoin(packets[0], LobbyAddMemberType); fmt.Sprint(joined) != "[2]" {
+		t.Errorf("first player was told that %v joined", joined)
+	}
+	// Episode III players have their own lobbies.
+	if joined := nextJoin(packets[2], LobbyJoinType); fmt.Sprint(joined) != "[3]" {
+		t.Errorf("Episode III player joined a lobby with %v", joined)
+	}
+	if players[2].lobby != server.lobbies[VersionEp3][0] {
+		t.Error("Episode III player wasn't put in an Episode III lobby")
+	}
+
+	server.Disconnected(players[1])
+	select {
+	case data := <-packets[0]:
+		if data[0] != LobbyRemoveMemberType || data[1] != 1 {
+			t.Errorf("got packet %02x for client %d, expected the second player to leave", data[0], data[1])
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the leave notice")
+	}
+}
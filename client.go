@@ -27,18 +27,20 @@ import (
 	"github.com/dcrodman/archon/util"
 	"io"
 	"net"
-	"strings"
 	"sync"
+	"time"
 )
 
 // Client struct intended to be included as part of the client definitions
 // in each of the servers. This struct wraps the connection handling logic
 // used by Process() below to handle receiving packets.
 type Client struct {
-	conn   *net.TCPConn
+	conn   net.Conn
 	ipAddr string
 	port   string
 
+	// Version of the client, which determines the header layout.
+	version    ClientVersion
 	hdrSize    uint16
 	recvSize   int
 	packetSize uint16
@@ -47,6 +49,14 @@ type Client struct {
 	clientCrypt *crypto.PSOCrypt
 	serverCrypt *crypto.PSOCrypt
 
+	// Packets waiting to be written by the client's writer goroutine, which
+	// is the only one that touches serverCrypt once the client is created.
+	outbound   chan outboundPacket
+	writerDone chan struct{}
+	// Held for reading while queueing and for writing when closing the queue.
+	sendLock sync.RWMutex
+	closed   bool
+
 	guildcard uint32
 	teamId    uint32
 	isGm      bool
@@ -58,37 +68,293 @@ type Client struct {
 	gcDataSize uint16
 	config     ClientConfig
 	flag       uint32
+	// How far the client has made it through the login flow on this connection.
+	phase loginPhase
+	// Character the player picked, once they've connected to a block.
+	character *CharacterPreview
+	// Lobby or game the player is in and their id within it.
+	lobby    *Lobby
+	game     *Game
+	clientId uint8
+	// Character's meseta and items as of the last time they were saved.
+	inventory *Inventory
+	// Items in the last shop the player opened.
+	shop     []ItemData
+	shopType uint8
+	// Weapon the tekker identified that the player hasn't accepted yet.
+	identified *ItemData
+	// Config blob the PC, Dreamcast, and Gamecube clients hold on to for us.
+	classicConfig [0x20]byte
+	// Character the classic clients send when they log in to a block, which
+	// is passed along to the other players in their lobby.
+	classicChar *ClassicCharDataPacket
+
+	// Connection timeout state.
+	connCfg       ConnectionConfig
+	connectedAt   time.Time
+	lastRecv      time.Time
+	handshakeDone bool
+	pingSent      bool
+	// Set once the client has been sent on to another server, so that its
+	// disconnecting from this one doesn't end the session.
+	redirected bool
+
+	// Number of malformed or invalid packets received.
+	misbehaviorCount int
+	// Per packet type rate limits; see RateLimit.
+	packetLimits map[uint16]*tokenBucket
+
+	// Server the client is connected to and, if they're being captured,
+	// where their packets are recorded.
+	server  Server
+	capture *packetCapture
+	// Packets sent before capturing started; see capturePacket.
+	earlyCapture []*CaptureRecord
+	captureLock  sync.Mutex
 }
 
-func NewClient(conn *net.TCPConn, hdrSize uint16, cCrypt, sCrypt *crypto.PSOCrypt) *Client {
-	addr := strings.Split(conn.RemoteAddr().String(), ":")
+func NewClient(conn net.Conn, hdrSize uint16, cCrypt, sCrypt *crypto.PSOCrypt) *Client {
+	host, port, _ := net.SplitHostPort(conn.RemoteAddr().String())
 	c := &Client{
 		conn:        conn,
-		ipAddr:      addr[0],
-		port:        addr[1],
+		ipAddr:      host,
+		port:        port,
 		hdrSize:     hdrSize,
 		clientCrypt: cCrypt,
 		serverCrypt: sCrypt,
 		buffer:      make([]byte, 512),
+		connectedAt: time.Now(),
+		outbound:    make(chan outboundPacket, config.OutboundQueueSize),
+		writerDone:  make(chan struct{}),
 	}
+	go c.writeLoop()
 	return c
 }
 
 func (c *Client) IPAddr() string { return c.ipAddr }
 
+// Address of this server that the client should be redirected to.
+func (c *Client) RedirectAddr() [4]byte {
+	return config.RedirectAddr(net.ParseIP(c.ipAddr))
+}
+
 func (c *Client) ClientVector() []uint8 { return c.clientCrypt.Vector }
 
 func (c *Client) ServerVector() []uint8 { return c.serverCrypt.Vector }
 
-func (c *Client) Data() []byte { return c.buffer }
+// Returns the most recently received packet.
+func (c *Client) Data() []byte { return c.buffer[:c.packetSize] }
+
+// Returns the type of the packet in data based on the client's header layout.
+func (c *Client) packetType(data []byte) uint16 {
+	switch {
+	case c.version == VersionBB:
+		// BB and the patch server both use two byte types.
+		return uint16(data[2]) | uint16(data[3])<<8
+	case c.version == VersionPC:
+		return uint16(data[2])
+	}
+	return uint16(data[0])
+}
+
+// Returns the flags from the header of the packet in data, for versions
+// with four byte headers.
+func (c *Client) packetFlags(data []byte) uint8 {
+	if c.version == VersionPC {
+		return data[3]
+	}
+	return data[1]
+}
+
+// Close the connection once anything queued for the client has been sent
+// or closeFlushTimeout has passed, whichever comes first.
+func (c *Client) Close() {
+	c.sendLock.Lock()
+	if !c.closed {
+		c.closed = true
+		close(c.outbound)
+	}
+	c.sendLock.Unlock()
+
+	select {
+	case <-c.writerDone:
+	case <-time.After(closeFlushTimeout):
+	}
+	c.stopCapture()
+	c.conn.Close()
+}
+
+// How long Close waits for queued packets to be written.
+const closeFlushTimeout = 5 * time.Second
+
+// Packet waiting in a client's outbound queue. The write timeout is looked
+// up when it's queued so that the writer doesn't need to touch connCfg.
+type outboundPacket struct {
+	data    []byte
+	encrypt bool
+	timeout time.Duration
+}
+
+var errClientClosed = errors.New("connection closed")
+
+// Add a copy of data to the client's outbound queue, encrypting it with the
+// server cipher before it's sent if encrypt is true. If the queue is full and
+// wait is set then this blocks until there's room or the write timeout passes,
+// otherwise the client is disconnected right away for falling behind.
+func (c *Client) queue(data []byte, encrypt, wait bool) error {
+	c.sendLock.RLock()
+	defer c.sendLock.RUnlock()
+	if c.closed {
+		return errClientClosed
+	}
+	c.capturePacket(captureOut, data, !encrypt)
+	pkt := outboundPacket{
+		data:    append([]byte(nil), data...),
+		encrypt: encrypt,
+		timeout: seconds(c.connCfg.WriteTimeout),
+	}
+	select {
+	case c.outbound <- pkt:
+		return nil
+	default:
+	}
+	if wait {
+		var timeout <-chan time.Time
+		if pkt.timeout > 0 {
+			timer := time.NewTimer(pkt.timeout)
+			defer timer.Stop()
+			timeout = timer.C
+		}
+		select {
+		case c.outbound <- pkt:
+			return nil
+		case <-timeout:
+		}
+	}
+	// Closing the socket ends the client's read loop, which cleans up after them.
+	c.conn.Close()
+	return fmt.Errorf("Outbound queue full (%s): %d packets waiting", c.ipAddr, len(c.outbound))
+}
 
-func (c *Client) Close() { c.conn.Close() }
+// Write packets from the outbound queue to the socket until the queue is
+// closed. Anything queued after a failed write is discarded.
+func (c *Client) writeLoop() {
+	defer close(c.writerDone)
+	var err error
+	for pkt := range c.outbound {
+		if err != nil {
+			continue
+		}
+		if pkt.encrypt {
+			c.Encrypt(pkt.data, uint32(len(pkt.data)))
+		}
+		if err = c.write(pkt); err != nil {
+			log.Infof("Error sending to client %v: %s", c.IPAddr(), err.Error())
+			c.conn.Close()
+		}
+	}
+}
 
-func (c *Client) Send(data []byte) error {
-	_, err := c.conn.Write(data)
+func (c *Client) write(pkt outboundPacket) error {
+	if pkt.timeout > 0 {
+		c.conn.SetWriteDeadline(time.Now().Add(pkt.timeout))
+	}
+	_, err := c.conn.Write(pkt.data)
 	return err
 }
 
+// Mark the client as having made it through the login process, after
+// which the idle timeout applies instead of the handshake timeout.
+func (c *Client) CompleteHandshake() {
+	c.handshakeDone = true
+}
+
+// Set the deadline for the next read. If startOfPacket is true then we're
+// waiting on a new packet and the handshake, idle, or keepalive deadline
+// applies, otherwise the rest of the current packet should arrive within
+// the read timeout.
+func (c *Client) setReadDeadline(startOfPacket bool) {
+	var deadline time.Time
+	if !startOfPacket {
+		if timeout := seconds(c.connCfg.ReadTimeout); timeout > 0 {
+			deadline = time.Now().Add(timeout)
+		}
+	} else if !c.handshakeDone {
+		if timeout := seconds(c.connCfg.HandshakeTimeout); timeout > 0 {
+			deadline = c.connectedAt.Add(timeout)
+		}
+	} else {
+		last := c.lastRecv
+		if last.IsZero() {
+			last = c.connectedAt
+		}
+		if timeout := seconds(c.connCfg.IdleTimeout); timeout > 0 {
+			deadline = last.Add(timeout)
+		}
+		if interval := seconds(c.connCfg.KeepAliveInterval); interval > 0 && !c.pingSent {
+			if ping := last.Add(interval); deadline.IsZero() || ping.Before(deadline) {
+				deadline = ping
+			}
+		}
+	}
+	c.conn.SetReadDeadline(deadline)
+}
+
+// Handle a read deadline expiring. Returns nil if the client was sent a
+// keepalive ping and we should keep waiting, otherwise an error with the
+// reason for disconnecting them.
+func (c *Client) handleReadTimeout(startOfPacket bool) error {
+	switch {
+	case !startOfPacket:
+		return errors.New("Read timeout (" + c.ipAddr + "): packet incomplete")
+	case !c.handshakeDone:
+		return errors.New("Handshake timeout (" + c.ipAddr + "): login not completed")
+	case c.connCfg.KeepAliveInterval > 0 && !c.pingSent:
+		c.pingSent = true
+		c.SendPing()
+		return nil
+	default:
+		return errors.New("Idle timeout (" + c.ipAddr + "): no packets received")
+	}
+}
+
+// Error returned by handlers when a client sends a packet that should be
+// ignored without dropping the connection.
+type droppedPacketError struct {
+	reason string
+}
+
+func (e *droppedPacketError) Error() string { return e.reason }
+
+// Record a malformed or otherwise invalid packet from the client. The returned
+// error should be passed back to the dispatcher; it will drop the packet until
+// the client exceeds MaxMisbehavior, after which it will disconnect them.
+func (c *Client) Misbehave(reason string) error {
+	c.misbehaviorCount++
+	if max := c.connCfg.MaxMisbehavior; max > 0 && c.misbehaviorCount >= max {
+		return fmt.Errorf("Disconnecting %s after %d invalid packets; last: %s",
+			c.ipAddr, c.misbehaviorCount, reason)
+	}
+	return &droppedPacketError{
+		reason: fmt.Sprintf("Dropped packet from %s: %s", c.ipAddr, reason),
+	}
+}
+
+// Populate the struct pointed to by pkt from the most recently received packet,
+// treating a packet that's too short to fill it as misbehavior.
+func (c *Client) ReadPacket(pkt interface{}) error {
+	if err := util.StructFromBytes(c.Data(), pkt); err != nil {
+		return c.Misbehave(err.Error())
+	}
+	return nil
+}
+
+// Returns true if err was caused by a read or write deadline expiring.
+func isTimeout(err error) bool {
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
+
 func (c *Client) Encrypt(data []byte, size uint32) {
 	c.serverCrypt.Encrypt(data, size)
 }
@@ -105,12 +371,18 @@ func (c *Client) Process() error {
 
 	// Wait for the packet header.
 	for c.recvSize < hdrint {
+		startOfPacket := c.recvSize == 0
+		c.setReadDeadline(startOfPacket)
 		bytes, err := c.conn.Read(c.buffer[c.recvSize:c.hdrSize])
-		if bytes == 0 || err == io.EOF {
+		if isTimeout(err) {
+			if err = c.handleReadTimeout(startOfPacket); err != nil {
+				return err
+			}
+			continue
+		} else if bytes == 0 || err == io.EOF {
 			// The client disconnected, we're done.
 			return err
 		} else if err != nil {
-			fmt.Println("Sockt error")
 			// Socket error, nothing we can do now.
 			return errors.New("Socket Error (" + c.ipAddr + ") " + err.Error())
 		}
@@ -119,10 +391,18 @@ func (c *Client) Process() error {
 		if c.recvSize >= hdrint {
 			// We have our header; decrypt it.
 			c.Decrypt(c.buffer[:c.hdrSize], uint32(c.hdrSize))
-			c.packetSize, err = util.GetPacketSize(c.buffer[:2])
+			sizeOff := c.version.sizeOffset()
+			c.packetSize, err = util.GetPacketSize(c.buffer[sizeOff : sizeOff+2])
 			if err != nil {
-				// Something is seriously wrong if this causes an error. Bail.
-				panic(err.Error())
+				return errors.New("Malformed header (" + c.ipAddr + "): " + err.Error())
+			}
+			if c.packetSize < c.hdrSize {
+				return fmt.Errorf("Malformed header (%s): declared size %d is "+
+					"smaller than the header", c.ipAddr, c.packetSize)
+			}
+			if max := c.connCfg.MaxPacketSize; max > 0 && int(c.packetSize) > max {
+				return fmt.Errorf("Packet too large (%s): %d bytes exceeds the "+
+					"maximum of %d", c.ipAddr, c.packetSize, max)
 			}
 			// PSO likes to occasionally send us packets that are longer than their declared
 			// size, but are always a multiple of the length of the packet header. Adjust the
@@ -144,18 +424,24 @@ func (c *Client) Process() error {
 
 	// Read in the rest of the packet.
 	for c.recvSize < pktSize {
+		c.setReadDeadline(false)
 		remaining := pktSize - c.recvSize
 		bytes, err := c.conn.Read(c.buffer[c.recvSize : c.recvSize+remaining])
-		if err != nil {
+		if isTimeout(err) {
+			return c.handleReadTimeout(false)
+		} else if err != nil {
 			return errors.New("Socket Error (" + c.ipAddr + ") " + err.Error())
 		}
 		c.recvSize += bytes
 	}
+	c.lastRecv = time.Now()
+	c.pingSent = false
 
 	// We have the whole thing; decrypt the rest of it.
 	if c.packetSize > c.hdrSize {
 		c.Decrypt(c.buffer[c.hdrSize:c.packetSize], uint32(c.packetSize-c.hdrSize))
 	}
+	c.capturePacket(captureIn, c.Data(), false)
 	return nil
 }
 
@@ -181,17 +467,20 @@ func (cl *ConnList) Add(c *Client) {
 // Returns true if the list has a Client matching the IP address of c.
 // Note that this comparison is by IP address, not element value.
 func (cl *ConnList) Has(c *Client) bool {
-	found := false
-	clAddr := c.IPAddr()
+	return cl.CountIP(c.IPAddr()) > 0
+}
+
+// Returns the number of connected clients with the IP address ipAddr.
+func (cl *ConnList) CountIP(ipAddr string) int {
+	count := 0
 	cl.RLock()
 	for client := cl.clientList.Front(); client != nil; client = client.Next() {
-		if c.IPAddr() == clAddr {
-			found = true
-			break
+		if client.Value.(*Client).IPAddr() == ipAddr {
+			count++
 		}
 	}
 	cl.RUnlock()
-	return found
+	return count
 }
 
 func (cl *ConnList) Remove(c *Client) {
@@ -206,6 +495,26 @@ func (cl *ConnList) Remove(c *Client) {
 	cl.Unlock()
 }
 
+// Queue data for every client in the list for which match returns true (or all
+// of them if match is nil) without waiting on any of them. Clients that have
+// fallen too far behind are disconnected. Returns the number of clients the
+// packet was queued for.
+func (cl *ConnList) Broadcast(data []byte, match func(*Client) bool) int {
+	sent := 0
+	cl.RLock()
+	for client := cl.clientList.Front(); client != nil; client = client.Next() {
+		c := client.Value.(*Client)
+		if match != nil && !match(c) {
+			continue
+		}
+		if c.sendAsync(data) == 0 {
+			sent++
+		}
+	}
+	cl.RUnlock()
+	return sent
+}
+
 func (cl *ConnList) Count() int {
 	cl.RLock()
 	length := cl.size
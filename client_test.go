@@ -0,0 +1,147 @@
+/*
+* Archon PSO Server
+* Copyright (C) 2014 Andrew Rodman
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"encoding/binary"
+	crypto "github.com/dcrodman/archon/encryption"
+	"github.com/dcrodman/archon/util"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Create a client for one end of a pipe with room for queueSize packets.
+func newPipeClient(queueSize int) (*Client, net.Conn) {
+	defer func(size int) { config.OutboundQueueSize = size }(config.OutboundQueueSize)
+	config.OutboundQueueSize = queueSize
+
+	server, client := net.Pipe()
+	return NewClient(server, BBHeaderSize, crypto.NewBBCrypt(), crypto.NewBBCrypt()), client
+}
+
+// Decrypt the packets sent to c and pass them along.
+func readPackets(c *Client, conn net.Conn) <-chan []byte {
+	packets := make(chan []byte, 16)
+	go func() {
+		defer close(packets)
+		crypt := crypto.NewBBCryptWithVector(c.ServerVector())
+		for {
+			data := make([]byte, BBHeaderSize)
+			if _, err := io.ReadFull(conn, data); err != nil {
+				return
+			}
+			crypt.Decrypt(data, BBHeaderSize)
+			size := int(binary.LittleEndian.Uint16(data))
+			data = append(data, make([]byte, size-BBHeaderSize)...)
+			if _, err := io.ReadFull(conn, data[BBHeaderSize:]); err != nil {
+				return
+			}
+			crypt.Decrypt(data[BBHeaderSize:], uint32(size-BBHeaderSize))
+			packets <- data
+		}
+	}()
+	return packets
+}
+
+// Wait for the next packet sent to a client and parse it into pkt.
+func nextPacket(t *testing.T, packets <-chan []byte, pkt interface{}) {
+	t.Helper()
+	select {
+	case data := <-packets:
+		if err := util.StructFromBytes(data, pkt); err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for a packet")
+	}
+}
+
+// Handle pkt from c with handler as if c had sent it.
+func handlePacket(t *testing.T, c *Client, handler PacketHandler, pkt interface{}) {
+	t.Helper()
+	data, _ := util.BytesFromStruct(pkt)
+	c.packetSize = uint16(copy(c.buffer, data))
+	if err := handler(c); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Packets broadcast from several goroutines at once should all arrive intact
+// since only the writer goroutine touches the cipher.
+func TestConcurrentBroadcast(t *testing.T) {
+	const senders, perSender = 8, 20
+	c, client := newPipeClient(senders * perSender)
+	defer c.Close()
+	clients := NewClientList()
+	clients.Add(c)
+
+	var wg sync.WaitGroup
+	for i := 0; i < senders; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < perSender; j++ {
+				pkt := &BBHeader{Type: PingType, Flags: uint32(i)}
+				data, _ := util.BytesFromStruct(pkt)
+				if clients.Broadcast(data, nil) != 1 {
+					t.Error("broadcast wasn't queued")
+				}
+			}
+		}(i)
+	}
+
+	crypt := crypto.NewBBCryptWithVector(c.ServerVector())
+	buf := make([]byte, BBHeaderSize)
+	for n := 0; n < senders*perSender; n++ {
+		if _, err := io.ReadFull(client, buf); err != nil {
+			t.Fatal(err)
+		}
+		crypt.Decrypt(buf, BBHeaderSize)
+		var hdr BBHeader
+		util.StructFromBytes(buf, &hdr)
+		if hdr.Size != BBHeaderSize || hdr.Type != PingType || hdr.Flags >= senders {
+			t.Fatalf("packet %d corrupted: %+v", n, hdr)
+		}
+	}
+	wg.Wait()
+}
+
+// A client that stops reading should be disconnected once their queue fills
+// up rather than holding up whoever is broadcasting.
+func TestBroadcastOverflow(t *testing.T) {
+	c, client := newPipeClient(2)
+	defer c.Close()
+	clients := NewClientList()
+	clients.Add(c)
+
+	data, _ := util.BytesFromStruct(&BBHeader{Type: PingType})
+	overflowed := false
+	for i := 0; i < 4 && !overflowed; i++ {
+		overflowed = clients.Broadcast(data, nil) == 0
+	}
+	if !overflowed {
+		t.Fatal("queue never overflowed")
+	}
+	// The connection should have been closed on the server's end.
+	if _, err := io.ReadFull(client, make([]byte, 64)); err == nil {
+		t.Error("connection still open after overflowing")
+	}
+}
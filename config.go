@@ -26,20 +26,42 @@ import (
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"github.com/dcrodman/archon/util"
 	_ "github.com/go-sql-driver/mysql"
 	"io/ioutil"
+	"net"
+	"os"
 	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
+	"unicode"
 )
 
+// Prefix for environment variables that override config parameters.
+const EnvPrefix = "ARCHON_"
+
 // Configuration structure that can be shared between sub servers.
 // The fields are intentionally exported to cut down on verbosity
-// with the intent that they be considered immutable.
+// with the intent that they be considered immutable. Every exported
+// field not tagged with json:"-" can be set from the config file,
+// an ARCHON_* environment variable, or a command line flag.
 type Config struct {
+	// Address advertised to clients in redirect packets. Can be either an
+	// IPv4 address or a hostname that resolves to one.
 	Hostname string
+	// Address advertised instead of Hostname to clients connecting from one
+	// of LANNetworks, for servers that have both LAN and internet players.
+	LANHostname string
+	LANNetworks []string
+	// Local address on which to listen for connections. Defaults to all
+	// interfaces (IPv4 and IPv6) if left empty.
+	BindAddress string
 	// Patch ports.
 	PatchPort string
 	DataPort  string
@@ -49,28 +71,105 @@ type Config struct {
 	// Shipgate ports.
 	ShipgatePort string
 	WebPort      string
+	// Forward events (logins, new characters, etc.) to the ships connected
+	// to the shipgate so that they see what happens on this one.
+	ShipgateForwardEvents bool
+	// Key shared with the ships connected to the shipgate, which they sign
+	// each connection with to prove that they're ships. Required to forward
+	// events.
+	ShipgateSecret string
 	// Ship ports.
 	ShipPort string
 
+	// Versions besides Blue Burst to serve (any of PC, DC, and GC), each of
+	// which gets its own login, ship, and block servers.
+	ClassicVersions []string
+	// Login ports for the other versions. The Gamecube login server also
+	// listens on Ep3LoginPort for Episode III clients.
+	PCLoginPort  string
+	DCLoginPort  string
+	GCLoginPort  string
+	Ep3LoginPort string
+	// Ship ports for the other versions; their blocks use the ports after them.
+	PCShipPort string
+	DCShipPort string
+	GCShipPort string
+
+	// Days for which deleted characters are kept so that they can be restored.
+	CharacterRetentionDays int
+	// Number of characters an account can create in a day, or 0 for no limit.
+	MaxCharacterCreationsPerDay int
+	// Seconds a player has to wait between changes to a character in the
+	// dressing room, or 0 for no limit.
+	DressingRoomCooldown int
+	// Character name rules. Lengths don't include the language or color
+	// prefix and the pattern is a regular expression the rest of the name
+	// must match. Names containing any of the banned words or matching one
+	// of the reserved names (which only GMs can use) are rejected, ignoring
+	// case. Set UniqueCharacterNames to prevent duplicate names server-wide.
+	CharacterNameMinLength   int
+	CharacterNameMaxLength   int
+	CharacterNamePattern     string
+	CharacterNameBannedWords []string
+	CharacterNameReserved    []string
+	UniqueCharacterNames     bool
+
 	// Number of blocks to open on the ship server.
 	NumBlocks int
 	// Number of lobbies available per block.
 	NumLobbies     int
 	MaxConnections int
 
+	// Connection limiting. Rates of 0 disable the corresponding limit.
+	MaxConnectionsPerIP int
+	// Connections allowed per minute from a single address.
+	IPConnectionRate  int
+	IPConnectionBurst int
+	// Connections allowed per second across all addresses.
+	GlobalConnectionRate  int
+	GlobalConnectionBurst int
+	// Number of rate limited attempts from an address before it's blocked
+	// and the number of seconds for which it will be blocked.
+	FloodBlockThreshold int
+	FloodBlockDuration  int
+
+	// Connection settings for each type of sub-server (PATCH, DATA, LOGIN,
+	// CHARACTER, SHIPGATE, SHIP, BLOCK). Anything not set for a server
+	// type falls back to the "default" entry.
+	Connections map[string]*ConnectionConfig
+	// Number of packets that can be waiting to be sent to a client. Anyone
+	// who falls this far behind is disconnected.
+	OutboundQueueSize int
+	// Key used to sign the session tokens passed between the login, character,
+	// and ship servers. Must be the same for all of the servers if they're run
+	// separately; a random key is generated at startup if it's empty.
+	SessionSecret string
+	// Seconds for which a session token can be used to connect to the next
+	// server, or 0 for no limit.
+	SessionTimeout int
+
 	// Patch server welcome message.
 	WelcomeMessage string
 	// Scrolling message on ship select.
 	ScrollMessage string
-	MessageBytes  []byte
-	MessageSize   uint16
+	MessageBytes  []byte `json:"-"`
+	MessageSize   uint16 `json:"-"`
 
 	PatchDir      string
 	ParametersDir string
-	KeysDir       string
+	// Directory with the client's map files for loading the enemies in
+	// games; see enemies.go. Experience isn't given out without them, so
+	// leaving it empty (the default) turns it off.
+	MapsDir string
+	KeysDir string
+	// Directory of Lua scripts to load; scripting is disabled if it's empty.
+	ScriptsDir string
 
-	// Database parameters.
+	// Database parameters. DBDriver is either "mysql" or "sqlite3", in which
+	// case DBName is the path to the database file and the connection
+	// parameters are ignored. SQLite support requires the sqlite build tag.
 	database   *sql.DB
+	DBDriver   string
 	DBHost     string
 	DBPort     string
 	DBName     string
@@ -81,17 +180,35 @@ type Config struct {
 	LogLevel  string
 	DebugMode bool
 
+	// Decrypted packets to and from clients connecting from one of
+	// CaptureAddresses or logging into one of CaptureAccounts are written
+	// to a file per connection in CaptureDir. Empty to disable.
+	CaptureDir       string
+	CaptureAccounts  []string
+	CaptureAddresses []string
+
 	// Ship server config.
 	ShipName string
+	// Percentages of the usual prices that this ship's shops charge for items
+	// and pay for the items players sell to them.
+	ShopPricePercent     int
+	ShopSellPricePercent int
 
-	cachedHostBytes [4]byte
+	classicVersions []ClientVersion
+	hostAddr        [4]byte
+	lanHostAddr     [4]byte
+	lanNets         []*net.IPNet
 	cachedScrollMsg []byte
+	namePattern     *regexp.Regexp
 }
 
 // Singleton instance. Provides reasonable default values so
 // that some configurations can remain simpler.
 var config *Config = &Config{
-	Hostname:       "127.0.0.1",
+	Hostname: "127.0.0.1",
+	LANNetworks: []string{
+		"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "fd00::/8",
+	},
 	PatchPort:      "11000",
 	DataPort:       "11001",
 	LoginPort:      "12000",
@@ -99,21 +216,69 @@ var config *Config = &Config{
 	ShipgatePort:   "13000",
 	WebPort:        "14000",
 	ShipPort:       "15000",
+	PCLoginPort:    "9300",
+	DCLoginPort:    "9200",
+	GCLoginPort:    "9100",
+	Ep3LoginPort:   "9103",
+	PCShipPort:     "15100",
+	DCShipPort:     "15200",
+	GCShipPort:     "15300",
 	NumBlocks:      2,
 	NumLobbies:     15,
 	MaxConnections: 30000,
 
+	MaxConnectionsPerIP:   8,
+	IPConnectionRate:      60,
+	IPConnectionBurst:     20,
+	GlobalConnectionRate:  200,
+	GlobalConnectionBurst: 400,
+	FloodBlockThreshold:   10,
+	FloodBlockDuration:    300,
+
+	Connections: map[string]*ConnectionConfig{
+		"default": {
+			HandshakeTimeout: 30,
+			IdleTimeout:      900,
+			ReadTimeout:      30,
+			WriteTimeout:     30,
+			MaxPacketSize:    0x7C00,
+			MaxMisbehavior:   5,
+		},
+		"PATCH":     {MaxPacketSize: 0x100},
+		"DATA":      {MaxPacketSize: 0x100},
+		"LOGIN":     {MaxPacketSize: 0x400},
+		"CHARACTER": {MaxPacketSize: 0x1000},
+		"SHIP":      {KeepAliveInterval: 60},
+		"BLOCK":     {KeepAliveInterval: 60},
+	},
+	OutboundQueueSize: 256,
+	SessionTimeout:    3600,
+
+	CharacterRetentionDays:      7,
+	MaxCharacterCreationsPerDay: 8,
+	DressingRoomCooldown:        3600,
+	CharacterNameMinLength:      1,
+	CharacterNameMaxLength:      10,
+	CharacterNamePattern:        `^[^\x00-\x1F\x7F]+$`,
+	CharacterNameReserved:       []string{"GM", "Admin", "Administrator", "Moderator", "Server", "Archon"},
+
+	ShopPricePercent:     100,
+	ShopSellPricePercent: 100,
+
 	ShipName:       "Unconfigured",
 	WelcomeMessage: "Unconfigured Welcome Message",
 	ScrollMessage:  "Add a welcome message here",
 
 	PatchDir:      "patches/",
 	ParametersDir: "parameters/",
+	MapsDir:       "",
 	KeysDir:       "keys/",
+	ScriptsDir:    "",
 
-	DBHost: "127.0.0.1",
-	DBPort: "3306",
-	DBName: "archondb",
+	DBDriver: "mysql",
+	DBHost:   "127.0.0.1",
+	DBPort:   "3306",
+	DBName:   "archondb",
 
 	Logfile:   "",
 	LogLevel:  "warn",
@@ -122,6 +287,58 @@ var config *Config = &Config{
 
 func GetConfig() *Config { return config }
 
+// Timeouts applied to client connections, all in seconds. Zero values inherit
+// the value from the default entry and negative values disable the timeout.
+type ConnectionConfig struct {
+	// Time allowed between connecting and completing the login.
+	HandshakeTimeout int
+	// Time allowed between packets once logged in.
+	IdleTimeout int
+	// Time allowed for the rest of a packet to arrive once it's started.
+	ReadTimeout int
+	// Time allowed for a write to the client to complete.
+	WriteTimeout int
+	// Time since the last packet after which the client will be pinged.
+	KeepAliveInterval int
+	// Largest packet (in bytes) that a client may send.
+	MaxPacketSize int
+	// Number of malformed or invalid packets a client can send before
+	// they're disconnected.
+	MaxMisbehavior int
+}
+
+// Returns the connection settings for serverType with any unset
+// values filled in from the default entry.
+func (config *Config) ConnectionConfig(serverType string) ConnectionConfig {
+	var cfg ConnectionConfig
+	if def, ok := config.Connections["default"]; ok {
+		cfg = *def
+	}
+	if c, ok := config.Connections[serverType]; ok {
+		merge := func(val int, dst *int) {
+			if val != 0 {
+				*dst = val
+			}
+		}
+		merge(c.HandshakeTimeout, &cfg.HandshakeTimeout)
+		merge(c.IdleTimeout, &cfg.IdleTimeout)
+		merge(c.ReadTimeout, &cfg.ReadTimeout)
+		merge(c.WriteTimeout, &cfg.WriteTimeout)
+		merge(c.KeepAliveInterval, &cfg.KeepAliveInterval)
+		merge(c.MaxPacketSize, &cfg.MaxPacketSize)
+		merge(c.MaxMisbehavior, &cfg.MaxMisbehavior)
+	}
+	return cfg
+}
+
+// Converts a timeout from the config into a duration, or 0 if it's disabled.
+func seconds(n int) time.Duration {
+	if n <= 0 {
+		return 0
+	}
+	return time.Duration(n) * time.Second
+}
+
 // Populate config with the contents of a JSON file at path fileName. Config parameters
 // in the file must match the above fields exactly in order to be read.
 func (config *Config) InitFromFile(fileName string) error {
@@ -129,8 +346,75 @@ func (config *Config) InitFromFile(fileName string) error {
 	if err != nil {
 		return err
 	}
-	json.Unmarshal(data, config)
+	// The Connections entries are merged into the defaults like they are from
+	// the environment and flags, rather than replacing them outright.
+	var file struct {
+		Connections map[string]json.RawMessage
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return err
+	}
+	connections := config.Connections
+	config.Connections = nil
+	if err := json.Unmarshal(data, config); err != nil {
+		return err
+	}
+	config.Connections = connections
+	if err := mergeEntries(reflect.ValueOf(&config.Connections).Elem(), file.Connections); err != nil {
+		return fmt.Errorf("Connections: %s", err)
+	}
+	return nil
+}
 
+// Override any config parameters for which an environment variable is set. The
+// variable names are the field names in upper snake case prefixed with EnvPrefix,
+// e.g. DBPassword can be set with ARCHON_DB_PASSWORD.
+func (config *Config) InitFromEnv() error {
+	for _, field := range configFields() {
+		value, ok := os.LookupEnv(EnvPrefix + field.envName)
+		if !ok {
+			continue
+		}
+		if err := config.setField(field.name, value); err != nil {
+			return fmt.Errorf("%s: %s", EnvPrefix+field.envName, err)
+		}
+	}
+	return nil
+}
+
+// Register a command line flag for each config parameter on fs. The flag names
+// are the field names in kebab case, e.g. DBPassword becomes --db-password.
+func (config *Config) RegisterFlags(fs *flag.FlagSet) {
+	for _, field := range configFields() {
+		fs.Var(&configFlag{isBool: field.kind == reflect.Bool}, field.flagName,
+			fmt.Sprintf("Override the %s config parameter", field.name))
+	}
+}
+
+// Override any config parameters that were explicitly set on the command line.
+// Should be called after fs has been parsed and any other sources have been read
+// so that flags take precedence over everything else.
+func (config *Config) InitFromFlags(fs *flag.FlagSet) error {
+	fieldsByFlag := make(map[string]configField)
+	for _, field := range configFields() {
+		fieldsByFlag[field.flagName] = field
+	}
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		field, ok := fieldsByFlag[f.Name]
+		if !ok || err != nil {
+			return
+		}
+		if setErr := config.setField(field.name, f.Value.String()); setErr != nil {
+			err = fmt.Errorf("--%s: %s", f.Name, setErr)
+		}
+	})
+	return err
+}
+
+// Compute and cache any values derived from the config parameters. Must be called
+// once all of the config sources have been applied.
+func (config *Config) Finalize() error {
 	// Convert the welcome message to UTF-16LE and cache it.
 	config.MessageBytes = util.ConvertToUtf16(config.WelcomeMessage)
 	// PSOBB expects this prefix to the message, not completely sure why. Language perhaps?
@@ -147,16 +431,86 @@ func (config *Config) InitFromFile(fileName string) error {
 	if strings.HasSuffix(config.PatchDir, "/") {
 		config.PatchDir = filepath.Dir(config.PatchDir)
 	}
+
+	if config.OutboundQueueSize < 1 {
+		return errors.New("OutboundQueueSize must be at least 1")
+	}
+
+	if config.CharacterRetentionDays < 0 || config.MaxCharacterCreationsPerDay < 0 ||
+		config.DressingRoomCooldown < 0 {
+		return errors.New("CharacterRetentionDays, MaxCharacterCreationsPerDay, and " +
+			"DressingRoomCooldown must not be negative")
+	}
+	if config.CharacterNameMinLength > config.CharacterNameMaxLength {
+		return errors.New("CharacterNameMinLength must not be more than CharacterNameMaxLength")
+	}
+	config.namePattern = nil
+	if config.CharacterNamePattern != "" {
+		var err error
+		if config.namePattern, err = regexp.Compile(config.CharacterNamePattern); err != nil {
+			return fmt.Errorf("Invalid CharacterNamePattern: %s", err)
+		}
+	}
+	if config.ShipgateForwardEvents && config.ShipgateSecret == "" {
+		return errors.New("ShipgateSecret must be set to forward events")
+	}
+	if config.SessionTimeout < 0 {
+		return errors.New("SessionTimeout must not be negative")
+	}
+	if config.ShopPricePercent <= 0 || config.ShopSellPricePercent < 0 {
+		return errors.New("ShopPricePercent must be positive and ShopSellPricePercent must not be negative")
+	}
+	if err := initSessionKey(config.SessionSecret); err != nil {
+		return err
+	}
+
+	config.classicVersions = nil
+	for _, name := range config.ClassicVersions {
+		v, err := parseClientVersion(name)
+		if err != nil || v == VersionBB || v == VersionEp3 {
+			return errors.New("Classic versions must be PC, DC, or GC, got: " + name)
+		}
+		config.classicVersions = append(config.classicVersions, v)
+	}
+
+	// Resolve the advertised addresses up front so that we aren't doing
+	// DNS lookups every time a client needs to be redirected.
+	var err error
+	if config.hostAddr, err = resolveIPv4(config.Hostname); err != nil {
+		return err
+	}
+	config.lanHostAddr = config.hostAddr
+	if config.LANHostname != "" {
+		if config.lanHostAddr, err = resolveIPv4(config.LANHostname); err != nil {
+			return err
+		}
+	}
+	config.lanNets = nil
+	for _, cidr := range config.LANNetworks {
+		_, network, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			return errors.New("Invalid LAN network: " + cidr)
+		}
+		config.lanNets = append(config.lanNets, network)
+	}
 	return nil
 }
 
 // Establish a connection to the database and ping it to verify.
 func (config *Config) InitDb() error {
-	dbName := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s", config.DBUsername,
-		config.DBPassword, config.DBHost, config.DBPort, config.DBName)
+	var dbName string
+	switch config.DBDriver {
+	case "mysql":
+		dbName = fmt.Sprintf("%s:%s@tcp(%s:%s)/%s", config.DBUsername,
+			config.DBPassword, config.DBHost, config.DBPort, config.DBName)
+	case "sqlite3":
+		dbName = config.DBName
+	default:
+		return fmt.Errorf("unsupported database driver %q", config.DBDriver)
+	}
 
 	var err error
-	config.database, err = sql.Open("mysql", dbName)
+	config.database, err = sql.Open(config.DBDriver, dbName)
 	if err == nil {
 		err = config.database.Ping()
 	}
@@ -178,18 +532,59 @@ func (config *Config) DB() *sql.DB {
 	return config.database
 }
 
-// Convert the hostname string into 4 bytes to be used with the redirect packet.
-func (config *Config) HostnameBytes() [4]byte {
-	// Hacky, but chances are the IP address isn't going to start with 0 and a
-	// fixed-length array can't be null.
-	if config.cachedHostBytes[0] == 0x00 {
-		parts := strings.Split(config.Hostname, ".")
-		for i := 0; i < 4; i++ {
-			tmp, _ := strconv.ParseUint(parts[i], 10, 8)
-			config.cachedHostBytes[i] = uint8(tmp)
+// Returns the address that should be sent in redirect packets to a client
+// connecting from clientIP. Clients on the same machine or one of the LAN
+// networks are given the local address instead of the public one.
+func (config *Config) RedirectAddr(clientIP net.IP) [4]byte {
+	if clientIP == nil {
+		return config.hostAddr
+	}
+	if clientIP.IsLoopback() && config.listensOnLoopback() {
+		return [4]byte{127, 0, 0, 1}
+	}
+	if config.LANHostname != "" {
+		for _, network := range config.lanNets {
+			if network.Contains(clientIP) {
+				return config.lanHostAddr
+			}
 		}
 	}
-	return config.cachedHostBytes
+	return config.hostAddr
+}
+
+// Returns true if the sockets we listen on are reachable over loopback.
+func (config *Config) listensOnLoopback() bool {
+	if config.BindAddress == "" {
+		return true
+	}
+	ip := net.ParseIP(config.BindAddress)
+	return ip != nil && (ip.IsUnspecified() || ip.IsLoopback())
+}
+
+// Returns the host:port address on which a sub-server should listen.
+func (config *Config) ListenAddr(port string) string {
+	return net.JoinHostPort(config.BindAddress, port)
+}
+
+// Convert host (either an IP address or DNS name) into the four bytes that are
+// used by the redirect packets. The clients only support IPv4 addresses, so
+// hostnames must resolve to at least one A record.
+func resolveIPv4(host string) ([4]byte, error) {
+	var addr [4]byte
+	ips := []net.IP{net.ParseIP(host)}
+	if ips[0] == nil {
+		var err error
+		if ips, err = net.LookupIP(host); err != nil {
+			return addr, fmt.Errorf("Failed to resolve %s: %s", host, err)
+		}
+	}
+	for _, ip := range ips {
+		if ip4 := ip.To4(); ip4 != nil {
+			copy(addr[:], ip4)
+			return addr, nil
+		}
+	}
+	return addr, errors.New("No IPv4 address found for " + host)
 }
 
 // Returns the configured scroll message for the login server.
@@ -203,27 +598,240 @@ func (config *Config) String() string {
 		outfile = "Standard Out"
 	}
 	return "Hostname: " + config.Hostname + "\n" +
+		"LAN Hostname: " + config.LANHostname + "\n" +
+		"LAN Networks: " + strings.Join(config.LANNetworks, ",") + "\n" +
+		"Bind Address: " + config.BindAddress + "\n" +
 		"Patch Port: " + config.PatchPort + "\n" +
 		"Data Port: " + config.DataPort + "\n" +
 		"Login Port: " + config.LoginPort + "\n" +
 		"Character Port: " + config.CharacterPort + "\n" +
 		"Shipgate Port: " + config.ShipgatePort + "\n" +
+		"Shipgate Forward Events: " + strconv.FormatBool(config.ShipgateForwardEvents) + "\n" +
+		"Shipgate Secret: " + redact(config.ShipgateSecret) + "\n" +
 		"Web Port: " + config.WebPort + "\n" +
 		"Ship Port: " + config.ShipPort + "\n" +
+		"Classic Versions: " + strings.Join(config.ClassicVersions, ",") + "\n" +
+		"PC Login/Ship Ports: " + config.PCLoginPort + "/" + config.PCShipPort + "\n" +
+		"DC Login/Ship Ports: " + config.DCLoginPort + "/" + config.DCShipPort + "\n" +
+		"GC Login/Ship Ports: " + config.GCLoginPort + "," + config.Ep3LoginPort + "/" + config.GCShipPort + "\n" +
+		"Character Retention (days): " + strconv.Itoa(config.CharacterRetentionDays) + "\n" +
+		"Max Character Creations Per Day: " + strconv.Itoa(config.MaxCharacterCreationsPerDay) + "\n" +
+		"Dressing Room Cooldown (sec): " + strconv.Itoa(config.DressingRoomCooldown) + "\n" +
+		"Character Name Length: " + strconv.Itoa(config.CharacterNameMinLength) + "-" + strconv.Itoa(config.CharacterNameMaxLength) + "\n" +
+		"Character Name Pattern: " + config.CharacterNamePattern + "\n" +
+		"Character Name Banned Words: " + strings.Join(config.CharacterNameBannedWords, ",") + "\n" +
+		"Character Name Reserved: " + strings.Join(config.CharacterNameReserved, ",") + "\n" +
+		"Unique Character Names: " + strconv.FormatBool(config.UniqueCharacterNames) + "\n" +
 		"Num Ship Blocks: " + strconv.FormatInt(int64(config.NumBlocks), 10) + "\n" +
 		"Num Lobbies: " + strconv.FormatInt(int64(config.NumLobbies), 10) + "\n" +
 		"Max Connections: " + strconv.FormatInt(int64(config.MaxConnections), 10) + "\n" +
+		"Max Connections Per IP: " + strconv.Itoa(config.MaxConnectionsPerIP) + "\n" +
+		"IP Connection Rate (per min): " + strconv.Itoa(config.IPConnectionRate) + "\n" +
+		"IP Connection Burst: " + strconv.Itoa(config.IPConnectionBurst) + "\n" +
+		"Global Connection Rate (per sec): " + strconv.Itoa(config.GlobalConnectionRate) + "\n" +
+		"Global Connection Burst: " + strconv.Itoa(config.GlobalConnectionBurst) + "\n" +
+		"Flood Block Threshold: " + strconv.Itoa(config.FloodBlockThreshold) + "\n" +
+		"Flood Block Duration (sec): " + strconv.Itoa(config.FloodBlockDuration) + "\n" +
+		config.connectionsString() +
+		"Outbound Queue Size: " + strconv.Itoa(config.OutboundQueueSize) + "\n" +
+		"Session Secret: " + redact(config.SessionSecret) + "\n" +
+		"Session Timeout (sec): " + strconv.Itoa(config.SessionTimeout) + "\n" +
 		"Ship Name: " + config.ShipName + "\n" +
+		"Shop Price Percent: " + strconv.Itoa(config.ShopPricePercent) + "\n" +
+		"Shop Sell Price Percent: " + strconv.Itoa(config.ShopSellPricePercent) + "\n" +
 		"Welcome Message: " + config.WelcomeMessage + "\n" +
+		"Scroll Message: " + config.ScrollMessage + "\n" +
 		"Parameters Directory: " + config.ParametersDir + "\n" +
+		"Maps Directory: " + config.MapsDir + "\n" +
 		"Patch Directory: " + config.PatchDir + "\n" +
 		"Keys Directory: " + config.KeysDir + "\n" +
+		"Scripts Directory: " + config.ScriptsDir + "\n" +
+		"Database Driver: " + config.DBDriver + "\n" +
 		"Database Host: " + config.DBHost + "\n" +
 		"Database Port: " + config.DBPort + "\n" +
 		"Database Name: " + config.DBName + "\n" +
 		"Database Username: " + config.DBUsername + "\n" +
-		"Database Password: " + config.DBPassword + "\n" +
+		"Database Password: " + redact(config.DBPassword) + "\n" +
 		"Output Logged To: " + outfile + "\n" +
 		"Logging Level: " + config.LogLevel + "\n" +
-		"Debug Mode Enabled: " + strconv.FormatBool(config.DebugMode)
+		"Debug Mode Enabled: " + strconv.FormatBool(config.DebugMode) + "\n" +
+		"Capture Directory: " + config.CaptureDir + "\n" +
+		"Capture Accounts: " + strings.Join(config.CaptureAccounts, ",") + "\n" +
+		"Capture Addresses: " + strings.Join(config.CaptureAddresses, ",")
+}
+
+// Server types that the Connections settings apply to.
+var connectionServerTypes = []string{"PATCH", "DATA", "LOGIN", "CHARACTER", "SHIP", "BLOCK", "SHIPGATE"}
+
+// Returns a line for each type of server with the connection settings it
+// ends up with after merging in the defaults, along with any other entries
+// in Connections.
+func (config *Config) connectionsString() string {
+	known := map[string]bool{"default": true}
+	for _, t := range connectionServerTypes {
+		known[t] = true
+	}
+	var others []string
+	for name := range config.Connections {
+		if !known[name] {
+			others = append(others, name)
+		}
+	}
+	sort.Strings(others)
+	types := append(append([]string(nil), connectionServerTypes...), others...)
+	var b strings.Builder
+	for _, t := range types {
+		fmt.Fprintf(&b, "Connections (%s): %+v\n", t, config.ConnectionConfig(t))
+	}
+	return b.String()
+}
+
+// Hide sensitive values when displaying the configuration.
+func redact(value string) string {
+	if value == "" {
+		return ""
+	}
+	return "********"
+}
+
+// Metadata for a config parameter that can be overridden.
+type configField struct {
+	name     string
+	kind     reflect.Kind
+	envName  string
+	flagName string
+}
+
+// Returns the config parameters that can be set from the environment or command
+// line. Unexported fields and those excluded from the config file are skipped.
+func configFields() []configField {
+	var fields []configField
+	t := reflect.TypeOf(Config{})
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" || f.Tag.Get("json") == "-" {
+			continue
+		}
+		switch f.Type.Kind() {
+		case reflect.String, reflect.Int, reflect.Bool:
+		case reflect.Slice:
+			if f.Type.Elem().Kind() != reflect.String {
+				continue
+			}
+		case reflect.Map:
+			elem := f.Type.Elem()
+			if f.Type.Key().Kind() != reflect.String || elem.Kind() != reflect.Ptr ||
+				elem.Elem().Kind() != reflect.Struct {
+				continue
+			}
+		default:
+			continue
+		}
+		words := splitFieldName(f.Name)
+		fields = append(fields, configField{
+			name:     f.Name,
+			kind:     f.Type.Kind(),
+			envName:  strings.ToUpper(strings.Join(words, "_")),
+			flagName: strings.ToLower(strings.Join(words, "-")),
+		})
+	}
+	return fields
+}
+
+// Break a field name into its component words, keeping acronyms together
+// (e.g. "DBPassword" -> ["DB", "Password"]).
+func splitFieldName(name string) []string {
+	var words []string
+	runes := []rune(name)
+	start := 0
+	for i := 1; i < len(runes); i++ {
+		if !unicode.IsUpper(runes[i]) {
+			continue
+		}
+		prevLower := !unicode.IsUpper(runes[i-1])
+		nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+		if prevLower || nextLower {
+			words = append(words, string(runes[start:i]))
+			start = i
+		}
+	}
+	return append(words, string(runes[start:]))
 }
+
+// Parse value according to the type of the named field and set it.
+func (config *Config) setField(name, value string) error {
+	field := reflect.ValueOf(config).Elem().FieldByName(name)
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int:
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return errors.New("expected an integer, got " + value)
+		}
+		field.SetInt(int64(i))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return errors.New("expected true or false, got " + value)
+		}
+		field.SetBool(b)
+	case reflect.Slice:
+		// Lists are passed as comma-separated values.
+		var values []string
+		for _, v := range strings.Split(value, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+		field.Set(reflect.ValueOf(values))
+	case reflect.Map:
+		// Maps are passed as JSON objects whose entries are merged into the
+		// existing ones, e.g. {"BLOCK": {"IdleTimeout": 60}} only changes the
+		// block servers' idle timeout.
+		var entries map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(value), &entries); err != nil {
+			return errors.New("expected a JSON object, got " + value)
+		}
+		return mergeEntries(field, entries)
+	default:
+		return errors.New("unsupported config parameter " + name)
+	}
+	return nil
+}
+
+// Decode each of the entries into the matching element of field, a map of
+// struct pointers, leaving any values they don't set as they were.
+func mergeEntries(field reflect.Value, entries map[string]json.RawMessage) error {
+	if len(entries) > 0 && field.IsNil() {
+		field.Set(reflect.MakeMap(field.Type()))
+	}
+	for key, raw := range entries {
+		k := reflect.ValueOf(key)
+		elem := field.MapIndex(k)
+		if !elem.IsValid() || elem.IsNil() {
+			elem = reflect.New(field.Type().Elem().Elem())
+		}
+		if err := json.Unmarshal(raw, elem.Interface()); err != nil {
+			return fmt.Errorf("invalid entry %s: %s", key, err)
+		}
+		field.SetMapIndex(k, elem)
+	}
+	return nil
+}
+
+// flag.Value implementation that just records the raw string so that
+// the conversion can be done after all of the config sources are read.
+type configFlag struct {
+	value  string
+	isBool bool
+}
+
+func (f *configFlag) String() string { return f.value }
+
+func (f *configFlag) Set(value string) error {
+	f.value = value
+	return nil
+}
+
+func (f *configFlag) IsBoolFlag() bool { return f.isBool }
@@ -50,6 +50,12 @@ CREATE TABLE characters (
   proportion_x float,
   proportion_y float,
   name binary(24),
+  # Lowercase name without the color prefix for finding duplicates.
+  name_key varchar(24),
+  # Same as name_key while names have to be unique and the character hasn't
+  # been deleted, otherwise NULL. Unique so that two players creating
+  # characters at once can't both take the same name.
+  unique_name_key varchar(24),
   playtime int DEFAULT 0,
   # keyConfig binary(232),
   # techniques blob,
@@ -64,11 +70,22 @@ CREATE TABLE characters (
   meseta int,
   bank_use int DEFAULT 0,
   bank_meseta int DEFAULT 0,
+  # Data of the items in the inventory, one after another.
+  inventory blob,
+  # Unix timestamps; deleted_at is 0 unless the character has been deleted.
+  created_at bigint NOT NULL DEFAULT 0,
+  deleted_at bigint NOT NULL DEFAULT 0,
+  # Last time the character was changed in the dressing room.
+  modified_at bigint NOT NULL DEFAULT 0,
+  CHECK (slot_num BETWEEN 0 AND 3),
   FOREIGN KEY (guildcard) REFERENCES account_data(guildcard)
 );
 
 -- Keep an index to make queries from paket E3 fast.
-CREATE INDEX character_index ON characters(guildcard, slot_num);
+-- Unique so that restoring a deleted character brings back exactly one.
+CREATE UNIQUE INDEX character_index ON characters(guildcard, slot_num, deleted_at);
+CREATE INDEX character_name_index ON characters(name_key);
+CREATE UNIQUE INDEX character_unique_name_index ON characters(unique_name_key);
 
 CREATE TABLE guildcard_entries (
   guildcard int(11) PRIMARY KEY,
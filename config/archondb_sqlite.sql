@@ -0,0 +1,101 @@
+-- SQLite version of archondb.sql for use with DBDriver "sqlite3". Keep the
+-- two schemas in sync.
+
+DROP TABLE IF EXISTS account_data;
+CREATE TABLE account_data (
+  username varchar(17) NOT NULL,
+  password char(64) NOT NULL,
+  email varchar(255),
+  registration_date timestamp DEFAULT CURRENT_TIMESTAMP,
+  lastip varchar(16),
+  lasthwinfo blob,
+  guildcard integer PRIMARY KEY AUTOINCREMENT,
+  is_gm boolean DEFAULT 0,
+  is_banned boolean DEFAULT 0,
+  is_active boolean DEFAULT 0,
+  team_id integer NOT NULL DEFAULT -1,
+  privlevel smallint NOT NULL DEFAULT 0,
+  lastchar blob
+);
+
+-- Queried every time a user logs in.
+CREATE INDEX login_index ON account_data (username, password);
+
+CREATE TABLE player_options (
+  guildcard integer PRIMARY KEY,
+  key_config blob,
+  FOREIGN KEY (guildcard) REFERENCES account_data(guildcard)
+);
+
+CREATE TABLE characters (
+  guildcard integer,
+  slot_num tinyint,
+  experience integer DEFAULT 0,
+  level smallint DEFAULT 0,
+  guildcard_str blob,
+  name_color integer DEFAULT 4294967295,
+  model smallint,
+  name_color_chksm integer,
+  section_id tinyint,
+  char_class tinyint,
+  v2_flags tinyint,
+  version tinyint,
+  v1_flags integer,
+  costume smallint,
+  skin smallint,
+  face smallint,
+  head smallint,
+  hair smallint,
+  hair_red smallint,
+  hair_green smallint,
+  hair_blue smallint,
+  proportion_x float,
+  proportion_y float,
+  name blob,
+  -- Lowercase name without the color prefix for finding duplicates.
+  name_key varchar(24),
+  -- Same as name_key while names have to be unique and the character hasn't
+  -- been deleted, otherwise NULL. Unique so that two players creating
+  -- characters at once can't both take the same name.
+  unique_name_key varchar(24),
+  playtime integer DEFAULT 0,
+  atp smallint,
+  mst smallint,
+  evp smallint,
+  hp smallint,
+  dfp smallint,
+  ata smallint,
+  lck smallint,
+  meseta integer,
+  bank_use integer DEFAULT 0,
+  bank_meseta integer DEFAULT 0,
+  -- Data of the items in the inventory, one after another.
+  inventory blob,
+  -- Unix timestamps; deleted_at is 0 unless the character has been deleted.
+  created_at integer NOT NULL DEFAULT 0,
+  deleted_at integer NOT NULL DEFAULT 0,
+  -- Last time the character was changed in the dressing room.
+  modified_at integer NOT NULL DEFAULT 0,
+  CHECK (slot_num BETWEEN 0 AND 3),
+  FOREIGN KEY (guildcard) REFERENCES account_data(guildcard)
+);
+
+-- Keep an index to make queries from paket E3 fast.
+-- Unique so that restoring a deleted character brings back exactly one.
+CREATE UNIQUE INDEX character_index ON characters(guildcard, slot_num, deleted_at);
+CREATE INDEX character_name_index ON characters(name_key);
+CREATE UNIQUE INDEX character_unique_name_index ON characters(unique_name_key);
+
+CREATE TABLE guildcard_entries (
+  guildcard integer PRIMARY KEY,
+  friend_gc integer NOT NULL,
+  name blob,
+  team_name blob,
+  description blob,
+  language tinyint,
+  section_id tinyint,
+  char_class tinyint,
+  comment blob,
+  FOREIGN KEY (guildcard) REFERENCES account_data(guildcard),
+  FOREIGN KEY (friend_gc) REFERENCES account_data(guildcard)
+);
@@ -0,0 +1,24 @@
+-- Example Archon script. Copy it into the directory named by ScriptsDir
+-- and send the server SIGHUP to reload after making changes.
+
+-- Refuse logins from a specific account.
+archon.on("login", function(ev)
+	if ev.username == "guest" then
+		return false, "Guest logins are disabled."
+	end
+end)
+
+-- Log new characters.
+archon.on("character_create", function(ev)
+	archon.log("Guildcard " .. ev.guildcard .. " created " .. ev.name)
+end)
+
+-- Filter a word out of chat messages.
+archon.on("chat", function(ev)
+	ev.text = string.gsub(ev.text, "heck", "****")
+end)
+
+-- Chat commands are typed with a slash in front, e.g. /hello.
+archon.command("hello", function(player, args)
+	return "Hello, " .. player.name
+end)
@@ -0,0 +1,142 @@
+/*
+* Archon PSO Server
+* Copyright (C) 2014 Andrew Rodman
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestConfigFieldsFromEnv(t *testing.T) {
+	cfg := &Config{
+		Connections: map[string]*ConnectionConfig{
+			"BLOCK": {KeepAliveInterval: 60, IdleTimeout: 900},
+		},
+	}
+	env := map[string]string{
+		"ARCHON_CAPTURE_ACCOUNTS": " alice, bob ,,",
+		"ARCHON_CONNECTIONS":      `{"BLOCK": {"IdleTimeout": 600}, "SHIP": {"MaxPacketSize": 256}}`,
+	}
+	for k, v := range env {
+		os.Setenv(k, v)
+		defer os.Unsetenv(k)
+	}
+	if err := cfg.InitFromEnv(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cfg.CaptureAccounts, []string{"alice", "bob"}) {
+		t.Errorf("CaptureAccounts is %q", cfg.CaptureAccounts)
+	}
+	block := cfg.Connections["BLOCK"]
+	if block.IdleTimeout != 600 || block.KeepAliveInterval != 60 {
+		t.Errorf("BLOCK connection config not merged: %+v", block)
+	}
+	if ship := cfg.Connections["SHIP"]; ship == nil || ship.MaxPacketSize != 256 {
+		t.Errorf("SHIP connection config not added: %+v", ship)
+	}
+}
+
+func TestConfigConnectionsFlag(t *testing.T) {
+	cfg := &Config{}
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	cfg.RegisterFlags(fs)
+	if err := fs.Parse([]string{"--connections", `{"LOGIN": {"ReadTimeout": 5}}`}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.InitFromFlags(fs); err != nil {
+		t.Fatal(err)
+	}
+	if login := cfg.Connections["LOGIN"]; login == nil || login.ReadTimeout != 5 {
+		t.Errorf("LOGIN connection config not set: %+v", login)
+	}
+
+	fs = flag.NewFlagSet("test", flag.ContinueOnError)
+	cfg.RegisterFlags(fs)
+	fs.Parse([]string{"--connections", "BLOCK"})
+	if cfg.InitFromFlags(fs) == nil {
+		t.Error("expected invalid JSON to be rejected")
+	}
+}
+
+func TestConfigConnectionsFromFile(t *testing.T) {
+	cfg := &Config{
+		NumBlocks: 2,
+		Connections: map[string]*ConnectionConfig{
+			"default": {IdleTimeout: 900, MaxMisbehavior: 5},
+			"BLOCK":   {KeepAliveInterval: 60},
+		},
+	}
+	path := filepath.Join(t.TempDir(), "config.json")
+	data := `{"NumBlocks": 4, "Connections": {"default": {"IdleTimeout": 600}, "BLOCK": {"IdleTimeout": 300}, "LOGIN": {"ReadTimeout": 5}}}`
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.InitFromFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.NumBlocks != 4 {
+		t.Errorf("NumBlocks is %d", cfg.NumBlocks)
+	}
+	want := map[string]ConnectionConfig{
+		"default": {IdleTimeout: 600, MaxMisbehavior: 5},
+		"BLOCK":   {IdleTimeout: 300, KeepAliveInterval: 60},
+		"LOGIN":   {ReadTimeout: 5},
+	}
+	if len(cfg.Connections) != len(want) {
+		t.Errorf("got %d Connections entries, expected %d", len(cfg.Connections), len(want))
+	}
+	for name, w := range want {
+		if c := cfg.Connections[name]; c == nil || *c != w {
+			t.Errorf("%s connection config is %+v, expected %+v", name, c, w)
+		}
+	}
+}
+
+// The printed config should have every server's merged connection settings
+// and all of the values in lists.
+func TestConfigString(t *testing.T) {
+	cfg := &Config{
+		CharacterNameBannedWords: []string{"heck", "darn"},
+		Connections: map[string]*ConnectionConfig{
+			"default": {IdleTimeout: 900, MaxMisbehavior: 5},
+			"BLOCK":   {IdleTimeout: 300},
+			"CUSTOM":  {ReadTimeout: 5},
+		},
+	}
+	s := cfg.String()
+	for _, want := range []string{
+		"Character Name Banned Words: heck,darn\n",
+		"Connections (LOGIN): {HandshakeTimeout:0 IdleTimeout:900 ReadTimeout:0 WriteTimeout:0 " +
+			"KeepAliveInterval:0 MaxPacketSize:0 MaxMisbehavior:5}\n",
+		"Connections (BLOCK): {HandshakeTimeout:0 IdleTimeout:300 ReadTimeout:0 WriteTimeout:0 " +
+			"KeepAliveInterval:0 MaxPacketSize:0 MaxMisbehavior:5}\n",
+		"Connections (CUSTOM): {HandshakeTimeout:0 IdleTimeout:900 ReadTimeout:5 ",
+	} {
+		if !strings.Contains(s, want) {
+			t.Errorf("printed config is missing %q:\n%s", want, s)
+		}
+	}
+	if strings.Contains(s, "Connections (default)") {
+		t.Error("expected the defaults to only be shown merged into each server")
+	}
+}
@@ -0,0 +1,36 @@
+//go:build sqlite
+// +build sqlite
+
+/*
+* Archon PSO Server
+* Copyright (C) 2014 Andrew Rodman
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+* ---------------------------------------------------------------------
+* Registers the SQLite driver for running without a MySQL server, mostly
+* useful for development and end-to-end tests. Build with -tags sqlite
+* and set DBDriver to "sqlite3".
+ */
+package main
+
+import (
+	"github.com/mattn/go-sqlite3"
+)
+
+func init() {
+	duplicateKeyChecks = append(duplicateKeyChecks, func(err error) bool {
+		sqliteErr, ok := err.(sqlite3.Error)
+		return ok && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+	})
+}
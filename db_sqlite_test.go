@@ -0,0 +1,65 @@
+//go:build sqlite
+// +build sqlite
+
+/*
+* Archon PSO Server
+* Copyright (C) 2014 Andrew Rodman
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// Open an empty SQLite database with the schema from the config directory
+// and use it as the server's database until the test finishes.
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	schema, err := ioutil.ReadFile(filepath.Join("config", "archondb_sqlite.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "archon.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.Exec(string(schema)); err != nil {
+		db.Close()
+		t.Fatal(err)
+	}
+	saved := config.database
+	config.database = db
+	t.Cleanup(func() {
+		config.database = saved
+		db.Close()
+	})
+	return db
+}
+
+// Add a character to the test database with just the columns the queries
+// under test look at.
+func insertTestCharacter(t *testing.T, db *sql.DB, guildcard, slot uint32, name string, createdAt, deletedAt int64) {
+	t.Helper()
+	_, err := db.Exec("INSERT INTO characters (guildcard, slot_num, name_key, "+
+		"created_at, deleted_at) VALUES (?, ?, ?, ?, ?)",
+		guildcard, slot, characterNameKey(name), createdAt, deletedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
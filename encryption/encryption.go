@@ -67,6 +67,17 @@ func NewPCCrypt() *PSOCrypt {
 	return crypt
 }
 
+// Returns a newly allocated PSOCrypt with randomly generated, appropriately
+// sized keys for encrypting packets over PSO Gamecube connections.
+func NewGCCrypt() *PSOCrypt {
+	crypt := &PSOCrypt{Vector: createKey(4)}
+	var err error
+	if crypt.cipher, err = newGCCipher(crypt.Vector); err != nil {
+		panic(err)
+	}
+	return crypt
+}
+
 // Returns a newly allocated PSOCrypt with randomly generated, appropriately
 // sized keys for encrypting packets over PSOBB connections.
 func NewBBCrypt() *PSOCrypt {
@@ -78,6 +89,39 @@ func NewBBCrypt() *PSOCrypt {
 	return crypt
 }
 
+// Returns a PSOCrypt for PSOPC connections keyed with an existing vector,
+// such as one received from the server in a welcome packet.
+func NewPCCryptWithVector(vector []byte) *PSOCrypt {
+	crypt := &PSOCrypt{Vector: append([]byte(nil), vector...)}
+	var err error
+	if crypt.cipher, err = newPCCipher(crypt.Vector); err != nil {
+		panic(err)
+	}
+	return crypt
+}
+
+// Returns a PSOCrypt for PSO Gamecube connections keyed with an existing
+// vector, such as one received from the server in a welcome packet.
+func NewGCCryptWithVector(vector []byte) *PSOCrypt {
+	crypt := &PSOCrypt{Vector: append([]byte(nil), vector...)}
+	var err error
+	if crypt.cipher, err = newGCCipher(crypt.Vector); err != nil {
+		panic(err)
+	}
+	return crypt
+}
+
+// Returns a PSOCrypt for PSOBB connections keyed with an existing vector,
+// such as one received from the server in a welcome packet.
+func NewBBCryptWithVector(vector []byte) *PSOCrypt {
+	crypt := &PSOCrypt{Vector: append([]byte(nil), vector...)}
+	var err error
+	if crypt.cipher, err = newCipher(crypt.Vector); err != nil {
+		panic(err)
+	}
+	return crypt
+}
+
 // Encrypt a block of data in place.
 func (crypt *PSOCrypt) Encrypt(data []byte, size uint32) {
 	blockSize := crypt.cipher.blockSize()
@@ -0,0 +1,118 @@
+/*
+* Archon PSO Server
+* Copyright (C) 2014 Andrew Rodman
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+* ---------------------------------------------------------------------
+*
+* PSO Gamecube (V3) encryption algorithm. Like the PC cipher it's a stream
+* of 32-bit keys XOR'd with the data, but generated from a larger table.
+* Implementation based on the encryption library included with Fuzziqer
+* Software's newserv code.
+ */
+package encryption
+
+import "strconv"
+
+const (
+	GCBlockSize = 4
+	gcStreamLen = 521
+)
+
+type GCCrypt struct {
+	position int
+	stream   [gcStreamLen]uint32
+}
+
+type GCKeySizeError int
+
+func (k GCKeySizeError) Error() string {
+	return "encryption/gccrypt: invalid key size " + strconv.Itoa(int(k))
+}
+
+func newGCCipher(key []byte) (psoCipher, error) {
+	if len(key) > 4 {
+		return nil, GCKeySizeError(len(key))
+	}
+	// Key is expected to be in little endian.
+	crypt := new(GCCrypt)
+	crypt.createKeys(le(key))
+	return crypt, nil
+}
+
+func (crypt *GCCrypt) blockSize() int { return GCBlockSize }
+
+// Initialize the key stream from the seed.
+func (crypt *GCCrypt) createKeys(seed uint32) {
+	// The first 17 keys are built one bit at a time from the top bit of
+	// successive values of the seed.
+	for i := 0; i < 17; i++ {
+		var key uint32
+		for j := 0; j < 32; j++ {
+			seed = seed*0x5D588B65 + 1
+			key = key>>1 | seed&0x80000000
+		}
+		crypt.stream[i] = key
+	}
+	s := &crypt.stream
+	s[16] = (s[0] >> 9) ^ (s[16] << 23) ^ s[15]
+	for i := 17; i < gcStreamLen; i++ {
+		s[i] = s[i-1] ^ ((s[i-17]<<23)&0xFF800000 ^ (s[i-16]>>9)&0x007FFFFF)
+	}
+	for i := 0; i < 3; i++ {
+		crypt.mixKeys()
+	}
+	crypt.position = gcStreamLen - 1
+}
+
+func (crypt *GCCrypt) mixKeys() {
+	s := &crypt.stream
+	i := 0
+	for j := 489; j < gcStreamLen; i, j = i+1, j+1 {
+		s[i] ^= s[j]
+	}
+	for j := 0; i < gcStreamLen; i, j = i+1, j+1 {
+		s[i] ^= s[j]
+	}
+}
+
+func (crypt *GCCrypt) getNextKey() uint32 {
+	if crypt.position == gcStreamLen {
+		crypt.mixKeys()
+		crypt.position = 0
+	}
+	key := crypt.stream[crypt.position]
+	crypt.position++
+	return key
+}
+
+func (crypt *GCCrypt) encrypt(src []byte) {
+	crypt.process(src)
+}
+
+func (crypt *GCCrypt) decrypt(src []byte) {
+	crypt.process(src)
+}
+
+// The operation is symmetrical, so the same algorithm is used for
+// both encryption and decryption.
+func (crypt *GCCrypt) process(data []byte) {
+	for x := 0; x+4 <= len(data); x += 4 {
+		tmp := le(data[x:x+4]) ^ crypt.getNextKey()
+		data[x] = byte(tmp)
+		data[x+1] = byte(tmp >> 8)
+		data[x+2] = byte(tmp >> 16)
+		data[x+3] = byte(tmp >> 24)
+	}
+}
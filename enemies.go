@@ -0,0 +1,407 @@
+/*
+* Archon PSO Server
+* Copyright (C) 2014 Andrew Rodman
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+* ---------------------------------------------------------------------
+* Enemies and how they're placed on maps. The client numbers the enemies in
+* a game in the order they appear in the map files, with the enemies that
+* each one spawns (like the Mothmants from a Monest) numbered right after it,
+* and refers to them by that index. The server builds the same list so that
+* it knows which enemy a client is talking about.
+*
+* The enemies are loaded from the client's map files (e.g. map_forest01_00e.dat)
+* for the first layout of each area, copied into a directory per episode
+* under MapsDir (ep1, ep2, and ep4) and named so that they sort in the order
+* of the areas.
+ */
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// An enemy and the index of its entry in the battle parameters.
+type EnemyType struct {
+	Name    string
+	BPIndex uint8
+}
+
+var (
+	Mothmant        = EnemyType{"Mothmant", 0x00}
+	Monest          = EnemyType{"Monest", 0x01}
+	SavageWolf      = EnemyType{"Savage Wolf", 0x02}
+	BarbarousWolf   = EnemyType{"Barbarous Wolf", 0x03}
+	PoisonLily      = EnemyType{"Poison Lily", 0x04}
+	NarLily         = EnemyType{"Nar Lily", 0x05}
+	SinowBeat       = EnemyType{"Sinow Beat", 0x06}
+	Canadine        = EnemyType{"Canadine", 0x07}
+	CanadineGroup   = EnemyType{"Canadine", 0x08}
+	Canane          = EnemyType{"Canane", 0x09}
+	ChaosSorcerer   = EnemyType{"Chaos Sorcerer", 0x0A}
+	BeeR            = EnemyType{"Bee R", 0x0B}
+	BeeL            = EnemyType{"Bee L", 0x0C}
+	ChaosBringer    = EnemyType{"Chaos Bringer", 0x0D}
+	DarkBelra       = EnemyType{"Dark Belra", 0x0E}
+	DeRolLe         = EnemyType{"De Rol Le", 0x0F}
+	Dragon          = EnemyType{"Dragon", 0x12}
+	SinowGold       = EnemyType{"Sinow Gold", 0x13}
+	RagRappy        = EnemyType{"Rag Rappy", 0x18}
+	AlRappy         = EnemyType{"Al Rappy", 0x19}
+	NanoDragon      = EnemyType{"Nano Dragon", 0x1A}
+	Dubchic         = EnemyType{"Dubchic", 0x1B}
+	Gilchic         = EnemyType{"Gilchic", 0x1C}
+	Garanz          = EnemyType{"Garanz", 0x1D}
+	DarkGunner      = EnemyType{"Dark Gunner", 0x1E}
+	Bulclaw         = EnemyType{"Bulclaw", 0x1F}
+	Claw            = EnemyType{"Claw", 0x20}
+	VolOpt          = EnemyType{"Vol Opt", 0x25}
+	PofuillySlime   = EnemyType{"Pofuilly Slime", 0x30}
+	PanArms         = EnemyType{"Pan Arms", 0x31}
+	Hidoom          = EnemyType{"Hidoom", 0x32}
+	Migium          = EnemyType{"Migium", 0x33}
+	PouillySlime    = EnemyType{"Pouilly Slime", 0x34}
+	DarkFalz        = EnemyType{"Dark Falz", 0x37}
+	Hildebear       = EnemyType{"Hildebear", 0x49}
+	Hildeblue       = EnemyType{"Hildeblue", 0x4A}
+	Booma           = EnemyType{"Booma", 0x4B}
+	Gobooma         = EnemyType{"Gobooma", 0x4C}
+	Gigobooma       = EnemyType{"Gigobooma", 0x4D}
+	GrassAssassin   = EnemyType{"Grass Assassin", 0x4E}
+	EvilShark       = EnemyType{"Evil Shark", 0x4F}
+	PalShark        = EnemyType{"Pal Shark", 0x50}
+	GuilShark       = EnemyType{"Guil Shark", 0x51}
+	Delsaber        = EnemyType{"Delsaber", 0x52}
+	Dimenian        = EnemyType{"Dimenian", 0x53}
+	LaDimenian      = EnemyType{"La Dimenian", 0x54}
+	SoDimenian      = EnemyType{"So Dimenian", 0x55}
+	SinowBerill     = EnemyType{"Sinow Berill", 0x06}
+	Gee             = EnemyType{"Gee", 0x07}
+	Delbiter        = EnemyType{"Delbiter", 0x0D}
+	BarbaRay        = EnemyType{"Barba Ray", 0x0F}
+	GolDragon       = EnemyType{"Gol Dragon", 0x12}
+	SinowSpigell    = EnemyType{"Sinow Spigell", 0x13}
+	LoveRappy       = EnemyType{"Love Rappy", 0x19}
+	GiGue           = EnemyType{"Gi Gue", 0x1A}
+	GalGryphon      = EnemyType{"Gal Gryphon", 0x1E}
+	IllGill         = EnemyType{"Ill Gill", 0x26}
+	OlgaFlow        = EnemyType{"Olga Flow", 0x2C}
+	Deldepth        = EnemyType{"Deldepth", 0x30}
+	Mericarol       = EnemyType{"Mericarol", 0x3A}
+	UlGibbon        = EnemyType{"Ul Gibbon", 0x3B}
+	ZolGibbon       = EnemyType{"Zol Gibbon", 0x3C}
+	Gibbles         = EnemyType{"Gibbles", 0x3D}
+	Morfos          = EnemyType{"Morfos", 0x40}
+	Recobox         = EnemyType{"Recobox", 0x41}
+	Recon           = EnemyType{"Recon", 0x42}
+	SinowZoa        = EnemyType{"Sinow Zoa", 0x43}
+	SinowZele       = EnemyType{"Sinow Zele", 0x44}
+	Merikle         = EnemyType{"Merikle", 0x45}
+	Mericus         = EnemyType{"Mericus", 0x46}
+	Merillia        = EnemyType{"Merillia", 0x4B}
+	Meriltas        = EnemyType{"Meriltas", 0x4C}
+	Dolmolm         = EnemyType{"Dolmolm", 0x4F}
+	Dolmdarl        = EnemyType{"Dolmdarl", 0x50}
+	Boota           = EnemyType{"Boota", 0x00}
+	ZeBoota         = EnemyType{"Ze Boota", 0x01}
+	BaBoota         = EnemyType{"Ba Boota", 0x03}
+	SandRappy       = EnemyType{"Sand Rappy", 0x05}
+	DelRappy        = EnemyType{"Del Rappy", 0x06}
+	Zu              = EnemyType{"Zu", 0x07}
+	Pazuzu          = EnemyType{"Pazuzu", 0x08}
+	Astark          = EnemyType{"Astark", 0x09}
+	SatelliteLizard = EnemyType{"Satellite Lizard", 0x0D}
+	Yowie           = EnemyType{"Yowie", 0x0E}
+	Dorphon         = EnemyType{"Dorphon", 0x0F}
+	DorphonEclair   = EnemyType{"Dorphon Eclair", 0x10}
+	Goran           = EnemyType{"Goran", 0x11}
+	PyroGoran       = EnemyType{"Pyro Goran", 0x12}
+	GoranDetonator  = EnemyType{"Goran Detonator", 0x13}
+	MerissaA        = EnemyType{"Merissa A", 0x19}
+	MerissaAA       = EnemyType{"Merissa AA", 0x1A}
+	Girtablulu      = EnemyType{"Girtablulu", 0x1F}
+	SaintMilion     = EnemyType{"Saint-Milion", 0x22}
+	Shambertin      = EnemyType{"Shambertin", 0x26}
+	Kondrieu        = EnemyType{"Kondrieu", 0x2A}
+)
+
+// Enemy as it's placed in a map file.
+type MapEnemy struct {
+	// Id of the kind of enemy.
+	Type uint16
+	// Picks between the variants of some enemies.
+	Skin uint32
+	// Number of enemies it spawns, for those where it isn't fixed.
+	Children uint16
+	// Whether the server decided this one should be the rare variant.
+	Rare bool
+}
+
+// What a map enemy id turns into in the game.
+type mapEnemyKind struct {
+	// Variants picked by the skin; see variant.
+	variants []EnemyType
+	// The skin bit that picks the second variant, if it isn't picked by
+	// the skin modulo the number of variants.
+	skinBit uint32
+	rare    *EnemyType
+	// One in how many of the enemy are rare, if not rareEnemyOdds.
+	rareOdds int
+	// Enemies spawned along with it, in the order the client numbers them.
+	children []mapEnemyChild
+}
+
+type mapEnemyChild struct {
+	enemy EnemyType
+	// Zero for the number in the map entry.
+	count int
+}
+
+func (k *mapEnemyKind) variant(e MapEnemy) EnemyType {
+	if e.Rare && k.rare != nil {
+		return *k.rare
+	}
+	if k.skinBit != 0 {
+		if e.Skin&k.skinBit != 0 {
+			return k.variants[1]
+		}
+		return k.variants[0]
+	}
+	return k.variants[e.Skin%uint32(len(k.variants))]
+}
+
+func enemyKind(variants ...EnemyType) mapEnemyKind {
+	return mapEnemyKind{variants: variants}
+}
+
+func rareEnemyKind(normal, rare EnemyType) mapEnemyKind {
+	return mapEnemyKind{variants: []EnemyType{normal}, rare: &rare}
+}
+
+// Map enemy ids found in each episode. Variants of Ep4 enemies specific to
+// the desert areas aren't distinguished.
+var mapEnemies = map[Episode]map[uint16]mapEnemyKind{
+	Episode1: {
+		0x40: rareEnemyKind(Hildebear, Hildeblue),
+		0x41: rareEnemyKind(RagRappy, AlRappy),
+		0x42: {variants: []EnemyType{Monest}, children: []mapEnemyChild{{Mothmant, 30}}},
+		0x43: {variants: []EnemyType{SavageWolf, BarbarousWolf}, skinBit: 0x800000},
+		0x44: enemyKind(Booma, Gobooma, Gigobooma),
+		0x60: enemyKind(GrassAssassin),
+		0x61: rareEnemyKind(PoisonLily, NarLily),
+		0x62: enemyKind(NanoDragon),
+		0x63: enemyKind(EvilShark, PalShark, GuilShark),
+		0x64: rareEnemyKind(PofuillySlime, PouillySlime),
+		0x65: {variants: []EnemyType{PanArms}, children: []mapEnemyChild{{Hidoom, 1}, {Migium, 1}}},
+		0x80: {variants: []EnemyType{Dubchic, Gilchic}, skinBit: 1},
+		0x81: enemyKind(Garanz),
+		0x82: {variants: []EnemyType{SinowBeat, SinowGold}, skinBit: 1},
+		0x83: enemyKind(Canadine),
+		0x84: {variants: []EnemyType{Canane}, children: []mapEnemyChild{{CanadineGroup, 8}}},
+		0xA0: enemyKind(Delsaber),
+		0xA1: {variants: []EnemyType{ChaosSorcerer}, children: []mapEnemyChild{{BeeR, 1}, {BeeL, 1}}},
+		0xA2: enemyKind(DarkGunner),
+		0xA4: enemyKind(ChaosBringer),
+		0xA5: enemyKind(DarkBelra),
+		0xA6: enemyKind(Dimenian, LaDimenian, SoDimenian),
+		0xA7: {variants: []EnemyType{Bulclaw}, children: []mapEnemyChild{{Claw, 4}}},
+		0xA8: enemyKind(Claw),
+		0xC0: enemyKind(Dragon),
+		0xC1: enemyKind(DeRolLe),
+		0xC5: enemyKind(VolOpt),
+		0xC8: enemyKind(DarkFalz),
+	},
+	Episode2: {
+		0x40: rareEnemyKind(Hildebear, Hildeblue),
+		0x41: rareEnemyKind(RagRappy, LoveRappy),
+		0x42: {variants: []EnemyType{Monest}, children: []mapEnemyChild{{Mothmant, 30}}},
+		0x43: {variants: []EnemyType{SavageWolf, BarbarousWolf}, skinBit: 0x800000},
+		0x61: rareEnemyKind(PoisonLily, NarLily),
+		0x63: enemyKind(EvilShark, PalShark, GuilShark),
+		0x65: {variants: []EnemyType{PanArms}, children: []mapEnemyChild{{Hidoom, 1}, {Migium, 1}}},
+		0x80: {variants: []EnemyType{Dubchic, Gilchic}, skinBit: 1},
+		0x81: enemyKind(Garanz),
+		0xA0: enemyKind(Delsaber),
+		0xA1: {variants: []EnemyType{ChaosSorcerer}, children: []mapEnemyChild{{BeeR, 1}, {BeeL, 1}}},
+		0xA6: enemyKind(Dimenian, LaDimenian, SoDimenian),
+		0xC0: enemyKind(GalGryphon),
+		0xC1: enemyKind(BarbaRay),
+		0xCA: enemyKind(OlgaFlow),
+		0xCB: enemyKind(GolDragon),
+		0xD4: {variants: []EnemyType{SinowBerill, SinowSpigell}, skinBit: 1},
+		0xD5: {variants: []EnemyType{Merillia, Meriltas}, skinBit: 1},
+		0xD6: enemyKind(Mericarol, Mericus, Merikle),
+		0xD7: {variants: []EnemyType{UlGibbon, ZolGibbon}, skinBit: 1},
+		0xD8: enemyKind(Gibbles),
+		0xD9: enemyKind(Gee),
+		0xDA: enemyKind(GiGue),
+		0xDB: enemyKind(Deldepth),
+		0xDC: enemyKind(Delbiter),
+		0xDD: {variants: []EnemyType{Dolmolm, Dolmdarl}, skinBit: 1},
+		0xDE: enemyKind(Morfos),
+		0xDF: {variants: []EnemyType{Recobox}, children: []mapEnemyChild{{Recon, 0}}},
+		0xE0: {variants: []EnemyType{SinowZoa, SinowZele}, skinBit: 1},
+		0xE1: enemyKind(IllGill),
+	},
+	Episode4: {
+		0x41:  rareEnemyKind(SandRappy, DelRappy),
+		0x110: enemyKind(Astark),
+		0x111: {variants: []EnemyType{SatelliteLizard, Yowie}, skinBit: 1},
+		0x112: rareEnemyKind(MerissaA, MerissaAA),
+		0x113: enemyKind(Girtablulu),
+		0x114: rareEnemyKind(Zu, Pazuzu),
+		0x115: enemyKind(Boota, ZeBoota, BaBoota),
+		0x116: rareEnemyKind(Dorphon, DorphonEclair),
+		0x117: enemyKind(Goran, PyroGoran, GoranDetonator),
+		0x119: {variants: []EnemyType{SaintMilion, Shambertin}, skinBit: 1, rare: &Kondrieu, rareOdds: 10},
+	},
+}
+
+// Chance of an enemy with a rare variant being rare. A game has at most
+// maxRareEnemies since that's all the client can be told about.
+const rareEnemyOdds = 512
+
+// Rare variants of the enemies found on the maps.
+var rareEnemies = func() map[EnemyType]bool {
+	rares := make(map[EnemyType]bool)
+	for _, kinds := range mapEnemies {
+		for _, kind := range kinds {
+			if kind.rare != nil {
+				rares[*kind.rare] = true
+			}
+		}
+	}
+	return rares
+}()
+
+// Returns a copy of the enemies placed on the maps of a game in episode with
+// the ones that have a rare variant marked rare when roll returns true for
+// the one in odds chance of them being rare.
+func rollRareEnemies(episode Episode, placed []MapEnemy, roll func(odds int) bool) []MapEnemy {
+	kinds := mapEnemies[episode]
+	enemies := make([]MapEnemy, len(placed))
+	copy(enemies, placed)
+	rares := 0
+	for i := range enemies {
+		kind, ok := kinds[enemies[i].Type]
+		if !ok || kind.rare == nil || rares == maxRareEnemies {
+			continue
+		}
+		odds := kind.rareOdds
+		if odds == 0 {
+			odds = rareEnemyOdds
+		}
+		if roll(odds) {
+			enemies[i].Rare = true
+			rares++
+		}
+	}
+	return enemies
+}
+
+// Returns the enemies in the order the client numbers them for the enemies
+// placed on the maps of a game in episode.
+func expandMapEnemies(episode Episode, entries []MapEnemy) ([]EnemyType, error) {
+	kinds := mapEnemies[episode]
+	var enemies []EnemyType
+	for i, e := range entries {
+		kind, ok := kinds[e.Type]
+		if !ok {
+			return nil, fmt.Errorf("unknown enemy 0x%x at index %d in episode %d", e.Type, i, episode)
+		}
+		enemies = append(enemies, kind.variant(e))
+		for _, child := range kind.children {
+			count := child.count
+			if count == 0 {
+				count = int(e.Children)
+			}
+			for j := 0; j < count; j++ {
+				enemies = append(enemies, child.enemy)
+			}
+		}
+	}
+	return enemies, nil
+}
+
+// Size of an enemy entry in a map file and where the fields we need are.
+const (
+	mapEnemySize     = 0x48
+	mapEnemyChildren = 0x06
+	mapEnemySkin     = 0x40
+)
+
+// Parse the enemies in a map file.
+func parseMapEnemies(data []byte) ([]MapEnemy, error) {
+	if len(data)%mapEnemySize != 0 {
+		return nil, fmt.Errorf("size %d isn't a multiple of %d", len(data), mapEnemySize)
+	}
+	enemies := make([]MapEnemy, len(data)/mapEnemySize)
+	for i := range enemies {
+		entry := data[i*mapEnemySize:]
+		enemies[i] = MapEnemy{
+			Type:     binary.LittleEndian.Uint16(entry),
+			Children: binary.LittleEndian.Uint16(entry[mapEnemyChildren:]),
+			Skin:     binary.LittleEndian.Uint32(entry[mapEnemySkin:]),
+		}
+	}
+	return enemies, nil
+}
+
+// Enemies placed on the maps for each episode, in the order the client
+// numbers them. Games in episodes without any don't track their enemies.
+var gameMaps map[Episode][]MapEnemy
+
+var mapDirs = map[Episode]string{Episode1: "ep1", Episode2: "ep2", Episode4: "ep4"}
+
+// Load the map files for every episode from the directories under dir.
+func loadGameMaps(dir string) (map[Episode][]MapEnemy, error) {
+	maps := make(map[Episode][]MapEnemy)
+	for episode, sub := range mapDirs {
+		files, err := ioutil.ReadDir(filepath.Join(dir, sub))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		var names []string
+		for _, f := range files {
+			if !f.IsDir() && filepath.Ext(f.Name()) == ".dat" {
+				names = append(names, f.Name())
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			path := filepath.Join(dir, sub, name)
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			enemies, err := parseMapEnemies(data)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", path, err)
+			}
+			maps[episode] = append(maps[episode], enemies...)
+		}
+		// Check now that the games will be able to use them.
+		if _, err := expandMapEnemies(episode, maps[episode]); err != nil {
+			return nil, fmt.Errorf("%s: %s", filepath.Join(dir, sub), err)
+		}
+	}
+	return maps, nil
+}
@@ -0,0 +1,176 @@
+/*
+* Archon PSO Server
+* Copyright (C) 2014 Andrew Rodman
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+* ---------------------------------------------------------------------
+* Publish/subscribe bus for things that happen on one sub-server that the
+* others (or plugins) might care about. Each subscriber gets its own
+* goroutine and queue so that a slow subscriber can't hold up the client
+* goroutines publishing events.
+ */
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+type EventType uint32
+
+const (
+	EventPlayerLogin EventType = iota + 1
+	EventPlayerLogout
+	EventCharacterCreated
+	EventCharacterDeleted
+	EventShipRegistered
+	EventLobbyJoined
+	EventGameJoined
+	EventBanIssued
+)
+
+var eventNames = map[EventType]string{
+	EventPlayerLogin:      "PlayerLogin",
+	EventPlayerLogout:     "PlayerLogout",
+	EventCharacterCreated: "CharacterCreated",
+	EventCharacterDeleted: "CharacterDeleted",
+	EventShipRegistered:   "ShipRegistered",
+	EventLobbyJoined:      "LobbyJoined",
+	EventGameJoined:       "GameJoined",
+	EventBanIssued:        "BanIssued",
+}
+
+func (t EventType) String() string {
+	if name, ok := eventNames[t]; ok {
+		return name
+	}
+	return "Unknown"
+}
+
+// Something that happened on one of the servers. Which of the fields are set
+// depends on the type of event.
+type Event struct {
+	Type EventType
+	Time time.Time
+	// Sub-server and ship the event happened on.
+	Server string
+	Ship   uint32
+	// Player the event is about, if any.
+	Guildcard uint32
+	// Character slot, ship, lobby, or game id, or the GM's guildcard for a ban.
+	Id uint32
+	// Character, ship, or game name, or the reason for a ban.
+	Text string
+	// Set for events from other ships that were forwarded by the shipgate.
+	Remote bool
+}
+
+type EventHandler func(Event)
+
+// Number of events that can be waiting on a subscriber before new
+// events for it are dropped.
+const eventQueueSize = 64
+
+type subscription struct {
+	handler EventHandler
+	// Event types the subscriber wants, or nil for all of them.
+	types map[EventType]bool
+	queue chan Event
+}
+
+func (s *subscription) wants(t EventType) bool {
+	return s.types == nil || s.types[t]
+}
+
+func (s *subscription) run() {
+	for e := range s.queue {
+		s.handler(e)
+	}
+}
+
+type EventBus struct {
+	subs []*subscription
+	sync.RWMutex
+}
+
+func NewEventBus() *EventBus {
+	return new(EventBus)
+}
+
+// Bus shared by all of the sub-servers.
+var events = NewEventBus()
+
+// Call handler with every event of one of the given types, or all events if
+// no types are given. Handlers are called from a goroutine belonging to the
+// subscription in the order that the events were published. Returns a
+// function that cancels the subscription.
+func (b *EventBus) Subscribe(handler EventHandler, types ...EventType) func() {
+	s := &subscription{handler: handler, queue: make(chan Event, eventQueueSize)}
+	if len(types) > 0 {
+		s.types = make(map[EventType]bool)
+		for _, t := range types {
+			s.types[t] = true
+		}
+	}
+	b.Lock()
+	b.subs = append(b.subs, s)
+	b.Unlock()
+	go s.run()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { b.unsubscribe(s) })
+	}
+}
+
+func (b *EventBus) unsubscribe(s *subscription) {
+	b.Lock()
+	for i, sub := range b.subs {
+		if sub == s {
+			b.subs = append(b.subs[:i], b.subs[i+1:]...)
+			close(s.queue)
+			break
+		}
+	}
+	b.Unlock()
+}
+
+// Pass e to everyone subscribed to its type without waiting on them. Events
+// for subscribers that have fallen too far behind are dropped.
+func (b *EventBus) Publish(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	b.RLock()
+	for _, s := range b.subs {
+		if !s.wants(e.Type) {
+			continue
+		}
+		select {
+		case s.queue <- e:
+		default:
+			log.Warnf("Dropped %v event for a subscriber that isn't keeping up", e.Type)
+		}
+	}
+	b.RUnlock()
+}
+
+// Publish an event about the player connected as c.
+func (c *Client) publishEvent(t EventType, id uint32, text string) {
+	e := Event{Type: t, Guildcard: c.guildcard, Id: id, Text: text, Ship: shipList[0].id}
+	if c.server != nil {
+		e.Server = c.server.Name()
+	}
+	events.Publish(e)
+}
@@ -0,0 +1,125 @@
+//go:build sqlite
+// +build sqlite
+
+/*
+* Archon PSO Server
+* Copyright (C) 2014 Andrew Rodman
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"github.com/dcrodman/archon/util"
+	"testing"
+)
+
+func insertTestAccount(t *testing.T, db *sql.DB, guildcard uint32, username, password string, gm bool) {
+	t.Helper()
+	hash := sha256.Sum256([]byte(password))
+	_, err := db.Exec("INSERT INTO account_data (username, password, guildcard, is_gm, is_active) "+
+		"VALUES (?, ?, ?, ?, 1)", username, hex.EncodeToString(hash[:]), guildcard, gm)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// The player logs in again on every server they're sent to, but only the
+// LOGIN server should announce it.
+func TestLoginEvent(t *testing.T) {
+	db := newTestDB(t)
+	insertTestAccount(t, db, 1, "player", "secret", false)
+	logins := make(chan Event, 4)
+	cancel := events.Subscribe(func(e Event) { logins <- e }, EventPlayerLogin)
+	defer cancel()
+
+	pkt := &LoginPkt{Header: BBHeader{Type: LoginType}}
+	copy(pkt.Username[:], "player")
+	copy(pkt.Password[:], "secret")
+	for _, server := range []Server{new(CharacterServer), new(LoginServer)} {
+		c, _ := newPipeClient(8)
+		defer c.Close()
+		c.server = server
+		handlePacket(t, c, func(c *Client) error {
+			_, err := VerifyAccount(c, false)
+			return err
+		}, pkt)
+	}
+	if e := nextEvent(t, logins); e.Server != new(LoginServer).Name() || e.Guildcard != 1 || e.Text != "" {
+		t.Errorf("got %+v, expected a login on the LOGIN server", e)
+	}
+}
+
+func TestBanCommand(t *testing.T) {
+	db := newTestDB(t)
+	insertTestAccount(t, db, 1, "gm", "secret", true)
+	insertTestAccount(t, db, 2, "cheater", "secret", false)
+	bans := make(chan Event, 4)
+	cancel := events.Subscribe(func(e Event) { bans <- e }, EventBanIssued)
+	defer cancel()
+
+	server := &BlockServer{name: "BLOCK1", num: 1, lobbies: []*Lobby{newLobby(0)}}
+	var players []*Client
+	var packets []<-chan []byte
+	for _, guildcard := range []uint32{1, 2} {
+		c, conn := newPipeClient(8)
+		defer c.Close()
+		packets = append(packets, readPackets(c, conn))
+		c.server = server
+		c.guildcard = guildcard
+		c.character = new(CharacterPreview)
+		if err := server.enterLobby(c, server.lobbies[0]); err != nil {
+			t.Fatal(err)
+		}
+		players = append(players, c)
+	}
+	players[0].isGm = true
+	// Skip joining the lobby and the notice about the second player arriving.
+	nextPacket(t, packets[0], new(LobbyJoinPacket))
+	nextPacket(t, packets[0], new(LobbyJoinPacket))
+	nextPacket(t, packets[1], new(LobbyJoinPacket))
+
+	chat := func(c *Client, replies <-chan []byte, text string) string {
+		handlePacket(t, c, server.handleChat, &ChatPacket{
+			Header:  BBHeader{Type: ChatType},
+			Message: util.ConvertToUtf16("\tE" + text),
+		})
+		var reply ChatPacket
+		nextPacket(t, replies, &reply)
+		return util.ConvertFromUtf16(reply.Message)
+	}
+	if reply := chat(players[1], packets[1], "/ban 1"); reply != "\tEOnly GMs can ban players." {
+		t.Errorf("non-GM ban replied %q", reply)
+	}
+	if reply := chat(players[0], packets[0], "/ban 9"); reply != "\tENo account has guildcard 9." {
+		t.Errorf("ban of a missing account replied %q", reply)
+	}
+	if reply := chat(players[0], packets[0], "/ban 2 speed hacking"); reply != "\tEBanned guildcard 2." {
+		t.Errorf("ban replied %q", reply)
+	}
+	if e := nextEvent(t, bans); e.Guildcard != 2 || e.Id != 1 || e.Text != "speed hacking" {
+		t.Errorf("got %+v, expected the ban of guildcard 2", e)
+	}
+	for range packets[1] {
+		// Drained once the banned player has been disconnected.
+	}
+	c, _ := newPipeClient(8)
+	defer c.Close()
+	if err := authenticate(c, "cheater", "secret"); err != errAccountBanned {
+		t.Errorf("banned player logging in got %v", err)
+	}
+}
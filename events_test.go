@@ -0,0 +1,230 @@
+/*
+* Archon PSO Server
+* Copyright (C) 2014 Andrew Rodman
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	crypto "github.com/dcrodman/archon/encryption"
+	"github.com/dcrodman/archon/util"
+	"github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+// Wait for an event to arrive on ch.
+func nextEvent(t *testing.T, ch <-chan Event) Event {
+	select {
+	case e := <-ch:
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for an event")
+	}
+	return Event{}
+}
+
+func TestEventBusSubscribe(t *testing.T) {
+	bus := NewEventBus()
+	logins := make(chan Event, 10)
+	all := make(chan Event, 10)
+	cancel := bus.Subscribe(func(e Event) { logins <- e }, EventPlayerLogin)
+	bus.Subscribe(func(e Event) { all <- e })
+
+	bus.Publish(Event{Type: EventCharacterCreated, Guildcard: 1})
+	bus.Publish(Event{Type: EventPlayerLogin, Guildcard: 2})
+	if e := nextEvent(t, logins); e.Guildcard != 2 || e.Time.IsZero() {
+		t.Errorf("got %+v, expected the login event", e)
+	}
+	for _, guildcard := range []uint32{1, 2} {
+		if e := nextEvent(t, all); e.Guildcard != guildcard {
+			t.Errorf("got event for %d, expected %d", e.Guildcard, guildcard)
+		}
+	}
+
+	cancel()
+	cancel()
+	bus.Publish(Event{Type: EventPlayerLogin, Guildcard: 3})
+	if e := nextEvent(t, all); e.Guildcard != 3 {
+		t.Errorf("got event for %d, expected 3", e.Guildcard)
+	}
+	select {
+	case e := <-logins:
+		t.Errorf("cancelled subscription got %+v", e)
+	default:
+	}
+}
+
+// Events published on this server should be sent to connected ships, while
+// those received from a ship are published locally as remote events.
+func TestShipgateForwardEvents(t *testing.T) {
+	c, ship := newPipeClient(8)
+	defer c.Close()
+	conns := NewClientList()
+	server := &ShipgateServer{conns: conns}
+	c.server = server
+	c.CompleteHandshake()
+	conns.Add(c)
+
+	remote := make(chan Event, 1)
+	cancel := events.Subscribe(func(e Event) {
+		if e.Remote {
+			remote <- e
+		}
+	}, EventBanIssued)
+	defer cancel()
+
+	server.forwardEvent(Event{Type: EventBanIssued, Guildcard: 42, Text: "cheating", Time: time.Now()})
+	data := make([]byte, (&ShipgateEventPacket{}).BinarySize())
+	if _, err := io.ReadFull(ship, data); err != nil {
+		t.Fatal(err)
+	}
+	crypto.NewBBCryptWithVector(c.ServerVector()).Decrypt(data, uint32(len(data)))
+	var pkt ShipgateEventPacket
+	util.StructFromBytes(data, &pkt)
+	if EventType(pkt.EventType) != EventBanIssued || pkt.Guildcard != 42 ||
+		string(util.StripPadding(pkt.Text[:])) != "cheating" {
+		t.Errorf("unexpected packet: %+v", pkt)
+	}
+
+	// Feed the packet back in as though another ship had sent it.
+	copy(c.buffer, data)
+	c.packetSize = uint16(len(data))
+	if err := server.handleEvent(c); err != nil {
+		t.Fatal(err)
+	}
+	if e := nextEvent(t, remote); e.Guildcard != 42 || e.Text != "cheating" {
+		t.Errorf("got %+v, expected the forwarded ban", e)
+	}
+}
+
+// Only ships that sign their connection with the shipgate secret should
+// see events.
+func TestShipgateAuth(t *testing.T) {
+	defer func(secret string) { config.ShipgateSecret = secret }(config.ShipgateSecret)
+	config.ShipgateSecret = "shared"
+	server := &ShipgateServer{conns: NewClientList()}
+
+	for _, test := range []struct {
+		secret string
+		ok     bool
+	}{
+		{"shared", true},
+		{"guessed", false},
+	} {
+		c, conn := newPipeClient(8)
+		defer c.Close()
+		defer conn.Close()
+		c.server = server
+		pkt := &ShipgateAuthPacket{
+			Header:    BBHeader{Type: ShipgateAuthType},
+			Signature: shipgateSignature(test.secret, c),
+		}
+		data, _ := util.BytesFromStruct(pkt)
+		c.packetSize = uint16(copy(c.buffer, data))
+		if err := server.handleAuth(c); (err == nil) != test.ok {
+			t.Errorf("signed with %q: got %v", test.secret, err)
+		}
+		if isShipgateClient(c) != test.ok {
+			t.Errorf("signed with %q: expected isShipgateClient to be %v", test.secret, test.ok)
+		}
+		server.conns.Add(c)
+	}
+
+	// A ship that connected but hasn't signed its connection isn't sent events.
+	c, conn := newPipeClient(8)
+	defer c.Close()
+	c.server = server
+	server.conns.Add(c)
+	server.forwardEvent(Event{Type: EventPlayerLogin, Guildcard: 42, Time: time.Now()})
+	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if n, err := conn.Read(make([]byte, 8)); err == nil {
+		t.Errorf("unauthenticated ship was sent %d bytes", n)
+	}
+}
+
+// Players are redirected from server to server as they log in, so only the
+// disconnect that doesn't follow a redirect should count as a logout.
+func TestLogoutEvents(t *testing.T) {
+	logouts := make(chan Event, 4)
+	cancel := events.Subscribe(func(e Event) { logouts <- e }, EventPlayerLogout)
+	defer cancel()
+
+	d := &Dispatcher{conns: NewClientList(), log: logrus.New()}
+	d.log.SetOutput(ioutil.Discard)
+	server := &ShipServer{}
+	for _, redirected := range []bool{true, false} {
+		c, conn := newPipeClient(8)
+		c.server = server
+		c.guildcard = 1
+		if !redirected {
+			c.guildcard = 2
+		}
+		c.CompleteHandshake()
+		c.redirected = redirected
+		d.dispatch(c, server)
+		conn.Close()
+		for d.conns.Count() > 0 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	if e := nextEvent(t, logouts); e.Guildcard != 2 {
+		t.Errorf("got a logout for %d after a redirect", e.Guildcard)
+	}
+}
+
+func TestBlockEvents(t *testing.T) {
+	joins := make(chan Event, 4)
+	cancel := events.Subscribe(func(e Event) { joins <- e }, EventLobbyJoined, EventGameJoined)
+	defer cancel()
+
+	server := &BlockServer{name: "BLOCK1", num: 1, lobbies: []*Lobby{newLobby(0), newLobby(1)}}
+	var players []*Client
+	for _, guildcard := range []uint32{1, 2} {
+		c, conn := newPipeClient(8)
+		defer c.Close()
+		readPackets(c, conn)
+		c.server = server
+		c.guildcard = guildcard
+		c.character = new(CharacterPreview)
+		copy(c.character.Name[:], util.ConvertToUtf16("\tEAlice"))
+		if err := server.enterLobby(c, server.lobbies[1]); err != nil {
+			t.Fatal(err)
+		}
+		if e := nextEvent(t, joins); e.Type != EventLobbyJoined || e.Guildcard != guildcard ||
+			e.Id != 2 || e.Text != "Alice" || e.Server != "BLOCK1" {
+			t.Errorf("got %+v, expected %d to join the second lobby", e, guildcard)
+		}
+		players = append(players, c)
+	}
+
+	create := &CreateGamePacket{Header: BBHeader{Type: CreateGameType}, Episode: 1}
+	copy(create.Name[:], util.ConvertToUtf16("Fun"))
+	handlePacket(t, players[0], server.handleCreateGame, create)
+	g := players[0].game
+	if e := nextEvent(t, joins); e.Type != EventGameJoined || e.Guildcard != 1 || e.Id != g.id || e.Text != "Fun" {
+		t.Errorf("got %+v, expected the game's creator to join it", e)
+	}
+	handlePacket(t, players[1], server.handleGameSelection, &GameSelectionPacket{
+		Header: BBHeader{Type: MenuSelectType},
+		MenuId: GameMenuId,
+		ItemId: g.id,
+	})
+	if e := nextEvent(t, joins); e.Type != EventGameJoined || e.Guildcard != 2 || e.Id != g.id {
+		t.Errorf("got %+v, expected the second player to join the game", e)
+	}
+}
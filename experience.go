@@ -0,0 +1,262 @@
+/*
+* Archon PSO Server
+* Copyright (C) 2014 Andrew Rodman
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+* ---------------------------------------------------------------------
+* Game commands and experience. Most subcommands are only meaningful to the
+* other players and are passed along to them, but the ones that change a
+* character's progress are handled here so that clients can't award
+* themselves experience. The server watches which players hit each enemy
+* and who killed it, and only gives experience for enemies that it saw die.
+ */
+package main
+
+import (
+	"fmt"
+	"github.com/dcrodman/archon/util"
+)
+
+// Percentage of an enemy's experience given to players that hit it but
+// didn't land the killing blow.
+const assistExperiencePercent = 80
+
+// Subcommands handled by the server rather than forwarded to the game.
+var gameSubcommands = map[uint8]PacketHandler{
+	SubEnemyHitType:        handleEnemyHit,
+	SubEnemyKilledType:     handleEnemyKilled,
+	SubEnemyExpRequestType: handleEnemyExpRequest,
+	SubGiveExperienceType:  serverOnlySubcommand,
+	SubLevelUpType:         serverOnlySubcommand,
+	SubDropItemType:        handleDropItem,
+	SubShopRequestType:     handleShopRequest,
+	SubShopBuyType:         handleShopBuy,
+	SubShopSellType:        handleShopSell,
+	SubIdentifyItemType:    handleIdentifyItem,
+	SubAcceptIdentifyType:  handleAcceptIdentify,
+	SubIdentifyResultType:  serverOnlySubcommand,
+	SubShopContentsType:    serverOnlySubcommand,
+	SubCreateItemType:      serverOnlySubcommand,
+}
+
+// Handle a game command (60) or a command for one player (62).
+func handleGameCommand(c *Client) error {
+	var hdr SubcommandHeader
+	if err := c.ReadPacket(&hdr); err != nil {
+		return err
+	}
+	if hdr.Size == 0 || int(hdr.Size)*4 > len(c.Data())-BBHeaderSize {
+		return c.Misbehave(fmt.Sprintf("subcommand %02x has invalid size %d", hdr.Type, hdr.Size))
+	}
+	if handler, ok := gameSubcommands[hdr.Type]; ok {
+		return handler(c)
+	}
+	forwardGameCommand(c)
+	return nil
+}
+
+// Pass the game command the player sent on to the others in their game or
+// lobby, or just to the player it's for if it's a 62.
+func forwardGameCommand(c *Client) {
+	room := c.room()
+	if room == nil {
+		return
+	}
+	var hdr BBHeader
+	util.StructFromBytes(c.Data(), &hdr)
+	if hdr.Type == GameCommandTargetType {
+		// The recipient's client id is in the header flags.
+		if target := room.player(uint8(hdr.Flags)); target != nil && target != c {
+			target.sendAsync(c.Data())
+		}
+		return
+	}
+	room.broadcast(c, c.Data())
+}
+
+func serverOnlySubcommand(c *Client) error {
+	return c.Misbehave(fmt.Sprintf("client sent server subcommand %02x", c.Data()[BBHeaderSize]))
+}
+
+// Enemy ids are the enemy's index plus this.
+const enemyIdBase = 0x1000
+
+func handleEnemyHit(c *Client) error {
+	var pkt EnemyHitPacket
+	if err := c.ReadPacket(&pkt); err != nil {
+		return err
+	}
+	if c.game != nil {
+		if err := c.game.recordHit(c, pkt.EnemyIndex); err != nil {
+			return c.Misbehave(err.Error())
+		}
+	}
+	forwardGameCommand(c)
+	return nil
+}
+
+func handleEnemyKilled(c *Client) error {
+	var pkt EnemyKilledPacket
+	if err := c.ReadPacket(&pkt); err != nil {
+		return err
+	}
+	if c.game != nil {
+		if err := c.game.recordKill(c, pkt.EnemyId-enemyIdBase); err != nil {
+			return c.Misbehave(err.Error())
+		}
+	}
+	forwardGameCommand(c)
+	return nil
+}
+
+func handleEnemyExpRequest(c *Client) error {
+	var pkt EnemyExpRequestPacket
+	if err := c.ReadPacket(&pkt); err != nil {
+		return err
+	}
+	if c.game == nil || c.character == nil {
+		return c.Misbehave("experience requested outside of a game")
+	}
+	exp, err := c.game.claimExperience(c, pkt.EnemyIndex)
+	if err != nil {
+		return c.Misbehave(err.Error())
+	}
+	return giveExperience(c, exp)
+}
+
+// Returns the enemy at index, which must be called with the game locked.
+// Returns nil without an error if the game's enemies aren't being tracked.
+func (g *Game) enemy(index uint16) (*gameEnemy, error) {
+	if g.enemies == nil {
+		return nil, nil
+	}
+	if int(index) >= len(g.enemies) {
+		return nil, fmt.Errorf("nonexistent enemy %d", index)
+	}
+	return &g.enemies[index], nil
+}
+
+// Record that c has hit the enemy at index. Hits on an enemy that's already
+// dead don't count toward its experience.
+func (g *Game) recordHit(c *Client, index uint16) error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	e, err := g.enemy(index)
+	if e != nil && !e.dead {
+		e.hitBy |= 1 << c.clientId
+	}
+	return err
+}
+
+// Record that c killed the enemy at index, which they have to have hit.
+func (g *Game) recordKill(c *Client, index uint16) error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	e, err := g.enemy(index)
+	if e == nil {
+		return err
+	}
+	if e.hitBy&(1<<c.clientId) == 0 {
+		return fmt.Errorf("killed enemy %d without hitting it", index)
+	}
+	if !e.dead {
+		e.dead, e.killer = true, c.clientId
+	}
+	return nil
+}
+
+// Returns the experience c has earned for the enemy at index, which has to
+// be dead and have been hit by them. The player that killed it gets all of
+// its experience and anyone else that hit it gets a share. Each player can
+// only be given experience for an enemy once.
+func (g *Game) claimExperience(c *Client, index uint16) (uint32, error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	e, err := g.enemy(index)
+	if e == nil {
+		return 0, err
+	}
+	bit := uint8(1) << c.clientId
+	if !e.dead {
+		return 0, fmt.Errorf("experience requested for enemy %d before it was killed", index)
+	} else if e.hitBy&bit == 0 {
+		return 0, fmt.Errorf("experience requested for enemy %d without hitting it", index)
+	} else if e.rewarded&bit != 0 {
+		return 0, fmt.Errorf("experience for enemy %d requested twice", index)
+	}
+	params, err := lookupEnemy(g.episode, !g.solo, g.difficulty, e.enemy)
+	if err != nil {
+		return 0, err
+	}
+	e.rewarded |= bit
+	if e.killer != c.clientId {
+		return params.Stats.Experience * assistExperiencePercent / 100, nil
+	}
+	return params.Stats.Experience, nil
+}
+
+// Add experience to the player's character, leveling it up if they've
+// earned enough, and save the result. Experience stops accumulating at the
+// maximum level.
+func giveExperience(c *Client, amount uint32) error {
+	ch := c.character
+	maxExp := levelTable.Levels[ch.Class][MaxLevel-1].Experience
+	exp := ch.Experience + amount
+	if exp > maxExp || exp < ch.Experience {
+		exp = maxExp
+	}
+	if exp <= ch.Experience {
+		return nil
+	}
+	level := levelTable.LevelForExperience(ch.Class, exp)
+	err := saveCharacterProgress(config.DB(), c.guildcard, uint32(c.config.SlotNum), ch.Class, level, exp)
+	if err != nil {
+		log.Error(err.Error())
+		return err
+	}
+	gained := exp - ch.Experience
+	leveledUp := level > ch.Level
+	ch.Experience, ch.Level = exp, level
+
+	c.SendGiveExperience(gained)
+	if leveledUp {
+		// Everyone in the game sees the new level.
+		data := levelUpPacket(c)
+		if c.game != nil {
+			c.game.broadcast(c, data)
+		}
+		sendEncrypted(c, data, uint16(len(data)))
+	}
+	return nil
+}
+
+// Level up subcommand with the player's stats at their current level.
+func levelUpPacket(c *Client) []byte {
+	stats := levelTable.StatsAtLevel(c.character.Class, c.character.Level)
+	pkt := &LevelUpPacket{
+		Header:     BBHeader{Type: GameCommandType},
+		Subcommand: SubLevelUpType,
+		Size:       5,
+		ClientId:   uint16(c.clientId),
+		ATP:        stats.ATP,
+		MST:        stats.MST,
+		EVP:        stats.EVP,
+		HP:         stats.HP,
+		DFP:        stats.DFP,
+		ATA:        stats.ATA,
+		Level:      c.character.Level,
+	}
+	data, _ := util.BytesFromStruct(pkt)
+	return data
+}
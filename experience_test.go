@@ -0,0 +1,170 @@
+/*
+* Archon PSO Server
+* Copyright (C) 2014 Andrew Rodman
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"bytes"
+	"github.com/dcrodman/archon/util"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestClaimExperience(t *testing.T) {
+	killer, _ := newPipeClient(1)
+	defer killer.Close()
+	assist, _ := newPipeClient(1)
+	defer assist.Close()
+	bystander, _ := newPipeClient(1)
+	defer bystander.Close()
+
+	// Savage Wolves are worth 5 experience on Normal.
+	g, _ := NewGame(Episode1, 0, false, []MapEnemy{{Type: 0x43}})
+	g.addPlayer(killer)
+	g.addPlayer(assist)
+	g.addPlayer(bystander)
+
+	g.recordHit(killer, 0)
+	g.recordHit(assist, 0)
+	if _, err := g.claimExperience(killer, 0); err == nil {
+		t.Error("expected experience for a living enemy to be refused")
+	}
+	if err := g.recordKill(bystander, 0); err == nil {
+		t.Error("expected a kill without a hit to be refused")
+	}
+	if err := g.recordKill(killer, 0); err != nil {
+		t.Fatal(err)
+	}
+	g.recordHit(bystander, 0)
+
+	if exp, err := g.claimExperience(killer, 0); exp != 5 || err != nil {
+		t.Errorf("killer got %d experience, %v; want 5", exp, err)
+	}
+	if exp, err := g.claimExperience(assist, 0); exp != 4 || err != nil {
+		t.Errorf("assist got %d experience, %v; want 4", exp, err)
+	}
+	if _, err := g.claimExperience(bystander, 0); err == nil {
+		t.Error("expected experience for hitting a dead enemy to be refused")
+	}
+	if _, err := g.claimExperience(killer, 0); err == nil {
+		t.Error("expected experience to only be given once")
+	}
+	if _, err := g.claimExperience(killer, 1); err == nil {
+		t.Error("expected experience for a nonexistent enemy to be refused")
+	}
+}
+
+func TestClaimExperienceAfterLeaving(t *testing.T) {
+	c, _ := newPipeClient(1)
+	defer c.Close()
+	other, _ := newPipeClient(1)
+	defer other.Close()
+
+	g, _ := NewGame(Episode1, 0, false, []MapEnemy{{Type: 0x43}, {Type: 0x43}})
+	g.addPlayer(other)
+	g.addPlayer(c)
+	g.recordHit(c, 0)
+	g.recordHit(other, 1)
+	g.recordKill(other, 1)
+	g.removePlayer(c)
+
+	// The next player gets the same client id but didn't hit anything.
+	newcomer, _ := newPipeClient(1)
+	defer newcomer.Close()
+	g.addPlayer(newcomer)
+	if err := g.recordKill(newcomer, 0); err == nil {
+		t.Error("expected the hits of the player that left to be forgotten")
+	}
+	if _, err := g.claimExperience(newcomer, 1); err == nil {
+		t.Error("expected experience for an enemy killed before joining to be refused")
+	}
+}
+
+func TestUntrackedEnemies(t *testing.T) {
+	c, _ := newPipeClient(1)
+	defer c.Close()
+	g, _ := NewGame(Episode1, 0, false, nil)
+	g.addPlayer(c)
+
+	if err := g.recordHit(c, 5); err != nil {
+		t.Error(err)
+	}
+	if exp, err := g.claimExperience(c, 5); exp != 0 || err != nil {
+		t.Errorf("got %d experience, %v; want none without any maps", exp, err)
+	}
+}
+
+func TestCreateGameWithoutMaps(t *testing.T) {
+	defer func(saved map[Episode][]MapEnemy) { gameMaps = saved }(gameMaps)
+	var logged bytes.Buffer
+	defer func(saved io.Writer) { log.Out = saved }(log.Out)
+	log.Out = &logged
+
+	server := &BlockServer{name: "BLOCK1", num: 1, lobbies: []*Lobby{newLobby(0), newLobby(1)}}
+	create := func(episode uint8) *Game {
+		c, conn := newPipeClient(8)
+		defer c.Close()
+		readPackets(c, conn)
+		c.character = new(CharacterPreview)
+		if err := server.returnToLobby(c, nil); err != nil {
+			t.Fatal(err)
+		}
+		handlePacket(t, c, server.handleCreateGame,
+			&CreateGamePacket{Header: BBHeader{Type: CreateGameType}, Episode: episode})
+		if c.game == nil {
+			t.Fatal("game wasn't created")
+		}
+		return c.game
+	}
+
+	gameMaps = map[Episode][]MapEnemy{Episode1: {{Type: 0x43}}}
+	if g := create(1); len(g.enemies) != 1 || logged.Len() != 0 {
+		t.Errorf("got %d enemies and logged %q, want 1 and nothing", len(g.enemies), logged.String())
+	}
+	g := create(2)
+	if g.enemies != nil {
+		t.Errorf("got %d enemies in an episode without maps", len(g.enemies))
+	}
+	if !strings.Contains(logged.String(), "without any maps") {
+		t.Errorf("expected a warning about the missing maps, logged %q", logged.String())
+	}
+	if exp, err := g.claimExperience(g.players[0], 0); exp != 0 || err != nil {
+		t.Errorf("got %d experience, %v; want none without any maps", exp, err)
+	}
+}
+
+func TestServerOnlySubcommands(t *testing.T) {
+	c, _ := newPipeClient(1)
+	defer c.Close()
+	g, _ := NewGame(Episode1, 0, false, nil)
+	g.addPlayer(c)
+	c.phase = phaseLobby
+
+	for _, sub := range []uint8{SubGiveExperienceType, SubLevelUpType} {
+		data, _ := util.BytesFromStruct(&GiveExperiencePacket{
+			Header:     BBHeader{Size: 0x10, Type: GameCommandType},
+			Subcommand: sub,
+			Size:       2,
+			Amount:     1000,
+		})
+		c.packetSize = uint16(copy(c.buffer, data))
+		if _, ok := handleGameCommand(c).(*droppedPacketError); !ok {
+			t.Errorf("expected subcommand %02x from the client to be dropped", sub)
+		}
+	}
+}
@@ -0,0 +1,351 @@
+/*
+* Archon PSO Server
+* Copyright (C) 2014 Andrew Rodman
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+* ---------------------------------------------------------------------
+* Fuzz targets that feed arbitrary byte streams through Client.Process
+* and each of the sub-servers' packet handlers. Run one with e.g.:
+*
+*     go test -run '^$' -fuzz FuzzCharacterServer
+*
+* Without -fuzz, the seed corpus is run as a regular test.
+ */
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	crypto "github.com/dcrodman/archon/encryption"
+	"github.com/dcrodman/archon/util"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// In-memory net.Conn that reads from a fixed buffer and discards writes.
+type memConn struct {
+	in *bytes.Reader
+}
+
+func newMemConn(data []byte) *memConn {
+	return &memConn{in: bytes.NewReader(data)}
+}
+
+func (c *memConn) Read(b []byte) (int, error)  { return c.in.Read(b) }
+func (c *memConn) Write(b []byte) (int, error) { return len(b), nil }
+func (c *memConn) Close() error                { return nil }
+func (c *memConn) LocalAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 11000}
+}
+func (c *memConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 40000}
+}
+func (c *memConn) SetDeadline(t time.Time) error      { return nil }
+func (c *memConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *memConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// Database driver that fails every operation so that the handlers can be
+// exercised without a MySQL server; they'll follow their error paths.
+type failingDriver struct{}
+type failingConn struct{}
+
+var errNoDatabase = errors.New("no database in tests")
+
+func (failingDriver) Open(name string) (driver.Conn, error)   { return failingConn{}, nil }
+func (failingConn) Prepare(query string) (driver.Stmt, error) { return nil, errNoDatabase }
+func (failingConn) Close() error                              { return nil }
+func (failingConn) Begin() (driver.Tx, error)                 { return nil, errNoDatabase }
+
+func TestMain(m *testing.M) {
+	log = logrus.New()
+	log.Out = ioutil.Discard
+
+	config.ParametersDir = "config/parameters"
+	if err := config.Finalize(); err != nil {
+		panic(err)
+	}
+	sql.Register("archontest", failingDriver{})
+	config.database, _ = sql.Open("archontest", "")
+
+	// Use a fake patch tree rather than loading one from disk.
+	patchTree = PatchDir{
+		dirname: ".",
+		patches: []*PatchEntry{{filename: "a.txt", relativePath: "missing/a.txt", pathDirs: []string{"."}}},
+		subdirs: []*PatchDir{{
+			dirname: "data",
+			patches: []*PatchEntry{{filename: "b.txt", relativePath: "missing/b.txt", pathDirs: []string{".", "data"}}},
+		}},
+	}
+	buildPatchIndex(&patchTree)
+
+	// Initialize everything but the patch server, which would try to read
+	// the patches from disk. The shipgate sets up the ship list used by the
+	// others so it has to go first.
+	testServers = map[string]Server{
+		"PATCH":     new(PatchServer),
+		"DATA":      new(DataServer),
+		"LOGIN":     new(LoginServer),
+		"CHARACTER": new(CharacterServer),
+		"SHIPGATE":  new(ShipgateServer),
+		"SHIP":      new(ShipServer),
+		"BLOCK":     &BlockServer{name: "BLOCK1", port: "15001", num: 1},
+		"PCLOGIN":   &ClassicLoginServer{version: VersionPC},
+		"GCLOGIN":   &ClassicLoginServer{version: VersionGC},
+		"GCSHIP":    &ClassicShipServer{version: VersionGC},
+		"GCBLOCK":   &ClassicBlockServer{version: VersionGC, name: "GCBLOCK1", port: "15301"},
+	}
+	for _, name := range []string{"SHIPGATE", "DATA", "LOGIN", "CHARACTER", "SHIP", "BLOCK",
+		"PCLOGIN", "GCLOGIN", "GCSHIP", "GCBLOCK"} {
+		testServers[name].Init()
+	}
+	testServers["PATCH"].(*PatchServer).registerHandlers()
+	os.Exit(m.Run())
+}
+
+// Initialized sub-servers, keyed by server type.
+var testServers map[string]Server
+
+// Encrypt data with a cipher matching the one the client will use to decrypt it
+// so that the handlers see the fuzzed bytes as plaintext. The data is padded to
+// the header size since the ciphers only work on whole blocks.
+func encryptForClient(c *Client, data []byte) []byte {
+	var crypt *crypto.PSOCrypt
+	if c.hdrSize == BBHeaderSize {
+		crypt = crypto.NewBBCryptWithVector(c.ClientVector())
+	} else if c.version.isGC() {
+		crypt = crypto.NewGCCryptWithVector(c.ClientVector())
+	} else {
+		crypt = crypto.NewPCCryptWithVector(c.ClientVector())
+	}
+	buf := append([]byte(nil), data...)
+	for len(buf)%int(c.hdrSize) != 0 {
+		buf = append(buf, 0)
+	}
+	crypt.Encrypt(buf, uint32(len(buf)))
+	return buf
+}
+
+// Connect a client to s and feed it data until it's consumed or the
+// connection would be dropped. Any panic fails the test.
+func fuzzServer(t *testing.T, s Server, data []byte) {
+	conn := newMemConn(nil)
+	c, err := s.NewClient(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.connCfg = config.ConnectionConfig(serverType(s))
+	conn.in = bytes.NewReader(encryptForClient(c, data))
+
+	for {
+		if err := c.Process(); err != nil {
+			return
+		}
+		if err := s.Handle(c); err != nil {
+			if _, ok := err.(*droppedPacketError); !ok {
+				return
+			}
+		}
+	}
+}
+
+// Serialize a packet with its size filled in for use as a seed.
+func seedPacket(pkt interface{}) []byte {
+	data, size := util.BytesFromStruct(pkt)
+	data[0], data[1] = byte(size), byte(size>>8)
+	return data
+}
+
+func seedPCHeader(pktType uint16) []byte {
+	return seedPacket(&PCHeader{Type: pktType})
+}
+
+func seedBBHeader(pktType uint16, flags uint32) []byte {
+	return seedPacket(&BBHeader{Type: pktType, Flags: flags})
+}
+
+// Serialize a headerless classic packet body behind a header for version.
+func seedClassic(version ClientVersion, pktType uint8, body interface{}) []byte {
+	var data []byte
+	if version == VersionPC {
+		data, _ = util.BytesFromStruct(&PCv2Header{Type: pktType})
+	} else {
+		data, _ = util.BytesFromStruct(&DCHeader{Type: pktType})
+	}
+	if body != nil {
+		b, _ := util.BytesFromStruct(body)
+		data = append(data, b...)
+	}
+	sizeOff := version.sizeOffset()
+	data[sizeOff], data[sizeOff+1] = byte(len(data)), byte(len(data)>>8)
+	return data
+}
+
+func addSeeds(f *testing.F, seeds ...[]byte) {
+	f.Add([]byte{})
+	f.Add([]byte{0xFF, 0xFF, 0xFF, 0xFF})
+	f.Add([]byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00})
+	for _, seed := range seeds {
+		f.Add(seed)
+	}
+	// All of the seeds sent one after another on the same connection.
+	f.Add(bytes.Join(seeds, nil))
+}
+
+func FuzzClientProcess(f *testing.F) {
+	addSeeds(f, seedPCHeader(PatchWelcomeType), seedBBHeader(LoginType, 0))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, hdrSize := range []uint16{PCHeaderSize, BBHeaderSize} {
+			var cCrypt *crypto.PSOCrypt
+			if hdrSize == PCHeaderSize {
+				cCrypt = crypto.NewPCCrypt()
+			} else {
+				cCrypt = crypto.NewBBCrypt()
+			}
+			conn := newMemConn(nil)
+			c := NewClient(conn, hdrSize, cCrypt, cCrypt)
+			c.connCfg = config.ConnectionConfig("default")
+			conn.in = bytes.NewReader(encryptForClient(c, data))
+			for c.Process() == nil {
+				if len(c.Data()) < int(hdrSize) {
+					t.Fatalf("Process returned a %d byte packet", len(c.Data()))
+				}
+			}
+			c.Close()
+		}
+	})
+}
+
+func FuzzPatchServer(f *testing.F) {
+	addSeeds(f, seedPCHeader(PatchWelcomeType), seedPCHeader(PatchLoginType))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		fuzzServer(t, testServers["PATCH"], data)
+	})
+}
+
+func FuzzDataServer(f *testing.F) {
+	addSeeds(f,
+		seedPCHeader(PatchWelcomeType),
+		seedPCHeader(PatchLoginType),
+		seedPacket(&FileStatusPacket{Header: PCHeader{Type: PatchFileStatusType}, PatchId: 1}),
+		seedPacket(&FileStatusPacket{Header: PCHeader{Type: PatchFileStatusType}, PatchId: 7}),
+		seedPCHeader(PatchClientListDoneType))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		fuzzServer(t, testServers["DATA"], data)
+	})
+}
+
+func FuzzLoginServer(f *testing.F) {
+	addSeeds(f, seedPacket(&LoginPkt{Header: BBHeader{Type: LoginType}}), seedBBHeader(DisconnectType, 0))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		fuzzServer(t, testServers["LOGIN"], data)
+	})
+}
+
+func FuzzCharacterServer(f *testing.F) {
+	addSeeds(f,
+		seedPacket(&LoginPkt{Header: BBHeader{Type: LoginType}}),
+		seedBBHeader(LoginOptionsRequestType, 0),
+		seedPacket(&CharSelectionPacket{Header: BBHeader{Type: LoginCharPreviewReqType}, Slot: 1}),
+		seedBBHeader(LoginChecksumType, 0),
+		seedBBHeader(LoginGuildcardReqType, 0),
+		seedPacket(&GuildcardChunkReqPacket{Header: BBHeader{Type: LoginGuildcardChunkReqType}, Continue: 1}),
+		seedBBHeader(LoginParameterHeaderReqType, 0),
+		seedBBHeader(LoginParameterChunkReqType, 2),
+		seedPacket(&SetFlagPacket{Header: BBHeader{Type: LoginSetFlagType}, Flag: 2}),
+		seedPacket(&CharPreviewPacket{Header: BBHeader{Type: LoginCharPreviewType}, Character: new(CharacterPreview)}),
+		seedPacket(&MenuSelectionPacket{Header: BBHeader{Type: MenuSelectType}, ItemId: 1}))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		fuzzServer(t, testServers["CHARACTER"], data)
+	})
+}
+
+func FuzzShipgateServer(f *testing.F) {
+	addSeeds(f,
+		seedBBHeader(LoginType, 0),
+		seedPacket(&ShipgateEventPacket{Header: BBHeader{Type: ShipgateEventType}, EventType: uint32(EventPlayerLogin)}))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		fuzzServer(t, testServers["SHIPGATE"], data)
+	})
+}
+
+func FuzzShipServer(f *testing.F) {
+	addSeeds(f,
+		seedPacket(&LoginPkt{Header: BBHeader{Type: LoginType}}),
+		seedPacket(&MenuSelectionPacket{Header: BBHeader{Type: MenuSelectType}, MenuId: ShipSelectionMenuId, ItemId: 1}),
+		seedPacket(&MenuSelectionPacket{Header: BBHeader{Type: MenuSelectType}, ItemId: 1}),
+		seedPacket(&MenuSelectionPacket{Header: BBHeader{Type: MenuSelectType}, ItemId: BackMenuItem}),
+		seedBBHeader(PingType, 0))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		fuzzServer(t, testServers["SHIP"], data)
+	})
+}
+
+func FuzzBlockServer(f *testing.F) {
+	addSeeds(f,
+		seedPacket(&LoginPkt{Header: BBHeader{Type: LoginType}}),
+		seedPacket(&ChatPacket{Header: BBHeader{Type: ChatType}, Message: []byte("\t\x00E\x00/\x00")}),
+		seedPacket(&LobbyChangePacket{Header: BBHeader{Type: LobbyChangeType}, LobbyId: 1}),
+		seedPacket(&CreateGamePacket{Header: BBHeader{Type: CreateGameType}, Episode: 1}),
+		seedBBHeader(GameListType, 0),
+		seedPacket(&GameSelectionPacket{Header: BBHeader{Type: MenuSelectType}, MenuId: GameMenuId, ItemId: 1}),
+		seedPacket(&EnemyHitPacket{Header: BBHeader{Type: GameCommandType}, Subcommand: SubEnemyHitType, Size: 3}),
+		seedPacket(&EnemyKilledPacket{Header: BBHeader{Type: GameCommandType}, Subcommand: SubEnemyKilledType,
+			Size: 2, EnemyId: enemyIdBase}),
+		seedBBHeader(LeaveGameType, 0),
+		seedBBHeader(PingType, 0))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		fuzzServer(t, testServers["BLOCK"], data)
+	})
+}
+
+func FuzzClassicLoginServer(f *testing.F) {
+	addSeeds(f,
+		seedClassic(VersionGC, GCVerifyType, new(ClassicVerifyPkt)),
+		seedClassic(VersionGC, GCLoginType, &ClassicLoginPkt{SubVersion: ep3SubVersion}),
+		seedClassic(VersionGC, MenuSelectType, &ClassicMenuSelectionPacket{MenuId: 0x13, ItemId: 1}),
+		seedClassic(VersionPC, ClassicLoginType, new(ClassicLoginPkt)),
+		seedClassic(VersionPC, MenuSelectType, &ClassicMenuSelectionPacket{MenuId: 0x13, ItemId: 1}))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		fuzzServer(t, testServers["PCLOGIN"], data)
+		fuzzServer(t, testServers["GCLOGIN"], data)
+	})
+}
+
+func FuzzClassicShipServer(f *testing.F) {
+	addSeeds(f,
+		seedClassic(VersionGC, GCLoginType, new(ClassicLoginPkt)),
+		seedClassic(VersionGC, MenuSelectType, &ClassicMenuSelectionPacket{MenuId: classicBlockMenuId, ItemId: 1}),
+		seedClassic(VersionGC, MenuSelectType, &ClassicMenuSelectionPacket{MenuId: classicBlockMenuId, ItemId: BackMenuItem}),
+		seedClassic(VersionGC, MenuSelectType, &ClassicMenuSelectionPacket{MenuId: 0x13, ItemId: 1}))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		fuzzServer(t, testServers["GCSHIP"], data)
+	})
+}
+
+func FuzzClassicBlockServer(f *testing.F) {
+	addSeeds(f,
+		seedClassic(VersionGC, GCLoginType, new(ClassicLoginPkt)),
+		seedClassic(VersionGC, ClassicCharDataType, new(ClassicCharDataPacket)),
+		seedClassic(VersionGC, PingType, nil))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		fuzzServer(t, testServers["GCBLOCK"], data)
+	})
+}
@@ -0,0 +1,320 @@
+/*
+* Archon PSO Server
+* Copyright (C) 2014 Andrew Rodman
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+* ---------------------------------------------------------------------
+* Games on a block and the state the server keeps for them so that it
+* doesn't have to take the clients' word for what happens in them.
+ */
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/dcrodman/archon/util"
+	"math/rand"
+	"sync"
+)
+
+const MaxGamePlayers = 4
+
+// Menu ID used by the server for the game list.
+const GameMenuId uint16 = 0x0F
+
+type Game struct {
+	playerSlots
+	id       uint32
+	name     string
+	password string
+
+	episode    Episode
+	difficulty uint8
+	battle     uint8
+	challenge  uint8
+	sectionId  uint8
+	seed       uint32
+	// Single player games use the offline battle parameters.
+	solo bool
+
+	// Enemies in the order the clients number them, or nil if there aren't
+	// any maps for the game's episode and they aren't being tracked.
+	enemies []gameEnemy
+	// Indexes of the enemies that are the rare variant.
+	rareEnemies []uint16
+}
+
+type gameEnemy struct {
+	enemy EnemyType
+	// Bitmasks of the client ids that have hit it and that have been given
+	// experience for it.
+	hitBy    uint8
+	rewarded uint8
+	dead     bool
+	// Client id of the player that killed it.
+	killer uint8
+}
+
+// Create a game with the enemies placed on its maps.
+func NewGame(episode Episode, difficulty uint8, solo bool, placed []MapEnemy) (*Game, error) {
+	placed = rollRareEnemies(episode, placed, func(odds int) bool { return rand.Intn(odds) == 0 })
+	enemies, err := expandMapEnemies(episode, placed)
+	if err != nil {
+		return nil, err
+	}
+	g := &Game{episode: episode, difficulty: difficulty, solo: solo, seed: rand.Uint32()}
+	g.players = make([]*Client, MaxGamePlayers)
+	if enemies != nil {
+		g.enemies = make([]gameEnemy, len(enemies))
+		for i, enemy := range enemies {
+			g.enemies[i].enemy = enemy
+			if rareEnemies[enemy] {
+				g.rareEnemies = append(g.rareEnemies, uint16(i))
+			}
+		}
+	}
+	return g, nil
+}
+
+// Add c to the game in the first free slot.
+func (g *Game) addPlayer(c *Client) error {
+	id, err := g.add(c)
+	if err != nil {
+		return err
+	}
+	c.game, c.clientId = g, id
+	return nil
+}
+
+// Take c out of the game and forget which enemies they hit and were given
+// experience for, since their client id will be given to the next player to
+// join. Returns the number of players left.
+func (g *Game) removePlayer(c *Client) int {
+	left := g.remove(c)
+	g.lock.Lock()
+	for i := range g.enemies {
+		g.enemies[i].hitBy &^= 1 << c.clientId
+		g.enemies[i].rewarded &^= 1 << c.clientId
+	}
+	g.lock.Unlock()
+	c.game = nil
+	return left
+}
+
+var errGameClosed = errors.New("game no longer exists")
+
+// Games that have been created on a block.
+type gameList struct {
+	lock   sync.Mutex
+	games  []*Game
+	nextId uint32
+}
+
+func (l *gameList) add(g *Game) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.nextId++
+	g.id = l.nextId
+	l.games = append(l.games, g)
+}
+
+// Add c to g, as long as the last player hasn't left it in the meantime.
+func (l *gameList) join(g *Game, c *Client) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for _, game := range l.games {
+		if game == g {
+			return g.addPlayer(c)
+		}
+	}
+	return errGameClosed
+}
+
+// Take c out of g, getting rid of the game if they were the last one in it.
+// Returns the number of players left.
+func (l *gameList) leave(g *Game, c *Client) int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	left := g.removePlayer(c)
+	if left == 0 {
+		for i, game := range l.games {
+			if game == g {
+				l.games = append(l.games[:i], l.games[i+1:]...)
+				break
+			}
+		}
+	}
+	return left
+}
+
+func (l *gameList) find(id uint32) *Game {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for _, g := range l.games {
+		if g.id == id {
+			return g
+		}
+	}
+	return nil
+}
+
+func (l *gameList) all() []*Game {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return append([]*Game(nil), l.games...)
+}
+
+func (server *BlockServer) handleCreateGame(c *Client) error {
+	var pkt CreateGamePacket
+	if err := c.ReadPacket(&pkt); err != nil {
+		return err
+	}
+	if c.lobby == nil || c.character == nil {
+		return c.Misbehave("created a game from outside of a lobby")
+	}
+	episode := Episode(pkt.Episode)
+	if episode < Episode1 || episode > Episode4 || pkt.Difficulty >= NumDifficulties {
+		return c.Misbehave(fmt.Sprintf("invalid game episode %d and difficulty %d",
+			pkt.Episode, pkt.Difficulty))
+	}
+	// Let scripts refuse the game or change its settings.
+	args := c.scriptPlayer()
+	args["game"] = util.ConvertFromUtf16(pkt.Name[:])
+	args["episode"] = uint8(episode)
+	args["difficulty"] = pkt.Difficulty
+	args["battle"] = pkt.Battle != 0
+	args["challenge"] = pkt.Challenge != 0
+	args["solo"] = pkt.SinglePlayer != 0
+	if err := scripts.RunHook(HookGameCreate, args); err != nil {
+		c.SendClientMessage(vetoMessage(err))
+		return nil
+	}
+	if e := Episode(args["episode"].(uint8)); e >= Episode1 && e <= Episode4 {
+		episode = e
+	} else {
+		log.Errorf("Script set invalid episode %d for a game", e)
+	}
+	difficulty := args["difficulty"].(uint8)
+	if difficulty >= NumDifficulties {
+		log.Errorf("Script set invalid difficulty %d for a game", difficulty)
+		difficulty = pkt.Difficulty
+	}
+	battle, challenge, solo := args["battle"].(bool), args["challenge"].(bool), args["solo"].(bool)
+	if battle && challenge {
+		log.Errorf("Script set both battle and challenge mode for a game")
+		battle, challenge = pkt.Battle != 0, pkt.Challenge != 0
+	}
+
+	placed := gameMaps[episode]
+	if placed == nil {
+		log.Warnf("Game created in episode %d without any maps; no experience will be given in it", episode)
+	}
+	g, err := NewGame(episode, difficulty, solo, placed)
+	if err != nil {
+		log.Error(err.Error())
+		return err
+	}
+	g.name = args["game"].(string)
+	g.password = util.ConvertFromUtf16(pkt.Password[:])
+	if battle {
+		g.battle = 1
+	}
+	if challenge {
+		g.challenge = 1
+	}
+	g.sectionId = c.character.SectionId
+
+	server.leaveLobby(c)
+	g.addPlayer(c)
+	server.games.add(g)
+	c.SendRareEnemyList(g)
+	c.SendGameJoin(g)
+	c.publishEvent(EventGameJoined, g.id, g.name)
+	return nil
+}
+
+func (server *BlockServer) handleGameList(c *Client) error {
+	if c.lobby == nil {
+		return c.Misbehave("asked for the game list from outside of a lobby")
+	}
+	c.SendGameList(server.games.all())
+	return nil
+}
+
+// Player picked a game to join from the game list.
+func (server *BlockServer) handleGameSelection(c *Client) error {
+	var pkt GameSelectionPacket
+	if err := c.ReadPacket(&pkt); err != nil {
+		return err
+	}
+	if pkt.MenuId != GameMenuId {
+		return c.Misbehave(fmt.Sprintf("invalid menu %d", pkt.MenuId))
+	}
+	if c.lobby == nil || c.character == nil {
+		return c.Misbehave("joined a game from outside of a lobby")
+	}
+	g := server.games.find(pkt.ItemId)
+	switch {
+	case g == nil:
+		c.SendClientMessage("That game no longer exists.")
+		return nil
+	case g.solo:
+		c.SendClientMessage("That game is for one player.")
+		return nil
+	case g.password != "" && util.ConvertFromUtf16(pkt.Password) != g.password:
+		c.SendClientMessage("Incorrect password.")
+		return nil
+	}
+
+	lobby := c.lobby
+	server.leaveLobby(c)
+	if err := server.games.join(g, c); err == errGameClosed {
+		c.SendClientMessage("That game no longer exists.")
+		return server.returnToLobby(c, lobby)
+	} else if err != nil {
+		c.SendClientMessage("That game is full.")
+		return server.returnToLobby(c, lobby)
+	}
+	c.SendRareEnemyList(g)
+	c.SendGameJoin(g)
+	g.broadcast(c, memberJoinPacket(GameAddMemberType, c, g.leader(), 0, server.num))
+	c.publishEvent(EventGameJoined, g.id, g.name)
+	return nil
+}
+
+// Player left their game for the lobby.
+func (server *BlockServer) handleLeaveGame(c *Client) error {
+	if c.game == nil {
+		return c.Misbehave("left a game without being in one")
+	}
+	server.leaveGame(c)
+	return server.returnToLobby(c, nil)
+}
+
+// Take c out of their game, telling the others that they left or getting
+// rid of the game if they were the last one in it.
+func (server *BlockServer) leaveGame(c *Client) {
+	g := c.game
+	if g == nil {
+		return
+	}
+	// The shop they last opened and the weapon the tekker identified for
+	// them were only in that game.
+	c.shop, c.shopType = nil, 0
+	c.identified = nil
+	id := c.clientId
+	if server.games.leave(g, c) > 0 {
+		g.broadcast(c, leaveNoticePacket(GameRemoveMemberType, id, g.leader()))
+	}
+}
@@ -0,0 +1,203 @@
+/*
+* Archon PSO Server
+* Copyright (C) 2014 Andrew Rodman
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+* ---------------------------------------------------------------------
+* Packet handler registration. Each sub-server builds a HandlerRegistry in
+* Init with a handler for each packet type it accepts and passes packets
+* to it from Handle. Middleware wraps handlers with common checks like
+* requiring the client to have logged in.
+ */
+package main
+
+import (
+	"expvar"
+	"fmt"
+	"time"
+)
+
+// Processes the most recently received packet from a client.
+type PacketHandler func(c *Client) error
+
+// Wraps the handler for a packet type with additional behavior.
+type Middleware func(pktType uint16, next PacketHandler) PacketHandler
+
+type handlerEntry struct {
+	handler    PacketHandler
+	middleware []Middleware
+	// The handler wrapped in its own and the registry's middleware.
+	chain PacketHandler
+}
+
+// Packet handlers for a sub-server, keyed by packet type. Handlers should all
+// be registered before the server starts accepting connections.
+type HandlerRegistry struct {
+	name       string
+	handlers   map[uint16]*handlerEntry
+	families   map[uint8]*handlerEntry
+	middleware []Middleware
+	unknown    PacketHandler
+}
+
+func NewHandlerRegistry(name string) *HandlerRegistry {
+	r := &HandlerRegistry{
+		name:     name,
+		handlers: make(map[uint16]*handlerEntry),
+		families: make(map[uint8]*handlerEntry),
+	}
+	r.unknown = r.ignoreUnknown
+	return r
+}
+
+// Register h as the handler for pktType, wrapped in any middleware given
+// (which is applied inside of the registry's own middleware).
+func (r *HandlerRegistry) Handle(pktType uint16, h PacketHandler, mw ...Middleware) {
+	e := &handlerEntry{handler: h, middleware: mw}
+	r.handlers[pktType] = e
+	r.compose(pktType, e)
+}
+
+// Register h as the handler for any of the BB packet types whose low byte is
+// family (e.g. 0x01E8 and 0x03E8 for 0xE8) that don't have their own handler.
+func (r *HandlerRegistry) HandleFamily(family uint8, h PacketHandler, mw ...Middleware) {
+	e := &handlerEntry{handler: h, middleware: mw}
+	r.families[family] = e
+	r.compose(uint16(family), e)
+}
+
+// Add middleware that applies to every handler in the registry.
+func (r *HandlerRegistry) Use(mw ...Middleware) {
+	r.middleware = append(r.middleware, mw...)
+	for t, e := range r.handlers {
+		r.compose(t, e)
+	}
+	for f, e := range r.families {
+		r.compose(uint16(f), e)
+	}
+}
+
+// Set the handler for packets without a registered handler. By default
+// they're logged and otherwise ignored.
+func (r *HandlerRegistry) HandleUnknown(h PacketHandler) {
+	r.unknown = h
+}
+
+func (r *HandlerRegistry) compose(pktType uint16, e *handlerEntry) {
+	h := e.handler
+	for i := len(e.middleware) - 1; i >= 0; i-- {
+		h = e.middleware[i](pktType, h)
+	}
+	for i := len(r.middleware) - 1; i >= 0; i-- {
+		h = r.middleware[i](pktType, h)
+	}
+	e.chain = h
+}
+
+// Pass the client's most recent packet to the handler registered for its type.
+func (r *HandlerRegistry) Dispatch(c *Client) error {
+	pktType := c.packetType(c.Data())
+	if e, ok := r.handlers[pktType]; ok {
+		return e.chain(c)
+	}
+	if e, ok := r.families[uint8(pktType)]; ok && c.version == VersionBB {
+		return e.chain(c)
+	}
+	unknownPackets.Add(r.name, 1)
+	return r.unknown(c)
+}
+
+func (r *HandlerRegistry) ignoreUnknown(c *Client) error {
+	log.Infof("Received unknown packet %02x from %s", c.packetType(c.Data()), c.IPAddr())
+	return nil
+}
+
+// Handler for packets that the client sends but don't require a response.
+func ignorePacket(c *Client) error { return nil }
+
+// Packet counts and handling times exposed through expvar (/debug/vars on
+// the debug web server), keyed by server and packet type.
+var (
+	packetCounts   = expvar.NewMap("packets")
+	packetErrors   = expvar.NewMap("packet_errors")
+	packetTimes    = expvar.NewMap("packet_time_ns")
+	unknownPackets = expvar.NewMap("unknown_packets")
+)
+
+// Drop packets from clients that haven't logged in yet.
+func RequireLogin(pktType uint16, next PacketHandler) PacketHandler {
+	return func(c *Client) error {
+		if !c.handshakeDone {
+			return c.Misbehave(fmt.Sprintf("packet %02x sent before logging in", pktType))
+		}
+		return next(c)
+	}
+}
+
+// Log each packet that's handled at the debug level.
+func LogPackets(pktType uint16, next PacketHandler) PacketHandler {
+	return func(c *Client) error {
+		log.Debugf("Handling packet %02x (%d bytes) from %s", pktType, len(c.Data()), c.IPAddr())
+		return next(c)
+	}
+}
+
+// Returns middleware that counts packets handled by server, how long they
+// took, and how many of them failed.
+func PacketMetrics(server string) Middleware {
+	return func(pktType uint16, next PacketHandler) PacketHandler {
+		key := fmt.Sprintf("%s:%04x", server, pktType)
+		return func(c *Client) error {
+			start := time.Now()
+			err := next(c)
+			packetCounts.Add(key, 1)
+			packetTimes.Add(key, int64(time.Since(start)))
+			if err != nil {
+				packetErrors.Add(key, 1)
+			}
+			return err
+		}
+	}
+}
+
+// Returns middleware that allows each client to send up to burst packets of
+// a type at once and rate per second after that. Anything over the limit is
+// dropped and counts as misbehavior.
+func RateLimit(rate float64, burst int) Middleware {
+	return func(pktType uint16, next PacketHandler) PacketHandler {
+		return func(c *Client) error {
+			// Only the client's own goroutine handles its packets, so no locking.
+			if c.packetLimits == nil {
+				c.packetLimits = make(map[uint16]*tokenBucket)
+			}
+			now := time.Now()
+			bucket, ok := c.packetLimits[pktType]
+			if !ok {
+				bucket = newTokenBucket(rate, burst, now)
+				c.packetLimits[pktType] = bucket
+			}
+			if !bucket.take(now) {
+				return c.Misbehave(fmt.Sprintf("packet %02x rate exceeded", pktType))
+			}
+			return next(c)
+		}
+	}
+}
+
+// Returns a registry for server with the middleware that every sub-server uses.
+func newServerHandlers(server string) *HandlerRegistry {
+	r := NewHandlerRegistry(server)
+	r.Use(PacketMetrics(server), LogPackets)
+	return r
+}
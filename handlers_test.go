@@ -0,0 +1,135 @@
+/*
+* Archon PSO Server
+* Copyright (C) 2014 Andrew Rodman
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"github.com/dcrodman/archon/util"
+	"testing"
+)
+
+// Set the client's current packet to a bare header of the given type.
+func setPacket(c *Client, pktType uint16) {
+	data, _ := util.BytesFromStruct(&BBHeader{Size: BBHeaderSize, Type: pktType})
+	c.packetSize = uint16(copy(c.buffer, data))
+}
+
+func TestHandlerDispatch(t *testing.T) {
+	c, _ := newPipeClient(1)
+	defer c.Close()
+
+	var handled []string
+	record := func(name string) PacketHandler {
+		return func(c *Client) error {
+			handled = append(handled, name)
+			return nil
+		}
+	}
+	r := NewHandlerRegistry("TEST")
+	r.Handle(0x01E8, record("exact"))
+	r.HandleFamily(0xE8, record("family"))
+	r.HandleUnknown(record("unknown"))
+
+	for _, pktType := range []uint16{0x01E8, 0x03E8, 0x0093} {
+		setPacket(c, pktType)
+		if err := r.Dispatch(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []string{"exact", "family", "unknown"}
+	if len(handled) != len(want) {
+		t.Fatalf("handled %v, want %v", handled, want)
+	}
+	for i := range want {
+		if handled[i] != want[i] {
+			t.Errorf("handled %v, want %v", handled, want)
+		}
+	}
+}
+
+// Registry-wide middleware should run outside of the handler's own, in the
+// order they were added, even when added after the handler.
+func TestHandlerMiddlewareOrder(t *testing.T) {
+	c, _ := newPipeClient(1)
+	defer c.Close()
+
+	var order string
+	mark := func(s string) Middleware {
+		return func(pktType uint16, next PacketHandler) PacketHandler {
+			return func(c *Client) error {
+				order += s
+				return next(c)
+			}
+		}
+	}
+	r := NewHandlerRegistry("TEST")
+	r.Handle(PingType, func(c *Client) error {
+		order += "h"
+		return nil
+	}, mark("c"), mark("d"))
+	r.Use(mark("a"), mark("b"))
+
+	setPacket(c, PingType)
+	r.Dispatch(c)
+	if order != "abcdh" {
+		t.Errorf("middleware ran in order %q, want %q", order, "abcdh")
+	}
+}
+
+func TestRequireLogin(t *testing.T) {
+	c, _ := newPipeClient(1)
+	defer c.Close()
+
+	called := false
+	r := NewHandlerRegistry("TEST")
+	r.Handle(MenuSelectType, func(c *Client) error {
+		called = true
+		return nil
+	}, RequireLogin)
+
+	setPacket(c, MenuSelectType)
+	if _, ok := r.Dispatch(c).(*droppedPacketError); !ok || called {
+		t.Error("expected packet from client that hasn't logged in to be dropped")
+	}
+	c.CompleteHandshake()
+	if err := r.Dispatch(c); err != nil || !called {
+		t.Errorf("expected packet to be handled after logging in; got %v", err)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	c, _ := newPipeClient(1)
+	defer c.Close()
+
+	handled := 0
+	r := NewHandlerRegistry("TEST")
+	r.Handle(PingType, func(c *Client) error {
+		handled++
+		return nil
+	}, RateLimit(0.001, 3))
+
+	setPacket(c, PingType)
+	for i := 0; i < 5; i++ {
+		r.Dispatch(c)
+	}
+	if handled != 3 {
+		t.Errorf("handled %d packets, want 3", handled)
+	}
+	if c.misbehaviorCount != 2 {
+		t.Errorf("misbehavior count is %d, want 2", c.misbehaviorCount)
+	}
+}
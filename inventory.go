@@ -0,0 +1,208 @@
+/*
+* Archon PSO Server
+* Copyright (C) 2014 Andrew Rodman
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+* ---------------------------------------------------------------------
+* Characters' meseta and inventories. The inventory is kept in the
+* characters table as the items' data one after another, and the blocks
+* save it whenever the server changes it so that what a player has doesn't
+* depend on what their client says.
+ */
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	MaxInventoryItems = 30
+	MaxMeseta         = 999999
+	// Most tools can be stacked up to this many in one slot.
+	MaxToolStack = 10
+)
+
+type Inventory struct {
+	Meseta uint32
+	Items  []ItemData
+}
+
+// Returns true if item is a tool that's kept in stacks. The number in the
+// stack is the sixth byte of its data.
+func isStackable(item *ItemData) bool {
+	return item.Data[0] == ItemClassTool && item.Data[1] != ToolGroupTechDisk
+}
+
+// Number of items in the stack, which is always one for other items.
+func stackCount(item *ItemData) uint32 {
+	if !isStackable(item) {
+		return 1
+	}
+	return uint32(item.Data[5])
+}
+
+// Returns the kind of item, i.e. the class, group, and index. Technique
+// disks also have their level and technique.
+func itemKind(item *ItemData) [5]uint8 {
+	var kind [5]uint8
+	copy(kind[:3], item.Data[:3])
+	if item.Data[0] == ItemClassTool && item.Data[1] == ToolGroupTechDisk {
+		kind[4] = item.Data[4]
+	}
+	return kind
+}
+
+func (inv *Inventory) clone() *Inventory {
+	c := &Inventory{Meseta: inv.Meseta}
+	c.Items = append([]ItemData(nil), inv.Items...)
+	return c
+}
+
+// Returns the index of the item with id, or -1 if it's not in the inventory.
+func (inv *Inventory) find(id uint32) int {
+	for i := range inv.Items {
+		if inv.Items[i].Id == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// Add item to the inventory, adding it to an existing stack if there is one,
+// and return the item as it ends up in the inventory. A stack keeps its id
+// when items are added to it, so the id of the item being added is dropped.
+func (inv *Inventory) addItem(item ItemData) (ItemData, error) {
+	if isStackable(&item) {
+		if item.Data[5] == 0 || item.Data[5] > MaxToolStack {
+			return ItemData{}, fmt.Errorf("invalid stack of %d", item.Data[5])
+		}
+		for i := range inv.Items {
+			existing := &inv.Items[i]
+			if isStackable(existing) && itemKind(existing) == itemKind(&item) {
+				if existing.Data[5]+item.Data[5] > MaxToolStack {
+					return ItemData{}, fmt.Errorf("stack of %02x%02x%02x would be over %d",
+						item.Data[0], item.Data[1], item.Data[2], MaxToolStack)
+				}
+				existing.Data[5] += item.Data[5]
+				return *existing, nil
+			}
+		}
+	}
+	if inv.find(item.Id) >= 0 {
+		return ItemData{}, fmt.Errorf("item id %08x is already in the inventory", item.Id)
+	}
+	if len(inv.Items) >= MaxInventoryItems {
+		return ItemData{}, fmt.Errorf("inventory is full")
+	}
+	inv.Items = append(inv.Items, item)
+	return item, nil
+}
+
+// Remove amount of the item with id from the inventory and return what was
+// removed. Amount only matters for stacks; the whole item is removed
+// otherwise.
+func (inv *Inventory) removeItem(id, amount uint32) (ItemData, error) {
+	i := inv.find(id)
+	if i < 0 {
+		return ItemData{}, fmt.Errorf("no item with id %08x", id)
+	}
+	item := inv.Items[i]
+	if !isStackable(&item) {
+		inv.Items = append(inv.Items[:i], inv.Items[i+1:]...)
+		return item, nil
+	}
+	if amount == 0 || amount > stackCount(&item) {
+		return ItemData{}, fmt.Errorf("can't remove %d from a stack of %d", amount, stackCount(&item))
+	}
+	if amount == stackCount(&item) {
+		inv.Items = append(inv.Items[:i], inv.Items[i+1:]...)
+	} else {
+		inv.Items[i].Data[5] -= uint8(amount)
+	}
+	item.Data[5] = uint8(amount)
+	return item, nil
+}
+
+// Add meseta, stopping at the most a character can hold.
+func (inv *Inventory) addMeseta(amount uint32) {
+	if amount > MaxMeseta-inv.Meseta {
+		inv.Meseta = MaxMeseta
+	} else {
+		inv.Meseta += amount
+	}
+}
+
+func (inv *Inventory) spendMeseta(amount uint32) error {
+	if amount > inv.Meseta {
+		return fmt.Errorf("%d meseta needed but only has %d", amount, inv.Meseta)
+	}
+	inv.Meseta -= amount
+	return nil
+}
+
+func loadInventory(db queryer, guildcard, slot uint32) (*Inventory, error) {
+	inv := new(Inventory)
+	var data []byte
+	err := db.QueryRow("SELECT meseta, inventory FROM characters "+
+		"WHERE guildcard = ? AND slot_num = ? AND deleted_at = 0",
+		guildcard, slot).Scan(&inv.Meseta, &data)
+	if err != nil {
+		return nil, err
+	}
+	itemSize := binary.Size(ItemData{})
+	if len(data)%itemSize != 0 || len(data)/itemSize > MaxInventoryItems {
+		return nil, fmt.Errorf("Invalid inventory for character in slot %d for guildcard %d", slot, guildcard)
+	}
+	inv.Items = make([]ItemData, len(data)/itemSize)
+	if err := binary.Read(bytes.NewReader(data), binary.LittleEndian, inv.Items); err != nil {
+		return nil, err
+	}
+	return inv, nil
+}
+
+func saveInventory(db queryer, guildcard, slot uint32, inv *Inventory) error {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, inv.Items)
+	_, err := db.Exec("UPDATE characters SET meseta = ?, inventory = ? "+
+		"WHERE guildcard = ? AND slot_num = ? AND deleted_at = 0",
+		inv.Meseta, buf.Bytes(), guildcard, slot)
+	return err
+}
+
+// Player dropped an item on the floor. Scripts can stop the other players
+// from seeing it.
+func handleDropItem(c *Client) error {
+	var pkt DropItemPacket
+	if err := c.ReadPacket(&pkt); err != nil {
+		return err
+	}
+	args := c.scriptPlayer()
+	args["item_id"] = pkt.ItemId
+	args["area"] = pkt.Area
+	args["item"] = ""
+	if c.inventory != nil {
+		if i := c.inventory.find(pkt.ItemId); i >= 0 {
+			d := c.inventory.Items[i].Data
+			args["item"] = fmt.Sprintf("%02x%02x%02x", d[0], d[1], d[2])
+		}
+	}
+	if err := scripts.RunHook(HookItemDrop, args); err != nil {
+		c.SendClientMessage(vetoMessage(err))
+		return nil
+	}
+	forwardGameCommand(c)
+	return nil
+}
@@ -0,0 +1,85 @@
+/*
+* Archon PSO Server
+* Copyright (C) 2014 Andrew Rodman
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import "testing"
+
+func newStack(group, index, count uint8, id uint32) ItemData {
+	item := ItemData{Id: id}
+	item.Data[0], item.Data[1], item.Data[2], item.Data[5] = ItemClassTool, group, index, count
+	return item
+}
+
+func TestInventoryStacks(t *testing.T) {
+	inv := new(Inventory)
+	if _, err := inv.addItem(newStack(0, 0, 4, 1)); err != nil {
+		t.Fatal(err)
+	}
+	merged, err := inv.addItem(newStack(0, 0, 5, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inv.Items) != 1 || stackCount(&inv.Items[0]) != 9 {
+		t.Fatalf("expected one stack of 9, got %v", inv.Items)
+	}
+	if merged != inv.Items[0] || merged.Id != 1 {
+		t.Errorf("added item %+v doesn't match the stack %+v", merged, inv.Items[0])
+	}
+	if _, err := inv.addItem(newStack(0, 0, 2, 3)); err == nil {
+		t.Error("expected a stack over the limit to be rejected")
+	}
+	removed, err := inv.removeItem(1, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stackCount(&removed) != 3 || stackCount(&inv.Items[0]) != 6 {
+		t.Errorf("removed %d leaving %d, want 3 and 6", stackCount(&removed), stackCount(&inv.Items[0]))
+	}
+	if _, err := inv.removeItem(1, 7); err == nil {
+		t.Error("expected removing more than the stack to fail")
+	}
+	if _, err := inv.removeItem(1, 6); err != nil || len(inv.Items) != 0 {
+		t.Errorf("expected removing the whole stack to empty the inventory: %v", err)
+	}
+}
+
+func TestInventoryLimits(t *testing.T) {
+	inv := new(Inventory)
+	for i := 0; i < MaxInventoryItems; i++ {
+		item := *newItem(0x00, 0x01, 0x00)
+		item.Id = uint32(i + 1)
+		if _, err := inv.addItem(item); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := inv.addItem(item); err == nil {
+			t.Fatal("expected a duplicate item id to be rejected")
+		}
+	}
+	if _, err := inv.addItem(ItemData{Id: 100}); err == nil {
+		t.Error("expected adding to a full inventory to fail")
+	}
+
+	inv.Meseta = MaxMeseta - 10
+	inv.addMeseta(100)
+	if inv.Meseta != MaxMeseta {
+		t.Errorf("have %d meseta, want %d", inv.Meseta, MaxMeseta)
+	}
+	if inv.spendMeseta(MaxMeseta+1) == nil {
+		t.Error("expected spending more meseta than the player has to fail")
+	}
+}
@@ -0,0 +1,106 @@
+/*
+* Archon PSO Server
+* Copyright (C) 2014 Andrew Rodman
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+* ---------------------------------------------------------------------
+* Dump of the item definitions for documentation. Item names aren't in the
+* parameter files, so each item is identified by the hex code of its first
+* three bytes (class, group, and index) as used by the client and by item
+* lists elsewhere. Technique disks use the technique in place of the index.
+ */
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+type itemDumpEntry struct {
+	Code       string      `json:"code"`
+	Class      string      `json:"class"`
+	Stars      uint8       `json:"stars"`
+	Price      uint32      `json:"price"`
+	Definition interface{} `json:"definition"`
+}
+
+// Returns an entry for each item in the table in code order.
+func (t *ItemTable) dumpEntries() []itemDumpEntry {
+	var entries []itemDumpEntry
+	add := func(class string, data [3]uint8, def interface{}) {
+		item := &ItemData{}
+		copy(item.Data[:], data[:])
+		if data[0] == ItemClassTool && data[1] == ToolGroupTechDisk {
+			// Technique disks are priced at level 1.
+			item.Data[2], item.Data[4] = 0, data[2]
+		}
+		_, stars, _ := t.lookup(item)
+		price, _ := t.Price(item)
+		entries = append(entries, itemDumpEntry{
+			Code:       fmt.Sprintf("%02x%02x%02x", data[0], data[1], data[2]),
+			Class:      class,
+			Stars:      stars,
+			Price:      price,
+			Definition: def,
+		})
+	}
+	for group := range t.Weapons {
+		for i := range t.Weapons[group] {
+			add("weapon", [3]uint8{ItemClassWeapon, uint8(group), uint8(i)}, t.Weapons[group][i])
+		}
+	}
+	for i := range t.Frames {
+		add("frame", [3]uint8{ItemClassArmor, ArmorGroupFrame, uint8(i)}, t.Frames[i])
+	}
+	for i := range t.Shields {
+		add("shield", [3]uint8{ItemClassArmor, ArmorGroupShield, uint8(i)}, t.Shields[i])
+	}
+	for i := range t.Units {
+		add("unit", [3]uint8{ItemClassArmor, ArmorGroupUnit, uint8(i)}, t.Units[i])
+	}
+	for i := range t.Mags {
+		add("mag", [3]uint8{ItemClassMag, uint8(i), 0}, t.Mags[i])
+	}
+	for group := range t.Tools {
+		class := "tool"
+		if group == ToolGroupTechDisk {
+			class = "technique"
+		}
+		for i := range t.Tools[group] {
+			add(class, [3]uint8{ItemClassTool, uint8(group), uint8(i)}, t.Tools[group][i])
+		}
+	}
+	return entries
+}
+
+// Write every item definition to w as either text or JSON.
+func dumpItems(w io.Writer, t *ItemTable, format string) error {
+	entries := t.dumpEntries()
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entries)
+	case "text":
+		for _, e := range entries {
+			if _, err := fmt.Fprintf(w, "%s %-9s %2d* %8d %+v\n",
+				e.Code, e.Class, e.Stars, e.Price, e.Definition); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown item dump format %q", format)
+}
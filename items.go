@@ -0,0 +1,529 @@
+/*
+* Archon PSO Server
+* Copyright (C) 2014 Andrew Rodman
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+* ---------------------------------------------------------------------
+* Item definitions from ItemPMT.prs and ItemMagEdit.prs. ItemPMT is a REL
+* file whose root is a table of pointers to the definitions of each kind of
+* item along with tables used to price them; weapons and tools are split
+* into groups that match the second byte of the item data. ItemMagEdit has
+* the evolution stage of each mag, after some animation tables we don't use.
+ */
+package main
+
+import (
+	"fmt"
+)
+
+// Item classes, which are the first byte of an item's data.
+const (
+	ItemClassWeapon = 0x00
+	ItemClassArmor  = 0x01
+	ItemClassMag    = 0x02
+	ItemClassTool   = 0x03
+	ItemClassMeseta = 0x04
+)
+
+// Groups of the armor class.
+const (
+	ArmorGroupFrame  = 0x01
+	ArmorGroupShield = 0x02
+	ArmorGroupUnit   = 0x03
+)
+
+const (
+	// Tool group for technique disks, which use the index byte for the
+	// level and the fifth byte for the technique.
+	ToolGroupTechDisk = 0x02
+	NumTechniques     = 19
+	MaxTechLevel      = 30
+
+	// Flag set in a weapon's special byte until it's been identified.
+	WeaponUntekked = 0x80
+	// Percentage attributes a weapon can have.
+	NumWeaponAttributes = 5
+	MaxArmorSlots       = 4
+
+	// Price of rare items, which the shops only buy.
+	rareItemPrice = 80
+	// Items with at least this many stars are rare.
+	rareItemStars = 9
+)
+
+// Layout of ItemPMT's root table; only the entries we use are named.
+const (
+	pmtWeaponTable = iota
+	pmtArmorTable
+	pmtUnitTable
+	pmtToolTable
+	pmtMagTable
+	pmtWeaponSaleDivisors = 8
+	pmtSaleDivisors       = 9
+	pmtStarTable          = 11
+	pmtSpecialTable       = 12
+	pmtMaxTechLevels      = 16
+	pmtRootSize           = 23
+
+	numWeaponGroups       = 0xEE
+	numToolGroups         = 0x1B
+	numWeaponSaleDivisors = 0xA5
+	// Stars are indexed from the id of the first weapon, and the stars of
+	// weapon specials follow the items'.
+	firstStarItemId    = 0xB1
+	specialStarsOffset = 0x256
+	weaponSpecialMask  = 0x3F
+)
+
+// Fields at the start of every item definition.
+type ItemBase struct {
+	// Index of the item's name in the client's text tables.
+	Id         uint32
+	Type       uint16
+	Skin       uint16
+	TeamPoints uint32
+}
+
+type WeaponDef struct {
+	ItemBase
+	ClassFlags  uint16
+	ATPMin      uint16
+	ATPMax      uint16
+	ATPRequired uint16
+	MSTRequired uint16
+	ATARequired uint16
+	MST         uint16
+	MaxGrind    uint8
+	Photon      uint8
+	Special     uint8
+	ATA         uint8
+	StatBoost   uint8
+	Projectile  uint8
+	Trail       [4]int8
+	Color       int8
+	Unknown     [5]uint8
+	TechBoost   uint8
+	ComboType   uint8
+}
+
+// Definition of a frame or shield.
+type ArmorDef struct {
+	ItemBase
+	DFP           uint16
+	EVP           uint16
+	BlockParticle uint8
+	BlockEffect   uint8
+	ClassFlags    uint16
+	RequiredLevel uint8
+	EFR           uint8
+	ETH           uint8
+	EIC           uint8
+	EDK           uint8
+	ELT           uint8
+	DFPRange      uint8
+	EVPRange      uint8
+	StatBoost     uint8
+	TechBoost     uint8
+	Unknown       uint16
+}
+
+type UnitDef struct {
+	ItemBase
+	Stat           uint16
+	StatAmount     uint16
+	ModifierAmount int16
+	Unused         [2]uint8
+}
+
+type MagDef struct {
+	ItemBase
+	FeedTable    uint16
+	PhotonBlast  uint8
+	Activation   uint8
+	OnPBFull     uint8
+	OnLowHP      uint8
+	OnDeath      uint8
+	OnBoss       uint8
+	OnPBFullFlag uint8
+	OnLowHPFlag  uint8
+	OnDeathFlag  uint8
+	OnBossFlag   uint8
+	ClassFlags   uint16
+	Unused       uint16
+}
+
+type ToolDef struct {
+	ItemBase
+	Amount   uint16
+	Tech     uint16
+	Cost     int32
+	ItemFlag uint32
+}
+
+// Divisors used to price items other than weapons.
+type saleDivisors struct {
+	Armor  float32
+	Shield float32
+	Unit   float32
+	Mag    float32
+}
+
+type ItemTable struct {
+	// Weapons and tools indexed by group and index.
+	Weapons [][]WeaponDef
+	Frames  []ArmorDef
+	Shields []ArmorDef
+	Units   []UnitDef
+	Mags    []MagDef
+	Tools   [][]ToolDef
+	// Highest level of each technique each class can learn, minus one, or
+	// 0xFF if they can't learn it.
+	MaxTechLevels [NumTechniques][NumCharClasses]uint8
+	// Evolution stage of each mag.
+	MagEvolutions []uint8
+
+	weaponSaleDivisors [numWeaponSaleDivisors]float32
+	saleDivisors       saleDivisors
+	stars              []uint8
+}
+
+// Table loaded from the parameters directory at startup.
+var itemTable *ItemTable
+
+func LoadItemTable(pmtPath, magEditPath string) (*ItemTable, error) {
+	data, err := readPrsFile(pmtPath)
+	if err != nil {
+		return nil, err
+	}
+	t, err := parseItemPMT(data)
+	if err != nil {
+		return nil, err
+	}
+	if data, err = readPrsFile(magEditPath); err != nil {
+		return nil, err
+	}
+	if t.MagEvolutions, err = parseMagEvolutions(data, len(t.Mags)); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Load the item tables from the files in dir.
+func loadItemTable(dir string) (*ItemTable, error) {
+	return LoadItemTable(dir+"/ItemPMT.prs", dir+"/ItemMagEdit.prs")
+}
+
+// Pointer to a list of definitions.
+type relList struct {
+	Count  uint32
+	Offset uint32
+}
+
+func parseItemPMT(data []byte) (*ItemTable, error) {
+	rootOffset, err := relRoot(data)
+	if err != nil {
+		return nil, err
+	}
+	var root [pmtRootSize]uint32
+	if err := readRel(data, rootOffset, &root); err != nil {
+		return nil, err
+	}
+	t := new(ItemTable)
+
+	var weaponLists [numWeaponGroups]relList
+	if err := readRel(data, root[pmtWeaponTable], &weaponLists); err != nil {
+		return nil, fmt.Errorf("weapon table: %s", err)
+	}
+	t.Weapons = make([][]WeaponDef, numWeaponGroups)
+	for i, l := range weaponLists {
+		t.Weapons[i] = make([]WeaponDef, l.Count)
+		if err := readRel(data, l.Offset, t.Weapons[i]); err != nil {
+			return nil, fmt.Errorf("weapon group %02x: %s", i, err)
+		}
+	}
+
+	var armorLists [2]relList
+	if err := readRel(data, root[pmtArmorTable], &armorLists); err != nil {
+		return nil, fmt.Errorf("armor table: %s", err)
+	}
+	t.Frames = make([]ArmorDef, armorLists[0].Count)
+	t.Shields = make([]ArmorDef, armorLists[1].Count)
+	if err := readRel(data, armorLists[0].Offset, t.Frames); err != nil {
+		return nil, fmt.Errorf("frames: %s", err)
+	}
+	if err := readRel(data, armorLists[1].Offset, t.Shields); err != nil {
+		return nil, fmt.Errorf("shields: %s", err)
+	}
+
+	var unitList, magList relList
+	if err := readRel(data, root[pmtUnitTable], &unitList); err != nil {
+		return nil, fmt.Errorf("unit table: %s", err)
+	}
+	t.Units = make([]UnitDef, unitList.Count)
+	if err := readRel(data, unitList.Offset, t.Units); err != nil {
+		return nil, fmt.Errorf("units: %s", err)
+	}
+	if err := readRel(data, root[pmtMagTable], &magList); err != nil {
+		return nil, fmt.Errorf("mag table: %s", err)
+	}
+	t.Mags = make([]MagDef, magList.Count)
+	if err := readRel(data, magList.Offset, t.Mags); err != nil {
+		return nil, fmt.Errorf("mags: %s", err)
+	}
+
+	var toolLists [numToolGroups]relList
+	if err := readRel(data, root[pmtToolTable], &toolLists); err != nil {
+		return nil, fmt.Errorf("tool table: %s", err)
+	}
+	t.Tools = make([][]ToolDef, numToolGroups)
+	for i, l := range toolLists {
+		t.Tools[i] = make([]ToolDef, l.Count)
+		if err := readRel(data, l.Offset, t.Tools[i]); err != nil {
+			return nil, fmt.Errorf("tool group %02x: %s", i, err)
+		}
+	}
+
+	if err := readRel(data, root[pmtWeaponSaleDivisors], &t.weaponSaleDivisors); err != nil {
+		return nil, fmt.Errorf("weapon sale divisors: %s", err)
+	}
+	if err := readRel(data, root[pmtSaleDivisors], &t.saleDivisors); err != nil {
+		return nil, fmt.Errorf("sale divisors: %s", err)
+	}
+	if err := readRel(data, root[pmtMaxTechLevels], &t.MaxTechLevels); err != nil {
+		return nil, fmt.Errorf("technique levels: %s", err)
+	}
+	// The star table runs up to the table that follows it.
+	if root[pmtSpecialTable] <= root[pmtStarTable] || int(root[pmtSpecialTable]) > len(data) {
+		return nil, fmt.Errorf("star table at 0x%x is invalid", root[pmtStarTable])
+	}
+	t.stars = data[root[pmtStarTable]:root[pmtSpecialTable]]
+	return t, nil
+}
+
+// Parse the evolution stage of each of the numMags mags from ItemMagEdit.
+func parseMagEvolutions(data []byte, numMags int) ([]uint8, error) {
+	rootOffset, err := relRoot(data)
+	if err != nil {
+		return nil, err
+	}
+	var root [6]uint32
+	if err := readRel(data, rootOffset, &root); err != nil {
+		return nil, err
+	}
+	evolutions := make([]uint8, numMags)
+	if err := readRel(data, root[5], evolutions); err != nil {
+		return nil, fmt.Errorf("mag evolutions: %s", err)
+	}
+	return evolutions, nil
+}
+
+// Star rating of the item with the definition's id.
+func (t *ItemTable) Stars(id uint32) uint8 {
+	if id < firstStarItemId || int(id-firstStarItemId) >= len(t.stars) {
+		return 0
+	}
+	return t.stars[id-firstStarItemId]
+}
+
+func (t *ItemTable) Weapon(group, index uint8) (*WeaponDef, error) {
+	if int(group) >= len(t.Weapons) || int(index) >= len(t.Weapons[group]) {
+		return nil, fmt.Errorf("no weapon %02x%02x", group, index)
+	}
+	return &t.Weapons[group][index], nil
+}
+
+// Returns the definition of a frame or shield.
+func (t *ItemTable) Armor(group, index uint8) (*ArmorDef, error) {
+	var defs []ArmorDef
+	switch group {
+	case ArmorGroupFrame:
+		defs = t.Frames
+	case ArmorGroupShield:
+		defs = t.Shields
+	}
+	if int(index) >= len(defs) {
+		return nil, fmt.Errorf("no armor %02x%02x", group, index)
+	}
+	return &defs[index], nil
+}
+
+func (t *ItemTable) Unit(index uint8) (*UnitDef, error) {
+	if int(index) >= len(t.Units) {
+		return nil, fmt.Errorf("no unit %02x", index)
+	}
+	return &t.Units[index], nil
+}
+
+func (t *ItemTable) Mag(index uint8) (*MagDef, error) {
+	if int(index) >= len(t.Mags) {
+		return nil, fmt.Errorf("no mag %02x", index)
+	}
+	return &t.Mags[index], nil
+}
+
+// Returns the definition of the tool; for technique disks index is the
+// technique rather than the level.
+func (t *ItemTable) Tool(group, index uint8) (*ToolDef, error) {
+	if int(group) >= len(t.Tools) || int(index) >= len(t.Tools[group]) {
+		return nil, fmt.Errorf("no tool %02x%02x", group, index)
+	}
+	return &t.Tools[group][index], nil
+}
+
+// Returns the definition of the item and its star rating.
+func (t *ItemTable) lookup(item *ItemData) (interface{}, uint8, error) {
+	d := &item.Data
+	switch d[0] {
+	case ItemClassWeapon:
+		def, err := t.Weapon(d[1], d[2])
+		if err != nil {
+			return nil, 0, err
+		}
+		return def, t.Stars(def.Id), nil
+	case ItemClassArmor:
+		if d[1] == ArmorGroupUnit {
+			def, err := t.Unit(d[2])
+			if err != nil {
+				return nil, 0, err
+			}
+			return def, t.Stars(def.Id), nil
+		}
+		def, err := t.Armor(d[1], d[2])
+		if err != nil {
+			return nil, 0, err
+		}
+		return def, t.Stars(def.Id), nil
+	case ItemClassMag:
+		def, err := t.Mag(d[1])
+		return def, 0, err
+	case ItemClassTool:
+		index := d[2]
+		if d[1] == ToolGroupTechDisk {
+			index = d[4]
+		}
+		def, err := t.Tool(d[1], index)
+		return def, 0, err
+	case ItemClassMeseta:
+		return nil, 0, nil
+	}
+	return nil, 0, fmt.Errorf("invalid item class %02x", d[0])
+}
+
+// Returns true if the item is rare, which is decided by its star rating.
+func (t *ItemTable) IsRare(item *ItemData) bool {
+	_, stars, err := t.lookup(item)
+	return err == nil && stars >= rareItemStars
+}
+
+// Check that the item exists and that its data is within the limits the
+// game allows for it.
+func (t *ItemTable) ValidateItem(item *ItemData) error {
+	def, _, err := t.lookup(item)
+	if err != nil {
+		return err
+	}
+	d := &item.Data
+	switch def := def.(type) {
+	case *WeaponDef:
+		if d[3] > def.MaxGrind {
+			return fmt.Errorf("weapon %02x%02x ground to %d past its maximum of %d",
+				d[1], d[2], d[3], def.MaxGrind)
+		}
+		seen := make(map[uint8]bool)
+		for i := 6; i < 12; i += 2 {
+			attr, percent := d[i], int8(d[i+1])
+			if attr == 0 {
+				continue
+			}
+			if attr > NumWeaponAttributes || seen[attr] || percent > 100 || percent < -100 {
+				return fmt.Errorf("weapon %02x%02x has invalid attribute %d: %d%%",
+					d[1], d[2], attr, percent)
+			}
+			seen[attr] = true
+		}
+	case *ArmorDef:
+		if d[1] == ArmorGroupFrame && d[5] > MaxArmorSlots {
+			return fmt.Errorf("frame %02x has %d slots", d[2], d[5])
+		}
+	case *ToolDef:
+		if d[1] == ToolGroupTechDisk && d[2] >= MaxTechLevel {
+			return fmt.Errorf("technique disk level %d is above %d", d[2]+1, MaxTechLevel)
+		}
+	}
+	return nil
+}
+
+// Price of the item in the shops, which sell it for this and buy it for an
+// eighth of it.
+func (t *ItemTable) Price(item *ItemData) (uint32, error) {
+	def, stars, err := t.lookup(item)
+	if err != nil {
+		return 0, err
+	}
+	if stars >= rareItemStars {
+		return rareItemPrice, nil
+	}
+	d := &item.Data
+	switch def := def.(type) {
+	case *WeaponDef:
+		if d[4]&WeaponUntekked != 0 {
+			return 8, nil
+		}
+		if int(d[1]) >= len(t.weaponSaleDivisors) || t.weaponSaleDivisors[d[1]] <= 0 {
+			return 0, fmt.Errorf("no price for weapon %02x%02x", d[1], d[2])
+		}
+		atp := float64(def.ATPMax) + float64(d[3])
+		price := atp * atp / float64(t.weaponSaleDivisors[d[1]])
+		// Attributes raise (or if they're negative, lower) the price by their
+		// percentage, though never below nothing.
+		bonus := 0.0
+		for i := 6; i < 12; i += 2 {
+			if d[i] > 0 && d[i] <= NumWeaponAttributes {
+				bonus += float64(int8(d[i+1]))
+			}
+		}
+		if bonus < -100 {
+			bonus = -100
+		}
+		special := 0.0
+		if d[4]&weaponSpecialMask != 0 {
+			special = float64(t.Stars(uint32(d[4]&weaponSpecialMask) + specialStarsOffset))
+		}
+		return uint32(price*(100+bonus)/100 + 1000*special*special), nil
+	case *ArmorDef:
+		divisor := t.saleDivisors.Armor
+		if d[1] == ArmorGroupShield {
+			divisor = t.saleDivisors.Shield
+		}
+		power := float64(def.DFP) + float64(def.EVP) + float64(d[6]) + float64(d[8])
+		slots := 0.0
+		if d[1] == ArmorGroupFrame {
+			slots = float64(d[5])
+		}
+		return uint32(int32(power*power/float64(divisor)) +
+			int32(70*(slots+1)*float64(def.RequiredLevel+1))), nil
+	case *UnitDef:
+		return uint32(t.saleDivisors.Unit), nil
+	case *MagDef:
+		return uint32(t.saleDivisors.Mag * float32(d[2]+1)), nil
+	case *ToolDef:
+		if d[1] == ToolGroupTechDisk {
+			return uint32(def.Cost) * uint32(d[2]+1), nil
+		}
+		return uint32(def.Cost), nil
+	}
+	// Meseta is worth the amount.
+	return uint32(item.Data2[0]) | uint32(item.Data2[1])<<8 |
+		uint32(item.Data2[2])<<16 | uint32(item.Data2[3])<<24, nil
+}
@@ -0,0 +1,176 @@
+/*
+* Archon PSO Server
+* Copyright (C) 2014 Andrew Rodman
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func loadTestItemTable(t *testing.T) *ItemTable {
+	table, err := loadItemTable("config/parameters")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return table
+}
+
+func newItem(data ...uint8) *ItemData {
+	item := new(ItemData)
+	copy(item.Data[:], data)
+	return item
+}
+
+func TestItemTable(t *testing.T) {
+	table := loadTestItemTable(t)
+	saber, err := table.Weapon(0x01, 0x00)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saber.ATPMin != 40 || saber.ATPMax != 55 || saber.ATA != 30 || saber.MaxGrind != 35 {
+		t.Errorf("unexpected Saber definition %+v", saber)
+	}
+	if stars := table.Stars(saber.Id); stars != 0 {
+		t.Errorf("Saber has %d stars, want 0", stars)
+	}
+	if !table.IsRare(newItem(0x00, 0x01, 0x05)) {
+		t.Error("expected DB's Saber to be rare")
+	}
+	monomate, err := table.Tool(0x00, 0x00)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if monomate.Cost != 50 {
+		t.Errorf("Monomate costs %d, want 50", monomate.Cost)
+	}
+	if len(table.Tools[ToolGroupTechDisk]) != NumTechniques {
+		t.Errorf("%d technique disks, want %d", len(table.Tools[ToolGroupTechDisk]), NumTechniques)
+	}
+	// HUmar can learn Foie up to level 15 but can't learn Grants.
+	if lvl := table.MaxTechLevels[0][Humar]; lvl != 14 {
+		t.Errorf("HUmar can learn Foie to level %d, want 15", lvl+1)
+	}
+	if lvl := table.MaxTechLevels[9][Humar]; lvl != 0xFF {
+		t.Errorf("HUmar can learn Grants to level %d", lvl+1)
+	}
+	if len(table.MagEvolutions) != len(table.Mags) || table.MagEvolutions[0] != 0 {
+		t.Errorf("unexpected mag evolutions %v", table.MagEvolutions)
+	}
+}
+
+func TestItemPrice(t *testing.T) {
+	table := loadTestItemTable(t)
+	tests := []struct {
+		item  *ItemData
+		price uint32
+	}{
+		{newItem(0x03, 0x00, 0x00), 50},
+		// Technique disks cost more for each level.
+		{newItem(0x03, 0x02, 0x04, 0x00, 0x00), 500},
+		{newItem(0x00, 0x01, 0x00, 0x00, WeaponUntekked|0x01), 8},
+		{newItem(0x00, 0x01, 0x05), rareItemPrice},
+	}
+	for _, test := range tests {
+		price, err := table.Price(test.item)
+		if err != nil {
+			t.Errorf("%x: %s", test.item.Data, err)
+		} else if price != test.price {
+			t.Errorf("%x costs %d, want %d", test.item.Data, price, test.price)
+		}
+	}
+
+	// Grinds, attributes, and specials all make a weapon more valuable.
+	base, _ := table.Price(newItem(0x00, 0x01, 0x00))
+	for _, item := range []*ItemData{
+		newItem(0x00, 0x01, 0x00, 0x05),
+		newItem(0x00, 0x01, 0x00, 0x00, 0x01),
+		newItem(0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x01, 20),
+	} {
+		if price, _ := table.Price(item); price <= base {
+			t.Errorf("%x costs %d, no more than the base %d", item.Data, price, base)
+		}
+	}
+	if _, err := table.Price(newItem(0x00, 0xFF, 0x00)); err == nil {
+		t.Error("expected a price for a nonexistent weapon to fail")
+	}
+
+	// Negative attributes make it cheaper, down to nothing.
+	if price, _ := table.Price(newItem(0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x01, 0xCE)); price >= base {
+		t.Errorf("weapon with -50%% costs %d, no less than the base %d", price, base)
+	}
+	worthless := newItem(0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x01, 0x9C, 0x02, 0x9C, 0x03, 0x9C)
+	if price, _ := table.Price(worthless); price != 0 {
+		t.Errorf("weapon with -300%% costs %d, want 0", price)
+	}
+}
+
+func TestValidateItem(t *testing.T) {
+	table := loadTestItemTable(t)
+	valid := []*ItemData{
+		newItem(0x00, 0x01, 0x00, 35, 0x00, 0x00, 0x01, 50, 0x02, 0xCE),
+		newItem(0x01, 0x01, 0x00, 0x00, 0x00, 0x04),
+		newItem(0x01, 0x03, 0x00),
+		newItem(0x02, 0x00),
+		newItem(0x03, 0x02, 29, 0x00, 0x00),
+		newItem(0x04),
+	}
+	for _, item := range valid {
+		if err := table.ValidateItem(item); err != nil {
+			t.Errorf("%x: %s", item.Data, err)
+		}
+	}
+	invalid := []*ItemData{
+		// Ground past the maximum.
+		newItem(0x00, 0x01, 0x00, 36),
+		// The same attribute twice.
+		newItem(0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x01, 10, 0x01, 10),
+		newItem(0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x06, 10),
+		newItem(0x01, 0x01, 0x00, 0x00, 0x00, 0x05),
+		newItem(0x01, 0x04, 0x00),
+		newItem(0x03, 0x02, 30, 0x00, 0x00),
+		newItem(0x03, 0x02, 0x00, 0x00, NumTechniques),
+		newItem(0x05),
+	}
+	for _, item := range invalid {
+		if table.ValidateItem(item) == nil {
+			t.Errorf("expected %x to be invalid", item.Data)
+		}
+	}
+}
+
+func TestDumpItems(t *testing.T) {
+	table := loadTestItemTable(t)
+	var buf bytes.Buffer
+	if err := dumpItems(&buf, table, "json"); err != nil {
+		t.Fatal(err)
+	}
+	var entries []itemDumpEntry
+	if err := json.Unmarshal(buf.Bytes(), &entries); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) == 0 {
+		t.Fatal("no items dumped")
+	}
+	if entries[0].Code != "000000" || entries[0].Class != "weapon" {
+		t.Errorf("unexpected first entry %+v", entries[0])
+	}
+	if err := dumpItems(&buf, table, "xml"); err == nil {
+		t.Error("expected an unknown format to fail")
+	}
+}
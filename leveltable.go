@@ -0,0 +1,184 @@
+/*
+* Archon PSO Server
+* Copyright (C) 2014 Andrew Rodman
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+* ---------------------------------------------------------------------
+* Parser for PlyLevelTbl.prs, which holds each class's starting stats and
+* the stats gained and experience required for each level. Like most of the
+* game's parameter files it's a REL file: the data is followed by a table of
+* pointers and a trailer giving the offset of the root structure, which for
+* this file is a pointer to the table of base stats and a pointer to the
+* table of per-level entries for each class.
+*
+* Levels are zero-based the same way they are in the character data, so a
+* level 1 character has level 0.
+ */
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/dcrodman/archon/prs"
+	"io/ioutil"
+)
+
+const (
+	NumCharClasses = 12
+	MaxLevel       = 200
+
+	// Size of the trailer at the end of a REL file.
+	relTrailerSize = 0x20
+)
+
+// Stats gained on reaching a level and the total experience needed for it.
+type LevelEntry struct {
+	ATP        uint8
+	MST        uint8
+	EVP        uint8
+	HP         uint8
+	DFP        uint8
+	ATA        uint8
+	LCK        uint8
+	TP         uint8
+	Experience uint32
+}
+
+type PlayerLevelTable struct {
+	// Starting stats, indexed by CharClass.
+	BaseStats [NumCharClasses]CharacterStats
+	// Level entries for each class. The first entry for each class is the
+	// starting level and doesn't add anything.
+	Levels [NumCharClasses][MaxLevel]LevelEntry
+}
+
+// Table loaded from the parameters directory at startup.
+var levelTable *PlayerLevelTable
+
+// Read and decompress a PRS compressed parameter file.
+func readPrsFile(path string) ([]byte, error) {
+	compressed, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return prs.Decompress(compressed)
+}
+
+// Returns the offset of the root structure of a REL file.
+func relRoot(data []byte) (uint32, error) {
+	if len(data) < relTrailerSize {
+		return 0, fmt.Errorf("REL file too short (%d bytes)", len(data))
+	}
+	root := binary.LittleEndian.Uint32(data[len(data)-relTrailerSize+0x10:])
+	if int(root) >= len(data)-relTrailerSize {
+		return 0, fmt.Errorf("REL root offset 0x%x out of range", root)
+	}
+	return root, nil
+}
+
+// Decode the little-endian value at offset in data into v.
+func readRel(data []byte, offset uint32, v interface{}) error {
+	if int(offset) >= len(data) {
+		return fmt.Errorf("offset 0x%x out of range", offset)
+	}
+	if binary.Size(v) > len(data)-int(offset) {
+		return fmt.Errorf("%d bytes at offset 0x%x run past the end of the file", binary.Size(v), offset)
+	}
+	return binary.Read(bytes.NewReader(data[offset:]), binary.LittleEndian, v)
+}
+
+func LoadPlayerLevelTable(path string) (*PlayerLevelTable, error) {
+	data, err := readPrsFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parsePlayerLevelTable(data)
+}
+
+func parsePlayerLevelTable(data []byte) (*PlayerLevelTable, error) {
+	root, err := relRoot(data)
+	if err != nil {
+		return nil, err
+	}
+	var tables [2]uint32
+	if err := readRel(data, root, &tables); err != nil {
+		return nil, err
+	}
+	var statsPtrs, levelPtrs [NumCharClasses]uint32
+	if err := readRel(data, tables[0], &statsPtrs); err != nil {
+		return nil, err
+	}
+	if err := readRel(data, tables[1], &levelPtrs); err != nil {
+		return nil, err
+	}
+
+	t := new(PlayerLevelTable)
+	for class := 0; class < NumCharClasses; class++ {
+		if err := readRel(data, statsPtrs[class], &t.BaseStats[class]); err != nil {
+			return nil, fmt.Errorf("base stats for class %d: %s", class, err)
+		}
+		if err := readRel(data, levelPtrs[class], &t.Levels[class]); err != nil {
+			return nil, fmt.Errorf("levels for class %d: %s", class, err)
+		}
+		for level := 1; level < MaxLevel; level++ {
+			if t.Levels[class][level].Experience < t.Levels[class][level-1].Experience {
+				return nil, fmt.Errorf("experience for class %d decreases at level %d", class, level)
+			}
+		}
+	}
+	return t, nil
+}
+
+// Stats of a character of class with no bonuses after reaching level.
+func (t *PlayerLevelTable) StatsAtLevel(class uint8, level uint32) CharacterStats {
+	stats := t.BaseStats[class]
+	for i := uint32(1); i <= level && i < MaxLevel; i++ {
+		e := &t.Levels[class][i]
+		stats.ATP += uint16(e.ATP)
+		stats.MST += uint16(e.MST)
+		stats.EVP += uint16(e.EVP)
+		stats.HP += uint16(e.HP)
+		stats.DFP += uint16(e.DFP)
+		stats.ATA += uint16(e.ATA)
+		stats.LCK += uint16(e.LCK)
+	}
+	return stats
+}
+
+// Level a character of class with experience should be at.
+func (t *PlayerLevelTable) LevelForExperience(class uint8, experience uint32) uint32 {
+	levels := &t.Levels[class]
+	level := uint32(0)
+	for level+1 < MaxLevel && levels[level+1].Experience <= experience {
+		level++
+	}
+	return level
+}
+
+// Make sure that level and experience are possible for a character of class
+// and agree with each other.
+func (t *PlayerLevelTable) ValidateProgress(class uint8, level, experience uint32) error {
+	if int(class) >= NumCharClasses {
+		return fmt.Errorf("invalid character class %d", class)
+	}
+	if level >= MaxLevel {
+		return fmt.Errorf("level %d is above the maximum of %d", level+1, MaxLevel)
+	}
+	if expected := t.LevelForExperience(class, experience); expected != level {
+		return fmt.Errorf("level %d doesn't match %d experience (expected level %d)",
+			level+1, experience, expected+1)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+/*
+* Archon PSO Server
+* Copyright (C) 2014 Andrew Rodman
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import "testing"
+
+func TestPlayerLevelTable(t *testing.T) {
+	table, err := LoadPlayerLevelTable("config/parameters/PlyLevelTbl.prs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	humar := table.BaseStats[Humar]
+	if humar.ATP != 35 || humar.HP != 20 || humar.LCK != 10 {
+		t.Errorf("unexpected HUmar base stats %+v", humar)
+	}
+	for class := uint8(0); class < NumCharClasses; class++ {
+		if table.Levels[class][0] != (LevelEntry{}) {
+			t.Errorf("class %d gains stats at the first level", class)
+		}
+		if table.StatsAtLevel(class, 0) != table.BaseStats[class] {
+			t.Errorf("class %d doesn't start with its base stats", class)
+		}
+	}
+	if exp := table.Levels[Humar][1].Experience; exp != 50 {
+		t.Errorf("HUmar needs %d experience for level 2, want 50", exp)
+	}
+	if s := table.StatsAtLevel(uint8(Humar), 1); s.ATP != humar.ATP+7 {
+		t.Errorf("HUmar has %d ATP at level 2, want %d", s.ATP, humar.ATP+7)
+	}
+}
+
+func TestValidateProgress(t *testing.T) {
+	table, err := LoadPlayerLevelTable("config/parameters/PlyLevelTbl.prs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := table.Levels[Humar][MaxLevel-1].Experience
+	valid := []struct{ level, exp uint32 }{{0, 0}, {0, 49}, {1, 50}, {MaxLevel - 1, last}, {MaxLevel - 1, last + 1000}}
+	for _, v := range valid {
+		if err := table.ValidateProgress(uint8(Humar), v.level, v.exp); err != nil {
+			t.Errorf("level %d with %d experience: %s", v.level, v.exp, err)
+		}
+	}
+	invalid := []struct{ level, exp uint32 }{{1, 0}, {0, 50}, {MaxLevel, last}}
+	for _, v := range invalid {
+		if table.ValidateProgress(uint8(Humar), v.level, v.exp) == nil {
+			t.Errorf("expected level %d with %d experience to be rejected", v.level, v.exp)
+		}
+	}
+	if table.ValidateProgress(NumCharClasses, 0, 0) == nil {
+		t.Error("expected invalid class to be rejected")
+	}
+}
+
+func TestParsePlayerLevelTableTruncated(t *testing.T) {
+	data, err := readPrsFile("config/parameters/PlyLevelTbl.prs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range []int{0, 0x10, len(data) / 2} {
+		if _, err := parsePlayerLevelTable(data[:n]); err == nil {
+			t.Errorf("expected %d bytes to fail to parse", n)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+/*
+* Archon PSO Server
+* Copyright (C) 2014 Andrew Rodman
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+* ---------------------------------------------------------------------
+* Connection limiting applied by the Dispatcher before a new connection
+* is handed off to one of the sub-servers.
+ */
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// How long an IP's limiter state is kept around after its last connection.
+const limiterStateTTL = 10 * time.Minute
+
+// Minimum time between log messages about rejected connections from one IP.
+const rejectionLogInterval = time.Minute
+
+// Simple token bucket; each connection attempt consumes one token and the
+// bucket is refilled continuously at rate tokens per second.
+type tokenBucket struct {
+	rate     float64
+	capacity float64
+	tokens   float64
+	last     time.Time
+}
+
+func newTokenBucket(rate float64, capacity int, now time.Time) *tokenBucket {
+	return &tokenBucket{
+		rate:     rate,
+		capacity: float64(capacity),
+		tokens:   float64(capacity),
+		last:     now,
+	}
+}
+
+// Attempt to consume a token, returning false if the bucket is empty.
+func (b *tokenBucket) take(now time.Time) bool {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.capacity {
+		b.tokens = b.capacity
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Limiter state for one remote address.
+type ipLimitState struct {
+	bucket       *tokenBucket
+	rejections   int
+	blockedUntil time.Time
+	lastSeen     time.Time
+	// Rejected connections that haven't been logged yet.
+	unlogged   int
+	lastLogged time.Time
+}
+
+// Decides whether or not newly accepted connections should be allowed based on
+// the number of connections an IP already has open and how quickly both it and
+// everyone else are connecting. IPs that keep connecting after being rate limited
+// are blocked entirely for a while.
+type ConnLimiter struct {
+	global   *tokenBucket
+	ips      map[string]*ipLimitState
+	lastTrim time.Time
+	// Source of the current time, replaced in tests.
+	now func() time.Time
+	sync.Mutex
+}
+
+func NewConnLimiter() *ConnLimiter {
+	return newConnLimiterAt(time.Now)
+}
+
+func newConnLimiterAt(clock func() time.Time) *ConnLimiter {
+	now := clock()
+	l := &ConnLimiter{ips: make(map[string]*ipLimitState), lastTrim: now, now: clock}
+	if config.GlobalConnectionRate > 0 {
+		l.global = newTokenBucket(float64(config.GlobalConnectionRate),
+			config.GlobalConnectionBurst, now)
+	}
+	return l
+}
+
+// Returns nil if a connection from ip should be accepted, otherwise an error
+// describing why it was rejected. active should be the number of connections
+// that ip currently has open.
+func (l *ConnLimiter) Allow(ip string, active int) error {
+	l.Lock()
+	defer l.Unlock()
+	now := l.now()
+	l.trim(now)
+
+	state, ok := l.ips[ip]
+	if !ok {
+		state = new(ipLimitState)
+		if config.IPConnectionRate > 0 {
+			state.bucket = newTokenBucket(float64(config.IPConnectionRate)/60,
+				config.IPConnectionBurst, now)
+		}
+		l.ips[ip] = state
+	}
+	state.lastSeen = now
+
+	if now.Before(state.blockedUntil) {
+		return fmt.Errorf("address blocked for %v", state.blockedUntil.Sub(now).Truncate(time.Second))
+	}
+	if config.MaxConnectionsPerIP > 0 && active >= config.MaxConnectionsPerIP {
+		return fmt.Errorf("too many open connections (%d)", active)
+	}
+	if state.bucket != nil && !state.bucket.take(now) {
+		state.rejections++
+		if config.FloodBlockThreshold > 0 && state.rejections >= config.FloodBlockThreshold {
+			state.rejections = 0
+			state.blockedUntil = now.Add(time.Duration(config.FloodBlockDuration) * time.Second)
+			return fmt.Errorf("connection flood; blocking for %ds", config.FloodBlockDuration)
+		}
+		return fmt.Errorf("connection rate exceeded")
+	}
+	state.rejections = 0
+	if l.global != nil && !l.global.take(now) {
+		return fmt.Errorf("global connection rate exceeded")
+	}
+	return nil
+}
+
+// Records that a connection from ip was rejected and returns whether or not it
+// should be logged, along with the number of rejections from ip since the last
+// one that was. Rejections are only logged once every rejectionLogInterval per
+// address so that a host that keeps reconnecting can't flood the log.
+func (l *ConnLimiter) LogRejection(ip string) (bool, int) {
+	l.Lock()
+	defer l.Unlock()
+	now := l.now()
+	state, ok := l.ips[ip]
+	if !ok {
+		return true, 0
+	}
+	if !state.lastLogged.IsZero() && now.Sub(state.lastLogged) < rejectionLogInterval {
+		state.unlogged++
+		return false, 0
+	}
+	skipped := state.unlogged
+	state.unlogged = 0
+	state.lastLogged = now
+	return true, skipped
+}
+
+// Periodically drop the state for addresses we haven't seen in a while
+// so that the map doesn't grow without bound.
+func (l *ConnLimiter) trim(now time.Time) {
+	if now.Sub(l.lastTrim) < limiterStateTTL {
+		return
+	}
+	l.lastTrim = now
+	for ip, state := range l.ips {
+		if now.Sub(state.lastSeen) > limiterStateTTL && now.After(state.blockedUntil) {
+			delete(l.ips, ip)
+		}
+	}
+}
+
+// Block until there are fewer than max open connections, checking again
+// every acceptPauseInterval. max <= 0 means there's no limit.
+func waitForConnectionSlot(count func() int, max int, sleep func(time.Duration)) {
+	for max > 0 && count() >= max {
+		sleep(acceptPauseInterval)
+	}
+}
@@ -0,0 +1,194 @@
+/*
+* Archon PSO Server
+* Copyright (C) 2014 Andrew Rodman
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// Clock for the limiter tests that only moves when told to.
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time          { return c.t }
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+func newFakeClock() *fakeClock               { return &fakeClock{t: time.Unix(1000000, 0)} }
+
+// Set the limiter config for a test, restoring the old values afterwards.
+func setLimiterConfig(t *testing.T, perIP, ipRate, ipBurst, globalRate, globalBurst, floodThreshold, floodDuration int) {
+	old := *config
+	t.Cleanup(func() {
+		config.MaxConnectionsPerIP, config.IPConnectionRate, config.IPConnectionBurst =
+			old.MaxConnectionsPerIP, old.IPConnectionRate, old.IPConnectionBurst
+		config.GlobalConnectionRate, config.GlobalConnectionBurst =
+			old.GlobalConnectionRate, old.GlobalConnectionBurst
+		config.FloodBlockThreshold, config.FloodBlockDuration =
+			old.FloodBlockThreshold, old.FloodBlockDuration
+	})
+	config.MaxConnectionsPerIP, config.IPConnectionRate, config.IPConnectionBurst = perIP, ipRate, ipBurst
+	config.GlobalConnectionRate, config.GlobalConnectionBurst = globalRate, globalBurst
+	config.FloodBlockThreshold, config.FloodBlockDuration = floodThreshold, floodDuration
+}
+
+func TestTokenBucket(t *testing.T) {
+	clock := newFakeClock()
+	b := newTokenBucket(2, 3, clock.now())
+	for i := 0; i < 3; i++ {
+		if !b.take(clock.now()) {
+			t.Fatalf("take %d failed with tokens left", i)
+		}
+	}
+	if b.take(clock.now()) {
+		t.Fatal("take succeeded with an empty bucket")
+	}
+	clock.advance(500 * time.Millisecond)
+	if !b.take(clock.now()) || b.take(clock.now()) {
+		t.Error("expected exactly one token after half a second at 2/s")
+	}
+	// The bucket never holds more than its capacity.
+	clock.advance(time.Hour)
+	for i := 0; i < 3; i++ {
+		b.take(clock.now())
+	}
+	if b.take(clock.now()) {
+		t.Error("bucket refilled past its capacity")
+	}
+}
+
+func TestConnLimiterPerIP(t *testing.T) {
+	setLimiterConfig(t, 2, 0, 0, 0, 0, 0, 0)
+	l := newConnLimiterAt(newFakeClock().now)
+	if err := l.Allow("10.0.0.1", 1); err != nil {
+		t.Error(err)
+	}
+	if l.Allow("10.0.0.1", 2) == nil {
+		t.Error("expected an IP at MaxConnectionsPerIP to be rejected")
+	}
+	if err := l.Allow("10.0.0.2", 0); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestConnLimiterRates(t *testing.T) {
+	// 60 per minute is one per second with a burst of 2; the global limit
+	// allows 3 at once.
+	setLimiterConfig(t, 0, 60, 2, 1, 3, 0, 0)
+	clock := newFakeClock()
+	l := newConnLimiterAt(clock.now)
+	for i := 0; i < 2; i++ {
+		if err := l.Allow("10.0.0.1", 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if l.Allow("10.0.0.1", 0) == nil {
+		t.Error("expected the IP's burst to be used up")
+	}
+	if err := l.Allow("10.0.0.2", 0); err != nil {
+		t.Error(err)
+	}
+	if l.Allow("10.0.0.3", 0) == nil {
+		t.Error("expected the global burst to be used up")
+	}
+	clock.advance(time.Second)
+	if err := l.Allow("10.0.0.1", 0); err != nil {
+		t.Errorf("expected tokens to be refilled after a second: %s", err)
+	}
+}
+
+func TestConnLimiterFloodBlock(t *testing.T) {
+	setLimiterConfig(t, 0, 60, 1, 0, 0, 3, 300)
+	clock := newFakeClock()
+	l := newConnLimiterAt(clock.now)
+	l.Allow("10.0.0.1", 0)
+	for i := 0; i < 3; i++ {
+		if l.Allow("10.0.0.1", 0) == nil {
+			t.Fatal("expected the rate limit to reject the connection")
+		}
+	}
+	// Blocked even once the bucket has refilled.
+	clock.advance(time.Minute)
+	if l.Allow("10.0.0.1", 0) == nil {
+		t.Error("expected the IP to be blocked after a flood")
+	}
+	clock.advance(5 * time.Minute)
+	if err := l.Allow("10.0.0.1", 0); err != nil {
+		t.Errorf("expected the block to expire: %s", err)
+	}
+}
+
+func TestConnLimiterLogRejection(t *testing.T) {
+	setLimiterConfig(t, 0, 60, 1, 0, 0, 0, 0)
+	clock := newFakeClock()
+	l := newConnLimiterAt(clock.now)
+	l.Allow("10.0.0.1", 0)
+	l.Allow("10.0.0.1", 0)
+	if ok, skipped := l.LogRejection("10.0.0.1"); !ok || skipped != 0 {
+		t.Errorf("expected the first rejection to be logged, got %v, %d", ok, skipped)
+	}
+	for i := 0; i < 5; i++ {
+		clock.advance(time.Second)
+		if ok, _ := l.LogRejection("10.0.0.1"); ok {
+			t.Fatal("expected repeated rejections not to be logged")
+		}
+	}
+	// Other addresses are logged independently.
+	l.Allow("10.0.0.2", 0)
+	if ok, _ := l.LogRejection("10.0.0.2"); !ok {
+		t.Error("expected a rejection from another IP to be logged")
+	}
+
+	clock.advance(rejectionLogInterval)
+	l.Allow("10.0.0.1", 0)
+	if ok, skipped := l.LogRejection("10.0.0.1"); !ok || skipped != 5 {
+		t.Errorf("expected a message covering 5 rejections, got %v, %d", ok, skipped)
+	}
+}
+
+func TestConnLimiterTrim(t *testing.T) {
+	setLimiterConfig(t, 0, 60, 1, 0, 0, 1, 3600)
+	clock := newFakeClock()
+	l := newConnLimiterAt(clock.now)
+	l.Allow("10.0.0.1", 0)
+	l.Allow("10.0.0.2", 0)
+	l.Allow("10.0.0.2", 0) // Blocked for an hour.
+
+	clock.advance(limiterStateTTL + time.Second)
+	l.Allow("10.0.0.3", 0)
+	if _, ok := l.ips["10.0.0.1"]; ok {
+		t.Error("expected an idle IP's state to be dropped")
+	}
+	if _, ok := l.ips["10.0.0.2"]; !ok {
+		t.Error("expected a blocked IP's state to be kept")
+	}
+}
+
+func TestWaitForConnectionSlot(t *testing.T) {
+	open := 5
+	var slept []time.Duration
+	// Each pause lets one connection close.
+	waitForConnectionSlot(func() int { return open }, 3, func(d time.Duration) {
+		slept = append(slept, d)
+		open--
+	})
+	if open != 2 || len(slept) != 3 || slept[0] != acceptPauseInterval {
+		t.Errorf("waited %v leaving %d open", slept, open)
+	}
+	waitForConnectionSlot(func() int { return 100 }, 0, func(time.Duration) {
+		t.Fatal("waited with no connection limit")
+	})
+}
@@ -0,0 +1,324 @@
+/*
+* Archon PSO Server
+* Copyright (C) 2014 Andrew Rodman
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+* ---------------------------------------------------------------------
+* Lobbies on a block, which players are put in when they connect to it and
+* where they create and join games from.
+ */
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/dcrodman/archon/util"
+	"sync"
+)
+
+const MaxLobbyPlayers = 12
+
+// Players in a lobby or game indexed by their client id, which is how the
+// clients refer to each other. The player with the lowest id is the leader.
+type playerSlots struct {
+	lock    sync.Mutex
+	players []*Client
+}
+
+var errRoomFull = errors.New("no room for another player")
+
+// Put c in the first free slot and return its client id.
+func (s *playerSlots) add(c *Client) (uint8, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for i, p := range s.players {
+		if p == nil {
+			s.players[i] = c
+			return uint8(i), nil
+		}
+	}
+	return 0, errRoomFull
+}
+
+// Take c out of its slot. Returns the number of players left.
+func (s *playerSlots) remove(c *Client) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	left := 0
+	for i, p := range s.players {
+		if p == c {
+			s.players[i] = nil
+		} else if p != nil {
+			left++
+		}
+	}
+	return left
+}
+
+// Returns the player with the client id, if there is one.
+func (s *playerSlots) player(id uint8) *Client {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if int(id) >= len(s.players) {
+		return nil
+	}
+	return s.players[id]
+}
+
+// Returns the players in the game or lobby.
+func (s *playerSlots) members() []*Client {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var members []*Client
+	for _, p := range s.players {
+		if p != nil {
+			members = append(members, p)
+		}
+	}
+	return members
+}
+
+func (s *playerSlots) count() int {
+	return len(s.members())
+}
+
+// Client id of the leader.
+func (s *playerSlots) leader() uint8 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for i, p := range s.players {
+		if p != nil {
+			return uint8(i)
+		}
+	}
+	return 0
+}
+
+// Headers describing each of the players to the others.
+func (s *playerSlots) headers() []PlayerHeader {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var headers []PlayerHeader
+	for i, p := range s.players {
+		if p != nil {
+			headers = append(headers, playerHeader(p, uint8(i)))
+		}
+	}
+	return headers
+}
+
+// Send data to everyone except for the player that sent it.
+func (s *playerSlots) broadcast(from *Client, data []byte) {
+	for _, p := range s.members() {
+		if p != from {
+			p.sendAsync(data)
+		}
+	}
+}
+
+func playerHeader(c *Client, id uint8) PlayerHeader {
+	h := PlayerHeader{Tag: 0x00010000, Guildcard: c.guildcard, TeamId: c.teamId, ClientId: uint32(id)}
+	if c.character != nil {
+		copy(h.Name[:], c.character.Name[:])
+	}
+	return h
+}
+
+type Lobby struct {
+	playerSlots
+	id uint8
+}
+
+func newLobby(id uint8) *Lobby {
+	l := &Lobby{id: id}
+	l.players = make([]*Client, MaxLobbyPlayers)
+	return l
+}
+
+// Returns n empty lobbies for a block.
+func newLobbies(n int) []*Lobby {
+	lobbies := make([]*Lobby, n)
+	for i := range lobbies {
+		lobbies[i] = newLobby(uint8(i))
+	}
+	return lobbies
+}
+
+// Returns the game or lobby the player is in, if any, for passing along
+// their game commands.
+func (c *Client) room() *playerSlots {
+	if c.game != nil {
+		return &c.game.playerSlots
+	} else if c.lobby != nil {
+		return &c.lobby.playerSlots
+	}
+	return nil
+}
+
+// Returns the player with the guildcard if they're in one of the block's
+// lobbies or games.
+func (server *BlockServer) findPlayer(guildcard uint32) *Client {
+	var rooms []*playerSlots
+	for _, l := range server.lobbies {
+		rooms = append(rooms, &l.playerSlots)
+	}
+	for _, g := range server.games.all() {
+		rooms = append(rooms, &g.playerSlots)
+	}
+	for _, room := range rooms {
+		for _, p := range room.members() {
+			if p.guildcard == guildcard {
+				return p
+			}
+		}
+	}
+	return nil
+}
+
+// Give scripts a chance to keep c out of lobby l and put them in it if they
+// don't. The caller tells the players in the lobby about it.
+func addToLobby(c *Client, l *Lobby) error {
+	args := c.scriptPlayer()
+	args["lobby"] = l.id + 1
+	if err := scripts.RunHook(HookLobbyJoin, args); err != nil {
+		return err
+	}
+	id, err := l.add(c)
+	if err != nil {
+		return err
+	}
+	c.lobby, c.clientId = l, id
+	return nil
+}
+
+var errLobbiesFull = errors.New("all of the lobbies are full")
+
+// Put c in lobby l, or the first other one of lobbies that will take them,
+// with enter. If none will, returns a script's veto if there was one.
+func findLobby(c *Client, l *Lobby, lobbies []*Lobby, enter func(*Client, *Lobby) error) error {
+	if l != nil && enter(c, l) == nil {
+		return nil
+	}
+	err := errLobbiesFull
+	for _, other := range lobbies {
+		if other == l {
+			continue
+		}
+		enterErr := enter(c, other)
+		if enterErr == nil {
+			return nil
+		} else if _, vetoed := enterErr.(*VetoError); vetoed {
+			err = enterErr
+		}
+	}
+	return err
+}
+
+// Put c in lobby l, telling them who's there and the others that they've
+// arrived. Scripts can keep them out of it.
+func (server *BlockServer) enterLobby(c *Client, l *Lobby) error {
+	if err := addToLobby(c, l); err != nil {
+		return err
+	}
+	c.SendLobbyJoin(l, server.num)
+	l.broadcast(c, memberJoinPacket(LobbyAddMemberType, c, l.leader(), l.id, server.num))
+	c.publishEvent(EventLobbyJoined, uint32(l.id)+1, c.characterName())
+	return nil
+}
+
+// Take c out of their lobby and tell the others that they left.
+func (server *BlockServer) leaveLobby(c *Client) {
+	l := c.lobby
+	if l == nil {
+		return
+	}
+	id := c.clientId
+	l.remove(c)
+	c.lobby = nil
+	l.broadcast(c, leaveNoticePacket(LobbyRemoveMemberType, id, l.leader()))
+}
+
+func (server *BlockServer) handleLobbyChange(c *Client) error {
+	var pkt LobbyChangePacket
+	if err := c.ReadPacket(&pkt); err != nil {
+		return err
+	}
+	if c.lobby == nil {
+		return c.Misbehave("changed lobbies from outside of a lobby")
+	}
+	if pkt.LobbyId >= uint32(len(server.lobbies)) {
+		return c.Misbehave(fmt.Sprintf("invalid lobby %d", pkt.LobbyId))
+	}
+	l := server.lobbies[pkt.LobbyId]
+	if l == c.lobby {
+		return nil
+	}
+	if l.count() >= MaxLobbyPlayers {
+		c.SendClientMessage("That lobby is full.")
+		return nil
+	}
+	old := c.lobby
+	server.leaveLobby(c)
+	if err := server.enterLobby(c, l); err != nil {
+		if _, vetoed := err.(*VetoError); vetoed {
+			c.SendClientMessage(vetoMessage(err))
+		}
+		// Either a script kept them out or it filled up in the meantime.
+		return server.returnToLobby(c, old)
+	}
+	return nil
+}
+
+// Put c back in lobby l, or the first other lobby that will take them.
+func (server *BlockServer) returnToLobby(c *Client, l *Lobby) error {
+	err := findLobby(c, l, server.lobbies, server.enterLobby)
+	if err == nil {
+		return nil
+	} else if _, vetoed := err.(*VetoError); vetoed {
+		c.SendClientMessage(vetoMessage(err))
+	} else {
+		c.SendClientMessage("The lobbies are full.")
+	}
+	return err
+}
+
+// The player's packets can't be handled after they disconnect, so take them
+// out of wherever they were.
+func (server *BlockServer) Disconnected(c *Client) {
+	server.leaveGame(c)
+	server.leaveLobby(c)
+}
+
+// Packet telling the others in a lobby or game that c has joined.
+func memberJoinPacket(pktType uint16, c *Client, leader, lobby uint8, block uint16) []byte {
+	data, _ := util.BytesFromStruct(&LobbyJoinPacket{
+		Header:   BBHeader{Type: pktType, Flags: 1},
+		ClientId: c.clientId,
+		LeaderId: leader,
+		Unknown:  1,
+		LobbyNum: lobby,
+		BlockNum: block,
+		Players:  []PlayerHeader{playerHeader(c, c.clientId)},
+	})
+	return data
+}
+
+func leaveNoticePacket(pktType uint16, id, leader uint8) []byte {
+	data, _ := util.BytesFromStruct(&LeaveNoticePacket{
+		Header:   BBHeader{Type: pktType, Flags: uint32(id)},
+		ClientId: id,
+		LeaderId: leader,
+	})
+	return data
+}
@@ -26,13 +26,13 @@ import (
 	"errors"
 	"fmt"
 	crypto "github.com/dcrodman/archon/encryption"
-	"github.com/dcrodman/archon/prs"
 	"github.com/dcrodman/archon/util"
 	"hash/crc32"
 	"io/ioutil"
 	"net"
 	"os"
 	"strconv"
+	"time"
 )
 
 const (
@@ -40,6 +40,17 @@ const (
 	ClientVersionString = "TethVer12510"
 	// Maximum size of a block of parameter or guildcard data.
 	MaxChunkSize = 0x6800
+	// Number of character slots available to each account.
+	MaxCharacterSlots = 4
+)
+
+// Values of the flag the client sets with packet 0xEC to indicate what the
+// character it sends next is for.
+const (
+	CharFlagCreate       = 0x00
+	CharFlagRecreate     = 0x01
+	CharFlagDressingRoom = 0x02
+	CharFlagDelete       = 0x03
 )
 
 var (
@@ -65,50 +76,35 @@ var (
 		"PlyLevelTbl.prs",
 	}
 
-	// Starting stats for any new character. The CharClass constants can be used
-	// to index into this array to obtain the base stats for each class.
-	BaseStats [12]CharacterStats
-
 	// Id sent in the menu selection packet to tell the client
 	// that the selection was made on the ship menu.
 	ShipSelectionMenuId uint16 = 0x13
 )
 
-// Entry in the available ships lis on the ship selection menu.
-type ShipMenuEntry struct {
-	MenuId   uint16
-	ShipId   uint32
-	Padding  uint16
-	Shipname [23]byte
-}
-
-// Struct for caching the parameter chunk data and header so
-// that the param files aren't re-read every time.
-type parameterEntry struct {
-	Size     uint32
-	Checksum uint32
-	Offset   uint32
-	Filename [0x40]uint8
-}
-
-// Handle account verification tasks.
-func VerifyAccount(client *Client) (*LoginPkt, error) {
-	var loginPkt LoginPkt
-	util.StructFromBytes(client.Data(), &loginPkt)
+// Reasons authenticate can reject an account.
+var (
+	errAccountNotFound = errors.New("account not found")
+	errAccountBanned   = errors.New("account banned")
+	errAccountInactive = errors.New("account not activated")
+)
 
+// Look up the account matching username and password and load its details
+// into client. Returns one of the errors above if the account can't be used
+// or the error from the database if the lookup failed.
+func authenticate(client *Client, username, password string) error {
 	// Passwords are stored as sha256 hashes, so hash what the client sent us for the query.
 	hasher := sha256.New()
-	hasher.Write(util.StripPadding(loginPkt.Password[:]))
-	pktUername := string(util.StripPadding(loginPkt.Username[:]))
-	pktPassword := hex.EncodeToString(hasher.Sum(nil)[:])
+	hasher.Write([]byte(password))
+	hashedPassword := hex.EncodeToString(hasher.Sum(nil)[:])
 
-	var username, password string
 	var isBanned, isActive bool
-	row := config.DB().QueryRow("SELECT username, password, "+
-		"guildcard, is_gm, is_banned, is_active, team_id from account_data "+
-		"WHERE username = ? and password = ?", pktUername, pktPassword)
-	err := row.Scan(&username, &password, &client.guildcard,
-		&client.isGm, &isBanned, &isActive, &client.teamId)
+	// team_id defaults to -1 for players without a team.
+	var teamId int32
+	row := config.DB().QueryRow("SELECT guildcard, is_gm, is_banned, is_active, "+
+		"team_id from account_data WHERE username = ? and password = ?",
+		username, hashedPassword)
+	err := row.Scan(&client.guildcard, &client.isGm, &isBanned, &isActive, &teamId)
+	client.teamId = uint32(teamId)
 	switch {
 	// Check if we have a valid username/combination.
 	case err == sql.ErrNoRows:
@@ -116,34 +112,107 @@ func VerifyAccount(client *Client) (*LoginPkt, error) {
 		// with a nonexistent username as some measure of account security. Note
 		// that if this is changed to query by username and add a password check,
 		// the index on account_data will need to be modified.
-		client.SendSecurity(BBLoginErrorPassword, 0, 0)
-		return nil, errors.New("Account does not exist for username: " + pktUername)
-	// Database error?
+		return errAccountNotFound
 	case err != nil:
-		client.SendClientMessage("Encountered an unexpected error while accessing the " +
-			"database.\n\nPlease contact your server administrator.")
-		log.Error(err.Error())
-		return nil, err
-	// Is the account banned?
+		return err
 	case isBanned:
+		return errAccountBanned
+	case !isActive:
+		return errAccountInactive
+	}
+	// TODO: Account, hardware, and IP ban checks.
+	return nil
+}
+
+// Keep the account with the guildcard from logging in again.
+func banAccount(db queryer, guildcard uint32) error {
+	res, err := db.Exec("UPDATE account_data SET is_banned = 1 WHERE guildcard = ?", guildcard)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return errAccountNotFound
+	}
+	return nil
+}
+
+// Whether the client is connected to the LOGIN server, where each login
+// starts. The other servers see the same login again as the player moves
+// through them.
+func (client *Client) onLoginServer() bool {
+	return client.server != nil && serverType(client.server) == "LOGIN"
+}
+
+// Give scripts a chance to refuse the login. Only run on the LOGIN server so
+// that it runs once per login.
+func runLoginHook(client *Client, username string) error {
+	args := map[string]interface{}{
+		"username":  username,
+		"guildcard": client.guildcard,
+		"gm":        client.isGm,
+		"ip":        client.IPAddr(),
+		"version":   client.version.String(),
+		"server":    "",
+	}
+	if client.server != nil {
+		args["server"] = client.server.Name()
+	}
+	return scripts.RunHook(HookLogin, args)
+}
+
+// Handle account verification tasks. Clients connecting to any server but
+// LOGIN also have to present the session token they were given by the last
+// server, which is restored as their config if checkSession is set.
+func VerifyAccount(client *Client, checkSession bool) (*LoginPkt, error) {
+	var loginPkt LoginPkt
+	if err := client.ReadPacket(&loginPkt); err != nil {
+		return nil, err
+	}
+	username := string(util.StripPadding(loginPkt.Username[:]))
+	password := string(util.StripPadding(loginPkt.Password[:]))
+
+	switch err := authenticate(client, username, password); err {
+	case nil:
+	case errAccountNotFound:
+		client.SendSecurity(BBLoginErrorPassword, 0, 0)
+		return nil, errors.New("Account does not exist for username: " + username)
+	case errAccountBanned:
 		client.SendSecurity(BBLoginErrorBanned, 0, 0)
 		return nil, errors.New("Account banned: " + username)
-	// Has the account been activated?
-	case !isActive:
+	case errAccountInactive:
 		client.SendClientMessage("Encountered an unexpected error while accessing the " +
 			"database.\n\nPlease contact your server administrator.")
 		return nil, errors.New("Account must be activated for username: " + username)
+	default:
+		client.SendClientMessage("Encountered an unexpected error while accessing the " +
+			"database.\n\nPlease contact your server administrator.")
+		log.Error(err.Error())
+		return nil, err
+	}
+	if checkSession {
+		// The config indicates how far they are in the login flow.
+		if err := client.restoreSession(loginPkt.Security[:]); err != nil {
+			client.SendSecurity(BBLoginErrorUnknown, 0, 0)
+			return nil, fmt.Errorf("Rejected login for %s: %s", username, err)
+		}
+	}
+	if client.onLoginServer() {
+		if err := runLoginHook(client, username); err != nil {
+			client.SendClientMessage(vetoMessage(err))
+			return nil, err
+		}
+	}
+	client.CompleteHandshake()
+	client.captureAccount(username)
+	if client.onLoginServer() {
+		client.publishEvent(EventPlayerLogin, 0, "")
 	}
-	// Copy over the config, which should indicate how far they are in the login flow.
-	util.StructFromBytes(loginPkt.Security[:], &client.config)
-
-	// TODO: Account, hardware, and IP ban checks.
 	return &loginPkt, nil
 }
 
 // Handle the initial login sent to the Login port.
 func handleLogin(client *Client, charPort uint16) error {
-	loginPkt, err := VerifyAccount(client)
+	loginPkt, err := VerifyAccount(client, false)
 	if err != nil {
 		return err
 	}
@@ -153,30 +222,50 @@ func handleLogin(client *Client, charPort uint16) error {
 		client.SendSecurity(BBLoginErrorPatch, 0, 0)
 		return errors.New("Incorrect version string")
 	}
-	// Newserv sets this field when the client first connects. I think this is
-	// used to indicate that the client has made it through the LOGIN server,
-	// but for now we'll just set it and leave it alone.
-	client.config.Magic = 0x48615467
+	// Start them off with a fresh session; the magic value indicates that
+	// they've made it through the LOGIN server.
+	client.config = ClientConfig{Magic: clientConfigMagic}
+	client.phase = phaseAuthenticated
 
 	client.SendSecurity(BBLoginErrorNone, client.guildcard, client.teamId)
-	client.SendRedirect(charPort, config.HostnameBytes())
+	client.SendRedirect(charPort, client.RedirectAddr())
 	return nil
 }
 
 // Handle initial login sent to the character port.
 func handleCharLogin(client *Client) error {
-	var err error = nil
-	if pkt, err := VerifyAccount(client); err == nil {
+	pkt, err := VerifyAccount(client, true)
+	if err != nil {
+		return err
+	}
+	// At this point, if we've chosen (or created) a character then the client
+	// will send us the corresponding phase. The slot number they send is
+	// ignored in favor of the one in their session.
+	if pkt.Phase != 4 {
+		client.phase = phaseCharacterSelect
 		client.SendSecurity(BBLoginErrorNone, client.guildcard, client.teamId)
-		// At this point, if we've chosen (or created) a character then the
-		// client will send us the slot number and the corresponding phase.
-		if pkt.SlotNum >= 0 && pkt.Phase == 4 {
-			client.SendTimestamp()
-			client.SendShipList(shipList)
-			client.SendScrollMessage()
+		return nil
+	}
+	if client.config.CharSelected == 0 {
+		client.SendSecurity(BBLoginErrorUnknown, 0, 0)
+		return errors.New("Ship select requested before selecting a character: " + client.IPAddr())
+	}
+	// The character could have been deleted since the session was signed,
+	// e.g. from another connection.
+	if _, err := loadCharacterPreview(client.guildcard, uint32(client.config.SlotNum)); err != nil {
+		client.SendSecurity(BBLoginErrorUnknown, 0, 0)
+		if err == sql.ErrNoRows {
+			return errors.New("Ship select requested for an empty slot: " + client.IPAddr())
 		}
+		log.Error(err.Error())
+		return err
 	}
-	return err
+	client.phase = phaseShipSelect
+	client.SendSecurity(BBLoginErrorNone, client.guildcard, client.teamId)
+	client.SendTimestamp()
+	client.SendShipList(shipList)
+	client.SendScrollMessage()
+	return nil
 }
 
 // Handle the options request - load key config and other option data from the
@@ -202,30 +291,45 @@ func handleKeyConfig(client *Client) error {
 	return nil
 }
 
-// Handle the character select/preview request. Will either return information
-// about a character given a particular slot in via 0xE5 response or ack the
-// selection with an 0xE4 (also used for an empty slot).
-func handleCharacterSelect(client *Client) error {
-	var pkt CharSelectionPacket
-	util.StructFromBytes(client.Data(), &pkt)
+// Load the preview of the character in slot. Returns sql.ErrNoRows if the slot is empty.
+func loadCharacterPreview(guildcard, slot uint32) (*CharacterPreview, error) {
 	prev := new(CharacterPreview)
-
-	// Character preview request.
-	archondb := config.DB()
 	var gc, name []uint8
-	row := archondb.QueryRow("SELECT experience, level, guildcard_str, "+
+	row := config.DB().QueryRow("SELECT experience, level, guildcard_str, "+
 		" name_color, name_color_chksm, model, section_id, char_class, "+
 		"v2_flags, version, v1_flags, costume, skin, face, head, hair, "+
 		"hair_red, hair_green, hair_blue, proportion_x, proportion_y, "+
-		"name, playtime FROM characters WHERE guildcard = ? AND slot_num = ?",
-		client.guildcard, pkt.Slot)
+		"name, playtime FROM characters WHERE guildcard = ? AND slot_num = ? "+
+		"AND deleted_at = 0",
+		guildcard, slot)
 	err := row.Scan(&prev.Experience, &prev.Level, &gc,
 		&prev.NameColor, &prev.NameColorChksm, &prev.Model, &prev.SectionId,
 		&prev.Class, &prev.V2flags, &prev.Version, &prev.V1Flags, &prev.Costume,
 		&prev.Skin, &prev.Face, &prev.Head, &prev.Hair, &prev.HairRed,
 		&prev.HairGreen, &prev.HairBlue, &prev.PropX, &prev.PropY,
 		&name, &prev.Playtime)
+	if err != nil {
+		return nil, err
+	}
+	copy(prev.GuildcardStr[:], gc[:])
+	copy(prev.Name[:], name[:])
+	return prev, nil
+}
+
+// Handle the character select/preview request. Will either return information
+// about a character given a particular slot in via 0xE5 response or ack the
+// selection with an 0xE4 (also used for an empty slot).
+func handleCharacterSelect(client *Client) error {
+	var pkt CharSelectionPacket
+	if err := client.ReadPacket(&pkt); err != nil {
+		return err
+	}
+	if pkt.Slot >= MaxCharacterSlots {
+		return client.Misbehave(fmt.Sprintf("invalid character slot %d", pkt.Slot))
+	}
 
+	// Character preview request.
+	prev, err := loadCharacterPreview(client.guildcard, pkt.Slot)
 	if err == sql.ErrNoRows {
 		// We don't have a character for this slot.
 		client.SendCharacterAck(pkt.Slot, 2)
@@ -237,13 +341,12 @@ func handleCharacterSelect(client *Client) error {
 
 	if pkt.Selecting == 0x01 {
 		// They've selected a character from the menu.
+		client.config.CharSelected = 1
 		client.config.SlotNum = uint8(pkt.Slot)
 		client.SendSecurity(BBLoginErrorNone, client.guildcard, client.teamId)
 		client.SendCharacterAck(pkt.Slot, 1)
 	} else {
 		// They have a character in that slot; send the character preview.
-		copy(prev.GuildcardStr[:], gc[:])
-		copy(prev.Name[:], name[:])
 		client.SendCharacterPreview(prev)
 	}
 	return nil
@@ -287,103 +390,291 @@ func handleGuildcardDataStart(client *Client) error {
 }
 
 // Send another chunk of the client's guildcard data.
-func handleGuildcardChunk(client *Client) {
+func handleGuildcardChunk(client *Client) error {
 	var chunkReq GuildcardChunkReqPacket
-	util.StructFromBytes(client.Data(), &chunkReq)
+	if err := client.ReadPacket(&chunkReq); err != nil {
+		return err
+	}
 	if chunkReq.Continue != 0x01 {
 		// Cancelled sending guildcard chunks.
-		return
+		return nil
+	}
+	offset := int(chunkReq.ChunkRequested) * MaxChunkSize
+	if chunkReq.ChunkRequested > 0xFF || offset >= int(client.gcDataSize) {
+		return client.Misbehave(fmt.Sprintf("invalid guildcard chunk %d", chunkReq.ChunkRequested))
 	}
 	client.SendGuildcardChunk(chunkReq.ChunkRequested)
+	return nil
 }
 
-// Create or update a character in a slot.
+// Create, update, or delete the character in a slot, depending on the flag
+// the client set before sending it.
 func handleCharacterUpdate(client *Client) error {
 	var charPkt CharPreviewPacket
 	charPkt.Character = new(CharacterPreview)
-	util.StructFromBytes(client.Data(), &charPkt)
+	if err := client.ReadPacket(&charPkt); err != nil {
+		return err
+	}
 	p := charPkt.Character
+	if charPkt.Slot >= MaxCharacterSlots {
+		return client.Misbehave(fmt.Sprintf("invalid character slot %d", charPkt.Slot))
+	}
+	if p.Class >= NumCharClasses {
+		return client.Misbehave(fmt.Sprintf("invalid character class %d", p.Class))
+	}
+
+	// The flag only applies to the character that follows it.
+	flag := client.flag
+	client.flag = CharFlagCreate
+	var err error
+	switch flag {
+	case CharFlagCreate, CharFlagRecreate:
+		// Recreating replaces the character in the slot with a new one, which
+		// is what creating a character in a slot that's in use does anyway.
+		err = createCharacter(client, charPkt.Slot, p)
+	case CharFlagDressingRoom:
+		err = modifyCharacter(client, charPkt.Slot, p)
+	case CharFlagDelete:
+		return removeCharacter(client, charPkt.Slot, p)
+	default:
+		return client.Misbehave(fmt.Sprintf("invalid character flag %d", flag))
+	}
+	if err != nil {
+		return err
+	}
+
+	// Send the security packet with the updated state and slot number so that
+	// we know a character has been selected.
+	client.config.SlotNum = uint8(charPkt.Slot)
+	client.SendSecurity(BBLoginErrorNone, client.guildcard, client.teamId)
+	client.SendCharacterAck(charPkt.Slot, 0)
+	return nil
+}
 
+// Create a new character in slot, replacing any that's already there.
+func createCharacter(client *Client, slot uint32, p *CharacterPreview) error {
 	archonDB := config.DB()
-	if client.flag == 0x02 {
-		// Player is using the dressing room; update the character. Messy
-		// query, but unavoidable if we don't want to be stuck with blobs.
-		_, err := archonDB.Exec("UPDATE characters SET name_color=?, model=?, "+
-			"name_color_chksm=?, section_id=?, char_class=?, costume=?, skin=?, "+
-			"head=?, hair_red=?, hair_green=?, hair_blue,=? proportion_x=?, "+
-			"proportion_y=?, name=? WHERE guildcard = ? AND slot_num = ?",
-			p.NameColor, p.Model, p.NameColorChksm, p.SectionId,
-			p.Class, p.Costume, p.Skin, p.Head, p.HairRed,
-			p.HairGreen, p.HairBlue, p.Name[:], p.PropX, p.PropY,
-			client.guildcard, charPkt.Slot)
-		if err != nil {
-			log.Error(err.Error())
-			return err
-		}
+	// Let scripts refuse the character or change its name or section id.
+	args := map[string]interface{}{
+		"guildcard":  client.guildcard,
+		"slot":       slot,
+		"name":       util.ConvertFromUtf16(p.Name[:]),
+		"section_id": p.SectionId,
+	}
+	if err := scripts.RunHook(HookCharacterCreate, args); err != nil {
+		client.SendClientMessage(vetoMessage(err))
+		return err
+	}
+	if id := args["section_id"].(uint8); id < NumSectionIds {
+		p.SectionId = id
 	} else {
-		// Delete a character if it already exists.
-		_, err := archonDB.Exec("DELETE FROM characters WHERE "+
-			"guildcard = ? AND slot_num = ?", client.guildcard, charPkt.Slot)
+		log.Errorf("Script set invalid section id %d for a character", id)
+	}
+	if name := args["name"].(string); name != util.ConvertFromUtf16(p.Name[:]) {
+		p.Name = [24]uint8{}
+		copy(p.Name[:], util.ConvertToUtf16(name))
+	}
+
+	name := util.ConvertFromUtf16(p.Name[:])
+	if err := checkCharacterName(archonDB, client, slot, name); err != nil {
+		return rejectCharacterName(client, err)
+	}
+	if max := config.MaxCharacterCreationsPerDay; max > 0 {
+		created, err := charactersCreatedToday(archonDB, client.guildcard)
 		if err != nil {
 			log.Error(err.Error())
 			return err
 		}
-		// Grab our base stats for this character class.
-		stats := BaseStats[p.Class]
-
-		// TODO: Set up the default inventory and techniques.
-		meseta := 300
-
-		/* TODO: Add the rest of these.
-		--unsigned char keyConfig[232]; // 0x3E8 - 0x4CF;
-		--techniques blob,
-		--options blob,
-		*/
-
-		// Create the new character.
-		_, err = archonDB.Exec("INSERT INTO characters (guildcard, slot_num,"+
-			"experience, level, guildcard_str, name_color, model, name_color_chksm,"+
-			"section_id, char_class, v2_flags, version, v1_flags, costume,"+
-			"skin, face, head, hair, hair_red, hair_green, hair_blue,"+
-			"proportion_x, proportion_y, name, playtime, atp, mst, evp, "+
-			"hp, dfp, ata, lck, meseta, bank_use, bank_meseta) "+
-			"VALUES (?, ?, 0, 0, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, "+
-			"?, ?, ?, ?, ?, ?, 0, ?, ?, ?, ?, ?, ?, ?, ?, 0, 0)",
-			client.guildcard, charPkt.Slot, p.GuildcardStr[:], p.NameColor,
-			p.Model, p.NameColorChksm, p.SectionId, p.Class, p.V2flags,
-			p.Version, p.V1Flags, p.Costume, p.Skin, p.Face, p.Head,
-			p.Hair, p.HairRed, p.HairGreen, p.HairBlue, p.PropX, p.PropY,
-			p.Name[:], stats.ATP, stats.MST, stats.EVP, stats.HP, stats.DFP, stats.ATA,
-			stats.LCK, meseta)
+		if created >= max {
+			client.SendClientMessage("You've created too many characters today.\n\n" +
+				"Please try again tomorrow.")
+			return fmt.Errorf("Guildcard %d has already created %d characters today",
+				client.guildcard, created)
+		}
+	}
+	// New characters always start out at the first level.
+	stats := levelTable.StatsAtLevel(p.Class, 0)
+
+	// TODO: Set up the default inventory and techniques.
+	meseta := 300
+
+	/* TODO: Add the rest of these.
+	--unsigned char keyConfig[232]; // 0x3E8 - 0x4CF;
+	--techniques blob,
+	--options blob,
+	*/
+
+	tx, err := archonDB.Begin()
+	if err != nil {
+		log.Error(err.Error())
+		return err
+	}
+	defer tx.Rollback()
+	// The client has the player confirm deleting any character that's
+	// already in the slot before creating a new one, so delete it (it
+	// can still be restored for a while).
+	deleted, err := deleteCharacter(tx, client.guildcard, slot)
+	if err != nil {
+		log.Error(err.Error())
+		return err
+	}
+	// Create the new character.
+	_, err = tx.Exec("INSERT INTO characters (guildcard, slot_num,"+
+		"experience, level, guildcard_str, name_color, model, name_color_chksm,"+
+		"section_id, char_class, v2_flags, version, v1_flags, costume,"+
+		"skin, face, head, hair, hair_red, hair_green, hair_blue,"+
+		"proportion_x, proportion_y, name, playtime, atp, mst, evp, "+
+		"hp, dfp, ata, lck, meseta, bank_use, bank_meseta, created_at, name_key, "+
+		"unique_name_key) VALUES (?, ?, 0, 0, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, "+
+		"?, ?, ?, ?, ?, ?, 0, ?, ?, ?, ?, ?, ?, ?, ?, 0, 0, ?, ?, ?)",
+		client.guildcard, slot, p.GuildcardStr[:], p.NameColor,
+		p.Model, p.NameColorChksm, p.SectionId, p.Class, p.V2flags,
+		p.Version, p.V1Flags, p.Costume, p.Skin, p.Face, p.Head,
+		p.Hair, p.HairRed, p.HairGreen, p.HairBlue, p.PropX, p.PropY,
+		p.Name[:], stats.ATP, stats.MST, stats.EVP, stats.HP, stats.DFP, stats.ATA,
+		stats.LCK, meseta, time.Now().Unix(), characterNameKey(name), uniqueNameKey(name))
+	if err == nil {
+		err = tx.Commit()
+	}
+	if isDuplicateKey(err) {
+		return rejectCharacterName(client, errNameTaken)
+	} else if err != nil {
+		log.Error(err.Error())
+		return err
+	}
+	if deleted {
+		client.publishEvent(EventCharacterDeleted, slot, "")
+	}
+	client.publishEvent(EventCharacterCreated, slot, name)
+	return nil
+}
+
+// Delete the character in slot at the player's request. The client sends the
+// character it's deleting, which has to match the saved one so that a stale
+// character select screen can't delete the wrong character.
+func removeCharacter(client *Client, slot uint32, p *CharacterPreview) error {
+	saved, err := loadCharacterPreview(client.guildcard, slot)
+	if err == sql.ErrNoRows {
+		return client.Misbehave(fmt.Sprintf("no character in slot %d to delete", slot))
+	} else if err != nil {
+		log.Error(err.Error())
+		return err
+	}
+	if saved.Name != p.Name || saved.Class != p.Class {
+		return client.Misbehave(fmt.Sprintf("character deleted from slot %d doesn't match", slot))
+	}
+	if _, err := deleteCharacter(config.DB(), client.guildcard, slot); err != nil {
+		log.Error(err.Error())
+		return err
+	}
+	// Re-sign the session so that it can't be used to pick the deleted
+	// character on the next server.
+	if client.config.CharSelected != 0 && uint32(client.config.SlotNum) == slot {
+		client.config.CharSelected = 0
+	}
+	client.SendSecurity(BBLoginErrorNone, client.guildcard, client.teamId)
+	client.publishEvent(EventCharacterDeleted, slot, util.ConvertFromUtf16(p.Name[:]))
+	// Tell the client the slot is empty now.
+	client.SendCharacterAck(slot, 2)
+	return nil
+}
+
+// Returns true if the only differences between the character as it's saved
+// and as the client sent it are things the dressing room can change.
+func onlyCosmeticChanges(saved, updated *CharacterPreview) bool {
+	return saved.Experience == updated.Experience &&
+		saved.Level == updated.Level &&
+		saved.NameColor == updated.NameColor &&
+		saved.Model == updated.Model &&
+		saved.SectionId == updated.SectionId &&
+		saved.Class == updated.Class &&
+		saved.Version == updated.Version
+}
+
+// Save the changes the player made to the character in slot in the dressing
+// room, which can change the character's appearance and name.
+func modifyCharacter(client *Client, slot uint32, p *CharacterPreview) error {
+	saved, err := loadCharacterPreview(client.guildcard, slot)
+	if err == sql.ErrNoRows {
+		return client.Misbehave(fmt.Sprintf("no character in slot %d to modify", slot))
+	} else if err != nil {
+		log.Error(err.Error())
+		return err
+	}
+	if !onlyCosmeticChanges(saved, p) {
+		return client.Misbehave(fmt.Sprintf("dressing room changes to slot %d aren't cosmetic", slot))
+	}
+
+	archonDB := config.DB()
+	if cooldown := seconds(config.DressingRoomCooldown); cooldown > 0 {
+		var modifiedAt int64
+		err = archonDB.QueryRow("SELECT modified_at FROM characters WHERE "+
+			"guildcard = ? AND slot_num = ? AND deleted_at = 0",
+			client.guildcard, slot).Scan(&modifiedAt)
 		if err != nil {
 			log.Error(err.Error())
 			return err
 		}
+		if wait := time.Unix(modifiedAt, 0).Add(cooldown).Sub(time.Now()); wait > 0 {
+			client.SendClientMessage(fmt.Sprintf("This character was changed recently.\n\n"+
+				"Please try again in %d minutes.", int(wait.Minutes())+1))
+			return fmt.Errorf("Guildcard %d used the dressing room too soon", client.guildcard)
+		}
+	}
+	name := util.ConvertFromUtf16(p.Name[:])
+	if err := checkCharacterName(archonDB, client, slot, name); err != nil {
+		return rejectCharacterName(client, err)
 	}
 
-	// Send the security packet with the updated state and slot number so that
-	// we know a character has been selected.
-	client.config.SlotNum = uint8(charPkt.Slot)
-	client.SendCharacterAck(charPkt.Slot, 0)
+	_, err = archonDB.Exec("UPDATE characters SET costume=?, skin=?, face=?, "+
+		"head=?, hair=?, hair_red=?, hair_green=?, hair_blue=?, proportion_x=?, "+
+		"proportion_y=?, name=?, name_key=?, unique_name_key=?, modified_at=? "+
+		"WHERE guildcard = ? AND slot_num = ? AND deleted_at = 0",
+		p.Costume, p.Skin, p.Face, p.Head, p.Hair, p.HairRed, p.HairGreen,
+		p.HairBlue, p.PropX, p.PropY, p.Name[:], characterNameKey(name),
+		uniqueNameKey(name), time.Now().Unix(), client.guildcard, slot)
+	if isDuplicateKey(err) {
+		return rejectCharacterName(client, errNameTaken)
+	} else if err != nil {
+		log.Error(err.Error())
+		return err
+	}
 	return nil
 }
 
+// Tell the player why their character's name was rejected. Errors other
+// than a nameError are from the database and are just logged.
+func rejectCharacterName(client *Client, err error) error {
+	if _, ok := err.(nameError); !ok {
+		log.Error(err.Error())
+		return err
+	}
+	client.SendClientMessage(err.Error())
+	return fmt.Errorf("Rejected character name for guildcard %d: %s", client.guildcard, err)
+}
+
 // Player selected one of the items on the ship select screen.
 func handleShipSelection(client *Client) error {
 	var pkt MenuSelectionPacket
-	util.StructFromBytes(client.Data(), &pkt)
+	if err := client.ReadPacket(&pkt); err != nil {
+		return err
+	}
 	selectedShip := pkt.ItemId - 1
-	if selectedShip < 0 || selectedShip >= uint32(len(shipList)) {
-		return errors.New("Invalid ship selection: " + string(selectedShip))
+	if pkt.ItemId < 1 || selectedShip >= uint32(len(shipList)) {
+		return client.Misbehave(fmt.Sprintf("invalid ship selection %d", pkt.ItemId))
 	}
 	s := &shipList[selectedShip]
-	client.SendRedirect(s.port, s.ipAddr)
+	if s.local {
+		client.SendRedirect(s.port, client.RedirectAddr())
+	} else {
+		client.SendRedirect(s.port, s.ipAddr)
+	}
 	return nil
 }
 
 // Create and initialize a new Login client so long as we're able
 // to send the welcome packet to begin encryption.
-func NewLoginClient(conn *net.TCPConn) (*Client, error) {
+func NewLoginClient(conn net.Conn) (*Client, error) {
 	var err error
 	cCrypt := crypto.NewBBCrypt()
 	sCrypt := crypto.NewBBCrypt()
@@ -399,6 +690,7 @@ func NewLoginClient(conn *net.TCPConn) (*Client, error) {
 type LoginServer struct {
 	// Cached and parsed representation of the character port.
 	charRedirectPort uint16
+	handlers         *HandlerRegistry
 }
 
 func (server LoginServer) Name() string { return "LOGIN" }
@@ -456,100 +748,94 @@ func (server LoginServer) loadParameterFiles() {
 func (server *LoginServer) Init() {
 	server.loadParameterFiles()
 
-	// Load the base stats for creating new characters. Newserv, Sylverant, and Tethealla
-	// all seem to rely on this file, so we'll do the same.
-	paramDir := config.ParametersDir
-	statsFile, _ := os.Open(paramDir + "/PlyLevelTbl.prs")
-	compressed, err := ioutil.ReadAll(statsFile)
+	// Load the stats for creating new characters and leveling them up. Newserv,
+	// Sylverant, and Tethealla all seem to rely on this file, so we'll do the same.
+	var err error
+	levelTable, err = LoadPlayerLevelTable(config.ParametersDir + "/PlyLevelTbl.prs")
 	if err != nil {
 		fmt.Println("Error reading stats file: " + err.Error())
 		os.Exit(1)
 	}
-	decompressedSize := prs.DecompressSize(compressed)
-	decompressed := make([]byte, decompressedSize)
-	prs.Decompress(compressed, decompressed)
-
-	for i := 0; i < 12; i++ {
-		util.StructFromBytes(decompressed[i*14:], &BaseStats[i])
-	}
 
 	charPort, _ := strconv.ParseUint(config.CharacterPort, 10, 16)
 	server.charRedirectPort = uint16(charPort)
+
+	server.handlers = newServerHandlers(server.Name())
+	server.handlers.Handle(LoginType, func(c *Client) error {
+		return handleLogin(c, server.charRedirectPort)
+	})
+	// Just wait until we recv 0 from the client to d/c.
+	server.handlers.Handle(DisconnectType, ignorePacket)
 	fmt.Println()
 }
 
-func (server LoginServer) NewClient(conn *net.TCPConn) (*Client, error) {
+func (server LoginServer) NewClient(conn net.Conn) (*Client, error) {
 	return NewLoginClient(conn)
 }
 
 func (server LoginServer) Handle(c *Client) error {
-	var err error = nil
-	var hdr BBHeader
-	util.StructFromBytes(c.Data()[:BBHeaderSize], &hdr)
-
-	switch hdr.Type {
-	case LoginType:
-		err = handleLogin(c, server.charRedirectPort)
-	case DisconnectType:
-		// Just wait until we recv 0 from the client to d/c.
-		break
-	default:
-		log.Infof("Received unknown packet %x from %s", hdr.Type, c.IPAddr())
-	}
-	return err
+	return server.handlers.Dispatch(c)
 }
 
 // Character sub-server definition.
-type CharacterServer struct{}
+type CharacterServer struct {
+	handlers *HandlerRegistry
+}
 
 func (server CharacterServer) Name() string { return "CHARACTER" }
 
 func (server CharacterServer) Port() string { return config.CharacterPort }
 
-func (server *CharacterServer) Init() {}
+func (server *CharacterServer) Init() {
+	h := newServerHandlers(server.Name())
+	h.Handle(LoginType, handleCharLogin)
+	// Just wait until we recv 0 from the client to d/c.
+	h.Handle(DisconnectType, ignorePacket)
+	h.Handle(LoginOptionsRequestType, handleKeyConfig, RequireLogin)
+	h.Handle(LoginCharPreviewReqType, handleCharacterSelect, RequirePhase(phaseCharacterSelect))
+	h.Handle(LoginChecksumType, func(c *Client) error {
+		// Everybody else seems to ignore this, so...
+		c.SendChecksumAck(1)
+		return nil
+	}, RequireLogin)
+	h.Handle(LoginGuildcardReqType, handleGuildcardDataStart, RequireLogin)
+	h.Handle(LoginGuildcardChunkReqType, handleGuildcardChunk, RequireLogin)
+	h.Handle(LoginParameterHeaderReqType, func(c *Client) error {
+		c.SendParameterHeader(uint32(len(paramFiles)), paramHeaderData)
+		return nil
+	}, RequireLogin)
+	h.Handle(LoginParameterChunkReqType, handleParameterChunk, RequireLogin)
+	h.Handle(LoginSetFlagType, func(c *Client) error {
+		var pkt SetFlagPacket
+		if err := c.ReadPacket(&pkt); err != nil {
+			return err
+		}
+		c.flag = pkt.Flag
+		return nil
+	}, RequireLogin)
+	// Creating characters hits the database, so don't let anyone spam it.
+	h.Handle(LoginCharPreviewType, handleCharacterUpdate,
+		RequirePhase(phaseCharacterSelect), RateLimit(0.5, 4))
+	h.Handle(MenuSelectType, handleShipSelection, RequirePhase(phaseShipSelect))
+	server.handlers = h
+}
 
-func (server CharacterServer) NewClient(conn *net.TCPConn) (*Client, error) {
+func (server CharacterServer) NewClient(conn net.Conn) (*Client, error) {
 	return NewLoginClient(conn)
 }
 
 func (server CharacterServer) Handle(c *Client) error {
-	var err error = nil
+	return server.handlers.Dispatch(c)
+}
+
+// Send the chunk of the parameter files requested in the header flags.
+func handleParameterChunk(c *Client) error {
 	var hdr BBHeader
 	util.StructFromBytes(c.Data()[:BBHeaderSize], &hdr)
-
-	switch hdr.Type {
-	case LoginType:
-		err = handleCharLogin(c)
-	case DisconnectType:
-		// Just wait until we recv 0 from the client to d/c.
-		break
-	case LoginOptionsRequestType:
-		err = handleKeyConfig(c)
-	case LoginCharPreviewReqType:
-		err = handleCharacterSelect(c)
-	case LoginChecksumType:
-		// Everybody else seems to ignore this, so...
-		c.SendChecksumAck(1)
-	case LoginGuildcardReqType:
-		err = handleGuildcardDataStart(c)
-	case LoginGuildcardChunkReqType:
-		handleGuildcardChunk(c)
-	case LoginParameterHeaderReqType:
-		c.SendParameterHeader(uint32(len(paramFiles)), paramHeaderData)
-	case LoginParameterChunkReqType:
-		var pkt BBHeader
-		util.StructFromBytes(c.Data(), &pkt)
-		c.SendParameterChunk(paramChunkData[int(pkt.Flags)], pkt.Flags)
-	case LoginSetFlagType:
-		var pkt SetFlagPacket
-		util.StructFromBytes(c.Data(), &pkt)
-		c.flag = pkt.Flag
-	case LoginCharPreviewType:
-		err = handleCharacterUpdate(c)
-	case MenuSelectType:
-		err = handleShipSelection(c)
-	default:
-		log.Infof("Received unknown packet %x from %s", hdr.Type, c.IPAddr())
+	chunk, ok := paramChunkData[int(hdr.Flags)]
+	if !ok {
+		return c.Misbehave(fmt.Sprintf("invalid parameter chunk %d", hdr.Flags))
 	}
-	return err
+	c.SendParameterChunk(chunk, hdr.Flags)
+	return nil
 }
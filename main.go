@@ -19,10 +19,11 @@
 package main
 
 import (
+	"flag"
 	"fmt"
-	"github.com/sirupsen/logrus"
 	"github.com/dcrodman/archon/util"
 	_ "github.com/go-sql-driver/mysql"
+	"github.com/sirupsen/logrus"
 	"io"
 	"net"
 	"net/http"
@@ -30,7 +31,9 @@ import (
 	"runtime/debug"
 	"runtime/pprof"
 	"strconv"
+	"strings"
 	"sync"
+	"time"
 )
 
 const (
@@ -38,6 +41,10 @@ const (
 	ServerConfigFile = "server_config.json"
 	CertificateFile  = "certificate.pem"
 	KeyFile          = "key.pem"
+
+	// How long the accept loops wait before checking whether a
+	// connection slot has freed up once MaxConnections is reached.
+	acceptPauseInterval = 500 * time.Millisecond
 )
 
 var (
@@ -55,19 +62,29 @@ type Server interface {
 	Init()
 	// Client factory responsible for performing whatever initialization is
 	// needed for Client objects to represent new connections.
-	NewClient(conn *net.TCPConn) (*Client, error)
+	NewClient(conn net.Conn) (*Client, error)
 	// Process the packet in the client's buffer. The dispatcher will
 	// read the latest packet from the client before calling.
 	Handle(c *Client) error
 }
 
 type Dispatcher struct {
-	host    string
 	servers []Server
 	conns   *ConnList
+	limiter *ConnLimiter
 	log     *logrus.Logger
 }
 
+// Returns the type of a server for looking up per-server settings. This is the
+// server's name without any instance number, e.g. BLOCK2 becomes BLOCK, unless
+// the server provides its own.
+func serverType(s Server) string {
+	if typed, ok := s.(interface{ ServerType() string }); ok {
+		return typed.ServerType()
+	}
+	return strings.ToUpper(strings.TrimRight(s.Name(), "0123456789"))
+}
+
 // Registers a server instance to be brought up once the dispatcher is run.
 func (d *Dispatcher) register(s Server) {
 	d.servers = append(d.servers, s)
@@ -80,7 +97,7 @@ func (d *Dispatcher) start(wg *sync.WaitGroup) {
 		s.Init()
 		// Open our server socket. All sockets must be open for the server
 		// to launch correctly, so errors are terminal.
-		hostAddr, err := net.ResolveTCPAddr("tcp", config.Hostname+":"+s.Port())
+		hostAddr, err := net.ResolveTCPAddr("tcp", config.ListenAddr(s.Port()))
 		if err != nil {
 			fmt.Println("Error creating socket: " + err.Error())
 			os.Exit(1)
@@ -91,29 +108,45 @@ func (d *Dispatcher) start(wg *sync.WaitGroup) {
 			os.Exit(1)
 		}
 
-		go func(serv Server) {
-			wg.Add(1)
-			// Poll until we can accept more clients.
-			for d.conns.Count() < config.MaxConnections {
+		wg.Add(1)
+		go func(serv Server, socket *net.TCPListener) {
+			defer wg.Done()
+			for {
+				// Stop accepting until a slot frees up if we're at capacity. Anyone who
+				// tries to connect in the meantime will wait in the listen backlog.
+				waitForConnectionSlot(d.conns.Count, config.MaxConnections, time.Sleep)
 				conn, err := socket.AcceptTCP()
 				if err != nil {
 					d.log.Warnf("Failed to accept connection: %v", err.Error())
 					continue
 				}
+				ipAddr, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+				if err := d.limiter.Allow(ipAddr, d.conns.CountIP(ipAddr)); err != nil {
+					if ok, skipped := d.limiter.LogRejection(ipAddr); ok && skipped > 0 {
+						d.log.Warnf("Rejected %s connection from %s: %s (%d more since the last message)",
+							serv.Name(), ipAddr, err, skipped)
+					} else if ok {
+						d.log.Warnf("Rejected %s connection from %s: %s", serv.Name(), ipAddr, err)
+					}
+					conn.Close()
+					continue
+				}
 				c, err := serv.NewClient(conn)
 				if err != nil {
 					d.log.Warn(err.Error())
 				} else {
+					c.connCfg = config.ConnectionConfig(serverType(serv))
+					c.server = serv
+					c.captureAddress()
 					d.log.Infof("Accepted %s connection from %s", serv.Name(), c.IPAddr())
 					d.dispatch(c, serv)
 				}
 			}
-			wg.Done()
-		}(s)
+		}(s, socket)
 	}
 	// Pass through again to prevent the output from changing due to race cond.
 	for _, s := range d.servers {
-		fmt.Printf("Waiting for %s connections on %v:%v\n", s.Name(), d.host, s.Port())
+		fmt.Printf("Waiting for %s connections on %v\n", s.Name(), config.ListenAddr(s.Port()))
 	}
 	d.log.Infof("Dispatcher: Server Initialized")
 }
@@ -121,6 +154,9 @@ func (d *Dispatcher) start(wg *sync.WaitGroup) {
 // Spawn a dedicated Goroutine for Client and handle communications
 // until the connection is closed.
 func (d *Dispatcher) dispatch(c *Client, s Server) {
+	// Add the client before spawning so that the per-IP counts are
+	// accurate by the time the next connection is accepted.
+	d.conns.Add(c)
 	go func() {
 		// Defer so that we catch any panics, d/c the client, and
 		// remove them from the list regardless of the connection state.
@@ -131,12 +167,20 @@ func (d *Dispatcher) dispatch(c *Client, s Server) {
 			}
 			c.Close()
 			d.conns.Remove(c)
+			// Let servers that keep track of their players clean up after them.
+			if ds, ok := s.(interface{ Disconnected(*Client) }); ok {
+				ds.Disconnected(c)
+			}
 			d.log.Infof("Disconnected %s client %s", s.Name(), c.IPAddr())
+			// Players are handed from server to server until they log off,
+			// so only the last disconnect ends the session. Ships connected
+			// to the shipgate don't have an account.
+			if c.handshakeDone && !c.redirected && c.guildcard != 0 {
+				c.publishEvent(EventPlayerLogout, 0, "")
+			}
 		}()
-		d.conns.Add(c)
 
 		// Connection loop; process packets until the connection is closed.
-		var pktHeader PCHeader
 		for {
 			err := c.Process()
 			if err == io.EOF {
@@ -147,16 +191,17 @@ func (d *Dispatcher) dispatch(c *Client, s Server) {
 				break
 			}
 
-			// PC and BB header packets have the same structure for the first four
-			// bytes, so for basic inspection it's safe to treat them the same way.
-			util.StructFromBytes(c.Data()[:PCHeaderSize], &pktHeader)
 			if config.DebugMode {
-				fmt.Printf("%s: Got %v bytes from client:\n", s.Name(), pktHeader.Size)
-				util.PrintPayload(c.Data(), int(pktHeader.Size))
+				fmt.Printf("%s: Got %v bytes from client:\n", s.Name(), len(c.Data()))
+				util.PrintPayload(c.Data(), len(c.Data()))
 				fmt.Println()
 			}
 
 			if err = s.Handle(c); err != nil {
+				if _, ok := err.(*droppedPacketError); ok {
+					d.log.Info(err.Error())
+					continue
+				}
 				d.log.Warn("Error in client communication: " + err.Error())
 				return
 			}
@@ -195,40 +240,112 @@ func initLogger(filename string) {
 }
 
 func main() {
-	fmt.Println("Archon PSO Server, Copyright (C) 2014 Andrew Rodman\n" +
+	fmt.Print("Archon PSO Server, Copyright (C) 2014 Andrew Rodman\n" +
 		"=====================================================\n" +
 		"This program is free software: you can redistribute it and/or\n" +
 		"modify it under the terms of the GNU General Public License as\n" +
 		"published by the Free Software Foundation, either version 3 of\n" +
 		"the License, or (at your option) any later version.\n" +
-		"This program is distributed WITHOUT ANY WARRANTY; See LICENSE for details.\n")
+		"This program is distributed WITHOUT ANY WARRANTY; See LICENSE for details.\n\n")
 
-	// Initialize our config singleton from one of two expected file locations.
-	fmt.Printf("Loading config file %v...", ServerConfigFile)
-	err := config.InitFromFile(ServerConfigFile)
+	configFile := flag.String("config", "", "Path to the server config file")
+	printConfig := flag.Bool("print-config", false,
+		"Print the effective configuration and exit")
+	restoreChar := flag.String("restore-character", "",
+		"Restore the most recently deleted character in GUILDCARD:SLOT and exit")
+	dumpItemsFormat := flag.String("dump-items", "",
+		"Print the item definitions as text or json and exit")
+	config.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+
+	// Initialize our config singleton from the file passed on the command
+	// line or, if there isn't one, from one of two expected file locations.
+	if *configFile != "" {
+		fmt.Printf("Loading config file %v...", *configFile)
+		if err := config.InitFromFile(*configFile); err != nil {
+			fmt.Println("Failed.")
+			fmt.Printf("Error: %s\n", err)
+			os.Exit(1)
+		}
+	} else {
+		fmt.Printf("Loading config file %v...", ServerConfigFile)
+		err := config.InitFromFile(ServerConfigFile)
+		if err != nil {
+			os.Chdir(ServerConfigDir)
+			fmt.Printf("Failed.\nLoading config from %v...", ServerConfigDir+"/"+ServerConfigFile)
+			err = config.InitFromFile(ServerConfigFile)
+			if err != nil {
+				fmt.Println("Failed.\nPlease check that one of these files exists and restart the server.")
+				fmt.Printf("Error: %s\n", err)
+				os.Exit(1)
+			}
+		}
+	}
+	// Anything set in the environment or on the command line takes precedence
+	// over the config file, in that order.
+	err := config.InitFromEnv()
+	if err == nil {
+		err = config.InitFromFlags(flag.CommandLine)
+	}
+	if err == nil {
+		err = config.Finalize()
+	}
 	if err != nil {
-		os.Chdir(ServerConfigDir)
-		fmt.Printf("Failed.\nLoading config from %v...", ServerConfigDir+"/"+ServerConfigFile)
-		err = config.InitFromFile(ServerConfigFile)
+		fmt.Println("Failed.")
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Done.\n\n--Configuration Parameters--\n%v\n\n", config.String())
+	if *printConfig {
+		os.Exit(0)
+	}
+	if *dumpItemsFormat != "" {
+		t, err := loadItemTable(config.ParametersDir)
+		if err == nil {
+			err = dumpItems(os.Stdout, t, *dumpItemsFormat)
+		}
 		if err != nil {
-			fmt.Println("Failed.\nPlease check that one of these files exists and restart the server.")
 			fmt.Printf("Error: %s\n", err)
 			os.Exit(1)
 		}
+		os.Exit(0)
 	}
-	fmt.Printf("Done.\n\n--Configuration Parameters--\n%v\n\n", config.String())
 
 	// Initialize the database.
-	fmt.Printf("Connecting to MySQL database %s:%s...", config.DBHost, config.DBPort)
+	if config.DBDriver == "sqlite3" {
+		fmt.Printf("Opening SQLite database %s...", config.DBName)
+	} else {
+		fmt.Printf("Connecting to MySQL database %s:%s...", config.DBHost, config.DBPort)
+	}
 	err = config.InitDb()
 	if err != nil {
 		fmt.Println("Failed.\nPlease make sure the database connection parameters are correct.")
 		fmt.Printf("Error: %s\n", err)
 		os.Exit(1)
 	}
-	fmt.Println("Done.\n")
+	fmt.Print("Done.\n\n")
 	defer config.CloseDB()
 
+	if *restoreChar != "" {
+		guildcard, slot, err := parseCharacterRef(*restoreChar)
+		if err == nil {
+			err = restoreCharacter(config.DB(), guildcard, slot)
+		}
+		config.CloseDB()
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Restored character in slot %d for guildcard %d.\n", slot, guildcard)
+		os.Exit(0)
+	}
+	if n, err := purgeDeletedCharacters(config.DB()); err != nil {
+		fmt.Printf("Failed to purge deleted characters; is the database schema up to date?\nError: %s\n", err)
+		os.Exit(1)
+	} else if n > 0 {
+		fmt.Printf("Purged %d deleted characters.\n\n", n)
+	}
+
 	// If we're in debug mode, spawn off an HTTP server that, when hit, dumps
 	// pprof output containing the stack traces of all running goroutines.
 	if config.DebugMode {
@@ -240,11 +357,22 @@ func main() {
 
 	initLogger(config.Logfile)
 
+	if config.ScriptsDir != "" {
+		fmt.Printf("Loading scripts from %s...", config.ScriptsDir)
+		if scripts, err = LoadScripts(config.ScriptsDir); err != nil {
+			fmt.Println("Failed.")
+			fmt.Printf("Error: %s\n", err)
+			os.Exit(1)
+		}
+		fmt.Print("Done.\n\n")
+		go reloadScriptsOnSignal()
+	}
+
 	// Register all of the server handlers and their corresponding ports.
 	dispatcher := Dispatcher{
-		host:    config.Hostname,
 		servers: make([]Server, 0),
 		conns:   NewClientList(),
+		limiter: NewConnLimiter(),
 		log:     log,
 	}
 
@@ -252,7 +380,11 @@ func main() {
 	dispatcher.register(new(DataServer))
 	dispatcher.register(new(LoginServer))
 	dispatcher.register(new(CharacterServer))
-	dispatcher.register(new(ShipgateServer))
+	shipgate := new(ShipgateServer)
+	if config.ShipgateForwardEvents {
+		shipgate.conns = dispatcher.conns
+	}
+	dispatcher.register(shipgate)
 	dispatcher.register(new(ShipServer))
 
 	// The available block ports will depend on how the server is configured,
@@ -262,8 +394,12 @@ func main() {
 		dispatcher.register(&BlockServer{
 			name: fmt.Sprintf("BLOCK%d", i),
 			port: strconv.FormatInt(shipPort+int64(i), 10),
+			num:  uint16(i),
 		})
 	}
+	for _, version := range config.classicVersions {
+		registerClassicServers(&dispatcher, version)
+	}
 
 	// Start up all of our servers and block until they exit.
 	var wg sync.WaitGroup
@@ -0,0 +1,137 @@
+/*
+* Archon PSO Server
+* Copyright (C) 2014 Andrew Rodman
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+* ---------------------------------------------------------------------
+* Character name rules. Names sent by the client start with a tab and a
+* letter (e.g. "\tE") selecting the language or color, which isn't counted
+* as part of the name.
+ */
+package main
+
+import (
+	"github.com/go-sql-driver/mysql"
+	"strings"
+	"unicode/utf8"
+)
+
+// Reason a name was rejected, which is shown to the player.
+type nameError string
+
+func (e nameError) Error() string { return string(e) }
+
+const errNameTaken = nameError("That name is already in use.")
+
+// Returns name without the language or color prefix, if it has one.
+func stripNamePrefix(name string) string {
+	if len(name) >= 2 && name[0] == '\t' {
+		_, size := utf8.DecodeRuneInString(name[1:])
+		return name[1+size:]
+	}
+	return name
+}
+
+// Normalized form of a name used to check whether two names are the same.
+func characterNameKey(name string) string {
+	return strings.ToLower(strings.TrimSpace(stripNamePrefix(name)))
+}
+
+// Value to save in the unique_name_key column for a live character named
+// name, which is NULL unless names have to be unique.
+func uniqueNameKey(name string) interface{} {
+	if !config.UniqueCharacterNames {
+		return nil
+	}
+	return characterNameKey(name)
+}
+
+// Functions that check whether an error from a database driver means a row
+// was refused because of a unique index. Drivers that are only built in with
+// a tag add their own.
+var duplicateKeyChecks = []func(error) bool{
+	func(err error) bool {
+		mysqlErr, ok := err.(*mysql.MySQLError)
+		return ok && mysqlErr.Number == 1062 // ER_DUP_ENTRY
+	},
+}
+
+// Returns true if err is from saving a character with a name that's taken,
+// which only happens if someone else took it after checkCharacterName.
+func isDuplicateKey(err error) bool {
+	for _, check := range duplicateKeyChecks {
+		if check(err) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check name against the configured rules. GMs are allowed to use reserved names.
+func validateCharacterName(name string, isGm bool) error {
+	bare := stripNamePrefix(name)
+	length := utf8.RuneCountInString(bare)
+	if strings.TrimSpace(bare) == "" || length < config.CharacterNameMinLength {
+		return nameError("That name is too short.")
+	}
+	if length > config.CharacterNameMaxLength {
+		return nameError("That name is too long.")
+	}
+	if config.namePattern != nil && !config.namePattern.MatchString(bare) {
+		return nameError("That name contains characters that aren't allowed.")
+	}
+	key := characterNameKey(name)
+	for _, word := range config.CharacterNameBannedWords {
+		if word != "" && strings.Contains(key, strings.ToLower(word)) {
+			return nameError("That name isn't allowed.")
+		}
+	}
+	if !isGm {
+		for _, reserved := range config.CharacterNameReserved {
+			if key == strings.ToLower(reserved) {
+				return nameError("That name is reserved.")
+			}
+		}
+	}
+	return nil
+}
+
+// Returns true if a character other than the one in the account's slot
+// already has a name matching key.
+func characterNameTaken(db queryer, key string, guildcard, slot uint32) (bool, error) {
+	var n int
+	err := db.QueryRow("SELECT COUNT(*) FROM characters WHERE name_key = ? "+
+		"AND deleted_at = 0 AND NOT (guildcard = ? AND slot_num = ?)",
+		key, guildcard, slot).Scan(&n)
+	return n > 0, err
+}
+
+// Check that the name follows the rules and, if names have to be unique,
+// that no one else is using it. Returns a nameError if it's rejected.
+func checkCharacterName(db queryer, client *Client, slot uint32, name string) error {
+	if err := validateCharacterName(name, client.isGm); err != nil {
+		return err
+	}
+	if !config.UniqueCharacterNames {
+		return nil
+	}
+	taken, err := characterNameTaken(db, characterNameKey(name), client.guildcard, slot)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return errNameTaken
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+/*
+* Archon PSO Server
+* Copyright (C) 2014 Andrew Rodman
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import "testing"
+
+func TestValidateCharacterName(t *testing.T) {
+	defer func(words []string) { config.CharacterNameBannedWords = words }(config.CharacterNameBannedWords)
+	config.CharacterNameBannedWords = []string{"darn"}
+
+	tests := []struct {
+		name string
+		gm   bool
+		ok   bool
+	}{
+		{"\tEAlice", false, true},
+		{"Alice", false, true},
+		// The prefix doesn't count towards the length.
+		{"\tEAbcdefghij", false, true},
+		{"\tEAbcdefghijk", false, false},
+		{"\tE", false, false},
+		{"\tE   ", false, false},
+		{"\tEAl\tice", false, false},
+		{"\tEDarnIt", false, false},
+		{"\tEgm", false, false},
+		{"\tEGM", true, true},
+	}
+	for _, test := range tests {
+		err := validateCharacterName(test.name, test.gm)
+		if (err == nil) != test.ok {
+			t.Errorf("validateCharacterName(%q, %v) = %v", test.name, test.gm, err)
+		}
+		if _, isNameErr := err.(nameError); err != nil && !isNameErr {
+			t.Errorf("expected a nameError for %q, got %T", test.name, err)
+		}
+	}
+}
+
+func TestCharacterNameKey(t *testing.T) {
+	if a, b := characterNameKey("\tEAlice"), characterNameKey("\tJALICE "); a != b || a != "alice" {
+		t.Errorf("expected both keys to be alice, got %q and %q", a, b)
+	}
+}
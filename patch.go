@@ -24,7 +24,6 @@ import (
 	"errors"
 	"fmt"
 	crypto "github.com/dcrodman/archon/encryption"
-	"github.com/dcrodman/archon/util"
 	"hash/crc32"
 	"io"
 	"io/ioutil"
@@ -88,14 +87,19 @@ func sendFileList(client *Client, node *PatchDir) {
 // The client sent us a checksum for one of the patch files. Compare it
 // to what we have and add it to the list of files to update if there
 // is any discrepancy.
-func handleFileStatus(client *Client) {
+func handleFileStatus(client *Client) error {
 	var fileStatus FileStatusPacket
-	util.StructFromBytes(client.Data(), &fileStatus)
-
+	if err := client.ReadPacket(&fileStatus); err != nil {
+		return err
+	}
+	if fileStatus.PatchId >= uint32(len(patchIndex)) {
+		return client.Misbehave(fmt.Sprintf("invalid patch id %d", fileStatus.PatchId))
+	}
 	patch := patchIndex[fileStatus.PatchId]
 	if fileStatus.Checksum != patch.checksum || fileStatus.FileSize != patch.fileSize {
 		client.updateList = append(client.updateList, patch)
 	}
+	return nil
 }
 
 // The client finished sending all of the file check packets. If they have
@@ -215,7 +219,7 @@ func buildPatchIndex(node *PatchDir) {
 
 // Create and initialize a new Patch client so long as we're able
 // to send the welcome packet to begin encryption.
-func NewPatchClient(conn *net.TCPConn) (*Client, error) {
+func NewPatchClient(conn net.Conn) (*Client, error) {
 	var err error
 	cCrypt := crypto.NewPCCrypt()
 	sCrypt := crypto.NewPCCrypt()
@@ -228,13 +232,17 @@ func NewPatchClient(conn *net.TCPConn) (*Client, error) {
 }
 
 // Patch sub-server definition.
-type PatchServer struct{}
+type PatchServer struct {
+	handlers *HandlerRegistry
+}
 
 func (server PatchServer) Name() string { return "PATCH" }
 
 func (server PatchServer) Port() string { return config.PatchPort }
 
 func (server *PatchServer) Init() {
+	server.registerHandlers()
+
 	wd, _ := os.Getwd()
 	os.Chdir(config.PatchDir)
 
@@ -257,59 +265,58 @@ func (server *PatchServer) Init() {
 	fmt.Println()
 }
 
-func (server PatchServer) NewClient(conn *net.TCPConn) (*Client, error) {
+func (server *PatchServer) registerHandlers() {
+	server.handlers = newServerHandlers(server.Name())
+	server.handlers.Handle(PatchWelcomeType, handlePatchWelcome)
+	server.handlers.Handle(PatchLoginType, func(c *Client) error {
+		c.CompleteHandshake()
+		if c.SendWelcomeMessage() == 0 {
+			c.SendPatchRedirect(dataRedirectPort, c.RedirectAddr())
+		}
+		return nil
+	})
+}
+
+func (server PatchServer) NewClient(conn net.Conn) (*Client, error) {
 	return NewPatchClient(conn)
 }
 
 func (server PatchServer) Handle(c *Client) error {
-	var hdr PCHeader
-	util.StructFromBytes(c.Data()[:PCHeaderSize], &hdr)
+	return server.handlers.Dispatch(c)
+}
 
-	switch hdr.Type {
-	case PatchWelcomeType:
-		c.SendWelcomeAck()
-	case PatchLoginType:
-		if c.SendWelcomeMessage() == 0 {
-			c.SendPatchRedirect(dataRedirectPort, config.HostnameBytes())
-		}
-	default:
-		log.Infof("Received unknown packet %2x from %s", hdr.Type, c.IPAddr())
-	}
+func handlePatchWelcome(c *Client) error {
+	c.SendWelcomeAck()
 	return nil
 }
 
 // Data sub-server definition.
-type DataServer struct{}
+type DataServer struct {
+	handlers *HandlerRegistry
+}
 
 func (server DataServer) Name() string { return "DATA" }
 
 func (server DataServer) Port() string { return config.DataPort }
 
-func (server *DataServer) Init() {}
+func (server *DataServer) Init() {
+	server.handlers = newServerHandlers(server.Name())
+	server.handlers.Handle(PatchWelcomeType, handlePatchWelcome)
+	server.handlers.Handle(PatchLoginType, func(c *Client) error {
+		c.CompleteHandshake()
+		c.SendDataAck()
+		sendFileList(c, &patchTree)
+		c.SendFileListDone()
+		return nil
+	})
+	server.handlers.Handle(PatchFileStatusType, handleFileStatus, RequireLogin)
+	server.handlers.Handle(PatchClientListDoneType, updateClientFiles, RequireLogin)
+}
 
-func (server DataServer) NewClient(conn *net.TCPConn) (*Client, error) {
+func (server DataServer) NewClient(conn net.Conn) (*Client, error) {
 	return NewPatchClient(conn)
 }
 
 func (server DataServer) Handle(c *Client) error {
-	var hdr PCHeader
-	util.StructFromBytes(c.Data()[:PCHeaderSize], &hdr)
-
-	switch hdr.Type {
-	case PatchWelcomeType:
-		c.SendWelcomeAck()
-	case PatchLoginType:
-		c.SendDataAck()
-		sendFileList(c, &patchTree)
-		c.SendFileListDone()
-	case PatchFileStatusType:
-		handleFileStatus(c)
-	case PatchClientListDoneType:
-		if err := updateClientFiles(c); err != nil {
-			return err
-		}
-	default:
-		log.Infof("Received unknown packet %02x from %s", hdr.Type, c.IPAddr())
-	}
-	return nil
+	return server.handlers.Dispatch(c)
 }
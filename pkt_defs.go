@@ -21,6 +21,8 @@
  */
 package main
 
+//go:generate go run tools/pktgen.go -o pkt_defs_gen.go -t pkt_defs_list_gen_test.go pkt_defs.go character.go
+
 const (
 	PCHeaderSize = 0x04
 	BBHeaderSize = 0x08
@@ -75,8 +77,42 @@ const (
 
 // Packet types for packets sent to and from the ship and block servers.
 const (
-	BlockListType = 0x07
-	LobbyListType = 0x83
+	ChatType              = 0x06
+	BlockListType         = 0x07
+	GameListType          = 0x08
+	LobbyListType         = 0x83
+	GameCommandType       = 0x60
+	GameCommandTargetType = 0x62
+	GameCommandLargeType  = 0x6C
+	GameJoinType          = 0x64
+	GameAddMemberType     = 0x65
+	GameRemoveMemberType  = 0x66
+	LobbyJoinType         = 0x67
+	LobbyAddMemberType    = 0x68
+	LobbyRemoveMemberType = 0x69
+	LobbyChangeType       = 0x84
+	LeaveGameType         = 0x98
+	CreateGameType        = 0xC1
+	RareEnemyListType     = 0xDE
+)
+
+// Subcommands sent in game commands.
+const (
+	SubEnemyHitType        = 0x0A
+	SubDestroyItemType     = 0x29
+	SubDropItemType        = 0x2A
+	SubLevelUpType         = 0x30
+	SubEnemyKilledType     = 0x76
+	SubShopRequestType     = 0xB5
+	SubShopContentsType    = 0xB6
+	SubShopBuyType         = 0xB7
+	SubIdentifyItemType    = 0xB8
+	SubIdentifyResultType  = 0xB9
+	SubAcceptIdentifyType  = 0xBA
+	SubCreateItemType      = 0xBE
+	SubGiveExperienceType  = 0xBF
+	SubShopSellType        = 0xC0
+	SubEnemyExpRequestType = 0xC8
 )
 
 // Packet types common to multiple servers.
@@ -84,6 +120,38 @@ const (
 	DisconnectType = 0x05
 	RedirectType   = 0x19
 	MenuSelectType = 0x10
+	PingType       = 0x1D
+)
+
+// Packet types used by the PC, Dreamcast, and Gamecube login and ship
+// servers in addition to the common ones above.
+const (
+	ClassicWelcomeType         = 0x02
+	ClassicSecurityType        = 0x04
+	ClassicMenuType            = 0x07
+	ClassicLoginWelcomeType    = 0x17
+	ClassicMessageType         = 0x1A
+	ClassicCharDataType        = 0x61
+	ClassicCharDataRequestType = 0x95
+	ClassicVerifyType          = 0x9A
+	ClassicLoginType           = 0x9D
+	GCLoginType                = 0x9E
+	GCVerifyType               = 0xDB
+)
+
+// Packet types sent between the shipgate and the ships connected to it.
+const (
+	ShipgateEventType = 0x0801
+	ShipgateAuthType  = 0x0802
+)
+
+// Flags sent with the classic license check and security packets.
+const (
+	// License check passed; the client should send its login packet.
+	ClassicVerifyOK = 0x02
+	// Security results.
+	ClassicLoginOK         = 0x00
+	ClassicLoginBadAccount = 0x03
 )
 
 // Error code types used for packet E6.
@@ -120,6 +188,21 @@ type BBHeader struct {
 	Flags uint32
 }
 
+// Header used by PC v2 clients outside of the patch server. Same as
+// PCHeader except that the type is only one byte.
+type PCv2Header struct {
+	Size  uint16
+	Type  uint8
+	Flags uint8
+}
+
+// Header used by Dreamcast and Gamecube clients.
+type DCHeader struct {
+	Type  uint8
+	Flags uint8
+	Size  uint16
+}
+
 // Welcome packet with encryption vectors sent to the client upon initial connection.
 type PatchWelcomePkt struct {
 	Header       PCHeader
@@ -132,7 +215,7 @@ type PatchWelcomePkt struct {
 // Packet containing the patch server welcome message.
 type PatchWelcomeMessage struct {
 	Header  PCHeader
-	Message []byte
+	Message []byte `pkt:"rest"`
 }
 
 // Redirect packet for patch to send character server IP.
@@ -185,7 +268,7 @@ type FileChunkPacket struct {
 	Chunk    uint32
 	Checksum uint32
 	Size     uint32
-	Data     []byte
+	Data     []byte `pkt:"rest"`
 }
 
 // Welcome packet with encryption vectors sent to the client upon initial connection.
@@ -220,8 +303,10 @@ type ClientConfig struct {
 	SlotNum      uint8  // Slot number of selected Character
 	Flags        uint16
 	Ports        [4]uint16
-	Unused       [4]uint32
-	Unused2      [2]uint32
+	// Unix time after which the session can't be used (0 for never) and the
+	// signature over the rest of the config; see session.go.
+	Expires   uint32
+	Signature [20]byte
 }
 
 // Security packet (0xE6) sent to the client to indicate the state of client login.
@@ -305,19 +390,19 @@ type GuildcardChunkPacket struct {
 	Header  BBHeader
 	Unknown uint32
 	Chunk   uint32
-	Data    []uint8
+	Data    []uint8 `pkt:"rest"`
 }
 
 // Parameter header containing details about the param files we're about to send.
 type ParameterHeaderPacket struct {
 	Header  BBHeader
-	Entries []byte
+	Entries []byte `pkt:"rest"`
 }
 
 type ParameterChunkPacket struct {
 	Header BBHeader
 	Chunk  uint32
-	Data   []byte
+	Data   []byte `pkt:"rest"`
 }
 
 // Used by the client to indicate whether a character should be recreated or updated.
@@ -337,7 +422,7 @@ type CharPreviewPacket struct {
 type LoginClientMessagePacket struct {
 	Header   BBHeader
 	Language uint32
-	Message  []byte
+	Message  []byte `pkt:"rest"`
 }
 
 // Indicate the server's current time.
@@ -354,14 +439,14 @@ type ShipListPacket struct {
 	Unknown2    uint32 // set to 0x02
 	Unknown3    uint16 // set to 0x04
 	ServerName  [36]byte
-	ShipEntries []ShipMenuEntry
+	ShipEntries []ShipMenuEntry `pkt:"rest"`
 }
 
 // Scroll message the client should display on the ship select screen.
 type ScrollMessagePacket struct {
 	Header  BBHeader
 	Padding [2]uint32
-	Message []byte
+	Message []byte `pkt:"rest"`
 }
 
 // Client's selection from the ship or block selection menu.
@@ -378,15 +463,519 @@ type BlockListPacket struct {
 	Padding  [10]byte
 	ShipName [32]byte
 	Unknown  uint32
-	Blocks   []Block
+	Blocks   []Block `pkt:"rest"`
+}
+
+// Entry in the available ships list on the ship selection menu.
+type ShipMenuEntry struct {
+	MenuId   uint16
+	ShipId   uint32
+	Padding  uint16
+	Shipname [23]byte
+}
+
+// Entry in the parameter header describing one of the parameter files.
+type parameterEntry struct {
+	Size     uint32
+	Checksum uint32
+	Offset   uint32
+	Filename [0x40]uint8
+}
+
+// Info about the available block servers.
+type Block struct {
+	Unknown   uint16
+	BlockId   uint32
+	Padding   uint16
+	BlockName [36]byte
 }
 
 // Available lobbies on a block.
 type LobbyListPacket struct {
 	Header  BBHeader
-	Lobbies []struct {
-		MenuId  uint32
-		LobbyId uint32
-		Padding uint32
-	}
+	Lobbies []LobbyListEntry `pkt:"rest"`
+}
+
+// Entry in the lobby list.
+type LobbyListEntry struct {
+	MenuId  uint32
+	LobbyId uint32
+	Padding uint32
+}
+
+// Identifies one of the players in a lobby or game.
+type PlayerHeader struct {
+	Tag       uint32
+	Guildcard uint32
+	TeamId    uint32
+	Unknown   [4]uint32
+	ClientId  uint32
+	Name      [32]byte
+	Unknown2  uint32
+}
+
+// Sent to a player joining a lobby with everyone in it, and with just the
+// new player to the others already there (as LobbyAddMemberType) and to the
+// players in a game someone joins (as GameAddMemberType). The number of
+// players is in the header flags.
+type LobbyJoinPacket struct {
+	Header   BBHeader
+	ClientId uint8
+	LeaderId uint8
+	Unknown  uint8
+	LobbyNum uint8
+	BlockNum uint16
+	Event    uint16
+	Padding  uint32
+	Players  []PlayerHeader `pkt:"rest"`
+}
+
+// Tells the rest of a lobby or game that a player left. The client id of the
+// player that left is also in the header flags.
+type LeaveNoticePacket struct {
+	Header   BBHeader
+	ClientId uint8
+	LeaderId uint8
+	Padding  uint16
+}
+
+// Chat message from a player, which is relayed to everyone in their lobby or
+// game with the player's name in front of the text.
+type ChatPacket struct {
+	Header    BBHeader
+	Unused    uint32
+	Guildcard uint32
+	Message   []byte `pkt:"rest"`
+}
+
+// Player asking to move to another lobby.
+type LobbyChangePacket struct {
+	Header  BBHeader
+	MenuId  uint32
+	LobbyId uint32
+}
+
+// Player creating a game from the lobby. The episode is 1, 2, or 3 for
+// Episode 4.
+type CreateGamePacket struct {
+	Header       BBHeader
+	Unused       [2]uint32
+	Name         [32]byte
+	Password     [32]byte
+	Difficulty   uint8
+	Battle       uint8
+	Challenge    uint8
+	Episode      uint8
+	SinglePlayer uint8
+	Padding      [3]uint8
+}
+
+// Sent to a player joining a game with the state of the game and everyone
+// in it. The number of players is in the header flags.
+type GameJoinPacket struct {
+	Header       BBHeader
+	Variations   [0x20]uint32
+	Players      [4]PlayerHeader
+	ClientId     uint8
+	LeaderId     uint8
+	Unknown      uint8
+	Difficulty   uint8
+	Battle       uint8
+	Event        uint8
+	SectionId    uint8
+	Challenge    uint8
+	RandomSeed   uint32
+	Episode      uint8
+	Unknown2     uint8
+	SinglePlayer uint8
+	Unused       uint8
+}
+
+const maxRareEnemies = 16
+
+// Sent before the game join with the indexes of the enemies in the game that
+// are the rare variant. Unused entries are 0xFFFF.
+type RareEnemyListPacket struct {
+	Header  BBHeader
+	Enemies [maxRareEnemies]uint16
+}
+
+// Games on the block. The first entry is the title of the menu and the
+// number of entries after it is in the header flags.
+type GameListPacket struct {
+	Header BBHeader
+	Games  []GameListEntry `pkt:"rest"`
+}
+
+type GameListEntry struct {
+	MenuId     uint32
+	GameId     uint32
+	Difficulty uint8
+	Players    uint8
+	Name       [32]byte
+	Episode    uint8
+	Flags      uint8
+}
+
+// Selection from the game list, with the password if the game has one.
+type GameSelectionPacket struct {
+	Header   BBHeader
+	Unknown  uint16
+	MenuId   uint16
+	ItemId   uint32
+	Password []byte `pkt:"rest"`
+}
+
+// Header of a subcommand sent in a game command. Size is in units of 4 bytes.
+type SubcommandHeader struct {
+	Header BBHeader
+	Type   uint8
+	Size   uint8
+}
+
+// Sent when a player damages an enemy.
+type EnemyHitPacket struct {
+	Header     BBHeader
+	Subcommand uint8
+	Size       uint8
+	EnemyId    uint16
+	EnemyIndex uint16
+	HP         uint16
+	Flags      uint32
+}
+
+// Sent by the player that killed an enemy. The enemy's index is the low
+// bits of its id.
+type EnemyKilledPacket struct {
+	Header     BBHeader
+	Subcommand uint8
+	Size       uint8
+	EnemyId    uint16
+	Flags      uint16
+	Unknown    uint16
+}
+
+// Sent by each player that hit an enemy once it's been killed to ask for
+// their share of the experience.
+type EnemyExpRequestPacket struct {
+	Header     BBHeader
+	Subcommand uint8
+	Size       uint8
+	EnemyId    uint16
+	EnemyIndex uint16
+	ClientId   uint16
+	// Set if the player landed the killing blow. Not trusted; the server
+	// goes by who it saw kill the enemy.
+	LastHitter uint8
+	Unused     [3]uint8
+}
+
+// Experience given to a player.
+type GiveExperiencePacket struct {
+	Header     BBHeader
+	Subcommand uint8
+	Size       uint8
+	ClientId   uint16
+	Amount     uint32
+}
+
+// A player's new level and stats after leveling up.
+type LevelUpPacket struct {
+	Header     BBHeader
+	Subcommand uint8
+	Size       uint8
+	ClientId   uint16
+	ATP        uint16
+	MST        uint16
+	EVP        uint16
+	HP         uint16
+	DFP        uint16
+	ATA        uint16
+	Level      uint32
+}
+
+// Sent when a player talks to one of the shopkeepers.
+type ShopRequestPacket struct {
+	Header     BBHeader
+	Subcommand uint8
+	Size       uint8
+	ClientId   uint16
+	ShopType   uint32
+}
+
+// Most items the client can show in a shop.
+const MaxShopItems = 20
+
+// Items for sale in a shop, with their prices in Data2.
+type ShopContentsPacket struct {
+	Header     BBHeader
+	Subcommand uint8
+	Size       uint8
+	Params     uint16
+	ShopType   uint8
+	NumItems   uint8
+	Unused     uint16
+	Items      [MaxShopItems]ItemData
+}
+
+// Request to buy one of the items in the last shop the player opened. The
+// client picks the id that the item will have in its inventory.
+type ShopBuyPacket struct {
+	Header     BBHeader
+	Subcommand uint8
+	Size       uint8
+	ClientId   uint16
+	ItemId     uint32
+	ShopType   uint8
+	ItemIndex  uint8
+	Amount     uint8
+	Unknown    uint8
+}
+
+type ShopSellPacket struct {
+	Header     BBHeader
+	Subcommand uint8
+	Size       uint8
+	ClientId   uint16
+	ItemId     uint32
+	Amount     uint32
+}
+
+// Request to have the tekker identify a weapon, or to accept the result.
+type IdentifyItemPacket struct {
+	Header     BBHeader
+	Subcommand uint8
+	Size       uint8
+	ClientId   uint16
+	ItemId     uint32
+}
+
+// What an identified weapon would be if the player accepts it.
+type IdentifyResultPacket struct {
+	Header     BBHeader
+	Subcommand uint8
+	Size       uint8
+	ClientId   uint16
+	Item       ItemData
+}
+
+// Item added to a player's inventory by the server.
+type CreateItemPacket struct {
+	Header     BBHeader
+	Subcommand uint8
+	Size       uint8
+	ClientId   uint16
+	Item       ItemData
+	Unused     uint32
+}
+
+// Player dropping an item from their inventory on the floor.
+type DropItemPacket struct {
+	Header     BBHeader
+	Subcommand uint8
+	Size       uint8
+	ClientId   uint16
+	Unknown    uint16
+	Area       uint16
+	ItemId     uint32
+	X          float32
+	Z          float32
+}
+
+// Item removed from a player's inventory.
+type DestroyItemPacket struct {
+	Header     BBHeader
+	Subcommand uint8
+	Size       uint8
+	ClientId   uint16
+	ItemId     uint32
+	Amount     uint32
+}
+
+// The remaining packets are used by the PC, Dreamcast, and Gamecube clients.
+// Since the layout of the header depends on the version, they're defined
+// without one and sendClassic adds it.
+
+// Welcome packet with the encryption vectors.
+type ClassicWelcomePkt struct {
+	Copyright    [0x40]byte
+	ServerVector [4]byte
+	ClientVector [4]byte
+}
+
+// License check sent as 9A by PC and Dreamcast clients and DB by Gamecube.
+type ClassicVerifyPkt struct {
+	Unused         [0x20]byte
+	V1SerialNumber [0x10]byte
+	V1AccessKey    [0x10]byte
+	SerialNumber   [0x10]byte
+	AccessKey      [0x10]byte
+	PlayerTag      uint32
+	Guildcard      uint32
+	SubVersion     uint32
+	SerialNumber2  [0x30]byte
+	AccessKey2     [0x30]byte
+	// Email address from PC and Dreamcast, password from Gamecube.
+	Password [0x30]byte
+}
+
+// Login sent as 9D by PC and Dreamcast clients and 9E by Gamecube, which
+// also includes the client config we sent it in the last security packet.
+type ClassicLoginPkt struct {
+	PlayerTag      uint32
+	Guildcard      uint32
+	Unused         [2]uint32
+	SubVersion     uint32
+	IsExtended     uint8
+	Language       uint8
+	Unused2        [2]byte
+	V1SerialNumber [0x10]byte
+	V1AccessKey    [0x10]byte
+	SerialNumber   [0x30]byte
+	AccessKey      [0x30]byte
+	Name           [0x10]byte
+	Config         []byte `pkt:"rest"`
+}
+
+// Security packet (04) with the player's guildcard number.
+type ClassicSecurityPacket struct {
+	PlayerTag uint32
+	Guildcard uint32
+	Config    [0x20]byte
+}
+
+// Menu (07) with Dreamcast and Gamecube entries. The first entry is the title.
+type ClassicMenuPacket struct {
+	Entries []ClassicMenuEntry `pkt:"rest"`
+}
+
+type ClassicMenuEntry struct {
+	MenuId uint32
+	ItemId uint32
+	Flags  uint16
+	Text   [0x12]byte
+}
+
+// Menu (07) with PC entries, which have UTF-16 text.
+type PCMenuPacket struct {
+	Entries []PCMenuEntry `pkt:"rest"`
+}
+
+type PCMenuEntry struct {
+	MenuId uint32
+	ItemId uint32
+	Flags  uint16
+	Text   [0x22]byte
+}
+
+// Item chosen from a menu.
+type ClassicMenuSelectionPacket struct {
+	MenuId uint32
+	ItemId uint32
+}
+
+// Address of the next server to connect to.
+type ClassicRedirectPacket struct {
+	IPAddr  [4]uint8
+	Port    uint16
+	Padding uint16
+}
+
+// Message box displayed by the client, usually before disconnecting.
+type ClassicMessagePacket struct {
+	Message []byte `pkt:"rest"`
+}
+
+// Available lobbies on a block.
+type ClassicLobbyListPacket struct {
+	Lobbies []LobbyListEntry `pkt:"rest"`
+}
+
+// Size of the inventory and character data that the classic clients send in
+// their character data and that's passed along to the other players.
+const (
+	classicInventorySize = 0x34C
+	classicDispDataSize  = 0xD0
+)
+
+// Character stats and appearance sent by the classic clients.
+type ClassicDispData struct {
+	Stats      [0x18]byte
+	Level      uint32
+	Experience uint32
+	Meseta     uint32
+	Name       [0x10]byte
+	Unknown    [8]byte
+	NameColor  uint32
+	Model      byte
+	Padding    [15]byte
+	Checksum   uint32
+	SectionId  byte
+	Class      byte
+	Appearance [0x7A]byte
+}
+
+// Character data (61) the classic clients send when asked for it (95). Only
+// the inventory and character are used; the rest of it differs by version.
+type ClassicCharDataPacket struct {
+	Inventory [classicInventorySize]byte
+	Disp      ClassicDispData
+}
+
+// Lobby join (67) and member notice (68) for the classic versions, in which
+// each player's header is followed by their inventory and character.
+type ClassicLobbyJoinPacket struct {
+	ClientId   uint8
+	LeaderId   uint8
+	DisableUdp uint8
+	LobbyNum   uint8
+	BlockNum   uint16
+	Event      uint16
+	Unused     uint32
+	Players    []byte `pkt:"rest"`
+}
+
+// Player header in a Dreamcast or Gamecube lobby join.
+type ClassicPlayerHeader struct {
+	Tag       uint32
+	Guildcard uint32
+	IPAddr    uint32
+	ClientId  uint32
+	Name      [0x10]byte
+}
+
+// Player header in a PC lobby join, which has a UTF-16 name.
+type PCPlayerHeader struct {
+	Tag       uint32
+	Guildcard uint32
+	IPAddr    uint32
+	ClientId  uint32
+	Name      [0x20]byte
+}
+
+// Notice (69) that a player left the lobby. The header's flags are the
+// client id of the player that left.
+type ClassicLeaveNoticePacket struct {
+	ClientId   uint8
+	LeaderId   uint8
+	DisableUdp uint8
+	Unused     uint8
+}
+
+// Sent by a ship when it connects to the shipgate to prove that it knows
+// the shipgate secret.
+type ShipgateAuthPacket struct {
+	Header    BBHeader
+	Signature [32]byte
+}
+
+// Event forwarded between ships by the shipgate.
+type ShipgateEventPacket struct {
+	Header    BBHeader
+	EventType uint32
+	Ship      uint32
+	Guildcard uint32
+	Id        uint32
+	Timestamp int64
+	Text      [0x40]byte
 }
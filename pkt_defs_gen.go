@@ -0,0 +1,3251 @@
+// Code generated by tools/pktgen.go from character.go, pkt_defs.go; DO NOT EDIT.
+
+package main
+
+import (
+	"encoding/binary"
+	"github.com/dcrodman/archon/util"
+	"math"
+)
+
+// BinarySize returns the number of bytes in the serialized PCHeader.
+func (p *PCHeader) BinarySize() int {
+	return 4
+}
+
+// MarshalTo serializes the PCHeader into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *PCHeader) MarshalTo(b []byte) int {
+	binary.LittleEndian.PutUint16(b[0:], p.Size)
+	binary.LittleEndian.PutUint16(b[2:], p.Type)
+	return 4
+}
+
+// Unmarshal populates the PCHeader from b.
+func (p *PCHeader) Unmarshal(b []byte) error {
+	if len(b) < 4 {
+		return &util.ShortDataError{Size: len(b), Type: "PCHeader"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *PCHeader) unmarshalFrom(b []byte) {
+	p.Size = binary.LittleEndian.Uint16(b[0:])
+	p.Type = binary.LittleEndian.Uint16(b[2:])
+}
+
+// BinarySize returns the number of bytes in the serialized BBHeader.
+func (p *BBHeader) BinarySize() int {
+	return 8
+}
+
+// MarshalTo serializes the BBHeader into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *BBHeader) MarshalTo(b []byte) int {
+	binary.LittleEndian.PutUint16(b[0:], p.Size)
+	binary.LittleEndian.PutUint16(b[2:], p.Type)
+	binary.LittleEndian.PutUint32(b[4:], p.Flags)
+	return 8
+}
+
+// Unmarshal populates the BBHeader from b.
+func (p *BBHeader) Unmarshal(b []byte) error {
+	if len(b) < 8 {
+		return &util.ShortDataError{Size: len(b), Type: "BBHeader"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *BBHeader) unmarshalFrom(b []byte) {
+	p.Size = binary.LittleEndian.Uint16(b[0:])
+	p.Type = binary.LittleEndian.Uint16(b[2:])
+	p.Flags = binary.LittleEndian.Uint32(b[4:])
+}
+
+// BinarySize returns the number of bytes in the serialized PCv2Header.
+func (p *PCv2Header) BinarySize() int {
+	return 4
+}
+
+// MarshalTo serializes the PCv2Header into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *PCv2Header) MarshalTo(b []byte) int {
+	binary.LittleEndian.PutUint16(b[0:], p.Size)
+	b[2] = p.Type
+	b[3] = p.Flags
+	return 4
+}
+
+// Unmarshal populates the PCv2Header from b.
+func (p *PCv2Header) Unmarshal(b []byte) error {
+	if len(b) < 4 {
+		return &util.ShortDataError{Size: len(b), Type: "PCv2Header"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *PCv2Header) unmarshalFrom(b []byte) {
+	p.Size = binary.LittleEndian.Uint16(b[0:])
+	p.Type = b[2]
+	p.Flags = b[3]
+}
+
+// BinarySize returns the number of bytes in the serialized DCHeader.
+func (p *DCHeader) BinarySize() int {
+	return 4
+}
+
+// MarshalTo serializes the DCHeader into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *DCHeader) MarshalTo(b []byte) int {
+	b[0] = p.Type
+	b[1] = p.Flags
+	binary.LittleEndian.PutUint16(b[2:], p.Size)
+	return 4
+}
+
+// Unmarshal populates the DCHeader from b.
+func (p *DCHeader) Unmarshal(b []byte) error {
+	if len(b) < 4 {
+		return &util.ShortDataError{Size: len(b), Type: "DCHeader"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *DCHeader) unmarshalFrom(b []byte) {
+	p.Type = b[0]
+	p.Flags = b[1]
+	p.Size = binary.LittleEndian.Uint16(b[2:])
+}
+
+// BinarySize returns the number of bytes in the serialized PatchWelcomePkt.
+func (p *PatchWelcomePkt) BinarySize() int {
+	return 76
+}
+
+// MarshalTo serializes the PatchWelcomePkt into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *PatchWelcomePkt) MarshalTo(b []byte) int {
+	p.Header.MarshalTo(b[0:])
+	copy(b[4:48], p.Copyright[:])
+	copy(b[48:68], p.Padding[:])
+	copy(b[68:72], p.ServerVector[:])
+	copy(b[72:76], p.ClientVector[:])
+	return 76
+}
+
+// Unmarshal populates the PatchWelcomePkt from b.
+func (p *PatchWelcomePkt) Unmarshal(b []byte) error {
+	if len(b) < 76 {
+		return &util.ShortDataError{Size: len(b), Type: "PatchWelcomePkt"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *PatchWelcomePkt) unmarshalFrom(b []byte) {
+	p.Header.unmarshalFrom(b[0:])
+	copy(p.Copyright[:], b[4:48])
+	copy(p.Padding[:], b[48:68])
+	copy(p.ServerVector[:], b[68:72])
+	copy(p.ClientVector[:], b[72:76])
+}
+
+// BinarySize returns the number of bytes in the serialized PatchWelcomeMessage.
+func (p *PatchWelcomeMessage) BinarySize() int {
+	return 4 + len(p.Message)
+}
+
+// MarshalTo serializes the PatchWelcomeMessage into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *PatchWelcomeMessage) MarshalTo(b []byte) int {
+	p.Header.MarshalTo(b[0:])
+	copy(b[4:], p.Message)
+	return p.BinarySize()
+}
+
+// Unmarshal populates the PatchWelcomeMessage from b, with Message taking up any bytes
+// after the fixed size fields.
+func (p *PatchWelcomeMessage) Unmarshal(b []byte) error {
+	if len(b) < 4 {
+		return &util.ShortDataError{Size: len(b), Type: "PatchWelcomeMessage"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *PatchWelcomeMessage) unmarshalFrom(b []byte) {
+	p.Header.unmarshalFrom(b[0:])
+	p.Message = append(p.Message[:0], b[4:]...)
+}
+
+// BinarySize returns the number of bytes in the serialized PatchRedirectPacket.
+func (p *PatchRedirectPacket) BinarySize() int {
+	return 12
+}
+
+// MarshalTo serializes the PatchRedirectPacket into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *PatchRedirectPacket) MarshalTo(b []byte) int {
+	p.Header.MarshalTo(b[0:])
+	copy(b[4:8], p.IPAddr[:])
+	binary.LittleEndian.PutUint16(b[8:], p.Port)
+	binary.LittleEndian.PutUint16(b[10:], p.Padding)
+	return 12
+}
+
+// Unmarshal populates the PatchRedirectPacket from b.
+func (p *PatchRedirectPacket) Unmarshal(b []byte) error {
+	if len(b) < 12 {
+		return &util.ShortDataError{Size: len(b), Type: "PatchRedirectPacket"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *PatchRedirectPacket) unmarshalFrom(b []byte) {
+	p.Header.unmarshalFrom(b[0:])
+	copy(p.IPAddr[:], b[4:8])
+	p.Port = binary.LittleEndian.Uint16(b[8:])
+	p.Padding = binary.LittleEndian.Uint16(b[10:])
+}
+
+// BinarySize returns the number of bytes in the serialized ChangeDirPacket.
+func (p *ChangeDirPacket) BinarySize() int {
+	return 68
+}
+
+// MarshalTo serializes the ChangeDirPacket into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *ChangeDirPacket) MarshalTo(b []byte) int {
+	p.Header.MarshalTo(b[0:])
+	copy(b[4:68], p.Dirname[:])
+	return 68
+}
+
+// Unmarshal populates the ChangeDirPacket from b.
+func (p *ChangeDirPacket) Unmarshal(b []byte) error {
+	if len(b) < 68 {
+		return &util.ShortDataError{Size: len(b), Type: "ChangeDirPacket"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *ChangeDirPacket) unmarshalFrom(b []byte) {
+	p.Header.unmarshalFrom(b[0:])
+	copy(p.Dirname[:], b[4:68])
+}
+
+// BinarySize returns the number of bytes in the serialized CheckFilePacket.
+func (p *CheckFilePacket) BinarySize() int {
+	return 40
+}
+
+// MarshalTo serializes the CheckFilePacket into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *CheckFilePacket) MarshalTo(b []byte) int {
+	p.Header.MarshalTo(b[0:])
+	binary.LittleEndian.PutUint32(b[4:], p.PatchId)
+	copy(b[8:40], p.Filename[:])
+	return 40
+}
+
+// Unmarshal populates the CheckFilePacket from b.
+func (p *CheckFilePacket) Unmarshal(b []byte) error {
+	if len(b) < 40 {
+		return &util.ShortDataError{Size: len(b), Type: "CheckFilePacket"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *CheckFilePacket) unmarshalFrom(b []byte) {
+	p.Header.unmarshalFrom(b[0:])
+	p.PatchId = binary.LittleEndian.Uint32(b[4:])
+	copy(p.Filename[:], b[8:40])
+}
+
+// BinarySize returns the number of bytes in the serialized FileStatusPacket.
+func (p *FileStatusPacket) BinarySize() int {
+	return 16
+}
+
+// MarshalTo serializes the FileStatusPacket into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *FileStatusPacket) MarshalTo(b []byte) int {
+	p.Header.MarshalTo(b[0:])
+	binary.LittleEndian.PutUint32(b[4:], p.PatchId)
+	binary.LittleEndian.PutUint32(b[8:], p.Checksum)
+	binary.LittleEndian.PutUint32(b[12:], p.FileSize)
+	return 16
+}
+
+// Unmarshal populates the FileStatusPacket from b.
+func (p *FileStatusPacket) Unmarshal(b []byte) error {
+	if len(b) < 16 {
+		return &util.ShortDataError{Size: len(b), Type: "FileStatusPacket"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *FileStatusPacket) unmarshalFrom(b []byte) {
+	p.Header.unmarshalFrom(b[0:])
+	p.PatchId = binary.LittleEndian.Uint32(b[4:])
+	p.Checksum = binary.LittleEndian.Uint32(b[8:])
+	p.FileSize = binary.LittleEndian.Uint32(b[12:])
+}
+
+// BinarySize returns the number of bytes in the serialized UpdateFilesPacket.
+func (p *UpdateFilesPacket) BinarySize() int {
+	return 12
+}
+
+// MarshalTo serializes the UpdateFilesPacket into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *UpdateFilesPacket) MarshalTo(b []byte) int {
+	p.Header.MarshalTo(b[0:])
+	binary.LittleEndian.PutUint32(b[4:], p.TotalSize)
+	binary.LittleEndian.PutUint32(b[8:], p.NumFiles)
+	return 12
+}
+
+// Unmarshal populates the UpdateFilesPacket from b.
+func (p *UpdateFilesPacket) Unmarshal(b []byte) error {
+	if len(b) < 12 {
+		return &util.ShortDataError{Size: len(b), Type: "UpdateFilesPacket"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *UpdateFilesPacket) unmarshalFrom(b []byte) {
+	p.Header.unmarshalFrom(b[0:])
+	p.TotalSize = binary.LittleEndian.Uint32(b[4:])
+	p.NumFiles = binary.LittleEndian.Uint32(b[8:])
+}
+
+// BinarySize returns the number of bytes in the serialized FileHeaderPacket.
+func (p *FileHeaderPacket) BinarySize() int {
+	return 60
+}
+
+// MarshalTo serializes the FileHeaderPacket into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *FileHeaderPacket) MarshalTo(b []byte) int {
+	p.Header.MarshalTo(b[0:])
+	binary.LittleEndian.PutUint32(b[4:], p.Padding)
+	binary.LittleEndian.PutUint32(b[8:], p.FileSize)
+	copy(b[12:60], p.Filename[:])
+	return 60
+}
+
+// Unmarshal populates the FileHeaderPacket from b.
+func (p *FileHeaderPacket) Unmarshal(b []byte) error {
+	if len(b) < 60 {
+		return &util.ShortDataError{Size: len(b), Type: "FileHeaderPacket"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *FileHeaderPacket) unmarshalFrom(b []byte) {
+	p.Header.unmarshalFrom(b[0:])
+	p.Padding = binary.LittleEndian.Uint32(b[4:])
+	p.FileSize = binary.LittleEndian.Uint32(b[8:])
+	copy(p.Filename[:], b[12:60])
+}
+
+// BinarySize returns the number of bytes in the serialized FileChunkPacket.
+func (p *FileChunkPacket) BinarySize() int {
+	return 16 + len(p.Data)
+}
+
+// MarshalTo serializes the FileChunkPacket into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *FileChunkPacket) MarshalTo(b []byte) int {
+	p.Header.MarshalTo(b[0:])
+	binary.LittleEndian.PutUint32(b[4:], p.Chunk)
+	binary.LittleEndian.PutUint32(b[8:], p.Checksum)
+	binary.LittleEndian.PutUint32(b[12:], p.Size)
+	copy(b[16:], p.Data)
+	return p.BinarySize()
+}
+
+// Unmarshal populates the FileChunkPacket from b, with Data taking up any bytes
+// after the fixed size fields.
+func (p *FileChunkPacket) Unmarshal(b []byte) error {
+	if len(b) < 16 {
+		return &util.ShortDataError{Size: len(b), Type: "FileChunkPacket"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *FileChunkPacket) unmarshalFrom(b []byte) {
+	p.Header.unmarshalFrom(b[0:])
+	p.Chunk = binary.LittleEndian.Uint32(b[4:])
+	p.Checksum = binary.LittleEndian.Uint32(b[8:])
+	p.Size = binary.LittleEndian.Uint32(b[12:])
+	p.Data = append(p.Data[:0], b[16:]...)
+}
+
+// BinarySize returns the number of bytes in the serialized WelcomePkt.
+func (p *WelcomePkt) BinarySize() int {
+	return 200
+}
+
+// MarshalTo serializes the WelcomePkt into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *WelcomePkt) MarshalTo(b []byte) int {
+	p.Header.MarshalTo(b[0:])
+	copy(b[8:104], p.Copyright[:])
+	copy(b[104:152], p.ServerVector[:])
+	copy(b[152:200], p.ClientVector[:])
+	return 200
+}
+
+// Unmarshal populates the WelcomePkt from b.
+func (p *WelcomePkt) Unmarshal(b []byte) error {
+	if len(b) < 200 {
+		return &util.ShortDataError{Size: len(b), Type: "WelcomePkt"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *WelcomePkt) unmarshalFrom(b []byte) {
+	p.Header.unmarshalFrom(b[0:])
+	copy(p.Copyright[:], b[8:104])
+	copy(p.ServerVector[:], b[104:152])
+	copy(p.ClientVector[:], b[152:200])
+}
+
+// BinarySize returns the number of bytes in the serialized LoginPkt.
+func (p *LoginPkt) BinarySize() int {
+	return 180
+}
+
+// MarshalTo serializes the LoginPkt into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *LoginPkt) MarshalTo(b []byte) int {
+	p.Header.MarshalTo(b[0:])
+	copy(b[8:16], p.Unknown[:])
+	binary.LittleEndian.PutUint16(b[16:], p.ClientVersion)
+	copy(b[18:21], p.Unknown2[:])
+	b[21] = uint8(p.SlotNum)
+	binary.LittleEndian.PutUint16(b[22:], p.Phase)
+	binary.LittleEndian.PutUint32(b[24:], p.TeamId)
+	copy(b[28:44], p.Username[:])
+	copy(b[44:76], p.Padding[:])
+	copy(b[76:92], p.Password[:])
+	copy(b[92:132], p.Unknown3[:])
+	copy(b[132:140], p.HardwareInfo[:])
+	copy(b[140:180], p.Security[:])
+	return 180
+}
+
+// Unmarshal populates the LoginPkt from b.
+func (p *LoginPkt) Unmarshal(b []byte) error {
+	if len(b) < 180 {
+		return &util.ShortDataError{Size: len(b), Type: "LoginPkt"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *LoginPkt) unmarshalFrom(b []byte) {
+	p.Header.unmarshalFrom(b[0:])
+	copy(p.Unknown[:], b[8:16])
+	p.ClientVersion = binary.LittleEndian.Uint16(b[16:])
+	copy(p.Unknown2[:], b[18:21])
+	p.SlotNum = int8(b[21])
+	p.Phase = binary.LittleEndian.Uint16(b[22:])
+	p.TeamId = binary.LittleEndian.Uint32(b[24:])
+	copy(p.Username[:], b[28:44])
+	copy(p.Padding[:], b[44:76])
+	copy(p.Password[:], b[76:92])
+	copy(p.Unknown3[:], b[92:132])
+	copy(p.HardwareInfo[:], b[132:140])
+	copy(p.Security[:], b[140:180])
+}
+
+// BinarySize returns the number of bytes in the serialized ClientConfig.
+func (p *ClientConfig) BinarySize() int {
+	return 40
+}
+
+// MarshalTo serializes the ClientConfig into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *ClientConfig) MarshalTo(b []byte) int {
+	binary.LittleEndian.PutUint32(b[0:], p.Magic)
+	b[4] = p.CharSelected
+	b[5] = p.SlotNum
+	binary.LittleEndian.PutUint16(b[6:], p.Flags)
+	for i := range p.Ports {
+		binary.LittleEndian.PutUint16(b[8+2*i:], p.Ports[i])
+	}
+	binary.LittleEndian.PutUint32(b[16:], p.Expires)
+	copy(b[20:40], p.Signature[:])
+	return 40
+}
+
+// Unmarshal populates the ClientConfig from b.
+func (p *ClientConfig) Unmarshal(b []byte) error {
+	if len(b) < 40 {
+		return &util.ShortDataError{Size: len(b), Type: "ClientConfig"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *ClientConfig) unmarshalFrom(b []byte) {
+	p.Magic = binary.LittleEndian.Uint32(b[0:])
+	p.CharSelected = b[4]
+	p.SlotNum = b[5]
+	p.Flags = binary.LittleEndian.Uint16(b[6:])
+	for i := range p.Ports {
+		p.Ports[i] = binary.LittleEndian.Uint16(b[8+2*i:])
+	}
+	p.Expires = binary.LittleEndian.Uint32(b[16:])
+	copy(p.Signature[:], b[20:40])
+}
+
+// BinarySize returns the number of bytes in the serialized SecurityPacket.
+func (p *SecurityPacket) BinarySize() int {
+	return 68
+}
+
+// MarshalTo serializes the SecurityPacket into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *SecurityPacket) MarshalTo(b []byte) int {
+	p.Header.MarshalTo(b[0:])
+	binary.LittleEndian.PutUint32(b[8:], p.ErrorCode)
+	binary.LittleEndian.PutUint32(b[12:], p.PlayerTag)
+	binary.LittleEndian.PutUint32(b[16:], p.Guildcard)
+	binary.LittleEndian.PutUint32(b[20:], p.TeamId)
+	p.Config.MarshalTo(b[24:])
+	binary.LittleEndian.PutUint32(b[64:], p.Capabilities)
+	return 68
+}
+
+// Unmarshal populates the SecurityPacket from b.
+func (p *SecurityPacket) Unmarshal(b []byte) error {
+	if len(b) < 68 {
+		return &util.ShortDataError{Size: len(b), Type: "SecurityPacket"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *SecurityPacket) unmarshalFrom(b []byte) {
+	p.Header.unmarshalFrom(b[0:])
+	p.ErrorCode = binary.LittleEndian.Uint32(b[8:])
+	p.PlayerTag = binary.LittleEndian.Uint32(b[12:])
+	p.Guildcard = binary.LittleEndian.Uint32(b[16:])
+	p.TeamId = binary.LittleEndian.Uint32(b[20:])
+	if p.Config == nil {
+		p.Config = new(ClientConfig)
+	}
+	p.Config.unmarshalFrom(b[24:])
+	p.Capabilities = binary.LittleEndian.Uint32(b[64:])
+}
+
+// BinarySize returns the number of bytes in the serialized RedirectPacket.
+func (p *RedirectPacket) BinarySize() int {
+	return 16
+}
+
+// MarshalTo serializes the RedirectPacket into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *RedirectPacket) MarshalTo(b []byte) int {
+	p.Header.MarshalTo(b[0:])
+	copy(b[8:12], p.IPAddr[:])
+	binary.LittleEndian.PutUint16(b[12:], p.Port)
+	binary.LittleEndian.PutUint16(b[14:], p.Padding)
+	return 16
+}
+
+// Unmarshal populates the RedirectPacket from b.
+func (p *RedirectPacket) Unmarshal(b []byte) error {
+	if len(b) < 16 {
+		return &util.ShortDataError{Size: len(b), Type: "RedirectPacket"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *RedirectPacket) unmarshalFrom(b []byte) {
+	p.Header.unmarshalFrom(b[0:])
+	copy(p.IPAddr[:], b[8:12])
+	p.Port = binary.LittleEndian.Uint16(b[12:])
+	p.Padding = binary.LittleEndian.Uint16(b[14:])
+}
+
+// BinarySize returns the number of bytes in the serialized KeyTeamConfig.
+func (p *KeyTeamConfig) BinarySize() int {
+	return 2804
+}
+
+// MarshalTo serializes the KeyTeamConfig into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *KeyTeamConfig) MarshalTo(b []byte) int {
+	copy(b[0:276], p.Unknown[:])
+	copy(b[276:640], p.KeyConfig[:])
+	copy(b[640:696], p.JoystickConfig[:])
+	binary.LittleEndian.PutUint32(b[696:], p.Guildcard)
+	binary.LittleEndian.PutUint32(b[700:], p.TeamId)
+	for i := range p.TeamInfo {
+		binary.LittleEndian.PutUint32(b[704+4*i:], p.TeamInfo[i])
+	}
+	binary.LittleEndian.PutUint16(b[712:], p.TeamPrivilegeLevel)
+	binary.LittleEndian.PutUint16(b[714:], p.Reserved)
+	for i := range p.Teamname {
+		binary.LittleEndian.PutUint16(b[716+2*i:], p.Teamname[i])
+	}
+	copy(b[748:2796], p.TeamFlag[:])
+	for i := range p.TeamRewards {
+		binary.LittleEndian.PutUint32(b[2796+4*i:], p.TeamRewards[i])
+	}
+	return 2804
+}
+
+// Unmarshal populates the KeyTeamConfig from b.
+func (p *KeyTeamConfig) Unmarshal(b []byte) error {
+	if len(b) < 2804 {
+		return &util.ShortDataError{Size: len(b), Type: "KeyTeamConfig"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *KeyTeamConfig) unmarshalFrom(b []byte) {
+	copy(p.Unknown[:], b[0:276])
+	copy(p.KeyConfig[:], b[276:640])
+	copy(p.JoystickConfig[:], b[640:696])
+	p.Guildcard = binary.LittleEndian.Uint32(b[696:])
+	p.TeamId = binary.LittleEndian.Uint32(b[700:])
+	for i := range p.TeamInfo {
+		p.TeamInfo[i] = binary.LittleEndian.Uint32(b[704+4*i:])
+	}
+	p.TeamPrivilegeLevel = binary.LittleEndian.Uint16(b[712:])
+	p.Reserved = binary.LittleEndian.Uint16(b[714:])
+	for i := range p.Teamname {
+		p.Teamname[i] = binary.LittleEndian.Uint16(b[716+2*i:])
+	}
+	copy(p.TeamFlag[:], b[748:2796])
+	for i := range p.TeamRewards {
+		p.TeamRewards[i] = binary.LittleEndian.Uint32(b[2796+4*i:])
+	}
+}
+
+// BinarySize returns the number of bytes in the serialized OptionsPacket.
+func (p *OptionsPacket) BinarySize() int {
+	return 2812
+}
+
+// MarshalTo serializes the OptionsPacket into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *OptionsPacket) MarshalTo(b []byte) int {
+	p.Header.MarshalTo(b[0:])
+	p.PlayerKeyConfig.MarshalTo(b[8:])
+	return 2812
+}
+
+// Unmarshal populates the OptionsPacket from b.
+func (p *OptionsPacket) Unmarshal(b []byte) error {
+	if len(b) < 2812 {
+		return &util.ShortDataError{Size: len(b), Type: "OptionsPacket"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *OptionsPacket) unmarshalFrom(b []byte) {
+	p.Header.unmarshalFrom(b[0:])
+	p.PlayerKeyConfig.unmarshalFrom(b[8:])
+}
+
+// BinarySize returns the number of bytes in the serialized CharSelectionPacket.
+func (p *CharSelectionPacket) BinarySize() int {
+	return 16
+}
+
+// MarshalTo serializes the CharSelectionPacket into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *CharSelectionPacket) MarshalTo(b []byte) int {
+	p.Header.MarshalTo(b[0:])
+	binary.LittleEndian.PutUint32(b[8:], p.Slot)
+	binary.LittleEndian.PutUint32(b[12:], p.Selecting)
+	return 16
+}
+
+// Unmarshal populates the CharSelectionPacket from b.
+func (p *CharSelectionPacket) Unmarshal(b []byte) error {
+	if len(b) < 16 {
+		return &util.ShortDataError{Size: len(b), Type: "CharSelectionPacket"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *CharSelectionPacket) unmarshalFrom(b []byte) {
+	p.Header.unmarshalFrom(b[0:])
+	p.Slot = binary.LittleEndian.Uint32(b[8:])
+	p.Selecting = binary.LittleEndian.Uint32(b[12:])
+}
+
+// BinarySize returns the number of bytes in the serialized CharAckPacket.
+func (p *CharAckPacket) BinarySize() int {
+	return 16
+}
+
+// MarshalTo serializes the CharAckPacket into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *CharAckPacket) MarshalTo(b []byte) int {
+	p.Header.MarshalTo(b[0:])
+	binary.LittleEndian.PutUint32(b[8:], p.Slot)
+	binary.LittleEndian.PutUint32(b[12:], p.Flag)
+	return 16
+}
+
+// Unmarshal populates the CharAckPacket from b.
+func (p *CharAckPacket) Unmarshal(b []byte) error {
+	if len(b) < 16 {
+		return &util.ShortDataError{Size: len(b), Type: "CharAckPacket"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *CharAckPacket) unmarshalFrom(b []byte) {
+	p.Header.unmarshalFrom(b[0:])
+	p.Slot = binary.LittleEndian.Uint32(b[8:])
+	p.Flag = binary.LittleEndian.Uint32(b[12:])
+}
+
+// BinarySize returns the number of bytes in the serialized ChecksumAckPacket.
+func (p *ChecksumAckPacket) BinarySize() int {
+	return 12
+}
+
+// MarshalTo serializes the ChecksumAckPacket into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *ChecksumAckPacket) MarshalTo(b []byte) int {
+	p.Header.MarshalTo(b[0:])
+	binary.LittleEndian.PutUint32(b[8:], p.Ack)
+	return 12
+}
+
+// Unmarshal populates the ChecksumAckPacket from b.
+func (p *ChecksumAckPacket) Unmarshal(b []byte) error {
+	if len(b) < 12 {
+		return &util.ShortDataError{Size: len(b), Type: "ChecksumAckPacket"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *ChecksumAckPacket) unmarshalFrom(b []byte) {
+	p.Header.unmarshalFrom(b[0:])
+	p.Ack = binary.LittleEndian.Uint32(b[8:])
+}
+
+// BinarySize returns the number of bytes in the serialized GuildcardHeaderPacket.
+func (p *GuildcardHeaderPacket) BinarySize() int {
+	return 20
+}
+
+// MarshalTo serializes the GuildcardHeaderPacket into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *GuildcardHeaderPacket) MarshalTo(b []byte) int {
+	p.Header.MarshalTo(b[0:])
+	binary.LittleEndian.PutUint32(b[8:], p.Unknown)
+	binary.LittleEndian.PutUint16(b[12:], p.Length)
+	binary.LittleEndian.PutUint16(b[14:], p.Padding)
+	binary.LittleEndian.PutUint32(b[16:], p.Checksum)
+	return 20
+}
+
+// Unmarshal populates the GuildcardHeaderPacket from b.
+func (p *GuildcardHeaderPacket) Unmarshal(b []byte) error {
+	if len(b) < 20 {
+		return &util.ShortDataError{Size: len(b), Type: "GuildcardHeaderPacket"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *GuildcardHeaderPacket) unmarshalFrom(b []byte) {
+	p.Header.unmarshalFrom(b[0:])
+	p.Unknown = binary.LittleEndian.Uint32(b[8:])
+	p.Length = binary.LittleEndian.Uint16(b[12:])
+	p.Padding = binary.LittleEndian.Uint16(b[14:])
+	p.Checksum = binary.LittleEndian.Uint32(b[16:])
+}
+
+// BinarySize returns the number of bytes in the serialized GuildcardChunkReqPacket.
+func (p *GuildcardChunkReqPacket) BinarySize() int {
+	return 20
+}
+
+// MarshalTo serializes the GuildcardChunkReqPacket into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *GuildcardChunkReqPacket) MarshalTo(b []byte) int {
+	p.Header.MarshalTo(b[0:])
+	binary.LittleEndian.PutUint32(b[8:], p.Unknown)
+	binary.LittleEndian.PutUint32(b[12:], p.ChunkRequested)
+	binary.LittleEndian.PutUint32(b[16:], p.Continue)
+	return 20
+}
+
+// Unmarshal populates the GuildcardChunkReqPacket from b.
+func (p *GuildcardChunkReqPacket) Unmarshal(b []byte) error {
+	if len(b) < 20 {
+		return &util.ShortDataError{Size: len(b), Type: "GuildcardChunkReqPacket"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *GuildcardChunkReqPacket) unmarshalFrom(b []byte) {
+	p.Header.unmarshalFrom(b[0:])
+	p.Unknown = binary.LittleEndian.Uint32(b[8:])
+	p.ChunkRequested = binary.LittleEndian.Uint32(b[12:])
+	p.Continue = binary.LittleEndian.Uint32(b[16:])
+}
+
+// BinarySize returns the number of bytes in the serialized GuildcardChunkPacket.
+func (p *GuildcardChunkPacket) BinarySize() int {
+	return 16 + len(p.Data)
+}
+
+// MarshalTo serializes the GuildcardChunkPacket into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *GuildcardChunkPacket) MarshalTo(b []byte) int {
+	p.Header.MarshalTo(b[0:])
+	binary.LittleEndian.PutUint32(b[8:], p.Unknown)
+	binary.LittleEndian.PutUint32(b[12:], p.Chunk)
+	copy(b[16:], p.Data)
+	return p.BinarySize()
+}
+
+// Unmarshal populates the GuildcardChunkPacket from b, with Data taking up any bytes
+// after the fixed size fields.
+func (p *GuildcardChunkPacket) Unmarshal(b []byte) error {
+	if len(b) < 16 {
+		return &util.ShortDataError{Size: len(b), Type: "GuildcardChunkPacket"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *GuildcardChunkPacket) unmarshalFrom(b []byte) {
+	p.Header.unmarshalFrom(b[0:])
+	p.Unknown = binary.LittleEndian.Uint32(b[8:])
+	p.Chunk = binary.LittleEndian.Uint32(b[12:])
+	p.Data = append(p.Data[:0], b[16:]...)
+}
+
+// BinarySize returns the number of bytes in the serialized ParameterHeaderPacket.
+func (p *ParameterHeaderPacket) BinarySize() int {
+	return 8 + len(p.Entries)
+}
+
+// MarshalTo serializes the ParameterHeaderPacket into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *ParameterHeaderPacket) MarshalTo(b []byte) int {
+	p.Header.MarshalTo(b[0:])
+	copy(b[8:], p.Entries)
+	return p.BinarySize()
+}
+
+// Unmarshal populates the ParameterHeaderPacket from b, with Entries taking up any bytes
+// after the fixed size fields.
+func (p *ParameterHeaderPacket) Unmarshal(b []byte) error {
+	if len(b) < 8 {
+		return &util.ShortDataError{Size: len(b), Type: "ParameterHeaderPacket"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *ParameterHeaderPacket) unmarshalFrom(b []byte) {
+	p.Header.unmarshalFrom(b[0:])
+	p.Entries = append(p.Entries[:0], b[8:]...)
+}
+
+// BinarySize returns the number of bytes in the serialized ParameterChunkPacket.
+func (p *ParameterChunkPacket) BinarySize() int {
+	return 12 + len(p.Data)
+}
+
+// MarshalTo serializes the ParameterChunkPacket into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *ParameterChunkPacket) MarshalTo(b []byte) int {
+	p.Header.MarshalTo(b[0:])
+	binary.LittleEndian.PutUint32(b[8:], p.Chunk)
+	copy(b[12:], p.Data)
+	return p.BinarySize()
+}
+
+// Unmarshal populates the ParameterChunkPacket from b, with Data taking up any bytes
+// after the fixed size fields.
+func (p *ParameterChunkPacket) Unmarshal(b []byte) error {
+	if len(b) < 12 {
+		return &util.ShortDataError{Size: len(b), Type: "ParameterChunkPacket"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *ParameterChunkPacket) unmarshalFrom(b []byte) {
+	p.Header.unmarshalFrom(b[0:])
+	p.Chunk = binary.LittleEndian.Uint32(b[8:])
+	p.Data = append(p.Data[:0], b[12:]...)
+}
+
+// BinarySize returns the number of bytes in the serialized SetFlagPacket.
+func (p *SetFlagPacket) BinarySize() int {
+	return 12
+}
+
+// MarshalTo serializes the SetFlagPacket into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *SetFlagPacket) MarshalTo(b []byte) int {
+	p.Header.MarshalTo(b[0:])
+	binary.LittleEndian.PutUint32(b[8:], p.Flag)
+	return 12
+}
+
+// Unmarshal populates the SetFlagPacket from b.
+func (p *SetFlagPacket) Unmarshal(b []byte) error {
+	if len(b) < 12 {
+		return &util.ShortDataError{Size: len(b), Type: "SetFlagPacket"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *SetFlagPacket) unmarshalFrom(b []byte) {
+	p.Header.unmarshalFrom(b[0:])
+	p.Flag = binary.LittleEndian.Uint32(b[8:])
+}
+
+// BinarySize returns the number of bytes in the serialized CharPreviewPacket.
+func (p *CharPreviewPacket) BinarySize() int {
+	return 128
+}
+
+// MarshalTo serializes the CharPreviewPacket into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *CharPreviewPacket) MarshalTo(b []byte) int {
+	p.Header.MarshalTo(b[0:])
+	binary.LittleEndian.PutUint32(b[8:], p.Slot)
+	p.Character.MarshalTo(b[12:])
+	return 128
+}
+
+// Unmarshal populates the CharPreviewPacket from b.
+func (p *CharPreviewPacket) Unmarshal(b []byte) error {
+	if len(b) < 128 {
+		return &util.ShortDataError{Size: len(b), Type: "CharPreviewPacket"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *CharPreviewPacket) unmarshalFrom(b []byte) {
+	p.Header.unmarshalFrom(b[0:])
+	p.Slot = binary.LittleEndian.Uint32(b[8:])
+	if p.Character == nil {
+		p.Character = new(CharacterPreview)
+	}
+	p.Character.unmarshalFrom(b[12:])
+}
+
+// BinarySize returns the number of bytes in the serialized LoginClientMessagePacket.
+func (p *LoginClientMessagePacket) BinarySize() int {
+	return 12 + len(p.Message)
+}
+
+// MarshalTo serializes the LoginClientMessagePacket into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *LoginClientMessagePacket) MarshalTo(b []byte) int {
+	p.Header.MarshalTo(b[0:])
+	binary.LittleEndian.PutUint32(b[8:], p.Language)
+	copy(b[12:], p.Message)
+	return p.BinarySize()
+}
+
+// Unmarshal populates the LoginClientMessagePacket from b, with Message taking up any bytes
+// after the fixed size fields.
+func (p *LoginClientMessagePacket) Unmarshal(b []byte) error {
+	if len(b) < 12 {
+		return &util.ShortDataError{Size: len(b), Type: "LoginClientMessagePacket"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *LoginClientMessagePacket) unmarshalFrom(b []byte) {
+	p.Header.unmarshalFrom(b[0:])
+	p.Language = binary.LittleEndian.Uint32(b[8:])
+	p.Message = append(p.Message[:0], b[12:]...)
+}
+
+// BinarySize returns the number of bytes in the serialized TimestampPacket.
+func (p *TimestampPacket) BinarySize() int {
+	return 36
+}
+
+// MarshalTo serializes the TimestampPacket into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *TimestampPacket) MarshalTo(b []byte) int {
+	p.Header.MarshalTo(b[0:])
+	copy(b[8:36], p.Timestamp[:])
+	return 36
+}
+
+// Unmarshal populates the TimestampPacket from b.
+func (p *TimestampPacket) Unmarshal(b []byte) error {
+	if len(b) < 36 {
+		return &util.ShortDataError{Size: len(b), Type: "TimestampPacket"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *TimestampPacket) unmarshalFrom(b []byte) {
+	p.Header.unmarshalFrom(b[0:])
+	copy(p.Timestamp[:], b[8:36])
+}
+
+// BinarySize returns the number of bytes in the serialized ShipListPacket.
+func (p *ShipListPacket) BinarySize() int {
+	return 54 + 31*len(p.ShipEntries)
+}
+
+// MarshalTo serializes the ShipListPacket into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *ShipListPacket) MarshalTo(b []byte) int {
+	p.Header.MarshalTo(b[0:])
+	binary.LittleEndian.PutUint16(b[8:], p.Padding)
+	binary.LittleEndian.PutUint16(b[10:], p.Unknown)
+	binary.LittleEndian.PutUint32(b[12:], p.Unknown2)
+	binary.LittleEndian.PutUint16(b[16:], p.Unknown3)
+	copy(b[18:54], p.ServerName[:])
+	for i := range p.ShipEntries {
+		p.ShipEntries[i].MarshalTo(b[54+31*i:])
+	}
+	return p.BinarySize()
+}
+
+// Unmarshal populates the ShipListPacket from b, with ShipEntries taking up any bytes
+// after the fixed size fields.
+func (p *ShipListPacket) Unmarshal(b []byte) error {
+	if len(b) < 54 {
+		return &util.ShortDataError{Size: len(b), Type: "ShipListPacket"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *ShipListPacket) unmarshalFrom(b []byte) {
+	p.Header.unmarshalFrom(b[0:])
+	p.Padding = binary.LittleEndian.Uint16(b[8:])
+	p.Unknown = binary.LittleEndian.Uint16(b[10:])
+	p.Unknown2 = binary.LittleEndian.Uint32(b[12:])
+	p.Unknown3 = binary.LittleEndian.Uint16(b[16:])
+	copy(p.ServerName[:], b[18:54])
+	n := (len(b) - 54) / 31
+	if cap(p.ShipEntries) < n {
+		p.ShipEntries = make([]ShipMenuEntry, n)
+	} else {
+		p.ShipEntries = p.ShipEntries[:n]
+	}
+	for i := range p.ShipEntries {
+		p.ShipEntries[i].unmarshalFrom(b[54+31*i:])
+	}
+}
+
+// BinarySize returns the number of bytes in the serialized ScrollMessagePacket.
+func (p *ScrollMessagePacket) BinarySize() int {
+	return 16 + len(p.Message)
+}
+
+// MarshalTo serializes the ScrollMessagePacket into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *ScrollMessagePacket) MarshalTo(b []byte) int {
+	p.Header.MarshalTo(b[0:])
+	for i := range p.Padding {
+		binary.LittleEndian.PutUint32(b[8+4*i:], p.Padding[i])
+	}
+	copy(b[16:], p.Message)
+	return p.BinarySize()
+}
+
+// Unmarshal populates the ScrollMessagePacket from b, with Message taking up any bytes
+// after the fixed size fields.
+func (p *ScrollMessagePacket) Unmarshal(b []byte) error {
+	if len(b) < 16 {
+		return &util.ShortDataError{Size: len(b), Type: "ScrollMessagePacket"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *ScrollMessagePacket) unmarshalFrom(b []byte) {
+	p.Header.unmarshalFrom(b[0:])
+	for i := range p.Padding {
+		p.Padding[i] = binary.LittleEndian.Uint32(b[8+4*i:])
+	}
+	p.Message = append(p.Message[:0], b[16:]...)
+}
+
+// BinarySize returns the number of bytes in the serialized MenuSelectionPacket.
+func (p *MenuSelectionPacket) BinarySize() int {
+	return 16
+}
+
+// MarshalTo serializes the MenuSelectionPacket into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *MenuSelectionPacket) MarshalTo(b []byte) int {
+	p.Header.MarshalTo(b[0:])
+	binary.LittleEndian.PutUint16(b[8:], p.Unknown)
+	binary.LittleEndian.PutUint16(b[10:], p.MenuId)
+	binary.LittleEndian.PutUint32(b[12:], p.ItemId)
+	return 16
+}
+
+// Unmarshal populates the MenuSelectionPacket from b.
+func (p *MenuSelectionPacket) Unmarshal(b []byte) error {
+	if len(b) < 16 {
+		return &util.ShortDataError{Size: len(b), Type: "MenuSelectionPacket"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *MenuSelectionPacket) unmarshalFrom(b []byte) {
+	p.Header.unmarshalFrom(b[0:])
+	p.Unknown = binary.LittleEndian.Uint16(b[8:])
+	p.MenuId = binary.LittleEndian.Uint16(b[10:])
+	p.ItemId = binary.LittleEndian.Uint32(b[12:])
+}
+
+// BinarySize returns the number of bytes in the serialized BlockListPacket.
+func (p *BlockListPacket) BinarySize() int {
+	return 54 + 44*len(p.Blocks)
+}
+
+// MarshalTo serializes the BlockListPacket into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *BlockListPacket) MarshalTo(b []byte) int {
+	p.Header.MarshalTo(b[0:])
+	copy(b[8:18], p.Padding[:])
+	copy(b[18:50], p.ShipName[:])
+	binary.LittleEndian.PutUint32(b[50:], p.Unknown)
+	for i := range p.Blocks {
+		p.Blocks[i].MarshalTo(b[54+44*i:])
+	}
+	return p.BinarySize()
+}
+
+// Unmarshal populates the BlockListPacket from b, with Blocks taking up any bytes
+// after the fixed size fields.
+func (p *BlockListPacket) Unmarshal(b []byte) error {
+	if len(b) < 54 {
+		return &util.ShortDataError{Size: len(b), Type: "BlockListPacket"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *BlockListPacket) unmarshalFrom(b []byte) {
+	p.Header.unmarshalFrom(b[0:])
+	copy(p.Padding[:], b[8:18])
+	copy(p.ShipName[:], b[18:50])
+	p.Unknown = binary.LittleEndian.Uint32(b[50:])
+	n := (len(b) - 54) / 44
+	if cap(p.Blocks) < n {
+		p.Blocks = make([]Block, n)
+	} else {
+		p.Blocks = p.Blocks[:n]
+	}
+	for i := range p.Blocks {
+		p.Blocks[i].unmarshalFrom(b[54+44*i:])
+	}
+}
+
+// BinarySize returns the number of bytes in the serialized ShipMenuEntry.
+func (p *ShipMenuEntry) BinarySize() int {
+	return 31
+}
+
+// MarshalTo serializes the ShipMenuEntry into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *ShipMenuEntry) MarshalTo(b []byte) int {
+	binary.LittleEndian.PutUint16(b[0:], p.MenuId)
+	binary.LittleEndian.PutUint32(b[2:], p.ShipId)
+	binary.LittleEndian.PutUint16(b[6:], p.Padding)
+	copy(b[8:31], p.Shipname[:])
+	return 31
+}
+
+// Unmarshal populates the ShipMenuEntry from b.
+func (p *ShipMenuEntry) Unmarshal(b []byte) error {
+	if len(b) < 31 {
+		return &util.ShortDataError{Size: len(b), Type: "ShipMenuEntry"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *ShipMenuEntry) unmarshalFrom(b []byte) {
+	p.MenuId = binary.LittleEndian.Uint16(b[0:])
+	p.ShipId = binary.LittleEndian.Uint32(b[2:])
+	p.Padding = binary.LittleEndian.Uint16(b[6:])
+	copy(p.Shipname[:], b[8:31])
+}
+
+// BinarySize returns the number of bytes in the serialized parameterEntry.
+func (p *parameterEntry) BinarySize() int {
+	return 76
+}
+
+// MarshalTo serializes the parameterEntry into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *parameterEntry) MarshalTo(b []byte) int {
+	binary.LittleEndian.PutUint32(b[0:], p.Size)
+	binary.LittleEndian.PutUint32(b[4:], p.Checksum)
+	binary.LittleEndian.PutUint32(b[8:], p.Offset)
+	copy(b[12:76], p.Filename[:])
+	return 76
+}
+
+// Unmarshal populates the parameterEntry from b.
+func (p *parameterEntry) Unmarshal(b []byte) error {
+	if len(b) < 76 {
+		return &util.ShortDataError{Size: len(b), Type: "parameterEntry"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *parameterEntry) unmarshalFrom(b []byte) {
+	p.Size = binary.LittleEndian.Uint32(b[0:])
+	p.Checksum = binary.LittleEndian.Uint32(b[4:])
+	p.Offset = binary.LittleEndian.Uint32(b[8:])
+	copy(p.Filename[:], b[12:76])
+}
+
+// BinarySize returns the number of bytes in the serialized Block.
+func (p *Block) BinarySize() int {
+	return 44
+}
+
+// MarshalTo serializes the Block into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *Block) MarshalTo(b []byte) int {
+	binary.LittleEndian.PutUint16(b[0:], p.Unknown)
+	binary.LittleEndian.PutUint32(b[2:], p.BlockId)
+	binary.LittleEndian.PutUint16(b[6:], p.Padding)
+	copy(b[8:44], p.BlockName[:])
+	return 44
+}
+
+// Unmarshal populates the Block from b.
+func (p *Block) Unmarshal(b []byte) error {
+	if len(b) < 44 {
+		return &util.ShortDataError{Size: len(b), Type: "Block"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *Block) unmarshalFrom(b []byte) {
+	p.Unknown = binary.LittleEndian.Uint16(b[0:])
+	p.BlockId = binary.LittleEndian.Uint32(b[2:])
+	p.Padding = binary.LittleEndian.Uint16(b[6:])
+	copy(p.BlockName[:], b[8:44])
+}
+
+// BinarySize returns the number of bytes in the serialized LobbyListPacket.
+func (p *LobbyListPacket) BinarySize() int {
+	return 8 + 12*len(p.Lobbies)
+}
+
+// MarshalTo serializes the LobbyListPacket into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *LobbyListPacket) MarshalTo(b []byte) int {
+	p.Header.MarshalTo(b[0:])
+	for i := range p.Lobbies {
+		p.Lobbies[i].MarshalTo(b[8+12*i:])
+	}
+	return p.BinarySize()
+}
+
+// Unmarshal populates the LobbyListPacket from b, with Lobbies taking up any bytes
+// after the fixed size fields.
+func (p *LobbyListPacket) Unmarshal(b []byte) error {
+	if len(b) < 8 {
+		return &util.ShortDataError{Size: len(b), Type: "LobbyListPacket"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *LobbyListPacket) unmarshalFrom(b []byte) {
+	p.Header.unmarshalFrom(b[0:])
+	n := (len(b) - 8) / 12
+	if cap(p.Lobbies) < n {
+		p.Lobbies = make([]LobbyListEntry, n)
+	} else {
+		p.Lobbies = p.Lobbies[:n]
+	}
+	for i := range p.Lobbies {
+		p.Lobbies[i].unmarshalFrom(b[8+12*i:])
+	}
+}
+
+// BinarySize returns the number of bytes in the serialized LobbyListEntry.
+func (p *LobbyListEntry) BinarySize() int {
+	return 12
+}
+
+// MarshalTo serializes the LobbyListEntry into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *LobbyListEntry) MarshalTo(b []byte) int {
+	binary.LittleEndian.PutUint32(b[0:], p.MenuId)
+	binary.LittleEndian.PutUint32(b[4:], p.LobbyId)
+	binary.LittleEndian.PutUint32(b[8:], p.Padding)
+	return 12
+}
+
+// Unmarshal populates the LobbyListEntry from b.
+func (p *LobbyListEntry) Unmarshal(b []byte) error {
+	if len(b) < 12 {
+		return &util.ShortDataError{Size: len(b), Type: "LobbyListEntry"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *LobbyListEntry) unmarshalFrom(b []byte) {
+	p.MenuId = binary.LittleEndian.Uint32(b[0:])
+	p.LobbyId = binary.LittleEndian.Uint32(b[4:])
+	p.Padding = binary.LittleEndian.Uint32(b[8:])
+}
+
+// BinarySize returns the number of bytes in the serialized PlayerHeader.
+func (p *PlayerHeader) BinarySize() int {
+	return 68
+}
+
+// MarshalTo serializes the PlayerHeader into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *PlayerHeader) MarshalTo(b []byte) int {
+	binary.LittleEndian.PutUint32(b[0:], p.Tag)
+	binary.LittleEndian.PutUint32(b[4:], p.Guildcard)
+	binary.LittleEndian.PutUint32(b[8:], p.TeamId)
+	for i := range p.Unknown {
+		binary.LittleEndian.PutUint32(b[12+4*i:], p.Unknown[i])
+	}
+	binary.LittleEndian.PutUint32(b[28:], p.ClientId)
+	copy(b[32:64], p.Name[:])
+	binary.LittleEndian.PutUint32(b[64:], p.Unknown2)
+	return 68
+}
+
+// Unmarshal populates the PlayerHeader from b.
+func (p *PlayerHeader) Unmarshal(b []byte) error {
+	if len(b) < 68 {
+		return &util.ShortDataError{Size: len(b), Type: "PlayerHeader"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *PlayerHeader) unmarshalFrom(b []byte) {
+	p.Tag = binary.LittleEndian.Uint32(b[0:])
+	p.Guildcard = binary.LittleEndian.Uint32(b[4:])
+	p.TeamId = binary.LittleEndian.Uint32(b[8:])
+	for i := range p.Unknown {
+		p.Unknown[i] = binary.LittleEndian.Uint32(b[12+4*i:])
+	}
+	p.ClientId = binary.LittleEndian.Uint32(b[28:])
+	copy(p.Name[:], b[32:64])
+	p.Unknown2 = binary.LittleEndian.Uint32(b[64:])
+}
+
+// BinarySize returns the number of bytes in the serialized LobbyJoinPacket.
+func (p *LobbyJoinPacket) BinarySize() int {
+	return 20 + 68*len(p.Players)
+}
+
+// MarshalTo serializes the LobbyJoinPacket into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *LobbyJoinPacket) MarshalTo(b []byte) int {
+	p.Header.MarshalTo(b[0:])
+	b[8] = p.ClientId
+	b[9] = p.LeaderId
+	b[10] = p.Unknown
+	b[11] = p.LobbyNum
+	binary.LittleEndian.PutUint16(b[12:], p.BlockNum)
+	binary.LittleEndian.PutUint16(b[14:], p.Event)
+	binary.LittleEndian.PutUint32(b[16:], p.Padding)
+	for i := range p.Players {
+		p.Players[i].MarshalTo(b[20+68*i:])
+	}
+	return p.BinarySize()
+}
+
+// Unmarshal populates the LobbyJoinPacket from b, with Players taking up any bytes
+// after the fixed size fields.
+func (p *LobbyJoinPacket) Unmarshal(b []byte) error {
+	if len(b) < 20 {
+		return &util.ShortDataError{Size: len(b), Type: "LobbyJoinPacket"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *LobbyJoinPacket) unmarshalFrom(b []byte) {
+	p.Header.unmarshalFrom(b[0:])
+	p.ClientId = b[8]
+	p.LeaderId = b[9]
+	p.Unknown = b[10]
+	p.LobbyNum = b[11]
+	p.BlockNum = binary.LittleEndian.Uint16(b[12:])
+	p.Event = binary.LittleEndian.Uint16(b[14:])
+	p.Padding = binary.LittleEndian.Uint32(b[16:])
+	n := (len(b) - 20) / 68
+	if cap(p.Players) < n {
+		p.Players = make([]PlayerHeader, n)
+	} else {
+		p.Players = p.Players[:n]
+	}
+	for i := range p.Players {
+		p.Players[i].unmarshalFrom(b[20+68*i:])
+	}
+}
+
+// BinarySize returns the number of bytes in the serialized LeaveNoticePacket.
+func (p *LeaveNoticePacket) BinarySize() int {
+	return 12
+}
+
+// MarshalTo serializes the LeaveNoticePacket into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *LeaveNoticePacket) MarshalTo(b []byte) int {
+	p.Header.MarshalTo(b[0:])
+	b[8] = p.ClientId
+	b[9] = p.LeaderId
+	binary.LittleEndian.PutUint16(b[10:], p.Padding)
+	return 12
+}
+
+// Unmarshal populates the LeaveNoticePacket from b.
+func (p *LeaveNoticePacket) Unmarshal(b []byte) error {
+	if len(b) < 12 {
+		return &util.ShortDataError{Size: len(b), Type: "LeaveNoticePacket"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *LeaveNoticePacket) unmarshalFrom(b []byte) {
+	p.Header.unmarshalFrom(b[0:])
+	p.ClientId = b[8]
+	p.LeaderId = b[9]
+	p.Padding = binary.LittleEndian.Uint16(b[10:])
+}
+
+// BinarySize returns the number of bytes in the serialized ChatPacket.
+func (p *ChatPacket) BinarySize() int {
+	return 16 + len(p.Message)
+}
+
+// MarshalTo serializes the ChatPacket into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *ChatPacket) MarshalTo(b []byte) int {
+	p.Header.MarshalTo(b[0:])
+	binary.LittleEndian.PutUint32(b[8:], p.Unused)
+	binary.LittleEndian.PutUint32(b[12:], p.Guildcard)
+	copy(b[16:], p.Message)
+	return p.BinarySize()
+}
+
+// Unmarshal populates the ChatPacket from b, with Message taking up any bytes
+// after the fixed size fields.
+func (p *ChatPacket) Unmarshal(b []byte) error {
+	if len(b) < 16 {
+		return &util.ShortDataError{Size: len(b), Type: "ChatPacket"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *ChatPacket) unmarshalFrom(b []byte) {
+	p.Header.unmarshalFrom(b[0:])
+	p.Unused = binary.LittleEndian.Uint32(b[8:])
+	p.Guildcard = binary.LittleEndian.Uint32(b[12:])
+	p.Message = append(p.Message[:0], b[16:]...)
+}
+
+// BinarySize returns the number of bytes in the serialized LobbyChangePacket.
+func (p *LobbyChangePacket) BinarySize() int {
+	return 16
+}
+
+// MarshalTo serializes the LobbyChangePacket into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *LobbyChangePacket) MarshalTo(b []byte) int {
+	p.Header.MarshalTo(b[0:])
+	binary.LittleEndian.PutUint32(b[8:], p.MenuId)
+	binary.LittleEndian.PutUint32(b[12:], p.LobbyId)
+	return 16
+}
+
+// Unmarshal populates the LobbyChangePacket from b.
+func (p *LobbyChangePacket) Unmarshal(b []byte) error {
+	if len(b) < 16 {
+		return &util.ShortDataError{Size: len(b), Type: "LobbyChangePacket"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *LobbyChangePacket) unmarshalFrom(b []byte) {
+	p.Header.unmarshalFrom(b[0:])
+	p.MenuId = binary.LittleEndian.Uint32(b[8:])
+	p.LobbyId = binary.LittleEndian.Uint32(b[12:])
+}
+
+// BinarySize returns the number of bytes in the serialized CreateGamePacket.
+func (p *CreateGamePacket) BinarySize() int {
+	return 88
+}
+
+// MarshalTo serializes the CreateGamePacket into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *CreateGamePacket) MarshalTo(b []byte) int {
+	p.Header.MarshalTo(b[0:])
+	for i := range p.Unused {
+		binary.LittleEndian.PutUint32(b[8+4*i:], p.Unused[i])
+	}
+	copy(b[16:48], p.Name[:])
+	copy(b[48:80], p.Password[:])
+	b[80] = p.Difficulty
+	b[81] = p.Battle
+	b[82] = p.Challenge
+	b[83] = p.Episode
+	b[84] = p.SinglePlayer
+	copy(b[85:88], p.Padding[:])
+	return 88
+}
+
+// Unmarshal populates the CreateGamePacket from b.
+func (p *CreateGamePacket) Unmarshal(b []byte) error {
+	if len(b) < 88 {
+		return &util.ShortDataError{Size: len(b), Type: "CreateGamePacket"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *CreateGamePacket) unmarshalFrom(b []byte) {
+	p.Header.unmarshalFrom(b[0:])
+	for i := range p.Unused {
+		p.Unused[i] = binary.LittleEndian.Uint32(b[8+4*i:])
+	}
+	copy(p.Name[:], b[16:48])
+	copy(p.Password[:], b[48:80])
+	p.Difficulty = b[80]
+	p.Battle = b[81]
+	p.Challenge = b[82]
+	p.Episode = b[83]
+	p.SinglePlayer = b[84]
+	copy(p.Padding[:], b[85:88])
+}
+
+// BinarySize returns the number of bytes in the serialized GameJoinPacket.
+func (p *GameJoinPacket) BinarySize() int {
+	return 424
+}
+
+// MarshalTo serializes the GameJoinPacket into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *GameJoinPacket) MarshalTo(b []byte) int {
+	p.Header.MarshalTo(b[0:])
+	for i := range p.Variations {
+		binary.LittleEndian.PutUint32(b[8+4*i:], p.Variations[i])
+	}
+	for i := range p.Players {
+		p.Players[i].MarshalTo(b[136+68*i:])
+	}
+	b[408] = p.ClientId
+	b[409] = p.LeaderId
+	b[410] = p.Unknown
+	b[411] = p.Difficulty
+	b[412] = p.Battle
+	b[413] = p.Event
+	b[414] = p.SectionId
+	b[415] = p.Challenge
+	binary.LittleEndian.PutUint32(b[416:], p.RandomSeed)
+	b[420] = p.Episode
+	b[421] = p.Unknown2
+	b[422] = p.SinglePlayer
+	b[423] = p.Unused
+	return 424
+}
+
+// Unmarshal populates the GameJoinPacket from b.
+func (p *GameJoinPacket) Unmarshal(b []byte) error {
+	if len(b) < 424 {
+		return &util.ShortDataError{Size: len(b), Type: "GameJoinPacket"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *GameJoinPacket) unmarshalFrom(b []byte) {
+	p.Header.unmarshalFrom(b[0:])
+	for i := range p.Variations {
+		p.Variations[i] = binary.LittleEndian.Uint32(b[8+4*i:])
+	}
+	for i := range p.Players {
+		p.Players[i].unmarshalFrom(b[136+68*i:])
+	}
+	p.ClientId = b[408]
+	p.LeaderId = b[409]
+	p.Unknown = b[410]
+	p.Difficulty = b[411]
+	p.Battle = b[412]
+	p.Event = b[413]
+	p.SectionId = b[414]
+	p.Challenge = b[415]
+	p.RandomSeed = binary.LittleEndian.Uint32(b[416:])
+	p.Episode = b[420]
+	p.Unknown2 = b[421]
+	p.SinglePlayer = b[422]
+	p.Unused = b[423]
+}
+
+// BinarySize returns the number of bytes in the serialized RareEnemyListPacket.
+func (p *RareEnemyListPacket) BinarySize() int {
+	return 40
+}
+
+// MarshalTo serializes the RareEnemyListPacket into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *RareEnemyListPacket) MarshalTo(b []byte) int {
+	p.Header.MarshalTo(b[0:])
+	for i := range p.Enemies {
+		binary.LittleEndian.PutUint16(b[8+2*i:], p.Enemies[i])
+	}
+	return 40
+}
+
+// Unmarshal populates the RareEnemyListPacket from b.
+func (p *RareEnemyListPacket) Unmarshal(b []byte) error {
+	if len(b) < 40 {
+		return &util.ShortDataError{Size: len(b), Type: "RareEnemyListPacket"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *RareEnemyListPacket) unmarshalFrom(b []byte) {
+	p.Header.unmarshalFrom(b[0:])
+	for i := range p.Enemies {
+		p.Enemies[i] = binary.LittleEndian.Uint16(b[8+2*i:])
+	}
+}
+
+// BinarySize returns the number of bytes in the serialized GameListPacket.
+func (p *GameListPacket) BinarySize() int {
+	return 8 + 44*len(p.Games)
+}
+
+// MarshalTo serializes the GameListPacket into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *GameListPacket) MarshalTo(b []byte) int {
+	p.Header.MarshalTo(b[0:])
+	for i := range p.Games {
+		p.Games[i].MarshalTo(b[8+44*i:])
+	}
+	return p.BinarySize()
+}
+
+// Unmarshal populates the GameListPacket from b, with Games taking up any bytes
+// after the fixed size fields.
+func (p *GameListPacket) Unmarshal(b []byte) error {
+	if len(b) < 8 {
+		return &util.ShortDataError{Size: len(b), Type: "GameListPacket"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *GameListPacket) unmarshalFrom(b []byte) {
+	p.Header.unmarshalFrom(b[0:])
+	n := (len(b) - 8) / 44
+	if cap(p.Games) < n {
+		p.Games = make([]GameListEntry, n)
+	} else {
+		p.Games = p.Games[:n]
+	}
+	for i := range p.Games {
+		p.Games[i].unmarshalFrom(b[8+44*i:])
+	}
+}
+
+// BinarySize returns the number of bytes in the serialized GameListEntry.
+func (p *GameListEntry) BinarySize() int {
+	return 44
+}
+
+// MarshalTo serializes the GameListEntry into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *GameListEntry) MarshalTo(b []byte) int {
+	binary.LittleEndian.PutUint32(b[0:], p.MenuId)
+	binary.LittleEndian.PutUint32(b[4:], p.GameId)
+	b[8] = p.Difficulty
+	b[9] = p.Players
+	copy(b[10:42], p.Name[:])
+	b[42] = p.Episode
+	b[43] = p.Flags
+	return 44
+}
+
+// Unmarshal populates the GameListEntry from b.
+func (p *GameListEntry) Unmarshal(b []byte) error {
+	if len(b) < 44 {
+		return &util.ShortDataError{Size: len(b), Type: "GameListEntry"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *GameListEntry) unmarshalFrom(b []byte) {
+	p.MenuId = binary.LittleEndian.Uint32(b[0:])
+	p.GameId = binary.LittleEndian.Uint32(b[4:])
+	p.Difficulty = b[8]
+	p.Players = b[9]
+	copy(p.Name[:], b[10:42])
+	p.Episode = b[42]
+	p.Flags = b[43]
+}
+
+// BinarySize returns the number of bytes in the serialized GameSelectionPacket.
+func (p *GameSelectionPacket) BinarySize() int {
+	return 16 + len(p.Password)
+}
+
+// MarshalTo serializes the GameSelectionPacket into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *GameSelectionPacket) MarshalTo(b []byte) int {
+	p.Header.MarshalTo(b[0:])
+	binary.LittleEndian.PutUint16(b[8:], p.Unknown)
+	binary.LittleEndian.PutUint16(b[10:], p.MenuId)
+	binary.LittleEndian.PutUint32(b[12:], p.ItemId)
+	copy(b[16:], p.Password)
+	return p.BinarySize()
+}
+
+// Unmarshal populates the GameSelectionPacket from b, with Password taking up any bytes
+// after the fixed size fields.
+func (p *GameSelectionPacket) Unmarshal(b []byte) error {
+	if len(b) < 16 {
+		return &util.ShortDataError{Size: len(b), Type: "GameSelectionPacket"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *GameSelectionPacket) unmarshalFrom(b []byte) {
+	p.Header.unmarshalFrom(b[0:])
+	p.Unknown = binary.LittleEndian.Uint16(b[8:])
+	p.MenuId = binary.LittleEndian.Uint16(b[10:])
+	p.ItemId = binary.LittleEndian.Uint32(b[12:])
+	p.Password = append(p.Password[:0], b[16:]...)
+}
+
+// BinarySize returns the number of bytes in the serialized SubcommandHeader.
+func (p *SubcommandHeader) BinarySize() int {
+	return 10
+}
+
+// MarshalTo serializes the SubcommandHeader into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *SubcommandHeader) MarshalTo(b []byte) int {
+	p.Header.MarshalTo(b[0:])
+	b[8] = p.Type
+	b[9] = p.Size
+	return 10
+}
+
+// Unmarshal populates the SubcommandHeader from b.
+func (p *SubcommandHeader) Unmarshal(b []byte) error {
+	if len(b) < 10 {
+		return &util.ShortDataError{Size: len(b), Type: "SubcommandHeader"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *SubcommandHeader) unmarshalFrom(b []byte) {
+	p.Header.unmarshalFrom(b[0:])
+	p.Type = b[8]
+	p.Size = b[9]
+}
+
+// BinarySize returns the number of bytes in the serialized EnemyHitPacket.
+func (p *EnemyHitPacket) BinarySize() int {
+	return 20
+}
+
+// MarshalTo serializes the EnemyHitPacket into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *EnemyHitPacket) MarshalTo(b []byte) int {
+	p.Header.MarshalTo(b[0:])
+	b[8] = p.Subcommand
+	b[9] = p.Size
+	binary.LittleEndian.PutUint16(b[10:], p.EnemyId)
+	binary.LittleEndian.PutUint16(b[12:], p.EnemyIndex)
+	binary.LittleEndian.PutUint16(b[14:], p.HP)
+	binary.LittleEndian.PutUint32(b[16:], p.Flags)
+	return 20
+}
+
+// Unmarshal populates the EnemyHitPacket from b.
+func (p *EnemyHitPacket) Unmarshal(b []byte) error {
+	if len(b) < 20 {
+		return &util.ShortDataError{Size: len(b), Type: "EnemyHitPacket"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *EnemyHitPacket) unmarshalFrom(b []byte) {
+	p.Header.unmarshalFrom(b[0:])
+	p.Subcommand = b[8]
+	p.Size = b[9]
+	p.EnemyId = binary.LittleEndian.Uint16(b[10:])
+	p.EnemyIndex = binary.LittleEndian.Uint16(b[12:])
+	p.HP = binary.LittleEndian.Uint16(b[14:])
+	p.Flags = binary.LittleEndian.Uint32(b[16:])
+}
+
+// BinarySize returns the number of bytes in the serialized EnemyKilledPacket.
+func (p *EnemyKilledPacket) BinarySize() int {
+	return 16
+}
+
+// MarshalTo serializes the EnemyKilledPacket into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *EnemyKilledPacket) MarshalTo(b []byte) int {
+	p.Header.MarshalTo(b[0:])
+	b[8] = p.Subcommand
+	b[9] = p.Size
+	binary.LittleEndian.PutUint16(b[10:], p.EnemyId)
+	binary.LittleEndian.PutUint16(b[12:], p.Flags)
+	binary.LittleEndian.PutUint16(b[14:], p.Unknown)
+	return 16
+}
+
+// Unmarshal populates the EnemyKilledPacket from b.
+func (p *EnemyKilledPacket) Unmarshal(b []byte) error {
+	if len(b) < 16 {
+		return &util.ShortDataError{Size: len(b), Type: "EnemyKilledPacket"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *EnemyKilledPacket) unmarshalFrom(b []byte) {
+	p.Header.unmarshalFrom(b[0:])
+	p.Subcommand = b[8]
+	p.Size = b[9]
+	p.EnemyId = binary.LittleEndian.Uint16(b[10:])
+	p.Flags = binary.LittleEndian.Uint16(b[12:])
+	p.Unknown = binary.LittleEndian.Uint16(b[14:])
+}
+
+// BinarySize returns the number of bytes in the serialized EnemyExpRequestPacket.
+func (p *EnemyExpRequestPacket) BinarySize() int {
+	return 20
+}
+
+// MarshalTo serializes the EnemyExpRequestPacket into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *EnemyExpRequestPacket) MarshalTo(b []byte) int {
+	p.Header.MarshalTo(b[0:])
+	b[8] = p.Subcommand
+	b[9] = p.Size
+	binary.LittleEndian.PutUint16(b[10:], p.EnemyId)
+	binary.LittleEndian.PutUint16(b[12:], p.EnemyIndex)
+	binary.LittleEndian.PutUint16(b[14:], p.ClientId)
+	b[16] = p.LastHitter
+	copy(b[17:20], p.Unused[:])
+	return 20
+}
+
+// Unmarshal populates the EnemyExpRequestPacket from b.
+func (p *EnemyExpRequestPacket) Unmarshal(b []byte) error {
+	if len(b) < 20 {
+		return &util.ShortDataError{Size: len(b), Type: "EnemyExpRequestPacket"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *EnemyExpRequestPacket) unmarshalFrom(b []byte) {
+	p.Header.unmarshalFrom(b[0:])
+	p.Subcommand = b[8]
+	p.Size = b[9]
+	p.EnemyId = binary.LittleEndian.Uint16(b[10:])
+	p.EnemyIndex = binary.LittleEndian.Uint16(b[12:])
+	p.ClientId = binary.LittleEndian.Uint16(b[14:])
+	p.LastHitter = b[16]
+	copy(p.Unused[:], b[17:20])
+}
+
+// BinarySize returns the number of bytes in the serialized GiveExperiencePacket.
+func (p *GiveExperiencePacket) BinarySize() int {
+	return 16
+}
+
+// MarshalTo serializes the GiveExperiencePacket into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *GiveExperiencePacket) MarshalTo(b []byte) int {
+	p.Header.MarshalTo(b[0:])
+	b[8] = p.Subcommand
+	b[9] = p.Size
+	binary.LittleEndian.PutUint16(b[10:], p.ClientId)
+	binary.LittleEndian.PutUint32(b[12:], p.Amount)
+	return 16
+}
+
+// Unmarshal populates the GiveExperiencePacket from b.
+func (p *GiveExperiencePacket) Unmarshal(b []byte) error {
+	if len(b) < 16 {
+		return &util.ShortDataError{Size: len(b), Type: "GiveExperiencePacket"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *GiveExperiencePacket) unmarshalFrom(b []byte) {
+	p.Header.unmarshalFrom(b[0:])
+	p.Subcommand = b[8]
+	p.Size = b[9]
+	p.ClientId = binary.LittleEndian.Uint16(b[10:])
+	p.Amount = binary.LittleEndian.Uint32(b[12:])
+}
+
+// BinarySize returns the number of bytes in the serialized LevelUpPacket.
+func (p *LevelUpPacket) BinarySize() int {
+	return 28
+}
+
+// MarshalTo serializes the LevelUpPacket into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *LevelUpPacket) MarshalTo(b []byte) int {
+	p.Header.MarshalTo(b[0:])
+	b[8] = p.Subcommand
+	b[9] = p.Size
+	binary.LittleEndian.PutUint16(b[10:], p.ClientId)
+	binary.LittleEndian.PutUint16(b[12:], p.ATP)
+	binary.LittleEndian.PutUint16(b[14:], p.MST)
+	binary.LittleEndian.PutUint16(b[16:], p.EVP)
+	binary.LittleEndian.PutUint16(b[18:], p.HP)
+	binary.LittleEndian.PutUint16(b[20:], p.DFP)
+	binary.LittleEndian.PutUint16(b[22:], p.ATA)
+	binary.LittleEndian.PutUint32(b[24:], p.Level)
+	return 28
+}
+
+// Unmarshal populates the LevelUpPacket from b.
+func (p *LevelUpPacket) Unmarshal(b []byte) error {
+	if len(b) < 28 {
+		return &util.ShortDataError{Size: len(b), Type: "LevelUpPacket"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *LevelUpPacket) unmarshalFrom(b []byte) {
+	p.Header.unmarshalFrom(b[0:])
+	p.Subcommand = b[8]
+	p.Size = b[9]
+	p.ClientId = binary.LittleEndian.Uint16(b[10:])
+	p.ATP = binary.LittleEndian.Uint16(b[12:])
+	p.MST = binary.LittleEndian.Uint16(b[14:])
+	p.EVP = binary.LittleEndian.Uint16(b[16:])
+	p.HP = binary.LittleEndian.Uint16(b[18:])
+	p.DFP = binary.LittleEndian.Uint16(b[20:])
+	p.ATA = binary.LittleEndian.Uint16(b[22:])
+	p.Level = binary.LittleEndian.Uint32(b[24:])
+}
+
+// BinarySize returns the number of bytes in the serialized ShopRequestPacket.
+func (p *ShopRequestPacket) BinarySize() int {
+	return 16
+}
+
+// MarshalTo serializes the ShopRequestPacket into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *ShopRequestPacket) MarshalTo(b []byte) int {
+	p.Header.MarshalTo(b[0:])
+	b[8] = p.Subcommand
+	b[9] = p.Size
+	binary.LittleEndian.PutUint16(b[10:], p.ClientId)
+	binary.LittleEndian.PutUint32(b[12:], p.ShopType)
+	return 16
+}
+
+// Unmarshal populates the ShopRequestPacket from b.
+func (p *ShopRequestPacket) Unmarshal(b []byte) error {
+	if len(b) < 16 {
+		return &util.ShortDataError{Size: len(b), Type: "ShopRequestPacket"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *ShopRequestPacket) unmarshalFrom(b []byte) {
+	p.Header.unmarshalFrom(b[0:])
+	p.Subcommand = b[8]
+	p.Size = b[9]
+	p.ClientId = binary.LittleEndian.Uint16(b[10:])
+	p.ShopType = binary.LittleEndian.Uint32(b[12:])
+}
+
+// BinarySize returns the number of bytes in the serialized ShopContentsPacket.
+func (p *ShopContentsPacket) BinarySize() int {
+	return 416
+}
+
+// MarshalTo serializes the ShopContentsPacket into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *ShopContentsPacket) MarshalTo(b []byte) int {
+	p.Header.MarshalTo(b[0:])
+	b[8] = p.Subcommand
+	b[9] = p.Size
+	binary.LittleEndian.PutUint16(b[10:], p.Params)
+	b[12] = p.ShopType
+	b[13] = p.NumItems
+	binary.LittleEndian.PutUint16(b[14:], p.Unused)
+	for i := range p.Items {
+		p.Items[i].MarshalTo(b[16+20*i:])
+	}
+	return 416
+}
+
+// Unmarshal populates the ShopContentsPacket from b.
+func (p *ShopContentsPacket) Unmarshal(b []byte) error {
+	if len(b) < 416 {
+		return &util.ShortDataError{Size: len(b), Type: "ShopContentsPacket"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *ShopContentsPacket) unmarshalFrom(b []byte) {
+	p.Header.unmarshalFrom(b[0:])
+	p.Subcommand = b[8]
+	p.Size = b[9]
+	p.Params = binary.LittleEndian.Uint16(b[10:])
+	p.ShopType = b[12]
+	p.NumItems = b[13]
+	p.Unused = binary.LittleEndian.Uint16(b[14:])
+	for i := range p.Items {
+		p.Items[i].unmarshalFrom(b[16+20*i:])
+	}
+}
+
+// BinarySize returns the number of bytes in the serialized ShopBuyPacket.
+func (p *ShopBuyPacket) BinarySize() int {
+	return 20
+}
+
+// MarshalTo serializes the ShopBuyPacket into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *ShopBuyPacket) MarshalTo(b []byte) int {
+	p.Header.MarshalTo(b[0:])
+	b[8] = p.Subcommand
+	b[9] = p.Size
+	binary.LittleEndian.PutUint16(b[10:], p.ClientId)
+	binary.LittleEndian.PutUint32(b[12:], p.ItemId)
+	b[16] = p.ShopType
+	b[17] = p.ItemIndex
+	b[18] = p.Amount
+	b[19] = p.Unknown
+	return 20
+}
+
+// Unmarshal populates the ShopBuyPacket from b.
+func (p *ShopBuyPacket) Unmarshal(b []byte) error {
+	if len(b) < 20 {
+		return &util.ShortDataError{Size: len(b), Type: "ShopBuyPacket"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *ShopBuyPacket) unmarshalFrom(b []byte) {
+	p.Header.unmarshalFrom(b[0:])
+	p.Subcommand = b[8]
+	p.Size = b[9]
+	p.ClientId = binary.LittleEndian.Uint16(b[10:])
+	p.ItemId = binary.LittleEndian.Uint32(b[12:])
+	p.ShopType = b[16]
+	p.ItemIndex = b[17]
+	p.Amount = b[18]
+	p.Unknown = b[19]
+}
+
+// BinarySize returns the number of bytes in the serialized ShopSellPacket.
+func (p *ShopSellPacket) BinarySize() int {
+	return 20
+}
+
+// MarshalTo serializes the ShopSellPacket into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *ShopSellPacket) MarshalTo(b []byte) int {
+	p.Header.MarshalTo(b[0:])
+	b[8] = p.Subcommand
+	b[9] = p.Size
+	binary.LittleEndian.PutUint16(b[10:], p.ClientId)
+	binary.LittleEndian.PutUint32(b[12:], p.ItemId)
+	binary.LittleEndian.PutUint32(b[16:], p.Amount)
+	return 20
+}
+
+// Unmarshal populates the ShopSellPacket from b.
+func (p *ShopSellPacket) Unmarshal(b []byte) error {
+	if len(b) < 20 {
+		return &util.ShortDataError{Size: len(b), Type: "ShopSellPacket"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *ShopSellPacket) unmarshalFrom(b []byte) {
+	p.Header.unmarshalFrom(b[0:])
+	p.Subcommand = b[8]
+	p.Size = b[9]
+	p.ClientId = binary.LittleEndian.Uint16(b[10:])
+	p.ItemId = binary.LittleEndian.Uint32(b[12:])
+	p.Amount = binary.LittleEndian.Uint32(b[16:])
+}
+
+// BinarySize returns the number of bytes in the serialized IdentifyItemPacket.
+func (p *IdentifyItemPacket) BinarySize() int {
+	return 16
+}
+
+// MarshalTo serializes the IdentifyItemPacket into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *IdentifyItemPacket) MarshalTo(b []byte) int {
+	p.Header.MarshalTo(b[0:])
+	b[8] = p.Subcommand
+	b[9] = p.Size
+	binary.LittleEndian.PutUint16(b[10:], p.ClientId)
+	binary.LittleEndian.PutUint32(b[12:], p.ItemId)
+	return 16
+}
+
+// Unmarshal populates the IdentifyItemPacket from b.
+func (p *IdentifyItemPacket) Unmarshal(b []byte) error {
+	if len(b) < 16 {
+		return &util.ShortDataError{Size: len(b), Type: "IdentifyItemPacket"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *IdentifyItemPacket) unmarshalFrom(b []byte) {
+	p.Header.unmarshalFrom(b[0:])
+	p.Subcommand = b[8]
+	p.Size = b[9]
+	p.ClientId = binary.LittleEndian.Uint16(b[10:])
+	p.ItemId = binary.LittleEndian.Uint32(b[12:])
+}
+
+// BinarySize returns the number of bytes in the serialized IdentifyResultPacket.
+func (p *IdentifyResultPacket) BinarySize() int {
+	return 32
+}
+
+// MarshalTo serializes the IdentifyResultPacket into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *IdentifyResultPacket) MarshalTo(b []byte) int {
+	p.Header.MarshalTo(b[0:])
+	b[8] = p.Subcommand
+	b[9] = p.Size
+	binary.LittleEndian.PutUint16(b[10:], p.ClientId)
+	p.Item.MarshalTo(b[12:])
+	return 32
+}
+
+// Unmarshal populates the IdentifyResultPacket from b.
+func (p *IdentifyResultPacket) Unmarshal(b []byte) error {
+	if len(b) < 32 {
+		return &util.ShortDataError{Size: len(b), Type: "IdentifyResultPacket"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *IdentifyResultPacket) unmarshalFrom(b []byte) {
+	p.Header.unmarshalFrom(b[0:])
+	p.Subcommand = b[8]
+	p.Size = b[9]
+	p.ClientId = binary.LittleEndian.Uint16(b[10:])
+	p.Item.unmarshalFrom(b[12:])
+}
+
+// BinarySize returns the number of bytes in the serialized CreateItemPacket.
+func (p *CreateItemPacket) BinarySize() int {
+	return 36
+}
+
+// MarshalTo serializes the CreateItemPacket into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *CreateItemPacket) MarshalTo(b []byte) int {
+	p.Header.MarshalTo(b[0:])
+	b[8] = p.Subcommand
+	b[9] = p.Size
+	binary.LittleEndian.PutUint16(b[10:], p.ClientId)
+	p.Item.MarshalTo(b[12:])
+	binary.LittleEndian.PutUint32(b[32:], p.Unused)
+	return 36
+}
+
+// Unmarshal populates the CreateItemPacket from b.
+func (p *CreateItemPacket) Unmarshal(b []byte) error {
+	if len(b) < 36 {
+		return &util.ShortDataError{Size: len(b), Type: "CreateItemPacket"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *CreateItemPacket) unmarshalFrom(b []byte) {
+	p.Header.unmarshalFrom(b[0:])
+	p.Subcommand = b[8]
+	p.Size = b[9]
+	p.ClientId = binary.LittleEndian.Uint16(b[10:])
+	p.Item.unmarshalFrom(b[12:])
+	p.Unused = binary.LittleEndian.Uint32(b[32:])
+}
+
+// BinarySize returns the number of bytes in the serialized DropItemPacket.
+func (p *DropItemPacket) BinarySize() int {
+	return 28
+}
+
+// MarshalTo serializes the DropItemPacket into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *DropItemPacket) MarshalTo(b []byte) int {
+	p.Header.MarshalTo(b[0:])
+	b[8] = p.Subcommand
+	b[9] = p.Size
+	binary.LittleEndian.PutUint16(b[10:], p.ClientId)
+	binary.LittleEndian.PutUint16(b[12:], p.Unknown)
+	binary.LittleEndian.PutUint16(b[14:], p.Area)
+	binary.LittleEndian.PutUint32(b[16:], p.ItemId)
+	binary.LittleEndian.PutUint32(b[20:], math.Float32bits(p.X))
+	binary.LittleEndian.PutUint32(b[24:], math.Float32bits(p.Z))
+	return 28
+}
+
+// Unmarshal populates the DropItemPacket from b.
+func (p *DropItemPacket) Unmarshal(b []byte) error {
+	if len(b) < 28 {
+		return &util.ShortDataError{Size: len(b), Type: "DropItemPacket"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *DropItemPacket) unmarshalFrom(b []byte) {
+	p.Header.unmarshalFrom(b[0:])
+	p.Subcommand = b[8]
+	p.Size = b[9]
+	p.ClientId = binary.LittleEndian.Uint16(b[10:])
+	p.Unknown = binary.LittleEndian.Uint16(b[12:])
+	p.Area = binary.LittleEndian.Uint16(b[14:])
+	p.ItemId = binary.LittleEndian.Uint32(b[16:])
+	p.X = math.Float32frombits(binary.LittleEndian.Uint32(b[20:]))
+	p.Z = math.Float32frombits(binary.LittleEndian.Uint32(b[24:]))
+}
+
+// BinarySize returns the number of bytes in the serialized DestroyItemPacket.
+func (p *DestroyItemPacket) BinarySize() int {
+	return 20
+}
+
+// MarshalTo serializes the DestroyItemPacket into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *DestroyItemPacket) MarshalTo(b []byte) int {
+	p.Header.MarshalTo(b[0:])
+	b[8] = p.Subcommand
+	b[9] = p.Size
+	binary.LittleEndian.PutUint16(b[10:], p.ClientId)
+	binary.LittleEndian.PutUint32(b[12:], p.ItemId)
+	binary.LittleEndian.PutUint32(b[16:], p.Amount)
+	return 20
+}
+
+// Unmarshal populates the DestroyItemPacket from b.
+func (p *DestroyItemPacket) Unmarshal(b []byte) error {
+	if len(b) < 20 {
+		return &util.ShortDataError{Size: len(b), Type: "DestroyItemPacket"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *DestroyItemPacket) unmarshalFrom(b []byte) {
+	p.Header.unmarshalFrom(b[0:])
+	p.Subcommand = b[8]
+	p.Size = b[9]
+	p.ClientId = binary.LittleEndian.Uint16(b[10:])
+	p.ItemId = binary.LittleEndian.Uint32(b[12:])
+	p.Amount = binary.LittleEndian.Uint32(b[16:])
+}
+
+// BinarySize returns the number of bytes in the serialized ClassicWelcomePkt.
+func (p *ClassicWelcomePkt) BinarySize() int {
+	return 72
+}
+
+// MarshalTo serializes the ClassicWelcomePkt into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *ClassicWelcomePkt) MarshalTo(b []byte) int {
+	copy(b[0:64], p.Copyright[:])
+	copy(b[64:68], p.ServerVector[:])
+	copy(b[68:72], p.ClientVector[:])
+	return 72
+}
+
+// Unmarshal populates the ClassicWelcomePkt from b.
+func (p *ClassicWelcomePkt) Unmarshal(b []byte) error {
+	if len(b) < 72 {
+		return &util.ShortDataError{Size: len(b), Type: "ClassicWelcomePkt"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *ClassicWelcomePkt) unmarshalFrom(b []byte) {
+	copy(p.Copyright[:], b[0:64])
+	copy(p.ServerVector[:], b[64:68])
+	copy(p.ClientVector[:], b[68:72])
+}
+
+// BinarySize returns the number of bytes in the serialized ClassicVerifyPkt.
+func (p *ClassicVerifyPkt) BinarySize() int {
+	return 252
+}
+
+// MarshalTo serializes the ClassicVerifyPkt into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *ClassicVerifyPkt) MarshalTo(b []byte) int {
+	copy(b[0:32], p.Unused[:])
+	copy(b[32:48], p.V1SerialNumber[:])
+	copy(b[48:64], p.V1AccessKey[:])
+	copy(b[64:80], p.SerialNumber[:])
+	copy(b[80:96], p.AccessKey[:])
+	binary.LittleEndian.PutUint32(b[96:], p.PlayerTag)
+	binary.LittleEndian.PutUint32(b[100:], p.Guildcard)
+	binary.LittleEndian.PutUint32(b[104:], p.SubVersion)
+	copy(b[108:156], p.SerialNumber2[:])
+	copy(b[156:204], p.AccessKey2[:])
+	copy(b[204:252], p.Password[:])
+	return 252
+}
+
+// Unmarshal populates the ClassicVerifyPkt from b.
+func (p *ClassicVerifyPkt) Unmarshal(b []byte) error {
+	if len(b) < 252 {
+		return &util.ShortDataError{Size: len(b), Type: "ClassicVerifyPkt"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *ClassicVerifyPkt) unmarshalFrom(b []byte) {
+	copy(p.Unused[:], b[0:32])
+	copy(p.V1SerialNumber[:], b[32:48])
+	copy(p.V1AccessKey[:], b[48:64])
+	copy(p.SerialNumber[:], b[64:80])
+	copy(p.AccessKey[:], b[80:96])
+	p.PlayerTag = binary.LittleEndian.Uint32(b[96:])
+	p.Guildcard = binary.LittleEndian.Uint32(b[100:])
+	p.SubVersion = binary.LittleEndian.Uint32(b[104:])
+	copy(p.SerialNumber2[:], b[108:156])
+	copy(p.AccessKey2[:], b[156:204])
+	copy(p.Password[:], b[204:252])
+}
+
+// BinarySize returns the number of bytes in the serialized ClassicLoginPkt.
+func (p *ClassicLoginPkt) BinarySize() int {
+	return 168 + len(p.Config)
+}
+
+// MarshalTo serializes the ClassicLoginPkt into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *ClassicLoginPkt) MarshalTo(b []byte) int {
+	binary.LittleEndian.PutUint32(b[0:], p.PlayerTag)
+	binary.LittleEndian.PutUint32(b[4:], p.Guildcard)
+	for i := range p.Unused {
+		binary.LittleEndian.PutUint32(b[8+4*i:], p.Unused[i])
+	}
+	binary.LittleEndian.PutUint32(b[16:], p.SubVersion)
+	b[20] = p.IsExtended
+	b[21] = p.Language
+	copy(b[22:24], p.Unused2[:])
+	copy(b[24:40], p.V1SerialNumber[:])
+	copy(b[40:56], p.V1AccessKey[:])
+	copy(b[56:104], p.SerialNumber[:])
+	copy(b[104:152], p.AccessKey[:])
+	copy(b[152:168], p.Name[:])
+	copy(b[168:], p.Config)
+	return p.BinarySize()
+}
+
+// Unmarshal populates the ClassicLoginPkt from b, with Config taking up any bytes
+// after the fixed size fields.
+func (p *ClassicLoginPkt) Unmarshal(b []byte) error {
+	if len(b) < 168 {
+		return &util.ShortDataError{Size: len(b), Type: "ClassicLoginPkt"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *ClassicLoginPkt) unmarshalFrom(b []byte) {
+	p.PlayerTag = binary.LittleEndian.Uint32(b[0:])
+	p.Guildcard = binary.LittleEndian.Uint32(b[4:])
+	for i := range p.Unused {
+		p.Unused[i] = binary.LittleEndian.Uint32(b[8+4*i:])
+	}
+	p.SubVersion = binary.LittleEndian.Uint32(b[16:])
+	p.IsExtended = b[20]
+	p.Language = b[21]
+	copy(p.Unused2[:], b[22:24])
+	copy(p.V1SerialNumber[:], b[24:40])
+	copy(p.V1AccessKey[:], b[40:56])
+	copy(p.SerialNumber[:], b[56:104])
+	copy(p.AccessKey[:], b[104:152])
+	copy(p.Name[:], b[152:168])
+	p.Config = append(p.Config[:0], b[168:]...)
+}
+
+// BinarySize returns the number of bytes in the serialized ClassicSecurityPacket.
+func (p *ClassicSecurityPacket) BinarySize() int {
+	return 40
+}
+
+// MarshalTo serializes the ClassicSecurityPacket into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *ClassicSecurityPacket) MarshalTo(b []byte) int {
+	binary.LittleEndian.PutUint32(b[0:], p.PlayerTag)
+	binary.LittleEndian.PutUint32(b[4:], p.Guildcard)
+	copy(b[8:40], p.Config[:])
+	return 40
+}
+
+// Unmarshal populates the ClassicSecurityPacket from b.
+func (p *ClassicSecurityPacket) Unmarshal(b []byte) error {
+	if len(b) < 40 {
+		return &util.ShortDataError{Size: len(b), Type: "ClassicSecurityPacket"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *ClassicSecurityPacket) unmarshalFrom(b []byte) {
+	p.PlayerTag = binary.LittleEndian.Uint32(b[0:])
+	p.Guildcard = binary.LittleEndian.Uint32(b[4:])
+	copy(p.Config[:], b[8:40])
+}
+
+// BinarySize returns the number of bytes in the serialized ClassicMenuPacket.
+func (p *ClassicMenuPacket) BinarySize() int {
+	return 0 + 28*len(p.Entries)
+}
+
+// MarshalTo serializes the ClassicMenuPacket into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *ClassicMenuPacket) MarshalTo(b []byte) int {
+	for i := range p.Entries {
+		p.Entries[i].MarshalTo(b[28*i:])
+	}
+	return p.BinarySize()
+}
+
+// Unmarshal populates the ClassicMenuPacket from b, with Entries taking up any bytes
+// after the fixed size fields.
+func (p *ClassicMenuPacket) Unmarshal(b []byte) error {
+	if len(b) < 0 {
+		return &util.ShortDataError{Size: len(b), Type: "ClassicMenuPacket"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *ClassicMenuPacket) unmarshalFrom(b []byte) {
+	n := (len(b) - 0) / 28
+	if cap(p.Entries) < n {
+		p.Entries = make([]ClassicMenuEntry, n)
+	} else {
+		p.Entries = p.Entries[:n]
+	}
+	for i := range p.Entries {
+		p.Entries[i].unmarshalFrom(b[28*i:])
+	}
+}
+
+// BinarySize returns the number of bytes in the serialized ClassicMenuEntry.
+func (p *ClassicMenuEntry) BinarySize() int {
+	return 28
+}
+
+// MarshalTo serializes the ClassicMenuEntry into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *ClassicMenuEntry) MarshalTo(b []byte) int {
+	binary.LittleEndian.PutUint32(b[0:], p.MenuId)
+	binary.LittleEndian.PutUint32(b[4:], p.ItemId)
+	binary.LittleEndian.PutUint16(b[8:], p.Flags)
+	copy(b[10:28], p.Text[:])
+	return 28
+}
+
+// Unmarshal populates the ClassicMenuEntry from b.
+func (p *ClassicMenuEntry) Unmarshal(b []byte) error {
+	if len(b) < 28 {
+		return &util.ShortDataError{Size: len(b), Type: "ClassicMenuEntry"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *ClassicMenuEntry) unmarshalFrom(b []byte) {
+	p.MenuId = binary.LittleEndian.Uint32(b[0:])
+	p.ItemId = binary.LittleEndian.Uint32(b[4:])
+	p.Flags = binary.LittleEndian.Uint16(b[8:])
+	copy(p.Text[:], b[10:28])
+}
+
+// BinarySize returns the number of bytes in the serialized PCMenuPacket.
+func (p *PCMenuPacket) BinarySize() int {
+	return 0 + 44*len(p.Entries)
+}
+
+// MarshalTo serializes the PCMenuPacket into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *PCMenuPacket) MarshalTo(b []byte) int {
+	for i := range p.Entries {
+		p.Entries[i].MarshalTo(b[44*i:])
+	}
+	return p.BinarySize()
+}
+
+// Unmarshal populates the PCMenuPacket from b, with Entries taking up any bytes
+// after the fixed size fields.
+func (p *PCMenuPacket) Unmarshal(b []byte) error {
+	if len(b) < 0 {
+		return &util.ShortDataError{Size: len(b), Type: "PCMenuPacket"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *PCMenuPacket) unmarshalFrom(b []byte) {
+	n := (len(b) - 0) / 44
+	if cap(p.Entries) < n {
+		p.Entries = make([]PCMenuEntry, n)
+	} else {
+		p.Entries = p.Entries[:n]
+	}
+	for i := range p.Entries {
+		p.Entries[i].unmarshalFrom(b[44*i:])
+	}
+}
+
+// BinarySize returns the number of bytes in the serialized PCMenuEntry.
+func (p *PCMenuEntry) BinarySize() int {
+	return 44
+}
+
+// MarshalTo serializes the PCMenuEntry into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *PCMenuEntry) MarshalTo(b []byte) int {
+	binary.LittleEndian.PutUint32(b[0:], p.MenuId)
+	binary.LittleEndian.PutUint32(b[4:], p.ItemId)
+	binary.LittleEndian.PutUint16(b[8:], p.Flags)
+	copy(b[10:44], p.Text[:])
+	return 44
+}
+
+// Unmarshal populates the PCMenuEntry from b.
+func (p *PCMenuEntry) Unmarshal(b []byte) error {
+	if len(b) < 44 {
+		return &util.ShortDataError{Size: len(b), Type: "PCMenuEntry"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *PCMenuEntry) unmarshalFrom(b []byte) {
+	p.MenuId = binary.LittleEndian.Uint32(b[0:])
+	p.ItemId = binary.LittleEndian.Uint32(b[4:])
+	p.Flags = binary.LittleEndian.Uint16(b[8:])
+	copy(p.Text[:], b[10:44])
+}
+
+// BinarySize returns the number of bytes in the serialized ClassicMenuSelectionPacket.
+func (p *ClassicMenuSelectionPacket) BinarySize() int {
+	return 8
+}
+
+// MarshalTo serializes the ClassicMenuSelectionPacket into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *ClassicMenuSelectionPacket) MarshalTo(b []byte) int {
+	binary.LittleEndian.PutUint32(b[0:], p.MenuId)
+	binary.LittleEndian.PutUint32(b[4:], p.ItemId)
+	return 8
+}
+
+// Unmarshal populates the ClassicMenuSelectionPacket from b.
+func (p *ClassicMenuSelectionPacket) Unmarshal(b []byte) error {
+	if len(b) < 8 {
+		return &util.ShortDataError{Size: len(b), Type: "ClassicMenuSelectionPacket"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *ClassicMenuSelectionPacket) unmarshalFrom(b []byte) {
+	p.MenuId = binary.LittleEndian.Uint32(b[0:])
+	p.ItemId = binary.LittleEndian.Uint32(b[4:])
+}
+
+// BinarySize returns the number of bytes in the serialized ClassicRedirectPacket.
+func (p *ClassicRedirectPacket) BinarySize() int {
+	return 8
+}
+
+// MarshalTo serializes the ClassicRedirectPacket into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *ClassicRedirectPacket) MarshalTo(b []byte) int {
+	copy(b[0:4], p.IPAddr[:])
+	binary.LittleEndian.PutUint16(b[4:], p.Port)
+	binary.LittleEndian.PutUint16(b[6:], p.Padding)
+	return 8
+}
+
+// Unmarshal populates the ClassicRedirectPacket from b.
+func (p *ClassicRedirectPacket) Unmarshal(b []byte) error {
+	if len(b) < 8 {
+		return &util.ShortDataError{Size: len(b), Type: "ClassicRedirectPacket"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *ClassicRedirectPacket) unmarshalFrom(b []byte) {
+	copy(p.IPAddr[:], b[0:4])
+	p.Port = binary.LittleEndian.Uint16(b[4:])
+	p.Padding = binary.LittleEndian.Uint16(b[6:])
+}
+
+// BinarySize returns the number of bytes in the serialized ClassicMessagePacket.
+func (p *ClassicMessagePacket) BinarySize() int {
+	return 0 + len(p.Message)
+}
+
+// MarshalTo serializes the ClassicMessagePacket into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *ClassicMessagePacket) MarshalTo(b []byte) int {
+	copy(b[0:], p.Message)
+	return p.BinarySize()
+}
+
+// Unmarshal populates the ClassicMessagePacket from b, with Message taking up any bytes
+// after the fixed size fields.
+func (p *ClassicMessagePacket) Unmarshal(b []byte) error {
+	if len(b) < 0 {
+		return &util.ShortDataError{Size: len(b), Type: "ClassicMessagePacket"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *ClassicMessagePacket) unmarshalFrom(b []byte) {
+	p.Message = append(p.Message[:0], b[0:]...)
+}
+
+// BinarySize returns the number of bytes in the serialized ClassicLobbyListPacket.
+func (p *ClassicLobbyListPacket) BinarySize() int {
+	return 0 + 12*len(p.Lobbies)
+}
+
+// MarshalTo serializes the ClassicLobbyListPacket into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *ClassicLobbyListPacket) MarshalTo(b []byte) int {
+	for i := range p.Lobbies {
+		p.Lobbies[i].MarshalTo(b[12*i:])
+	}
+	return p.BinarySize()
+}
+
+// Unmarshal populates the ClassicLobbyListPacket from b, with Lobbies taking up any bytes
+// after the fixed size fields.
+func (p *ClassicLobbyListPacket) Unmarshal(b []byte) error {
+	if len(b) < 0 {
+		return &util.ShortDataError{Size: len(b), Type: "ClassicLobbyListPacket"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *ClassicLobbyListPacket) unmarshalFrom(b []byte) {
+	n := (len(b) - 0) / 12
+	if cap(p.Lobbies) < n {
+		p.Lobbies = make([]LobbyListEntry, n)
+	} else {
+		p.Lobbies = p.Lobbies[:n]
+	}
+	for i := range p.Lobbies {
+		p.Lobbies[i].unmarshalFrom(b[12*i:])
+	}
+}
+
+// BinarySize returns the number of bytes in the serialized ClassicDispData.
+func (p *ClassicDispData) BinarySize() int {
+	return 208
+}
+
+// MarshalTo serializes the ClassicDispData into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *ClassicDispData) MarshalTo(b []byte) int {
+	copy(b[0:24], p.Stats[:])
+	binary.LittleEndian.PutUint32(b[24:], p.Level)
+	binary.LittleEndian.PutUint32(b[28:], p.Experience)
+	binary.LittleEndian.PutUint32(b[32:], p.Meseta)
+	copy(b[36:52], p.Name[:])
+	copy(b[52:60], p.Unknown[:])
+	binary.LittleEndian.PutUint32(b[60:], p.NameColor)
+	b[64] = p.Model
+	copy(b[65:80], p.Padding[:])
+	binary.LittleEndian.PutUint32(b[80:], p.Checksum)
+	b[84] = p.SectionId
+	b[85] = p.Class
+	copy(b[86:208], p.Appearance[:])
+	return 208
+}
+
+// Unmarshal populates the ClassicDispData from b.
+func (p *ClassicDispData) Unmarshal(b []byte) error {
+	if len(b) < 208 {
+		return &util.ShortDataError{Size: len(b), Type: "ClassicDispData"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *ClassicDispData) unmarshalFrom(b []byte) {
+	copy(p.Stats[:], b[0:24])
+	p.Level = binary.LittleEndian.Uint32(b[24:])
+	p.Experience = binary.LittleEndian.Uint32(b[28:])
+	p.Meseta = binary.LittleEndian.Uint32(b[32:])
+	copy(p.Name[:], b[36:52])
+	copy(p.Unknown[:], b[52:60])
+	p.NameColor = binary.LittleEndian.Uint32(b[60:])
+	p.Model = b[64]
+	copy(p.Padding[:], b[65:80])
+	p.Checksum = binary.LittleEndian.Uint32(b[80:])
+	p.SectionId = b[84]
+	p.Class = b[85]
+	copy(p.Appearance[:], b[86:208])
+}
+
+// BinarySize returns the number of bytes in the serialized ClassicCharDataPacket.
+func (p *ClassicCharDataPacket) BinarySize() int {
+	return 1052
+}
+
+// MarshalTo serializes the ClassicCharDataPacket into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *ClassicCharDataPacket) MarshalTo(b []byte) int {
+	copy(b[0:844], p.Inventory[:])
+	p.Disp.MarshalTo(b[844:])
+	return 1052
+}
+
+// Unmarshal populates the ClassicCharDataPacket from b.
+func (p *ClassicCharDataPacket) Unmarshal(b []byte) error {
+	if len(b) < 1052 {
+		return &util.ShortDataError{Size: len(b), Type: "ClassicCharDataPacket"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *ClassicCharDataPacket) unmarshalFrom(b []byte) {
+	copy(p.Inventory[:], b[0:844])
+	p.Disp.unmarshalFrom(b[844:])
+}
+
+// BinarySize returns the number of bytes in the serialized ClassicLobbyJoinPacket.
+func (p *ClassicLobbyJoinPacket) BinarySize() int {
+	return 12 + len(p.Players)
+}
+
+// MarshalTo serializes the ClassicLobbyJoinPacket into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *ClassicLobbyJoinPacket) MarshalTo(b []byte) int {
+	b[0] = p.ClientId
+	b[1] = p.LeaderId
+	b[2] = p.DisableUdp
+	b[3] = p.LobbyNum
+	binary.LittleEndian.PutUint16(b[4:], p.BlockNum)
+	binary.LittleEndian.PutUint16(b[6:], p.Event)
+	binary.LittleEndian.PutUint32(b[8:], p.Unused)
+	copy(b[12:], p.Players)
+	return p.BinarySize()
+}
+
+// Unmarshal populates the ClassicLobbyJoinPacket from b, with Players taking up any bytes
+// after the fixed size fields.
+func (p *ClassicLobbyJoinPacket) Unmarshal(b []byte) error {
+	if len(b) < 12 {
+		return &util.ShortDataError{Size: len(b), Type: "ClassicLobbyJoinPacket"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *ClassicLobbyJoinPacket) unmarshalFrom(b []byte) {
+	p.ClientId = b[0]
+	p.LeaderId = b[1]
+	p.DisableUdp = b[2]
+	p.LobbyNum = b[3]
+	p.BlockNum = binary.LittleEndian.Uint16(b[4:])
+	p.Event = binary.LittleEndian.Uint16(b[6:])
+	p.Unused = binary.LittleEndian.Uint32(b[8:])
+	p.Players = append(p.Players[:0], b[12:]...)
+}
+
+// BinarySize returns the number of bytes in the serialized ClassicPlayerHeader.
+func (p *ClassicPlayerHeader) BinarySize() int {
+	return 32
+}
+
+// MarshalTo serializes the ClassicPlayerHeader into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *ClassicPlayerHeader) MarshalTo(b []byte) int {
+	binary.LittleEndian.PutUint32(b[0:], p.Tag)
+	binary.LittleEndian.PutUint32(b[4:], p.Guildcard)
+	binary.LittleEndian.PutUint32(b[8:], p.IPAddr)
+	binary.LittleEndian.PutUint32(b[12:], p.ClientId)
+	copy(b[16:32], p.Name[:])
+	return 32
+}
+
+// Unmarshal populates the ClassicPlayerHeader from b.
+func (p *ClassicPlayerHeader) Unmarshal(b []byte) error {
+	if len(b) < 32 {
+		return &util.ShortDataError{Size: len(b), Type: "ClassicPlayerHeader"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *ClassicPlayerHeader) unmarshalFrom(b []byte) {
+	p.Tag = binary.LittleEndian.Uint32(b[0:])
+	p.Guildcard = binary.LittleEndian.Uint32(b[4:])
+	p.IPAddr = binary.LittleEndian.Uint32(b[8:])
+	p.ClientId = binary.LittleEndian.Uint32(b[12:])
+	copy(p.Name[:], b[16:32])
+}
+
+// BinarySize returns the number of bytes in the serialized PCPlayerHeader.
+func (p *PCPlayerHeader) BinarySize() int {
+	return 48
+}
+
+// MarshalTo serializes the PCPlayerHeader into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *PCPlayerHeader) MarshalTo(b []byte) int {
+	binary.LittleEndian.PutUint32(b[0:], p.Tag)
+	binary.LittleEndian.PutUint32(b[4:], p.Guildcard)
+	binary.LittleEndian.PutUint32(b[8:], p.IPAddr)
+	binary.LittleEndian.PutUint32(b[12:], p.ClientId)
+	copy(b[16:48], p.Name[:])
+	return 48
+}
+
+// Unmarshal populates the PCPlayerHeader from b.
+func (p *PCPlayerHeader) Unmarshal(b []byte) error {
+	if len(b) < 48 {
+		return &util.ShortDataError{Size: len(b), Type: "PCPlayerHeader"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *PCPlayerHeader) unmarshalFrom(b []byte) {
+	p.Tag = binary.LittleEndian.Uint32(b[0:])
+	p.Guildcard = binary.LittleEndian.Uint32(b[4:])
+	p.IPAddr = binary.LittleEndian.Uint32(b[8:])
+	p.ClientId = binary.LittleEndian.Uint32(b[12:])
+	copy(p.Name[:], b[16:48])
+}
+
+// BinarySize returns the number of bytes in the serialized ClassicLeaveNoticePacket.
+func (p *ClassicLeaveNoticePacket) BinarySize() int {
+	return 4
+}
+
+// MarshalTo serializes the ClassicLeaveNoticePacket into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *ClassicLeaveNoticePacket) MarshalTo(b []byte) int {
+	b[0] = p.ClientId
+	b[1] = p.LeaderId
+	b[2] = p.DisableUdp
+	b[3] = p.Unused
+	return 4
+}
+
+// Unmarshal populates the ClassicLeaveNoticePacket from b.
+func (p *ClassicLeaveNoticePacket) Unmarshal(b []byte) error {
+	if len(b) < 4 {
+		return &util.ShortDataError{Size: len(b), Type: "ClassicLeaveNoticePacket"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *ClassicLeaveNoticePacket) unmarshalFrom(b []byte) {
+	p.ClientId = b[0]
+	p.LeaderId = b[1]
+	p.DisableUdp = b[2]
+	p.Unused = b[3]
+}
+
+// BinarySize returns the number of bytes in the serialized ShipgateAuthPacket.
+func (p *ShipgateAuthPacket) BinarySize() int {
+	return 40
+}
+
+// MarshalTo serializes the ShipgateAuthPacket into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *ShipgateAuthPacket) MarshalTo(b []byte) int {
+	p.Header.MarshalTo(b[0:])
+	copy(b[8:40], p.Signature[:])
+	return 40
+}
+
+// Unmarshal populates the ShipgateAuthPacket from b.
+func (p *ShipgateAuthPacket) Unmarshal(b []byte) error {
+	if len(b) < 40 {
+		return &util.ShortDataError{Size: len(b), Type: "ShipgateAuthPacket"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *ShipgateAuthPacket) unmarshalFrom(b []byte) {
+	p.Header.unmarshalFrom(b[0:])
+	copy(p.Signature[:], b[8:40])
+}
+
+// BinarySize returns the number of bytes in the serialized ShipgateEventPacket.
+func (p *ShipgateEventPacket) BinarySize() int {
+	return 96
+}
+
+// MarshalTo serializes the ShipgateEventPacket into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *ShipgateEventPacket) MarshalTo(b []byte) int {
+	p.Header.MarshalTo(b[0:])
+	binary.LittleEndian.PutUint32(b[8:], p.EventType)
+	binary.LittleEndian.PutUint32(b[12:], p.Ship)
+	binary.LittleEndian.PutUint32(b[16:], p.Guildcard)
+	binary.LittleEndian.PutUint32(b[20:], p.Id)
+	binary.LittleEndian.PutUint64(b[24:], uint64(p.Timestamp))
+	copy(b[32:96], p.Text[:])
+	return 96
+}
+
+// Unmarshal populates the ShipgateEventPacket from b.
+func (p *ShipgateEventPacket) Unmarshal(b []byte) error {
+	if len(b) < 96 {
+		return &util.ShortDataError{Size: len(b), Type: "ShipgateEventPacket"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *ShipgateEventPacket) unmarshalFrom(b []byte) {
+	p.Header.unmarshalFrom(b[0:])
+	p.EventType = binary.LittleEndian.Uint32(b[8:])
+	p.Ship = binary.LittleEndian.Uint32(b[12:])
+	p.Guildcard = binary.LittleEndian.Uint32(b[16:])
+	p.Id = binary.LittleEndian.Uint32(b[20:])
+	p.Timestamp = int64(binary.LittleEndian.Uint64(b[24:]))
+	copy(p.Text[:], b[32:96])
+}
+
+// BinarySize returns the number of bytes in the serialized GuildcardEntry.
+func (p *GuildcardEntry) BinarySize() int {
+	return 444
+}
+
+// MarshalTo serializes the GuildcardEntry into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *GuildcardEntry) MarshalTo(b []byte) int {
+	binary.LittleEndian.PutUint32(b[0:], p.Guildcard)
+	for i := range p.Name {
+		binary.LittleEndian.PutUint16(b[4+2*i:], p.Name[i])
+	}
+	for i := range p.TeamName {
+		binary.LittleEndian.PutUint16(b[52+2*i:], p.TeamName[i])
+	}
+	for i := range p.Description {
+		binary.LittleEndian.PutUint16(b[84+2*i:], p.Description[i])
+	}
+	b[260] = p.Reserved
+	b[261] = p.Language
+	b[262] = p.SectionID
+	b[263] = p.CharClass
+	binary.LittleEndian.PutUint32(b[264:], p.Padding)
+	for i := range p.Comment {
+		binary.LittleEndian.PutUint16(b[268+2*i:], p.Comment[i])
+	}
+	return 444
+}
+
+// Unmarshal populates the GuildcardEntry from b.
+func (p *GuildcardEntry) Unmarshal(b []byte) error {
+	if len(b) < 444 {
+		return &util.ShortDataError{Size: len(b), Type: "GuildcardEntry"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *GuildcardEntry) unmarshalFrom(b []byte) {
+	p.Guildcard = binary.LittleEndian.Uint32(b[0:])
+	for i := range p.Name {
+		p.Name[i] = binary.LittleEndian.Uint16(b[4+2*i:])
+	}
+	for i := range p.TeamName {
+		p.TeamName[i] = binary.LittleEndian.Uint16(b[52+2*i:])
+	}
+	for i := range p.Description {
+		p.Description[i] = binary.LittleEndian.Uint16(b[84+2*i:])
+	}
+	p.Reserved = b[260]
+	p.Language = b[261]
+	p.SectionID = b[262]
+	p.CharClass = b[263]
+	p.Padding = binary.LittleEndian.Uint32(b[264:])
+	for i := range p.Comment {
+		p.Comment[i] = binary.LittleEndian.Uint16(b[268+2*i:])
+	}
+}
+
+// BinarySize returns the number of bytes in the serialized GuildcardData.
+func (p *GuildcardData) BinarySize() int {
+	return 54672
+}
+
+// MarshalTo serializes the GuildcardData into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *GuildcardData) MarshalTo(b []byte) int {
+	copy(b[0:276], p.Unknown[:])
+	copy(b[276:7932], p.Blocked[:])
+	copy(b[7932:8052], p.Unknown2[:])
+	for i := range p.Entries {
+		p.Entries[i].MarshalTo(b[8052+444*i:])
+	}
+	copy(b[54228:54672], p.Unknown3[:])
+	return 54672
+}
+
+// Unmarshal populates the GuildcardData from b.
+func (p *GuildcardData) Unmarshal(b []byte) error {
+	if len(b) < 54672 {
+		return &util.ShortDataError{Size: len(b), Type: "GuildcardData"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *GuildcardData) unmarshalFrom(b []byte) {
+	copy(p.Unknown[:], b[0:276])
+	copy(p.Blocked[:], b[276:7932])
+	copy(p.Unknown2[:], b[7932:8052])
+	for i := range p.Entries {
+		p.Entries[i].unmarshalFrom(b[8052+444*i:])
+	}
+	copy(p.Unknown3[:], b[54228:54672])
+}
+
+// BinarySize returns the number of bytes in the serialized CharacterPreview.
+func (p *CharacterPreview) BinarySize() int {
+	return 116
+}
+
+// MarshalTo serializes the CharacterPreview into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *CharacterPreview) MarshalTo(b []byte) int {
+	binary.LittleEndian.PutUint32(b[0:], p.Experience)
+	binary.LittleEndian.PutUint32(b[4:], p.Level)
+	copy(b[8:24], p.GuildcardStr[:])
+	for i := range p.Unknown {
+		binary.LittleEndian.PutUint32(b[24+4*i:], p.Unknown[i])
+	}
+	binary.LittleEndian.PutUint32(b[32:], p.NameColor)
+	b[36] = p.Model
+	copy(b[37:52], p.Padding[:])
+	binary.LittleEndian.PutUint32(b[52:], p.NameColorChksm)
+	b[56] = p.SectionId
+	b[57] = p.Class
+	b[58] = p.V2flags
+	b[59] = p.Version
+	binary.LittleEndian.PutUint32(b[60:], p.V1Flags)
+	binary.LittleEndian.PutUint16(b[64:], p.Costume)
+	binary.LittleEndian.PutUint16(b[66:], p.Skin)
+	binary.LittleEndian.PutUint16(b[68:], p.Face)
+	binary.LittleEndian.PutUint16(b[70:], p.Head)
+	binary.LittleEndian.PutUint16(b[72:], p.Hair)
+	binary.LittleEndian.PutUint16(b[74:], p.HairRed)
+	binary.LittleEndian.PutUint16(b[76:], p.HairGreen)
+	binary.LittleEndian.PutUint16(b[78:], p.HairBlue)
+	binary.LittleEndian.PutUint32(b[80:], math.Float32bits(p.PropX))
+	binary.LittleEndian.PutUint32(b[84:], math.Float32bits(p.PropY))
+	copy(b[88:112], p.Name[:])
+	binary.LittleEndian.PutUint32(b[112:], p.Playtime)
+	return 116
+}
+
+// Unmarshal populates the CharacterPreview from b.
+func (p *CharacterPreview) Unmarshal(b []byte) error {
+	if len(b) < 116 {
+		return &util.ShortDataError{Size: len(b), Type: "CharacterPreview"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *CharacterPreview) unmarshalFrom(b []byte) {
+	p.Experience = binary.LittleEndian.Uint32(b[0:])
+	p.Level = binary.LittleEndian.Uint32(b[4:])
+	copy(p.GuildcardStr[:], b[8:24])
+	for i := range p.Unknown {
+		p.Unknown[i] = binary.LittleEndian.Uint32(b[24+4*i:])
+	}
+	p.NameColor = binary.LittleEndian.Uint32(b[32:])
+	p.Model = b[36]
+	copy(p.Padding[:], b[37:52])
+	p.NameColorChksm = binary.LittleEndian.Uint32(b[52:])
+	p.SectionId = b[56]
+	p.Class = b[57]
+	p.V2flags = b[58]
+	p.Version = b[59]
+	p.V1Flags = binary.LittleEndian.Uint32(b[60:])
+	p.Costume = binary.LittleEndian.Uint16(b[64:])
+	p.Skin = binary.LittleEndian.Uint16(b[66:])
+	p.Face = binary.LittleEndian.Uint16(b[68:])
+	p.Head = binary.LittleEndian.Uint16(b[70:])
+	p.Hair = binary.LittleEndian.Uint16(b[72:])
+	p.HairRed = binary.LittleEndian.Uint16(b[74:])
+	p.HairGreen = binary.LittleEndian.Uint16(b[76:])
+	p.HairBlue = binary.LittleEndian.Uint16(b[78:])
+	p.PropX = math.Float32frombits(binary.LittleEndian.Uint32(b[80:]))
+	p.PropY = math.Float32frombits(binary.LittleEndian.Uint32(b[84:]))
+	copy(p.Name[:], b[88:112])
+	p.Playtime = binary.LittleEndian.Uint32(b[112:])
+}
+
+// BinarySize returns the number of bytes in the serialized CharacterStats.
+func (p *CharacterStats) BinarySize() int {
+	return 14
+}
+
+// MarshalTo serializes the CharacterStats into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *CharacterStats) MarshalTo(b []byte) int {
+	binary.LittleEndian.PutUint16(b[0:], p.ATP)
+	binary.LittleEndian.PutUint16(b[2:], p.MST)
+	binary.LittleEndian.PutUint16(b[4:], p.EVP)
+	binary.LittleEndian.PutUint16(b[6:], p.HP)
+	binary.LittleEndian.PutUint16(b[8:], p.DFP)
+	binary.LittleEndian.PutUint16(b[10:], p.ATA)
+	binary.LittleEndian.PutUint16(b[12:], p.LCK)
+	return 14
+}
+
+// Unmarshal populates the CharacterStats from b.
+func (p *CharacterStats) Unmarshal(b []byte) error {
+	if len(b) < 14 {
+		return &util.ShortDataError{Size: len(b), Type: "CharacterStats"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *CharacterStats) unmarshalFrom(b []byte) {
+	p.ATP = binary.LittleEndian.Uint16(b[0:])
+	p.MST = binary.LittleEndian.Uint16(b[2:])
+	p.EVP = binary.LittleEndian.Uint16(b[4:])
+	p.HP = binary.LittleEndian.Uint16(b[6:])
+	p.DFP = binary.LittleEndian.Uint16(b[8:])
+	p.ATA = binary.LittleEndian.Uint16(b[10:])
+	p.LCK = binary.LittleEndian.Uint16(b[12:])
+}
+
+// BinarySize returns the number of bytes in the serialized ItemData.
+func (p *ItemData) BinarySize() int {
+	return 20
+}
+
+// MarshalTo serializes the ItemData into b, which must be at least BinarySize()
+// bytes long, and returns the number of bytes written.
+func (p *ItemData) MarshalTo(b []byte) int {
+	copy(b[0:12], p.Data[:])
+	binary.LittleEndian.PutUint32(b[12:], p.Id)
+	copy(b[16:20], p.Data2[:])
+	return 20
+}
+
+// Unmarshal populates the ItemData from b.
+func (p *ItemData) Unmarshal(b []byte) error {
+	if len(b) < 20 {
+		return &util.ShortDataError{Size: len(b), Type: "ItemData"}
+	}
+	p.unmarshalFrom(b)
+	return nil
+}
+
+func (p *ItemData) unmarshalFrom(b []byte) {
+	copy(p.Data[:], b[0:12])
+	p.Id = binary.LittleEndian.Uint32(b[12:])
+	copy(p.Data2[:], b[16:20])
+}
@@ -0,0 +1,126 @@
+/*
+* Archon PSO Server
+* Copyright (C) 2014 Andrew Rodman
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+* ---------------------------------------------------------------------
+* Checks that the generated packet methods produce the same bytes as the
+* reflection based serialization in util.
+ */
+package main
+
+import (
+	"bytes"
+	"github.com/dcrodman/archon/util"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+type generatedPacket interface {
+	util.Marshaler
+	util.Unmarshaler
+}
+
+// Random bytes long enough to fill pkt, plus a few elements' worth of data
+// for any trailing slice. Bytes left over from a partial element are ignored.
+func randomPacketData(r *rand.Rand, pkt generatedPacket) []byte {
+	data := make([]byte, pkt.BinarySize()+3*0x108)
+	r.Read(data)
+	return data
+}
+
+func TestGeneratedRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, pkt := range generatedPackets() {
+		name := reflect.TypeOf(pkt).Elem().Name()
+		data := randomPacketData(r, pkt)
+		if err := pkt.Unmarshal(data); err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+		out, size := util.BytesFromStruct(pkt)
+		if size != pkt.BinarySize() || size != len(out) || size > len(data) {
+			t.Errorf("%s: wrote %d bytes, expected %d", name, size, pkt.BinarySize())
+			continue
+		}
+		if !bytes.Equal(out, data[:size]) {
+			t.Errorf("%s: round trip doesn't match the input", name)
+		}
+		// Passing the struct by value bypasses the generated methods, which
+		// have pointer receivers, and serializes it with reflection.
+		if refl, _ := util.BytesFromStruct(reflect.ValueOf(pkt).Elem().Interface()); !bytes.Equal(out, refl) {
+			t.Errorf("%s: generated bytes don't match reflection:\n%x\n%x", name, out, refl)
+		}
+	}
+}
+
+func TestGeneratedShortData(t *testing.T) {
+	for _, pkt := range generatedPackets() {
+		if pkt.BinarySize() == 0 {
+			// Packets that are just a trailing slice can't be too short.
+			continue
+		}
+		name := reflect.TypeOf(pkt).Elem().Name()
+		short := make([]byte, pkt.BinarySize()-1)
+		if pkt.Unmarshal(short) == nil {
+			t.Errorf("%s: expected an error unmarshaling %d bytes", name, len(short))
+		}
+		if err := util.StructFromBytes(short, pkt); err == nil {
+			t.Errorf("%s: expected an error from StructFromBytes", name)
+		} else if _, ok := err.(*util.ShortDataError); !ok {
+			t.Errorf("%s: unexpected error type %T", name, err)
+		}
+	}
+}
+
+// Same layout as LoginPkt but without the generated methods.
+type reflectLoginPkt LoginPkt
+
+func benchmarkLoginPkt() *LoginPkt {
+	pkt := &LoginPkt{Header: BBHeader{Type: LoginType}}
+	copy(pkt.Username[:], "username")
+	copy(pkt.Password[:], "password")
+	return pkt
+}
+
+func BenchmarkLoginPktMarshal(b *testing.B) {
+	pkt := benchmarkLoginPkt()
+	for i := 0; i < b.N; i++ {
+		util.BytesFromStruct(pkt)
+	}
+}
+
+func BenchmarkLoginPktMarshalReflect(b *testing.B) {
+	pkt := (*reflectLoginPkt)(benchmarkLoginPkt())
+	for i := 0; i < b.N; i++ {
+		util.BytesFromStruct(pkt)
+	}
+}
+
+func BenchmarkLoginPktUnmarshal(b *testing.B) {
+	data, _ := util.BytesFromStruct(benchmarkLoginPkt())
+	var pkt LoginPkt
+	for i := 0; i < b.N; i++ {
+		util.StructFromBytes(data, &pkt)
+	}
+}
+
+func BenchmarkLoginPktUnmarshalReflect(b *testing.B) {
+	data, _ := util.BytesFromStruct(benchmarkLoginPkt())
+	var pkt reflectLoginPkt
+	for i := 0; i < b.N; i++ {
+		util.StructFromBytes(data, &pkt)
+	}
+}
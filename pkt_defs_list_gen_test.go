@@ -0,0 +1,101 @@
+// Code generated by tools/pktgen.go from character.go, pkt_defs.go; DO NOT EDIT.
+
+package main
+
+// One of each of the types in pkt_defs_gen.go.
+func generatedPackets() []generatedPacket {
+	return []generatedPacket{
+		new(PCHeader),
+		new(BBHeader),
+		new(PCv2Header),
+		new(DCHeader),
+		new(PatchWelcomePkt),
+		new(PatchWelcomeMessage),
+		new(PatchRedirectPacket),
+		new(ChangeDirPacket),
+		new(CheckFilePacket),
+		new(FileStatusPacket),
+		new(UpdateFilesPacket),
+		new(FileHeaderPacket),
+		new(FileChunkPacket),
+		new(WelcomePkt),
+		new(LoginPkt),
+		new(ClientConfig),
+		new(SecurityPacket),
+		new(RedirectPacket),
+		new(KeyTeamConfig),
+		new(OptionsPacket),
+		new(CharSelectionPacket),
+		new(CharAckPacket),
+		new(ChecksumAckPacket),
+		new(GuildcardHeaderPacket),
+		new(GuildcardChunkReqPacket),
+		new(GuildcardChunkPacket),
+		new(ParameterHeaderPacket),
+		new(ParameterChunkPacket),
+		new(SetFlagPacket),
+		new(CharPreviewPacket),
+		new(LoginClientMessagePacket),
+		new(TimestampPacket),
+		new(ShipListPacket),
+		new(ScrollMessagePacket),
+		new(MenuSelectionPacket),
+		new(BlockListPacket),
+		new(ShipMenuEntry),
+		new(parameterEntry),
+		new(Block),
+		new(LobbyListPacket),
+		new(LobbyListEntry),
+		new(PlayerHeader),
+		new(LobbyJoinPacket),
+		new(LeaveNoticePacket),
+		new(ChatPacket),
+		new(LobbyChangePacket),
+		new(CreateGamePacket),
+		new(GameJoinPacket),
+		new(RareEnemyListPacket),
+		new(GameListPacket),
+		new(GameListEntry),
+		new(GameSelectionPacket),
+		new(SubcommandHeader),
+		new(EnemyHitPacket),
+		new(EnemyKilledPacket),
+		new(EnemyExpRequestPacket),
+		new(GiveExperiencePacket),
+		new(LevelUpPacket),
+		new(ShopRequestPacket),
+		new(ShopContentsPacket),
+		new(ShopBuyPacket),
+		new(ShopSellPacket),
+		new(IdentifyItemPacket),
+		new(IdentifyResultPacket),
+		new(CreateItemPacket),
+		new(DropItemPacket),
+		new(DestroyItemPacket),
+		new(ClassicWelcomePkt),
+		new(ClassicVerifyPkt),
+		new(ClassicLoginPkt),
+		new(ClassicSecurityPacket),
+		new(ClassicMenuPacket),
+		new(ClassicMenuEntry),
+		new(PCMenuPacket),
+		new(PCMenuEntry),
+		new(ClassicMenuSelectionPacket),
+		new(ClassicRedirectPacket),
+		new(ClassicMessagePacket),
+		new(ClassicLobbyListPacket),
+		new(ClassicDispData),
+		new(ClassicCharDataPacket),
+		new(ClassicLobbyJoinPacket),
+		new(ClassicPlayerHeader),
+		new(PCPlayerHeader),
+		new(ClassicLeaveNoticePacket),
+		new(ShipgateAuthPacket),
+		new(ShipgateEventPacket),
+		new(GuildcardEntry),
+		new(GuildcardData),
+		new(CharacterPreview),
+		new(CharacterStats),
+		new(ItemData),
+	}
+}
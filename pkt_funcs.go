@@ -29,8 +29,10 @@ import (
 
 const (
 	// Copyright messages the client expects.
-	patchCopyright = "Patch Server. Copyright SonicTeam, LTD. 2001"
-	loginCopyright = "Phantasy Star Online Blue Burst Game Server. Copyright 1999-2004 SONICTEAM."
+	patchCopyright        = "Patch Server. Copyright SonicTeam, LTD. 2001"
+	loginCopyright        = "Phantasy Star Online Blue Burst Game Server. Copyright 1999-2004 SONICTEAM."
+	classicLoginCopyright = "DreamCast Port Map. Copyright SEGA Enterprises. 1999"
+	classicShipCopyright  = "DreamCast Lobby Server. Copyright SEGA Enterprises. 1999"
 	// Format for the timestamp sent to the client.
 	timeFmt = "2006:01:02: 15:05:05"
 )
@@ -41,37 +43,53 @@ var (
 	serverName          = util.ConvertToUtf16("Archon")
 )
 
-// Send the packet serialized (or otherwise contained) in pkt to a client.
+// Send the packet serialized (or otherwise contained) in pkt to a client
+// without encrypting it.
 // Note: Packets sent to BB Clients must have a length divisible by 8.
 func sendPacket(c *Client, pkt []byte, length uint16) int {
-	if err := c.Send(pkt[:length]); err != nil {
-		log.Info("Error sending to client %v: %s", c.IPAddr(), err.Error())
+	if err := c.queue(pkt[:length], false, true); err != nil {
+		log.Infof("Error sending to client %v: %s", c.IPAddr(), err.Error())
 		return -1
 	}
 	return 0
 }
 
-// Send data to client after padding it to a length disible by 8 and
-// encrypting it with the client's server ciper.
+// Send data to client after padding it to a length disible by 8. It's
+// encrypted with the client's server cipher by their writer goroutine.
 func sendEncrypted(c *Client, data []byte, length uint16) int {
-	data, length = fixLength(data, length, c.hdrSize)
+	data, length = fixLength(data, length, c.hdrSize, c.version.sizeOffset())
 	if config.DebugMode {
 		util.PrintPayload(data, int(length))
 		fmt.Println()
 	}
-	c.Encrypt(data, uint32(length))
-	return sendPacket(c, data, length)
+	if err := c.queue(data[:length], true, true); err != nil {
+		log.Infof("Error sending to client %v: %s", c.IPAddr(), err.Error())
+		return -1
+	}
+	return 0
+}
+
+// Like sendEncrypted, but for packets sent from goroutines other than the
+// client's own. Never blocks; clients whose queue is full are disconnected.
+func (c *Client) sendAsync(data []byte) int {
+	data, length := fixLength(append([]byte(nil), data...), uint16(len(data)),
+		c.hdrSize, c.version.sizeOffset())
+	if err := c.queue(data[:length], true, false); err != nil {
+		log.Infof("Error sending to client %v: %s", c.IPAddr(), err.Error())
+		return -1
+	}
+	return 0
 }
 
-// Pad the length of a packet to a multiple of 8 and set the first two
-// bytes of the header.
-func fixLength(data []byte, length uint16, hdrSize uint16) ([]byte, uint16) {
+// Pad the length of a packet to a multiple of the header size and set the
+// size field of the header, which starts at sizeOff.
+func fixLength(data []byte, length uint16, hdrSize uint16, sizeOff int) ([]byte, uint16) {
 	for length%hdrSize != 0 {
 		length++
 		data = append(data, 0)
 	}
-	data[0] = byte(length & 0xFF)
-	data[1] = byte((length & 0xFF00) >> 8)
+	data[sizeOff] = byte(length & 0xFF)
+	data[sizeOff+1] = byte((length & 0xFF00) >> 8)
 	return data, length
 }
 
@@ -224,8 +242,8 @@ func (client *Client) SendFileHeader(patch *PatchEntry) int {
 // Send a chunk of file data.
 func (client *Client) SendFileChunk(chunk, chksm, chunkSize uint32, fdata []byte) int {
 	if chunkSize > MaxFileChunkSize {
-		log.Error("Attempted to send %v byte chunk; max is %v",
-			string(chunkSize), string(MaxFileChunkSize))
+		log.Errorf("Attempted to send %v byte chunk; max is %v",
+			chunkSize, MaxFileChunkSize)
 		panic(errors.New("File chunk size exceeds maximum"))
 	}
 	pkt := &FileChunkPacket{
@@ -282,6 +300,9 @@ func (client *Client) SendWelcome() int {
 func (client *Client) SendSecurity(errorCode BBLoginError,
 	guildcard uint32, teamId uint32) int {
 
+	if errorCode == BBLoginErrorNone {
+		client.signSession()
+	}
 	// Constants set according to how Newserv does it.
 	pkt := &SecurityPacket{
 		Header:       BBHeader{Type: LoginSecurityType},
@@ -311,6 +332,7 @@ func (client *Client) SendRedirect(port uint16, ipAddr [4]byte) int {
 	if config.DebugMode {
 		fmt.Println("Sending Redirect Packet")
 	}
+	client.redirected = true
 	return sendEncrypted(client, data, uint16(size))
 }
 
@@ -318,7 +340,7 @@ func (client *Client) SendRedirect(port uint16, ipAddr [4]byte) int {
 // point to the default keys array or loaded from the database.
 func (client *Client) SendOptions(keyConfig []byte) int {
 	if len(keyConfig) != 420 {
-		panic("Received keyConfig of length " + string(len(keyConfig)) + "; should be 420")
+		panic(fmt.Sprintf("Received keyConfig of length %d; should be 420", len(keyConfig)))
 	}
 	pkt := new(OptionsPacket)
 	pkt.Header.Type = LoginOptionsType
@@ -405,8 +427,8 @@ func (client *Client) SendGuildcardChunk(chunkNum uint32) int {
 	pkt.Chunk = chunkNum
 
 	// The client will only accept 0x6800 bytes of a chunk per packet.
-	offset := uint16(chunkNum) * MaxChunkSize
-	remaining := client.gcDataSize - offset
+	offset := int(chunkNum) * MaxChunkSize
+	remaining := int(client.gcDataSize) - offset
 	if remaining > MaxChunkSize {
 		pkt.Data = client.gcData[offset : offset+MaxChunkSize]
 	} else {
@@ -525,6 +547,19 @@ func (client *Client) SendScrollMessage() int {
 	return sendEncrypted(client, data, uint16(size))
 }
 
+// Send a keepalive ping; the client should respond with the same packet.
+func (client *Client) SendPing() int {
+	if client.version != VersionBB {
+		return client.sendClassic(PingType, 0, nil)
+	}
+	pkt := &BBHeader{Type: PingType}
+	data, size := util.BytesFromStruct(pkt)
+	if config.DebugMode {
+		fmt.Println("Sending Ping Packet")
+	}
+	return sendEncrypted(client, data, uint16(size))
+}
+
 // Send the client the block list on the selection screen.
 func (client *Client) SendBlockList(pkt *BlockListPacket) int {
 	data, size := util.BytesFromStruct(pkt)
@@ -543,6 +578,319 @@ func (client *Client) SendLobbyList(pkt *LobbyListPacket) int {
 	return sendEncrypted(client, data, uint16(size))
 }
 
+// Send a player joining a lobby everyone that's in it.
+func (client *Client) SendLobbyJoin(l *Lobby, block uint16) int {
+	players := l.headers()
+	pkt := &LobbyJoinPacket{
+		Header:   BBHeader{Type: LobbyJoinType, Flags: uint32(len(players))},
+		ClientId: client.clientId,
+		LeaderId: l.leader(),
+		Unknown:  1,
+		LobbyNum: l.id,
+		BlockNum: block,
+		Players:  players,
+	}
+	data, size := util.BytesFromStruct(pkt)
+	if config.DebugMode {
+		fmt.Println("Sending Lobby Join Packet")
+	}
+	return sendEncrypted(client, data, uint16(size))
+}
+
+// Send a player joining a game its settings and everyone that's in it.
+func (client *Client) SendGameJoin(g *Game) int {
+	players := g.headers()
+	pkt := &GameJoinPacket{
+		Header:     BBHeader{Type: GameJoinType, Flags: uint32(len(players))},
+		ClientId:   client.clientId,
+		LeaderId:   g.leader(),
+		Unknown:    1,
+		Difficulty: g.difficulty,
+		Battle:     g.battle,
+		SectionId:  g.sectionId,
+		Challenge:  g.challenge,
+		RandomSeed: g.seed,
+		Episode:    uint8(g.episode),
+		Unknown2:   1,
+	}
+	// Variations are left at zero so that every area uses its first layout,
+	// which is the one the server loads the enemies from.
+	if g.solo {
+		pkt.SinglePlayer = 1
+	}
+	copy(pkt.Players[:], players)
+	data, size := util.BytesFromStruct(pkt)
+	if config.DebugMode {
+		fmt.Println("Sending Game Join Packet")
+	}
+	return sendEncrypted(client, data, uint16(size))
+}
+
+// Tell the client which enemies in the game it's joining are rare.
+func (client *Client) SendRareEnemyList(g *Game) int {
+	pkt := &RareEnemyListPacket{Header: BBHeader{Type: RareEnemyListType}}
+	for i := range pkt.Enemies {
+		pkt.Enemies[i] = 0xFFFF
+	}
+	copy(pkt.Enemies[:], g.rareEnemies)
+	data, size := util.BytesFromStruct(pkt)
+	if config.DebugMode {
+		fmt.Println("Sending Rare Enemy List Packet")
+	}
+	return sendEncrypted(client, data, uint16(size))
+}
+
+// Send the list of games that can be joined on the block.
+func (client *Client) SendGameList(games []*Game) int {
+	pkt := &GameListPacket{
+		Header: BBHeader{Type: GameListType, Flags: uint32(len(games))},
+		Games:  []GameListEntry{{MenuId: uint32(GameMenuId), GameId: 0xFFFFFFFF, Flags: 0x04}},
+	}
+	copy(pkt.Games[0].Name[:], util.ConvertToUtf16(config.ShipName))
+	for _, g := range games {
+		entry := GameListEntry{
+			MenuId:     uint32(GameMenuId),
+			GameId:     g.id,
+			Difficulty: g.difficulty,
+			Players:    uint8(g.count()),
+			Episode:    uint8(g.episode),
+		}
+		if g.password != "" {
+			entry.Flags |= 0x02
+		}
+		copy(entry.Name[:], util.ConvertToUtf16(g.name))
+		pkt.Games = append(pkt.Games, entry)
+	}
+	data, size := util.BytesFromStruct(pkt)
+	if config.DebugMode {
+		fmt.Println("Sending Game List Packet")
+	}
+	return sendEncrypted(client, data, uint16(size))
+}
+
+// Send a chat message to the player from the player with guildcard, or
+// from the server if it's 0.
+func (client *Client) SendChat(guildcard uint32, message string) int {
+	data := chatPacket(guildcard, message)
+	if config.DebugMode {
+		fmt.Println("Sending Chat Packet")
+	}
+	return sendEncrypted(client, data, uint16(len(data)))
+}
+
+// Tell the player how much experience they've been given.
+func (client *Client) SendGiveExperience(amount uint32) int {
+	pkt := &GiveExperiencePacket{
+		Header:     BBHeader{Type: GameCommandType},
+		Subcommand: SubGiveExperienceType,
+		Size:       2,
+		ClientId:   uint16(client.clientId),
+		Amount:     amount,
+	}
+	data, size := util.BytesFromStruct(pkt)
+	if config.DebugMode {
+		fmt.Println("Sending Give Experience Packet")
+	}
+	return sendEncrypted(client, data, uint16(size))
+}
+
+// Send the items for sale in a shop, which fills in the rest of the list.
+func (client *Client) SendShopContents(shopType uint8, items []ItemData) int {
+	pkt := &ShopContentsPacket{
+		Header:     BBHeader{Type: GameCommandLargeType},
+		Subcommand: SubShopContentsType,
+		Size:       0x2C,
+		Params:     0x037F,
+		ShopType:   shopType,
+		NumItems:   uint8(len(items)),
+	}
+	copy(pkt.Items[:], items)
+	data, size := util.BytesFromStruct(pkt)
+	if config.DebugMode {
+		fmt.Println("Sending Shop Contents Packet")
+	}
+	return sendEncrypted(client, data, uint16(size))
+}
+
+// Send the result of identifying a weapon to the player that asked for it.
+func (client *Client) SendIdentifyResult(item ItemData) int {
+	pkt := &IdentifyResultPacket{
+		Header:     BBHeader{Type: GameCommandType},
+		Subcommand: SubIdentifyResultType,
+		Size:       6,
+		ClientId:   uint16(client.clientId),
+		Item:       item,
+	}
+	data, size := util.BytesFromStruct(pkt)
+	if config.DebugMode {
+		fmt.Println("Sending Identify Result Packet")
+	}
+	return sendEncrypted(client, data, uint16(size))
+}
+
+// Serialize body (if there is one) behind a four byte header laid out for the
+// client's version and send it. Used for the PC, Dreamcast, and Gamecube packets.
+func (client *Client) sendClassic(pktType, flags uint8, body interface{}) int {
+	data := client.classicHeader(pktType, flags)
+	if body != nil {
+		b, _ := util.BytesFromStruct(body)
+		data = append(data, b...)
+	}
+	return sendEncrypted(client, data, uint16(len(data)))
+}
+
+// Returns a header for the client's version without the size filled in.
+func (client *Client) classicHeader(pktType, flags uint8) []byte {
+	var data []byte
+	if client.version == VersionPC {
+		data, _ = util.BytesFromStruct(&PCv2Header{Type: pktType, Flags: flags})
+	} else {
+		data, _ = util.BytesFromStruct(&DCHeader{Type: pktType, Flags: flags})
+	}
+	return data
+}
+
+// Convert text to the encoding used by the client's version.
+func (client *Client) classicText(text string) []byte {
+	if client.version == VersionPC {
+		return util.ConvertToUtf16(text)
+	}
+	return []byte(text)
+}
+
+// Send the welcome packet with the encryption vectors. pktType determines
+// whether it's the one for the login server or for ships and blocks.
+func (client *Client) SendClassicWelcome(pktType uint8) int {
+	pkt := new(ClassicWelcomePkt)
+	if pktType == ClassicLoginWelcomeType {
+		copy(pkt.Copyright[:], classicLoginCopyright)
+	} else {
+		copy(pkt.Copyright[:], classicShipCopyright)
+	}
+	copy(pkt.ServerVector[:], client.ServerVector())
+	copy(pkt.ClientVector[:], client.ClientVector())
+
+	body, _ := util.BytesFromStruct(pkt)
+	data := append(client.classicHeader(pktType, 0), body...)
+	data, size := fixLength(data, uint16(len(data)), client.hdrSize, client.version.sizeOffset())
+	if config.DebugMode {
+		fmt.Println("Sending Classic Welcome Packet")
+		util.PrintPayload(data, int(size))
+		fmt.Println()
+	}
+	// Sent unencrypted since the client doesn't have the vectors yet.
+	return sendPacket(client, data, size)
+}
+
+// Tell the client whether their license check passed.
+func (client *Client) SendClassicVerify(result uint8) int {
+	if config.DebugMode {
+		fmt.Println("Sending Classic Verify Packet")
+	}
+	return client.sendClassic(ClassicVerifyType, result, nil)
+}
+
+// Send the security packet with the player's guildcard number, or just the
+// error code if they failed to log in.
+func (client *Client) SendClassicSecurity(result uint8, guildcard uint32) int {
+	pkt := &ClassicSecurityPacket{
+		PlayerTag: 0x00010000,
+		Guildcard: guildcard,
+	}
+	copy(pkt.Config[:], client.classicConfig[:])
+	if config.DebugMode {
+		fmt.Println("Sending Classic Security Packet")
+	}
+	return client.sendClassic(ClassicSecurityType, result, pkt)
+}
+
+// Send a menu with a title and the given items, each of which is a pair of
+// item id and text.
+func (client *Client) SendClassicMenu(menuId uint32, title string, items []classicMenuItem) int {
+	items = append([]classicMenuItem{{id: 0xFFFFFFFF, text: title}}, items...)
+	var pkt interface{}
+	if client.version == VersionPC {
+		menu := &PCMenuPacket{Entries: make([]PCMenuEntry, len(items))}
+		for i, item := range items {
+			e := &menu.Entries[i]
+			e.MenuId, e.ItemId, e.Flags = menuId, item.id, 0x0F04
+			copy(e.Text[:], util.ConvertToUtf16(item.text))
+		}
+		pkt = menu
+	} else {
+		menu := &ClassicMenuPacket{Entries: make([]ClassicMenuEntry, len(items))}
+		for i, item := range items {
+			e := &menu.Entries[i]
+			e.MenuId, e.ItemId, e.Flags = menuId, item.id, 0x0F04
+			copy(e.Text[:], item.text)
+		}
+		pkt = menu
+	}
+	// The title entry isn't included in the count.
+	entries := uint8(len(items) - 1)
+	if config.DebugMode {
+		fmt.Println("Sending Classic Menu Packet")
+	}
+	return client.sendClassic(ClassicMenuType, entries, pkt)
+}
+
+// Send the redirect packet, providing the IP and port of the next server.
+func (client *Client) SendClassicRedirect(port uint16, ipAddr [4]byte) int {
+	pkt := &ClassicRedirectPacket{IPAddr: ipAddr, Port: port}
+	if config.DebugMode {
+		fmt.Println("Sending Classic Redirect Packet")
+	}
+	client.redirected = true
+	return client.sendClassic(RedirectType, 0, pkt)
+}
+
+// Display a message box, usually used before disconnecting.
+func (client *Client) SendClassicMessage(message string) int {
+	pkt := &ClassicMessagePacket{Message: append(client.classicText(message), 0, 0)}
+	if config.DebugMode {
+		fmt.Println("Sending Classic Message Packet")
+	}
+	return client.sendClassic(ClassicMessageType, 0, pkt)
+}
+
+// Send the lobby list for the client's version.
+func (client *Client) SendClassicLobbyList(pkt *ClassicLobbyListPacket) int {
+	if config.DebugMode {
+		fmt.Println("Sending Classic Lobby List Packet")
+	}
+	return client.sendClassic(LobbyListType, uint8(len(pkt.Lobbies)), pkt)
+}
+
+// Ask a classic client for its character data.
+func (client *Client) SendClassicCharDataRequest() int {
+	if config.DebugMode {
+		fmt.Println("Sending Classic Character Data Request")
+	}
+	return client.sendClassic(ClassicCharDataRequestType, 0, nil)
+}
+
+// Send a player joining a classic lobby everyone that's in it.
+func (client *Client) SendClassicLobbyJoin(l *Lobby, block uint16) int {
+	pkt := &ClassicLobbyJoinPacket{
+		ClientId:   client.clientId,
+		LeaderId:   l.leader(),
+		DisableUdp: 1,
+		LobbyNum:   l.id,
+		BlockNum:   block,
+	}
+	players := 0
+	for id := uint8(0); id < MaxLobbyPlayers; id++ {
+		if p := l.player(id); p != nil {
+			pkt.Players = append(pkt.Players, classicPlayerEntry(client.version, p, id)...)
+			players++
+		}
+	}
+	if config.DebugMode {
+		fmt.Println("Sending Classic Lobby Join Packet")
+	}
+	return client.sendClassic(LobbyJoinType, uint8(players), pkt)
+}
+
 func init() {
 	patchCopyrightBytes = []byte(patchCopyright)
 	loginCopyrightBytes = []byte(loginCopyright)
@@ -0,0 +1,358 @@
+/*
+* Archon PSO Server
+* Copyright (C) 2014 Andrew Rodman
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+* ---------------------------------------------------------------------
+*
+* PRS compression. Compress and Writer produce the same output as the C
+* compressor archon used to wrap; CompressBest trades speed for a smaller
+* result.
+ */
+package prs
+
+import (
+	"bytes"
+	"errors"
+	"io"
+)
+
+const (
+	// The original compressor doesn't look further back than this.
+	greedyWindow = 0x1FF0
+	// Longest match the original compressor will use.
+	greedyMaxMatch = 0xFF
+
+	hashBits = 15
+
+	// Bytes of output buffered before the Writer flushes them.
+	flushSize = 0x1000
+	// Input buffered past the window before the Writer discards old data.
+	compactSize = 0x10000
+
+	// Most match candidates CompressBest will consider per position.
+	maxChain = 1024
+)
+
+var errClosed = errors.New("prs: write to closed Writer")
+
+// Builds the compressed stream. The control byte for the next eight commands
+// is reserved before their data, so output is only final up to ctrl.
+type encoder struct {
+	out  []byte
+	ctrl int
+	bit  uint
+}
+
+func newEncoder() encoder {
+	return encoder{out: []byte{0}}
+}
+
+func (e *encoder) putBit(bit byte) {
+	e.putBitNoSave(bit)
+	e.save()
+}
+
+func (e *encoder) putBitNoSave(bit byte) {
+	e.out[e.ctrl] = e.out[e.ctrl]>>1 | bit<<7
+	e.bit++
+}
+
+// Reserve a new control byte once the current one is full.
+func (e *encoder) save() {
+	if e.bit >= 8 {
+		e.bit = 0
+		e.ctrl = len(e.out)
+		e.out = append(e.out, 0)
+	}
+}
+
+func (e *encoder) literal(b byte) {
+	e.putBitNoSave(1)
+	e.out = append(e.out, b)
+	e.save()
+}
+
+// Copy size bytes from dist bytes back.
+func (e *encoder) copy(dist, size int) {
+	offset := -dist
+	if dist < maxShortOffset && size <= maxShortCopy {
+		size -= 2
+		e.putBit(0)
+		e.putBit(0)
+		e.putBit(byte(size>>1) & 1)
+		e.putBitNoSave(byte(size) & 1)
+		e.out = append(e.out, byte(offset))
+	} else {
+		e.putBit(0)
+		e.putBitNoSave(1)
+		if size <= 9 {
+			e.out = append(e.out, byte(offset<<3&0xF8|(size-2)&7), byte(offset>>5))
+		} else {
+			e.out = append(e.out, byte(offset<<3&0xF8), byte(offset>>5), byte(size-1))
+		}
+	}
+	e.save()
+}
+
+func (e *encoder) finish() {
+	e.putBit(0)
+	e.putBit(1)
+	if e.bit != 0 {
+		e.out[e.ctrl] >>= 8 - e.bit
+	}
+	e.out = append(e.out, 0, 0)
+}
+
+// Number of bits each kind of command takes up, for CompressBest.
+func copyCost(dist, size int) int {
+	switch {
+	case dist < maxShortOffset && size <= maxShortCopy:
+		return 4 + 8
+	case size <= 9:
+		return 2 + 16
+	}
+	return 2 + 24
+}
+
+const literalCost = 1 + 8
+
+func hash3(b []byte) int {
+	return int((uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])) * 2654435761 >> (32 - hashBits))
+}
+
+// Writer compresses the data written to it the same way as Compress.
+// Output is buffered until enough input has been written to find the
+// longest matches, and the stream isn't complete until Close is called.
+type Writer struct {
+	w   io.Writer
+	enc encoder
+	// Input not yet discarded; buf[0] is at position base in the stream.
+	buf  []byte
+	base int
+	// Position of the next byte to encode and of the next one to hash.
+	pos      int
+	inserted int
+	// Hash chains of earlier positions, most recent first.
+	head   [1 << hashBits]int32
+	prev   [maxLongOffset + 1]int32
+	err    error
+	closed bool
+}
+
+// NewWriter returns a Writer that writes compressed data to w.
+func NewWriter(w io.Writer) *Writer {
+	pw := &Writer{w: w, enc: newEncoder()}
+	for i := range pw.head {
+		pw.head[i] = -1
+	}
+	return pw
+}
+
+func (w *Writer) at(pos int) []byte {
+	return w.buf[pos-w.base:]
+}
+
+// Find the longest match for the data at pos the way the original
+// compressor does: nearest wins ties, copies can't overlap the data
+// they produce, and the first byte of the stream is never used.
+func (w *Writer) findMatch(pos, end int) (dist, size int) {
+	for ; w.inserted <= pos-3; w.inserted++ {
+		h := hash3(w.at(w.inserted))
+		w.prev[w.inserted&maxLongOffset] = w.head[h]
+		w.head[h] = int32(w.inserted)
+	}
+	if pos+3 > end {
+		return 0, 0
+	}
+	cur := w.at(pos)
+	for y := int(w.head[hash3(cur)]); y > 0 && y > pos-greedyWindow; y = int(w.prev[y&maxLongOffset]) {
+		cand := w.at(y)
+		if cand[0] != cur[0] || cand[1] != cur[1] || cand[2] != cur[2] {
+			continue
+		}
+		n := 4
+		for n <= greedyMaxMatch && y+n < pos && pos+n <= end && cand[n-1] == cur[n-1] {
+			n++
+		}
+		if n-1 > size {
+			dist, size = pos-y, n-1
+			if size >= greedyMaxMatch {
+				break
+			}
+		}
+	}
+	return dist, size
+}
+
+// Encode input up to end. Unless final, stop while there's still enough
+// data buffered to find the longest possible match.
+func (w *Writer) encode(end int, final bool) {
+	for w.pos < end && (final || w.pos+greedyMaxMatch+1 <= end) {
+		if dist, size := w.findMatch(w.pos, end); size == 0 {
+			w.enc.literal(w.at(w.pos)[0])
+			w.pos++
+		} else {
+			w.enc.copy(dist, size)
+			w.pos += size
+		}
+	}
+}
+
+// Write out everything up to n bytes into the encoder's output.
+func (w *Writer) flush(n int) error {
+	if w.err != nil {
+		return w.err
+	}
+	if _, w.err = w.w.Write(w.enc.out[:n]); w.err != nil {
+		return w.err
+	}
+	w.enc.ctrl -= n
+	w.enc.out = w.enc.out[:copy(w.enc.out, w.enc.out[n:])]
+	return nil
+}
+
+// Write compresses p, writing output to the underlying writer as it's completed.
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errClosed
+	}
+	if w.err != nil {
+		return 0, w.err
+	}
+	w.buf = append(w.buf, p...)
+	w.encode(w.base+len(w.buf), false)
+	if w.enc.ctrl >= flushSize {
+		if err := w.flush(w.enc.ctrl); err != nil {
+			return 0, err
+		}
+	}
+	// Keep enough of the input around for the hash chains and the window.
+	if keep := w.pos - w.base - (maxLongOffset + 1); keep > compactSize {
+		w.buf = w.buf[:copy(w.buf, w.buf[keep:])]
+		w.base += keep
+	}
+	return len(p), nil
+}
+
+// Close compresses any remaining data and writes the end of the stream.
+// It doesn't close the underlying writer.
+func (w *Writer) Close() error {
+	if w.closed {
+		return w.err
+	}
+	w.closed = true
+	if w.err != nil {
+		return w.err
+	}
+	w.encode(w.base+len(w.buf), true)
+	w.enc.finish()
+	return w.flush(len(w.enc.out))
+}
+
+// Compress returns src compressed the same way as the original C compressor.
+func Compress(src []byte) []byte {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.Write(src)
+	w.Close()
+	return buf.Bytes()
+}
+
+// CompressBest returns src compressed into as few bytes as it can manage by
+// choosing the cheapest sequence of commands instead of always taking the
+// longest match. Unlike Compress it also uses copies that overlap the data
+// they produce, which the game's decompressor handles. It's much slower.
+func CompressBest(src []byte) []byte {
+	n := len(src)
+	// Bits needed to encode src[:i] and the last command used to get there.
+	cost := make([]int, n+1)
+	type command struct{ dist, size int }
+	from := make([]command, n+1)
+	for i := 1; i <= n; i++ {
+		cost[i] = -1
+	}
+
+	// Chains of earlier positions keyed by their first two bytes.
+	var head [1 << 16]int32
+	for i := range head {
+		head[i] = -1
+	}
+	prev := make([]int32, n)
+
+	relax := func(i, c int, cmd command) {
+		if cost[i] < 0 || c < cost[i] {
+			cost[i], from[i] = c, cmd
+		}
+	}
+	for i := 0; i < n; i++ {
+		relax(i+1, cost[i]+literalCost, command{})
+		if i+2 > n {
+			break
+		}
+		maxSize := n - i
+		if maxSize > maxLongCopy {
+			maxSize = maxLongCopy
+		}
+		// Candidates are nearest first, so each length only needs to be
+		// considered the first time a match reaches it.
+		longest := 1
+		key := int(src[i])<<8 | int(src[i+1])
+		for y, chain := int(head[key]), 0; y >= 0 && i-y <= maxLongOffset && chain < maxChain; y, chain = int(prev[y]), chain+1 {
+			size := 2
+			for size < maxSize && src[y+size] == src[i+size] {
+				size++
+			}
+			dist := i - y
+			start := longest + 1
+			if start == 2 && dist >= maxShortOffset {
+				start = 3
+			}
+			for l := start; l <= size; l++ {
+				relax(i+l, cost[i]+copyCost(dist, l), command{dist, l})
+			}
+			if size > longest {
+				longest = size
+			}
+			if longest == maxSize {
+				break
+			}
+		}
+		prev[i] = head[key]
+		head[key] = int32(i)
+	}
+
+	var cmds []command
+	for i := n; i > 0; {
+		cmds = append(cmds, from[i])
+		if from[i].size == 0 {
+			i--
+		} else {
+			i -= from[i].size
+		}
+	}
+	enc := newEncoder()
+	pos := 0
+	for i := len(cmds) - 1; i >= 0; i-- {
+		if cmd := cmds[i]; cmd.size == 0 {
+			enc.literal(src[pos])
+			pos++
+		} else {
+			enc.copy(cmd.dist, cmd.size)
+			pos += cmd.size
+		}
+	}
+	enc.finish()
+	return enc.out
+}
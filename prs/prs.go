@@ -1,9 +1,3 @@
-package prs
-
-//#import "prs.h"
-import "C"
-import "unsafe"
-
 /*
 * Archon PSO Server
 * Copyright (C) 2014 Andrew Rodman
@@ -22,20 +16,203 @@ import "unsafe"
 * along with this program.  If not, see <http://www.gnu.org/licenses/>.
 * ---------------------------------------------------------------------
 *
-* PRS compression/decompression library. Original underlying C implementation
-* by Fuzziqer Software, wrapper written in Go for use with archon.
+* PRS compression and decompression. PRS is the LZ77 variant Sega used for
+* the game's data files; this is a Go port of the format as implemented by
+* Fuzziqer Software's prsutil, whose C code archon used to wrap.
+*
+* A compressed stream is a sequence of commands, each introduced by one or
+* more control bits. Control bits are packed eight to a byte, least
+* significant bit first, and a new control byte is read from the stream
+* whenever the previous one runs out, so they're interleaved with the data:
+*
+*     1             literal: copy the next byte to the output
+*     00 s1 s0 o    short copy: copy s+2 bytes from o-256 bytes back
+*     01 o1 o0      long copy: copy (o0&7)+2 bytes from the offset in
+*                   the upper 13 bits, counted from 8192 bytes back
+*     01 o1 o0 n    long copy with o0&7 == 0: copy n+1 bytes
+*
+* A long copy with both offset bytes set to 0 marks the end of the stream.
  */
+package prs
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+)
+
+const (
+	// Largest distance back a copy can reach.
+	maxLongOffset  = 0x1FFF
+	maxShortOffset = 0x100
+	// Longest copies the short and long commands can encode.
+	maxShortCopy = 5
+	maxLongCopy  = 0x100
+)
+
+// ErrCorrupt is returned when a stream refers to data before its start.
+var ErrCorrupt = errors.New("prs: corrupt input")
+
+// Decompress returns the decompressed contents of src.
+func Decompress(src []byte) ([]byte, error) {
+	return ioutil.ReadAll(NewReader(bytes.NewReader(src)))
+}
+
+// DecompressSize returns the size of the decompressed contents of src
+// without keeping any of the output.
+func DecompressSize(src []byte) (int, error) {
+	n, err := io.Copy(ioutil.Discard, NewReader(bytes.NewReader(src)))
+	return int(n), err
+}
+
+// Reader decompresses a PRS stream from an underlying reader.
+type Reader struct {
+	r io.ByteReader
+	// Last maxLongOffset+1 bytes of output, indexed by position mod the size.
+	window  [maxLongOffset + 1]byte
+	written int64
+	// Control byte currently being consumed and the number of bits left in it.
+	control byte
+	bits    uint
+	// Remaining length and distance of the copy in progress.
+	copyLen  int
+	copyDist int
+	done     bool
+	err      error
+}
+
+// NewReader returns a Reader that decompresses the PRS data read from r.
+// Truncated data results in io.ErrUnexpectedEOF.
+func NewReader(r io.Reader) *Reader {
+	br, ok := r.(io.ByteReader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	return &Reader{r: br}
+}
+
+func (r *Reader) readByte() (byte, error) {
+	b, err := r.r.ReadByte()
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return b, err
+}
+
+func (r *Reader) readBit() (byte, error) {
+	if r.bits == 0 {
+		b, err := r.readByte()
+		if err != nil {
+			return 0, err
+		}
+		r.control, r.bits = b, 8
+	}
+	bit := r.control & 1
+	r.control >>= 1
+	r.bits--
+	return bit, nil
+}
 
-func Compress(src, dest []byte, size uint32) uint32 {
-	return uint32(C.prs_compress(
-		unsafe.Pointer(&src[0]), unsafe.Pointer(&dest[0]), C.u32(size)))
+func (r *Reader) output(b byte) {
+	r.window[r.written&maxLongOffset] = b
+	r.written++
 }
 
-func Decompress(src, dest []byte) uint32 {
-	return uint32(C.prs_decompress(
-		unsafe.Pointer(&src[0]), unsafe.Pointer(&dest[0])))
+// Decode the next command, writing a literal straight to the window or
+// setting up copyLen and copyDist for a copy. Returns the literal if there
+// was one.
+func (r *Reader) next() (literal bool, b byte, err error) {
+	bit, err := r.readBit()
+	if err != nil {
+		return false, 0, err
+	}
+	if bit == 1 {
+		if b, err = r.readByte(); err != nil {
+			return false, 0, err
+		}
+		return true, b, nil
+	}
+	if bit, err = r.readBit(); err != nil {
+		return false, 0, err
+	}
+	if bit == 1 {
+		var lo, hi byte
+		if lo, err = r.readByte(); err != nil {
+			return false, 0, err
+		}
+		if hi, err = r.readByte(); err != nil {
+			return false, 0, err
+		}
+		offset := int(hi)<<8 | int(lo)
+		if offset == 0 {
+			r.done = true
+			return false, 0, io.EOF
+		}
+		r.copyDist = 0x2000 - offset>>3
+		if size := offset & 7; size != 0 {
+			r.copyLen = size + 2
+		} else {
+			if b, err = r.readByte(); err != nil {
+				return false, 0, err
+			}
+			r.copyLen = int(b) + 1
+		}
+	} else {
+		size := 0
+		for i := 0; i < 2; i++ {
+			if bit, err = r.readBit(); err != nil {
+				return false, 0, err
+			}
+			size = size<<1 | int(bit)
+		}
+		if b, err = r.readByte(); err != nil {
+			return false, 0, err
+		}
+		r.copyLen = size + 2
+		r.copyDist = 0x100 - int(b)
+	}
+	if int64(r.copyDist) > r.written {
+		return false, 0, ErrCorrupt
+	}
+	return false, 0, nil
 }
 
-func DecompressSize(src []byte) uint32 {
-	return uint32(C.prs_decompress_size(unsafe.Pointer(&src[0])))
+// Read decompresses data into p.
+func (r *Reader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if r.copyLen > 0 {
+			// Copies can overlap the bytes they're producing, so go one at a time.
+			b := r.window[(r.written-int64(r.copyDist))&maxLongOffset]
+			r.output(b)
+			p[n] = b
+			n++
+			r.copyLen--
+			continue
+		}
+		if r.done || r.err != nil {
+			break
+		}
+		literal, b, err := r.next()
+		if err != nil {
+			r.err = err
+			break
+		}
+		if literal {
+			r.output(b)
+			p[n] = b
+			n++
+		}
+	}
+	if n == 0 && r.copyLen == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		if r.done {
+			return 0, io.EOF
+		}
+	}
+	return n, nil
 }
@@ -0,0 +1,217 @@
+/*
+* Archon PSO Server
+* Copyright (C) 2014 Andrew Rodman
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+* ---------------------------------------------------------------------
+ */
+package prs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+	"testing/iotest"
+)
+
+// Output of the original C implementation for the files in config/parameters.
+var goldenFiles = []struct {
+	name string
+	// Size and SHA-256 of the decompressed file.
+	size int
+	sum  string
+	// Size and SHA-256 of the C compressor's output for the decompressed file.
+	compressedSize int
+	compressedSum  string
+}{
+	{"ItemMagEdit.prs", 1920, "a45566eede36358601ea84430e31b9d68abd4608c963412bdafc32cd28fc5175",
+		936, "b639ea34b232f983767fdfdc9950ca6697a07913c8c3661c4f52b420e991b867"},
+	{"ItemPMT.prs", 86880, "2c95bc301af3cee6baf8e1531da0d20555deec1e896758d288fab0971f5a4e80",
+		23524, "58b67bbd7d2fe56c1dcd3ad91a056de44a242b52a8b620222b2eea25b1b7bffc"},
+	{"PlyLevelTbl.prs", 29184, "59d29b9c510a39965074c45c29b4a1a0f5f7d78d5b156e9d32798aeb9a8386d6",
+		11928, "243dd50e824f8059238ed586226bcbe5d2962692e193064428c482dd9a22c177"},
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func readGolden(t testing.TB, name string) ([]byte, []byte) {
+	compressed, err := ioutil.ReadFile("../config/parameters/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := Decompress(compressed)
+	if err != nil {
+		t.Fatalf("%s: %s", name, err)
+	}
+	return compressed, data
+}
+
+func TestDecompressGolden(t *testing.T) {
+	for _, f := range goldenFiles {
+		compressed, data := readGolden(t, f.name)
+		if len(data) != f.size || sha256Hex(data) != f.sum {
+			t.Errorf("%s: decompressed to %d bytes with a different checksum", f.name, len(data))
+		}
+		if size, err := DecompressSize(compressed); err != nil || size != f.size {
+			t.Errorf("%s: DecompressSize returned %d, %v", f.name, size, err)
+		}
+		// Reading a byte at a time exercises resuming in the middle of a copy.
+		r := iotest.OneByteReader(NewReader(bytes.NewReader(compressed)))
+		if streamed, err := ioutil.ReadAll(r); err != nil || !bytes.Equal(streamed, data) {
+			t.Errorf("%s: streaming decompression doesn't match: %v", f.name, err)
+		}
+	}
+}
+
+func TestCompressGolden(t *testing.T) {
+	for _, f := range goldenFiles {
+		_, data := readGolden(t, f.name)
+		out := Compress(data)
+		if len(out) != f.compressedSize || sha256Hex(out) != f.compressedSum {
+			t.Errorf("%s: compressed to %d bytes, expected %d matching the C output",
+				f.name, len(out), f.compressedSize)
+		}
+
+		// Writing in odd sized pieces shouldn't change the output.
+		var buf bytes.Buffer
+		w := NewWriter(&buf)
+		for rest := data; len(rest) > 0; {
+			n := 1 + len(rest)%97
+			if n > len(rest) {
+				n = len(rest)
+			}
+			if _, err := w.Write(rest[:n]); err != nil {
+				t.Fatal(err)
+			}
+			rest = rest[n:]
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf.Bytes(), out) {
+			t.Errorf("%s: Writer output doesn't match Compress", f.name)
+		}
+	}
+}
+
+func TestCompressBestGolden(t *testing.T) {
+	for _, f := range goldenFiles {
+		_, data := readGolden(t, f.name)
+		out := CompressBest(data)
+		if len(out) > f.compressedSize {
+			t.Errorf("%s: CompressBest output is %d bytes, larger than the %d from Compress",
+				f.name, len(out), f.compressedSize)
+		}
+		if got, err := Decompress(out); err != nil || !bytes.Equal(got, data) {
+			t.Errorf("%s: CompressBest output doesn't decompress: %v", f.name, err)
+		}
+	}
+}
+
+func checkRoundTrip(t *testing.T, name string, data []byte) {
+	for mode, compress := range map[string]func([]byte) []byte{"Compress": Compress, "CompressBest": CompressBest} {
+		got, err := Decompress(compress(data))
+		if err != nil {
+			t.Errorf("%s: %s: %s", name, mode, err)
+		} else if !bytes.Equal(got, data) {
+			t.Errorf("%s: %s: round trip doesn't match", name, mode)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	random := make([]byte, 0x5000)
+	r.Read(random)
+	// Few distinct values so that there are lots of short matches at all distances.
+	lowEntropy := make([]byte, 0x5000)
+	for i := range lowEntropy {
+		lowEntropy[i] = byte(r.Intn(4))
+	}
+
+	checkRoundTrip(t, "empty", nil)
+	checkRoundTrip(t, "one byte", []byte{7})
+	checkRoundTrip(t, "zeros", make([]byte, 0x3000))
+	checkRoundTrip(t, "pattern", bytes.Repeat([]byte("archon"), 2000))
+	checkRoundTrip(t, "random", random)
+	checkRoundTrip(t, "low entropy", lowEntropy)
+}
+
+func TestDecompressErrors(t *testing.T) {
+	compressed := Compress(bytes.Repeat([]byte("abcdefgh"), 100))
+	for i := 0; i < len(compressed); i++ {
+		if _, err := Decompress(compressed[:i]); err != io.ErrUnexpectedEOF {
+			t.Errorf("truncated to %d bytes: expected ErrUnexpectedEOF, got %v", i, err)
+		}
+	}
+	// Short copy from one byte back before anything has been written.
+	if _, err := Decompress([]byte{0x00, 0xFF}); err != ErrCorrupt {
+		t.Errorf("expected ErrCorrupt, got %v", err)
+	}
+	// Long copy from 8 bytes back with only one byte written.
+	if _, err := Decompress([]byte{0x05, 'a', 0xC3, 0xFF, 0x00, 0x00}); err != ErrCorrupt {
+		t.Errorf("expected ErrCorrupt, got %v", err)
+	}
+}
+
+func TestWriterClosed(t *testing.T) {
+	w := NewWriter(ioutil.Discard)
+	w.Close()
+	if _, err := w.Write([]byte{1}); err == nil {
+		t.Error("expected an error writing to a closed Writer")
+	}
+}
+
+func FuzzDecompress(f *testing.F) {
+	f.Add([]byte{})
+	f.Add(Compress([]byte("archon archon archon")))
+	f.Add(CompressBest(make([]byte, 300)))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		out, err := Decompress(data)
+		if err == nil {
+			checkRoundTrip(t, "decompressed", out)
+		}
+	})
+}
+
+func FuzzCompress(f *testing.F) {
+	f.Add([]byte("archon archon archon"))
+	f.Add(make([]byte, 300))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		checkRoundTrip(t, "input", data)
+	})
+}
+
+func BenchmarkCompress(b *testing.B) {
+	_, data := readGolden(b, "ItemPMT.prs")
+	b.SetBytes(int64(len(data)))
+	for i := 0; i < b.N; i++ {
+		Compress(data)
+	}
+}
+
+func BenchmarkDecompress(b *testing.B) {
+	compressed, data := readGolden(b, "ItemPMT.prs")
+	b.SetBytes(int64(len(data)))
+	for i := 0; i < b.N; i++ {
+		Decompress(compressed)
+	}
+}
@@ -0,0 +1,256 @@
+/*
+* Archon PSO Server
+* Copyright (C) 2014 Andrew Rodman
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+* ---------------------------------------------------------------------
+* Headless PSO client for exercising the servers without the game.
+* Conn handles the framing and encryption for a single connection and
+* Session (session.go) scripts the flows across the servers.
+ */
+package psoclient
+
+import (
+	"encoding/binary"
+	"fmt"
+	crypto "github.com/dcrodman/archon/encryption"
+	"github.com/dcrodman/archon/util"
+	"io"
+	"net"
+	"time"
+	"unicode/utf16"
+)
+
+// Default time to wait for the server to respond.
+const DefaultTimeout = 10 * time.Second
+
+// Packet received from the server, header included.
+type Packet struct {
+	Type  uint16
+	Flags uint32
+	Data  []byte
+}
+
+// Parse the packet into target, which should be a pointer to a struct.
+func (p *Packet) Parse(target interface{}) error {
+	return util.StructFromBytes(p.Data, target)
+}
+
+// Conn is a connection to one of the servers. The server sends its welcome
+// packet with the encryption vectors immediately, so the ciphers are set up
+// as part of dialing.
+type Conn struct {
+	conn    net.Conn
+	hdrSize int
+
+	// Cipher for packets we send and the cipher for packets from the server.
+	sendCrypt *crypto.PSOCrypt
+	recvCrypt *crypto.PSOCrypt
+
+	Timeout time.Duration
+}
+
+// Connect to the patch or data server.
+func DialPatch(addr string, timeout time.Duration) (*Conn, error) {
+	c, err := dial(addr, PCHeaderSize, timeout)
+	if err != nil {
+		return nil, err
+	}
+	var welcome PatchWelcomePkt
+	if err = c.recvWelcome(PatchWelcomeType, &welcome); err != nil {
+		c.Close()
+		return nil, err
+	}
+	c.sendCrypt = crypto.NewPCCryptWithVector(welcome.ClientVector[:])
+	c.recvCrypt = crypto.NewPCCryptWithVector(welcome.ServerVector[:])
+	return c, nil
+}
+
+// Connect to a login, character, ship or block server.
+func DialBB(addr string, timeout time.Duration) (*Conn, error) {
+	c, err := dial(addr, BBHeaderSize, timeout)
+	if err != nil {
+		return nil, err
+	}
+	var welcome WelcomePkt
+	if err = c.recvWelcome(LoginWelcomeType, &welcome); err != nil {
+		c.Close()
+		return nil, err
+	}
+	c.sendCrypt = crypto.NewBBCryptWithVector(welcome.ClientVector[:])
+	c.recvCrypt = crypto.NewBBCryptWithVector(welcome.ServerVector[:])
+	return c, nil
+}
+
+func dial(addr string, hdrSize int, timeout time.Duration) (*Conn, error) {
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &Conn{conn: conn, hdrSize: hdrSize, Timeout: timeout}, nil
+}
+
+// The welcome packet is the only one that isn't encrypted.
+func (c *Conn) recvWelcome(pktType uint16, target interface{}) error {
+	pkt, err := c.recv(false)
+	if err != nil {
+		return err
+	}
+	if pkt.Type != pktType {
+		return fmt.Errorf("expected welcome packet %#x, got %#x", pktType, pkt.Type)
+	}
+	return pkt.Parse(target)
+}
+
+func (c *Conn) Close() error { return c.conn.Close() }
+
+// Serialize, encrypt and send a packet. The size field in the header is
+// filled in after padding the packet to a multiple of the header size.
+func (c *Conn) Send(pkt interface{}) error {
+	data, _ := util.BytesFromStruct(pkt)
+	return c.SendBytes(data)
+}
+
+// Encrypt and send an already serialized packet, padding it and setting the
+// size in the header as with Send.
+func (c *Conn) SendBytes(data []byte) error {
+	size := len(data)
+	if size < c.hdrSize {
+		return fmt.Errorf("packet of %d bytes is smaller than the header", size)
+	}
+	data = append([]byte(nil), data...)
+	for size%c.hdrSize != 0 {
+		data = append(data, 0)
+		size++
+	}
+	binary.LittleEndian.PutUint16(data, uint16(size))
+	c.sendCrypt.Encrypt(data, uint32(size))
+
+	c.conn.SetWriteDeadline(time.Now().Add(c.Timeout))
+	_, err := c.conn.Write(data[:size])
+	return err
+}
+
+// Send a packet that consists only of a header.
+func (c *Conn) SendHeader(pktType uint16, flags uint32) error {
+	if c.hdrSize == PCHeaderSize {
+		return c.Send(&PCHeader{Type: pktType})
+	}
+	return c.Send(&BBHeader{Type: pktType, Flags: flags})
+}
+
+func (c *Conn) HeaderSize() int { return c.hdrSize }
+
+// Wait for and decrypt the next packet from the server.
+func (c *Conn) Recv() (*Packet, error) {
+	return c.recv(true)
+}
+
+func (c *Conn) recv(encrypted bool) (*Packet, error) {
+	c.conn.SetReadDeadline(time.Now().Add(c.Timeout))
+	hdr := make([]byte, c.hdrSize)
+	if _, err := io.ReadFull(c.conn, hdr); err != nil {
+		return nil, err
+	}
+	if encrypted {
+		c.recvCrypt.Decrypt(hdr, uint32(c.hdrSize))
+	}
+	size := int(binary.LittleEndian.Uint16(hdr))
+	if size < c.hdrSize {
+		return nil, fmt.Errorf("received packet with invalid size %d", size)
+	}
+	// Packets should already be padded but the welcome packets aren't
+	// always, so only round up the encrypted ones.
+	if encrypted {
+		for size%c.hdrSize != 0 {
+			size++
+		}
+	}
+	data := make([]byte, size)
+	copy(data, hdr)
+	if _, err := io.ReadFull(c.conn, data[c.hdrSize:]); err != nil {
+		return nil, err
+	}
+	if encrypted {
+		c.recvCrypt.Decrypt(data[c.hdrSize:], uint32(size-c.hdrSize))
+	}
+
+	pkt := &Packet{Type: binary.LittleEndian.Uint16(data[2:]), Data: data}
+	if c.hdrSize == BBHeaderSize {
+		pkt.Flags = binary.LittleEndian.Uint32(data[4:])
+	}
+	return pkt, nil
+}
+
+// Receive the next packet and check that it has the expected type. A client
+// message from the server (usually an error) is returned as the error.
+func (c *Conn) Expect(pktType uint16) (*Packet, error) {
+	return c.ExpectSkipping(pktType)
+}
+
+// Like Expect, but first drop any packets of the types in skip, which the
+// server can send at any time (e.g. other players coming and going).
+func (c *Conn) ExpectSkipping(pktType uint16, skip ...uint16) (*Packet, error) {
+	pkt, err := c.Recv()
+	for err == nil && pkt.Type != pktType && containsType(skip, pkt.Type) {
+		pkt, err = c.Recv()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("waiting for packet %#x: %v", pktType, err)
+	}
+	if pkt.Type != pktType {
+		if c.hdrSize == BBHeaderSize && pkt.Type == LoginClientMessageType && len(pkt.Data) > 12 {
+			return nil, fmt.Errorf("expected packet %#x, got message: %s",
+				pktType, decodeUtf16(pkt.Data[12:]))
+		}
+		return nil, fmt.Errorf("expected packet %#x, got %#x", pktType, pkt.Type)
+	}
+	return pkt, nil
+}
+
+func containsType(types []uint16, pktType uint16) bool {
+	for _, t := range types {
+		if t == pktType {
+			return true
+		}
+	}
+	return false
+}
+
+// Receive a packet of type pktType and parse it into target.
+func (c *Conn) ExpectParse(pktType uint16, target interface{}) (*Packet, error) {
+	pkt, err := c.Expect(pktType)
+	if err == nil {
+		err = pkt.Parse(target)
+	}
+	return pkt, err
+}
+
+// Decode a NUL terminated UTF-16LE string from the server, dropping any
+// byte order mark.
+func decodeUtf16(data []byte) string {
+	var chars []uint16
+	for i := 0; i+1 < len(data); i += 2 {
+		ch := uint16(data[i]) | uint16(data[i+1])<<8
+		if ch == 0 {
+			break
+		} else if ch != 0xFEFF {
+			chars = append(chars, ch)
+		}
+	}
+	return string(utf16.Decode(chars))
+}
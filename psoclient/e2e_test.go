@@ -0,0 +1,334 @@
+//go:build e2e
+// +build e2e
+
+/*
+* Archon PSO Server
+* Copyright (C) 2014 Andrew Rodman
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+* ---------------------------------------------------------------------
+* End-to-end tests run against a live server. Build with -tags e2e and
+* point them at the server with the ARCHON_E2E_* variables below; the
+* account must already exist and be active. tools/e2e.sh sets up and
+* starts a server backed by SQLite for this.
+ */
+package psoclient
+
+import (
+	"github.com/dcrodman/archon/util"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func env(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+var (
+	host      = env("ARCHON_E2E_HOST", "127.0.0.1")
+	patchAddr = net.JoinHostPort(host, env("ARCHON_E2E_PATCH_PORT", "11000"))
+	loginAddr = net.JoinHostPort(host, env("ARCHON_E2E_LOGIN_PORT", "12000"))
+	username  = env("ARCHON_E2E_USERNAME", "e2etest")
+	password  = env("ARCHON_E2E_PASSWORD", "e2etest")
+)
+
+// Give the server a few seconds to come up in case it was just started.
+func TestMain(m *testing.M) {
+	for i := 0; i < 50; i++ {
+		if conn, err := net.Dial("tcp", loginAddr); err == nil {
+			conn.Close()
+			break
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	os.Exit(m.Run())
+}
+
+func TestPatch(t *testing.T) {
+	s := NewSession(username, password)
+	result, err := s.Patch(patchAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Checked) == 0 {
+		t.Fatal("server didn't ask us to check any files")
+	}
+	// We didn't report having anything so every file should be sent.
+	for _, file := range result.Checked {
+		if _, ok := result.Updated[file]; !ok {
+			t.Errorf("%s was checked but not sent", file)
+		}
+	}
+
+	// Once we're up to date nothing should be sent.
+	s.PatchFiles = result.Updated
+	if result, err = s.Patch(patchAddr); err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Updated) != 0 {
+		t.Errorf("expected no updates, got %d files", len(result.Updated))
+	}
+}
+
+func TestLoginBadPassword(t *testing.T) {
+	s := NewSession(username, password+"x")
+	if err := s.Login(loginAddr); err == nil {
+		t.Fatal("logged in with the wrong password")
+	}
+}
+
+func TestLoginToLobby(t *testing.T) {
+	const slot = 0
+	s := NewSession(username, password)
+	if err := s.Login(loginAddr); err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	if s.Guildcard == 0 {
+		t.Error("no guildcard number in the security packet")
+	}
+
+	c, err := s.CharacterLogin()
+	if err != nil {
+		t.Fatalf("character login: %v", err)
+	}
+	defer c.Close()
+	if err = s.LoadCharacters(c); err != nil {
+		t.Fatalf("loading characters: %v", err)
+	}
+	char := &CharacterPreview{Class: 0x03, SectionId: 2, Costume: 1, HairRed: 0xFF, PropX: 0.5}
+	copy(char.Name[:], util.ConvertToUtf16("\tEE2E"))
+	if err = s.CreateCharacter(c, slot, char); err != nil {
+		t.Fatalf("creating character: %v", err)
+	}
+	if err = s.LoadCharacters(c); err != nil {
+		t.Fatalf("reloading characters: %v", err)
+	}
+	if s.Characters[slot] == nil {
+		t.Fatal("created character is missing from the preview")
+	} else if s.Characters[slot].Name != char.Name || s.Characters[slot].Class != char.Class {
+		t.Errorf("preview doesn't match the created character: %+v", s.Characters[slot])
+	}
+	if err = s.SelectCharacter(c, slot); err != nil {
+		t.Fatalf("selecting character: %v", err)
+	}
+	if len(s.ParamEntries) == 0 || len(s.GuildcardData) == 0 {
+		t.Error("missing parameter or guildcard data")
+	}
+
+	if err = s.ShipSelect(slot); err != nil {
+		t.Fatalf("ship select: %v", err)
+	}
+	if err = s.SelectBlock(slot, 1); err != nil {
+		t.Fatalf("block select: %v", err)
+	}
+	if len(s.Blocks) < 2 || s.Blocks[len(s.Blocks)-1].BlockId != BackMenuItem {
+		t.Errorf("unexpected block list %+v", s.Blocks)
+	}
+	block, err := s.JoinBlock(slot)
+	if err != nil {
+		t.Fatalf("joining block: %v", err)
+	}
+	defer block.Close()
+	if len(s.Lobbies) == 0 {
+		t.Error("empty lobby list")
+	}
+}
+
+// The server should refuse a client config that it didn't sign, here one
+// claiming that a character has already been selected.
+func TestForgedSession(t *testing.T) {
+	s := NewSession(username, password)
+	if err := s.Login(loginAddr); err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	s.Config[4] = 1
+	if err := s.ShipSelect(0); err == nil {
+		t.Fatal("reached ship select with a forged config")
+	}
+}
+
+func TestDressingRoom(t *testing.T) {
+	const slot = 1
+	s := NewSession(username, password)
+	if err := s.Login(loginAddr); err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	c, err := s.CharacterLogin()
+	if err != nil {
+		t.Fatalf("character login: %v", err)
+	}
+	defer c.Close()
+	char := &CharacterPreview{Class: 0x01, SectionId: 5, Costume: 1}
+	copy(char.Name[:], util.ConvertToUtf16("\tEDresser"))
+	if err = s.CreateCharacter(c, slot, char); err != nil {
+		t.Fatalf("creating character: %v", err)
+	}
+
+	changed := *char
+	changed.Costume, changed.HairBlue = 2, 0x80
+	copy(changed.Name[:], util.ConvertToUtf16("\tERedressed"))
+	if err = s.ModifyCharacter(c, slot, &changed); err != nil {
+		t.Fatalf("modifying character: %v", err)
+	}
+	if err = s.LoadCharacters(c); err != nil {
+		t.Fatalf("reloading characters: %v", err)
+	}
+	if prev := s.Characters[slot]; prev == nil || prev.Name != changed.Name ||
+		prev.Costume != 2 || prev.HairBlue != 0x80 {
+		t.Errorf("preview doesn't match the modified character: %+v", prev)
+	}
+
+	// Changing it again right away should be refused.
+	changed.Costume = 3
+	if err = s.ModifyCharacter(c, slot, &changed); err == nil {
+		t.Error("modified the character twice in a row")
+	}
+}
+
+func TestDeleteCharacter(t *testing.T) {
+	const slot = 2
+	s := NewSession(username, password)
+	if err := s.Login(loginAddr); err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	c, err := s.CharacterLogin()
+	if err != nil {
+		t.Fatalf("character login: %v", err)
+	}
+	defer c.Close()
+	char := &CharacterPreview{Class: 0x02, SectionId: 3, Costume: 1}
+	copy(char.Name[:], util.ConvertToUtf16("\tEDoomed"))
+	if err = s.CreateCharacter(c, slot, char); err != nil {
+		t.Fatalf("creating character: %v", err)
+	}
+	if err = s.DeleteCharacter(c, slot); err != nil {
+		t.Fatalf("deleting character: %v", err)
+	}
+	if err = s.LoadCharacters(c); err != nil {
+		t.Fatalf("reloading characters: %v", err)
+	}
+	if prev := s.Characters[slot]; prev != nil {
+		t.Errorf("slot %d still has a character after deleting it: %+v", slot, prev)
+	}
+}
+
+// Log in with the character in slot and go through to a lobby on block 1.
+func enterLobby(t *testing.T, slot int) (*Session, *Conn) {
+	s := NewSession(username, password)
+	if err := s.Login(loginAddr); err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	c, err := s.CharacterLogin()
+	if err != nil {
+		t.Fatalf("character login: %v", err)
+	}
+	defer c.Close()
+	if err = s.SelectCharacter(c, slot); err != nil {
+		t.Fatalf("selecting character: %v", err)
+	}
+	if err = s.ShipSelect(slot); err != nil {
+		t.Fatalf("ship select: %v", err)
+	}
+	if err = s.SelectBlock(slot, 1); err != nil {
+		t.Fatalf("block select: %v", err)
+	}
+	block, err := s.JoinBlock(slot)
+	if err != nil {
+		t.Fatalf("joining block: %v", err)
+	}
+	return s, block
+}
+
+func TestGames(t *testing.T) {
+	const slot = 3
+	s := NewSession(username, password)
+	if err := s.Login(loginAddr); err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	c, err := s.CharacterLogin()
+	if err != nil {
+		t.Fatalf("character login: %v", err)
+	}
+	char := &CharacterPreview{Class: 0x00, SectionId: 1, Costume: 1}
+	copy(char.Name[:], util.ConvertToUtf16("\tEPartier"))
+	err = s.CreateCharacter(c, slot, char)
+	c.Close()
+	if err != nil {
+		t.Fatalf("creating character: %v", err)
+	}
+
+	host, hostConn := enterLobby(t, slot)
+	defer hostConn.Close()
+	game, err := host.CreateGame(hostConn, "E2E Game", "secret", 1, 0)
+	if err != nil {
+		t.Fatalf("creating game: %v", err)
+	}
+	if game.ClientId != 0 || game.Episode != 1 || len(host.Players) != 1 {
+		t.Errorf("unexpected game state %+v with players %+v", game, host.Players)
+	}
+
+	guest, guestConn := enterLobby(t, slot)
+	defer guestConn.Close()
+	games, err := guest.ListGames(guestConn)
+	if err != nil {
+		t.Fatalf("listing games: %v", err)
+	}
+	var listed *GameListEntry
+	for i := range games {
+		if util.ConvertFromUtf16(games[i].Name[:]) == "E2E Game" {
+			listed = &games[i]
+		}
+	}
+	if listed == nil {
+		t.Fatalf("created game is missing from the list %+v", games)
+	} else if listed.Players != 1 || listed.Flags&0x02 == 0 {
+		t.Errorf("unexpected game list entry %+v", listed)
+	}
+
+	if _, err = guest.JoinGame(guestConn, listed.GameId, "wrong"); err == nil {
+		t.Fatal("joined a game with the wrong password")
+	}
+	if game, err = guest.JoinGame(guestConn, listed.GameId, "secret"); err != nil {
+		t.Fatalf("joining game: %v", err)
+	}
+	if game.ClientId != 1 || game.LeaderId != 0 || len(guest.Players) != 2 {
+		t.Errorf("unexpected game state %+v with players %+v", game, guest.Players)
+	}
+	if _, err = hostConn.Expect(GameAddMemberType); err != nil {
+		t.Errorf("host wasn't told about the guest: %v", err)
+	}
+
+	if err = guest.LeaveGame(guestConn); err != nil {
+		t.Fatalf("leaving game: %v", err)
+	}
+	if _, err = hostConn.Expect(GameRemoveMemberType); err != nil {
+		t.Errorf("host wasn't told that the guest left: %v", err)
+	}
+	if err = host.LeaveGame(hostConn); err != nil {
+		t.Fatalf("leaving game: %v", err)
+	}
+	if games, err = guest.ListGames(guestConn); err != nil {
+		t.Fatalf("listing games: %v", err)
+	}
+	for _, g := range games {
+		if g.GameId == listed.GameId {
+			t.Error("game is still listed after everyone left")
+		}
+	}
+}
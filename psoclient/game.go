@@ -0,0 +1,132 @@
+/*
+* Archon PSO Server
+* Copyright (C) 2014 Andrew Rodman
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+* ---------------------------------------------------------------------
+* Moving between the lobbies and games on a block once JoinBlock has put
+* the player in a lobby.
+ */
+package psoclient
+
+import (
+	"github.com/dcrodman/archon/util"
+)
+
+// Menu ID the server uses for the game list.
+const GameMenuId = 0x0F
+
+// Notices about the other players, which the server sends whenever someone
+// comes or goes.
+var memberNotices = []uint16{LobbyAddMemberType, LobbyRemoveMemberType,
+	GameAddMemberType, GameRemoveMemberType}
+
+const playerHeaderSize = 0x44
+
+// Wait to be put in a lobby and record who's in it.
+func (s *Session) expectLobbyJoin(c *Conn) error {
+	var hdr LobbyJoinHeader
+	pkt, err := c.ExpectSkipping(LobbyJoinType, memberNotices...)
+	if err == nil {
+		err = pkt.Parse(&hdr)
+	}
+	if err != nil {
+		return err
+	}
+	s.ClientId, s.Players = hdr.ClientId, nil
+	for offset := BBHeaderSize + 12; offset+playerHeaderSize <= len(pkt.Data); offset += playerHeaderSize {
+		var player PlayerHeader
+		util.StructFromBytes(pkt.Data[offset:], &player)
+		s.Players = append(s.Players, player)
+	}
+	return nil
+}
+
+// Wait for the state of the game we're joining and record who's in it.
+func (s *Session) expectGameJoin(c *Conn) (*GameJoinPacket, error) {
+	var pkt GameJoinPacket
+	p, err := c.ExpectSkipping(GameJoinType, append(memberNotices, RareEnemyListType)...)
+	if err == nil {
+		err = p.Parse(&pkt)
+	}
+	if err != nil {
+		return nil, err
+	}
+	players := int(p.Flags)
+	if players > len(pkt.Players) {
+		players = len(pkt.Players)
+	}
+	s.ClientId, s.Players = pkt.ClientId, pkt.Players[:players]
+	return &pkt, nil
+}
+
+// Create a game from the lobby and join it. The episode is 1, 2, or 3 for
+// Episode 4.
+func (s *Session) CreateGame(c *Conn, name, password string, episode, difficulty uint8) (*GameJoinPacket, error) {
+	pkt := &CreateGamePacket{
+		Header:     BBHeader{Type: CreateGameType},
+		Difficulty: difficulty,
+		Episode:    episode,
+	}
+	copy(pkt.Name[:len(pkt.Name)-2], util.ConvertToUtf16(name))
+	copy(pkt.Password[:len(pkt.Password)-2], util.ConvertToUtf16(password))
+	if err := c.Send(pkt); err != nil {
+		return nil, err
+	}
+	return s.expectGameJoin(c)
+}
+
+// Returns the games on the block, without the title entry at the top of
+// the list.
+func (s *Session) ListGames(c *Conn) ([]GameListEntry, error) {
+	if err := c.SendHeader(GameListType, 0); err != nil {
+		return nil, err
+	}
+	pkt, err := c.ExpectSkipping(GameListType, memberNotices...)
+	if err != nil {
+		return nil, err
+	}
+	const entrySize = 44
+	var games []GameListEntry
+	for offset := BBHeaderSize + entrySize; offset+entrySize <= len(pkt.Data); offset += entrySize {
+		var game GameListEntry
+		util.StructFromBytes(pkt.Data[offset:], &game)
+		games = append(games, game)
+	}
+	return games, nil
+}
+
+// Join a game from the game list.
+func (s *Session) JoinGame(c *Conn, gameId uint32, password string) (*GameJoinPacket, error) {
+	data, _ := util.BytesFromStruct(&MenuSelectionPacket{
+		Header: BBHeader{Type: MenuSelectType},
+		MenuId: GameMenuId,
+		ItemId: gameId,
+	})
+	if password != "" {
+		data = append(data, util.ConvertToUtf16(password)...)
+	}
+	if err := c.SendBytes(data); err != nil {
+		return nil, err
+	}
+	return s.expectGameJoin(c)
+}
+
+// Leave the game and go back to a lobby.
+func (s *Session) LeaveGame(c *Conn) error {
+	if err := c.SendHeader(LeaveGameType, 0); err != nil {
+		return err
+	}
+	return s.expectLobbyJoin(c)
+}
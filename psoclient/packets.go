@@ -0,0 +1,438 @@
+/*
+* Archon PSO Server
+* Copyright (C) 2014 Andrew Rodman
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+* ---------------------------------------------------------------------
+* Packet definitions used by the test client. These mirror the ones in
+* the server's pkt_defs.go, which can't be imported from package main.
+ */
+package psoclient
+
+import "strings"
+
+const (
+	PCHeaderSize = 0x04
+	BBHeaderSize = 0x08
+
+	// Version string the login server expects in the security data.
+	ClientVersionString = "TethVer12510"
+	// Menu ID used by the server for the ship selection menu.
+	ShipSelectionMenuId = 0x13
+	// Block menu item for returning to the ship select screen.
+	BackMenuItem = 0xFF
+	// Maximum size of a block of parameter or guildcard data.
+	MaxChunkSize = 0x6800
+)
+
+// Packet types sent to and from the patch and data servers.
+const (
+	PatchWelcomeType        = 0x02
+	PatchLoginType          = 0x04
+	PatchMessageType        = 0x13
+	PatchRedirectType       = 0x14
+	PatchDataAckType        = 0x0B
+	PatchDirAboveType       = 0x0A
+	PatchChangeDirType      = 0x09
+	PatchCheckFileType      = 0x0C
+	PatchFileListDoneType   = 0x0D
+	PatchFileStatusType     = 0x0F
+	PatchClientListDoneType = 0x10
+	PatchUpdateFilesType    = 0x11
+	PatchFileHeaderType     = 0x06
+	PatchFileChunkType      = 0x07
+	PatchFileCompleteType   = 0x08
+	PatchUpdateCompleteType = 0x12
+)
+
+// Packet types sent to and from the login, character, ship and block servers.
+const (
+	LoginWelcomeType            = 0x03
+	LoginType                   = 0x93
+	LoginSecurityType           = 0xE6
+	LoginClientMessageType      = 0x1A
+	LoginOptionsRequestType     = 0xE0
+	LoginOptionsType            = 0xE2
+	LoginCharPreviewReqType     = 0xE3
+	LoginCharAckType            = 0xE4
+	LoginCharPreviewType        = 0xE5
+	LoginChecksumType           = 0x01E8
+	LoginChecksumAckType        = 0x02E8
+	LoginGuildcardReqType       = 0x03E8
+	LoginGuildcardHeaderType    = 0x01DC
+	LoginGuildcardChunkType     = 0x02DC
+	LoginGuildcardChunkReqType  = 0x03DC
+	LoginParameterHeaderType    = 0x01EB
+	LoginParameterChunkType     = 0x02EB
+	LoginParameterChunkReqType  = 0x03EB
+	LoginParameterHeaderReqType = 0x04EB
+	LoginSetFlagType            = 0xEC
+	LoginTimestampType          = 0xB1
+	LoginShipListType           = 0xA0
+	LoginScrollMessageType      = 0xEE
+	BlockListType               = 0x07
+	LobbyListType               = 0x83
+	DisconnectType              = 0x05
+	RedirectType                = 0x19
+	MenuSelectType              = 0x10
+	PingType                    = 0x1D
+	LobbyJoinType               = 0x67
+	LobbyAddMemberType          = 0x68
+	LobbyRemoveMemberType       = 0x69
+	GameListType                = 0x08
+	GameJoinType                = 0x64
+	GameAddMemberType           = 0x65
+	GameRemoveMemberType        = 0x66
+	LeaveGameType               = 0x98
+	CreateGameType              = 0xC1
+	RareEnemyListType           = 0xDE
+)
+
+type PCHeader struct {
+	Size uint16
+	Type uint16
+}
+
+type BBHeader struct {
+	Size  uint16
+	Type  uint16
+	Flags uint32
+}
+
+type PatchWelcomePkt struct {
+	Header       PCHeader
+	Copyright    [44]byte
+	Padding      [20]byte
+	ServerVector [4]byte
+	ClientVector [4]byte
+}
+
+// Login sent to the patch and data servers. Neither checks the credentials.
+type PatchLoginPkt struct {
+	Header   PCHeader
+	Padding  [12]byte
+	Username [16]byte
+	Password [16]byte
+	Padding2 [64]byte
+}
+
+type PatchRedirectPacket struct {
+	Header  PCHeader
+	IPAddr  [4]uint8
+	Port    uint16 // Big endian
+	Padding uint16
+}
+
+type ChangeDirPacket struct {
+	Header  PCHeader
+	Dirname [64]byte
+}
+
+type CheckFilePacket struct {
+	Header   PCHeader
+	PatchId  uint32
+	Filename [32]byte
+}
+
+type FileStatusPacket struct {
+	Header   PCHeader
+	PatchId  uint32
+	Checksum uint32
+	FileSize uint32
+}
+
+type UpdateFilesPacket struct {
+	Header    PCHeader
+	TotalSize uint32
+	NumFiles  uint32
+}
+
+type FileHeaderPacket struct {
+	Header   PCHeader
+	Padding  uint32
+	FileSize uint32
+	Filename [48]byte
+}
+
+type FileChunkHeader struct {
+	Header   PCHeader
+	Chunk    uint32
+	Checksum uint32
+	Size     uint32
+}
+
+type WelcomePkt struct {
+	Header       BBHeader
+	Copyright    [96]byte
+	ServerVector [48]byte
+	ClientVector [48]byte
+}
+
+type LoginPkt struct {
+	Header        BBHeader
+	Unknown       [8]byte
+	ClientVersion uint16
+	Unknown2      [3]byte
+	SlotNum       int8
+	Phase         uint16
+	TeamId        uint32
+	Username      [16]byte
+	Padding       [32]byte
+	Password      [16]byte
+	Unknown3      [40]byte
+	HardwareInfo  [8]byte
+	Security      [40]byte
+}
+
+// Size of the client config the server sends in the security packet.
+const ClientConfigSize = 40
+
+type SecurityPacket struct {
+	Header       BBHeader
+	ErrorCode    uint32
+	PlayerTag    uint32
+	Guildcard    uint32
+	TeamId       uint32
+	Config       [ClientConfigSize]byte
+	Capabilities uint32
+}
+
+type RedirectPacket struct {
+	Header  BBHeader
+	IPAddr  [4]uint8
+	Port    uint16
+	Padding uint16
+}
+
+type OptionsPacket struct {
+	Header    BBHeader
+	Unknown   [0x114]uint8
+	KeyConfig [0x16C]uint8
+	Joystick  [0x38]uint8
+	Guildcard uint32
+	TeamId    uint32
+}
+
+type CharSelectionPacket struct {
+	Header    BBHeader
+	Slot      uint32
+	Selecting uint32
+}
+
+type CharAckPacket struct {
+	Header BBHeader
+	Slot   uint32
+	Flag   uint32
+}
+
+type ChecksumPacket struct {
+	Header   BBHeader
+	Checksum uint32
+}
+
+type ChecksumAckPacket struct {
+	Header BBHeader
+	Ack    uint32
+}
+
+type GuildcardHeaderPacket struct {
+	Header   BBHeader
+	Unknown  uint32
+	Length   uint16
+	Padding  uint16
+	Checksum uint32
+}
+
+type GuildcardChunkReqPacket struct {
+	Header         BBHeader
+	Unknown        uint32
+	ChunkRequested uint32
+	Continue       uint32
+}
+
+type ChunkHeader struct {
+	Header BBHeader
+	Chunk  uint32
+}
+
+type GuildcardChunkHeader struct {
+	Header  BBHeader
+	Unknown uint32
+	Chunk   uint32
+}
+
+// One entry in the parameter header packet.
+type ParameterEntry struct {
+	Size     uint32
+	Checksum uint32
+	Offset   uint32
+	Filename [0x40]uint8
+}
+
+type SetFlagPacket struct {
+	Header BBHeader
+	Flag   uint32
+}
+
+// Basic details about a character, sent both ways on the character server.
+type CharacterPreview struct {
+	Experience     uint32
+	Level          uint32
+	GuildcardStr   [16]byte
+	Unknown        [2]uint32
+	NameColor      uint32
+	Model          byte
+	Padding        [15]byte
+	NameColorChksm uint32
+	SectionId      byte
+	Class          byte
+	V2flags        byte
+	Version        byte
+	V1Flags        uint32
+	Costume        uint16
+	Skin           uint16
+	Face           uint16
+	Head           uint16
+	Hair           uint16
+	HairRed        uint16
+	HairGreen      uint16
+	HairBlue       uint16
+	PropX          float32
+	PropY          float32
+	Name           [24]uint8
+	Playtime       uint32
+}
+
+// Character name without the language prefix (e.g. "\tE").
+func (p *CharacterPreview) DisplayName() string {
+	name := decodeUtf16(p.Name[:])
+	if strings.HasPrefix(name, "\t") && len(name) >= 2 {
+		name = name[2:]
+	}
+	return name
+}
+
+type CharPreviewPacket struct {
+	Header    BBHeader
+	Slot      uint32
+	Character CharacterPreview
+}
+
+type ShipListHeader struct {
+	Header     BBHeader
+	Padding    uint16
+	Unknown    uint16
+	Unknown2   uint32
+	Unknown3   uint16
+	ServerName [36]byte
+}
+
+type ShipMenuEntry struct {
+	MenuId   uint16
+	ShipId   uint32
+	Padding  uint16
+	Shipname [23]byte
+}
+
+type MenuSelectionPacket struct {
+	Header  BBHeader
+	Unknown uint16
+	MenuId  uint16
+	ItemId  uint32
+}
+
+type BlockListHeader struct {
+	Header   BBHeader
+	Padding  [10]byte
+	ShipName [32]byte
+	Unknown  uint32
+}
+
+type Block struct {
+	Unknown   uint16
+	BlockId   uint32
+	Padding   uint16
+	BlockName [36]byte
+}
+
+type Lobby struct {
+	MenuId  uint32
+	LobbyId uint32
+	Padding uint32
+}
+
+// Description of a player in a lobby or game.
+type PlayerHeader struct {
+	Tag       uint32
+	Guildcard uint32
+	TeamId    uint32
+	Unknown   [4]uint32
+	ClientId  uint32
+	Name      [32]byte
+	Unknown2  uint32
+}
+
+// Lobby join packet without the player headers that follow it.
+type LobbyJoinHeader struct {
+	Header   BBHeader
+	ClientId uint8
+	LeaderId uint8
+	Unknown  uint8
+	LobbyNum uint8
+	BlockNum uint16
+	Event    uint16
+	Padding  uint32
+}
+
+type CreateGamePacket struct {
+	Header       BBHeader
+	Unused       [2]uint32
+	Name         [32]byte
+	Password     [32]byte
+	Difficulty   uint8
+	Battle       uint8
+	Challenge    uint8
+	Episode      uint8
+	SinglePlayer uint8
+	Padding      [3]uint8
+}
+
+type GameJoinPacket struct {
+	Header       BBHeader
+	Variations   [0x20]uint32
+	Players      [4]PlayerHeader
+	ClientId     uint8
+	LeaderId     uint8
+	Unknown      uint8
+	Difficulty   uint8
+	Battle       uint8
+	Event        uint8
+	SectionId    uint8
+	Challenge    uint8
+	RandomSeed   uint32
+	Episode      uint8
+	Unknown2     uint8
+	SinglePlayer uint8
+	Unused       uint8
+}
+
+type GameListEntry struct {
+	MenuId     uint32
+	GameId     uint32
+	Difficulty uint8
+	Players    uint8
+	Name       [32]byte
+	Episode    uint8
+	Flags      uint8
+}
@@ -0,0 +1,634 @@
+/*
+* Archon PSO Server
+* Copyright (C) 2014 Andrew Rodman
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+* ---------------------------------------------------------------------
+* Scripted flows through the servers in the order the game client goes
+* through them: patch check, login, character selection or creation,
+* ship selection and block selection. Lobbies and games are in game.go.
+ */
+package psoclient
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/dcrodman/archon/util"
+	"hash/crc32"
+	"net"
+	"path"
+	"strconv"
+	"time"
+)
+
+// Results of a patch check.
+type PatchResult struct {
+	Message string
+	// Paths of every file the server asked us to check.
+	Checked []string
+	// Contents of each file the server sent, keyed by path.
+	Updated map[string][]byte
+}
+
+// Session tracks a player's progress across the servers. Each step leaves
+// the address of the next server to connect to in Next.
+type Session struct {
+	Username string
+	Password string
+	Timeout  time.Duration
+	// Checksums to report for files during the patch check, keyed by path
+	// (e.g. "./data/file.txt"). Any file not present is reported as missing.
+	PatchFiles map[string][]byte
+
+	Guildcard uint32
+	TeamId    uint32
+	// Client config from the last security packet; sent back on each login.
+	Config [ClientConfigSize]byte
+	// Address of the next server to connect to from the last redirect.
+	Next string
+
+	Characters [4]*CharacterPreview
+	Ships      []ShipMenuEntry
+	Blocks     []Block
+	Lobbies    []Lobby
+	// Client id in the current lobby or game and the players in it.
+	ClientId uint8
+	Players  []PlayerHeader
+	// Guildcard and parameter data received from the character server.
+	GuildcardData []byte
+	Parameters    []byte
+	ParamEntries  []ParameterEntry
+}
+
+func NewSession(username, password string) *Session {
+	return &Session{Username: username, Password: password, Timeout: DefaultTimeout}
+}
+
+// Run the patch check against the patch server, follow the redirect to the
+// data server and download any files that don't match PatchFiles.
+func (s *Session) Patch(addr string) (*PatchResult, error) {
+	c, err := s.patchLogin(addr)
+	if err != nil {
+		return nil, err
+	}
+	result := &PatchResult{Updated: make(map[string][]byte)}
+	pkt, err := c.Expect(PatchMessageType)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	result.Message = decodeUtf16(pkt.Data[PCHeaderSize:])
+	var redirect PatchRedirectPacket
+	_, err = c.ExpectParse(PatchRedirectType, &redirect)
+	c.Close()
+	if err != nil {
+		return nil, err
+	}
+	port := binary.BigEndian.Uint16([]byte{byte(redirect.Port), byte(redirect.Port >> 8)})
+	s.Next = redirectAddr(redirect.IPAddr, port)
+
+	if c, err = s.patchLogin(s.Next); err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	if _, err = c.Expect(PatchDataAckType); err != nil {
+		return nil, err
+	}
+	if err = s.checkFiles(c, result); err != nil {
+		return nil, err
+	}
+	return result, s.downloadFiles(c, result)
+}
+
+// Connect and perform the handshake used by both the patch and data servers.
+func (s *Session) patchLogin(addr string) (*Conn, error) {
+	c, err := DialPatch(addr, s.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	err = c.SendHeader(PatchWelcomeType, 0)
+	if err == nil {
+		_, err = c.Expect(PatchLoginType)
+	}
+	if err == nil {
+		pkt := &PatchLoginPkt{Header: PCHeader{Type: PatchLoginType}}
+		copy(pkt.Username[:], s.Username)
+		copy(pkt.Password[:], s.Password)
+		err = c.Send(pkt)
+	}
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Respond to the server's list of files to check.
+func (s *Session) checkFiles(c *Conn, result *PatchResult) error {
+	var dirs []string
+	var statuses []*FileStatusPacket
+	for {
+		pkt, err := c.Recv()
+		if err != nil {
+			return err
+		}
+		switch pkt.Type {
+		case PatchChangeDirType:
+			var cd ChangeDirPacket
+			if err = pkt.Parse(&cd); err != nil {
+				return err
+			}
+			dirs = append(dirs, string(util.StripPadding(cd.Dirname[:])))
+		case PatchDirAboveType:
+			if len(dirs) == 0 {
+				return errors.New("server moved above the top level directory")
+			}
+			dirs = dirs[:len(dirs)-1]
+		case PatchCheckFileType:
+			var check CheckFilePacket
+			if err = pkt.Parse(&check); err != nil {
+				return err
+			}
+			file := path.Join(append(dirs, string(util.StripPadding(check.Filename[:])))...)
+			result.Checked = append(result.Checked, file)
+			status := &FileStatusPacket{Header: PCHeader{Type: PatchFileStatusType}, PatchId: check.PatchId}
+			if data, ok := s.PatchFiles[file]; ok {
+				status.Checksum = crc32.ChecksumIEEE(data)
+				status.FileSize = uint32(len(data))
+			}
+			statuses = append(statuses, status)
+		case PatchFileListDoneType:
+			for _, status := range statuses {
+				if err = c.Send(status); err != nil {
+					return err
+				}
+			}
+			return c.SendHeader(PatchClientListDoneType, 0)
+		default:
+			return fmt.Errorf("unexpected packet %#x during file check", pkt.Type)
+		}
+	}
+}
+
+// Receive the files the server decided we need.
+func (s *Session) downloadFiles(c *Conn, result *PatchResult) error {
+	var dirs []string
+	var file string
+	var expected uint32
+	for {
+		pkt, err := c.Recv()
+		if err != nil {
+			return err
+		}
+		switch pkt.Type {
+		case PatchUpdateFilesType, PatchFileCompleteType:
+			if pkt.Type == PatchFileCompleteType && uint32(len(result.Updated[file])) != expected {
+				return fmt.Errorf("received %d bytes of %s, expected %d",
+					len(result.Updated[file]), file, expected)
+			}
+		case PatchChangeDirType:
+			var cd ChangeDirPacket
+			if err = pkt.Parse(&cd); err != nil {
+				return err
+			}
+			dirs = append(dirs, string(util.StripPadding(cd.Dirname[:])))
+		case PatchDirAboveType:
+			if len(dirs) > 0 {
+				dirs = dirs[:len(dirs)-1]
+			}
+		case PatchFileHeaderType:
+			var hdr FileHeaderPacket
+			if err = pkt.Parse(&hdr); err != nil {
+				return err
+			}
+			file = path.Join(append(dirs, string(util.StripPadding(hdr.Filename[:])))...)
+			expected = hdr.FileSize
+			result.Updated[file] = []byte{}
+		case PatchFileChunkType:
+			var chunk FileChunkHeader
+			if err = pkt.Parse(&chunk); err != nil {
+				return err
+			}
+			start := PCHeaderSize + 12
+			if start+int(chunk.Size) > len(pkt.Data) {
+				return fmt.Errorf("chunk %d of %s is truncated", chunk.Chunk, file)
+			}
+			result.Updated[file] = append(result.Updated[file], pkt.Data[start:start+int(chunk.Size)]...)
+		case PatchUpdateCompleteType:
+			return nil
+		default:
+			return fmt.Errorf("unexpected packet %#x during file update", pkt.Type)
+		}
+	}
+}
+
+// Build a login packet with our credentials and the current client config.
+func (s *Session) loginPacket(slot int8, phase uint16) *LoginPkt {
+	pkt := &LoginPkt{
+		Header:  BBHeader{Type: LoginType},
+		SlotNum: slot,
+		Phase:   phase,
+		TeamId:  s.TeamId,
+	}
+	copy(pkt.Username[:], s.Username)
+	copy(pkt.Password[:], s.Password)
+	copy(pkt.Security[:], s.Config[:])
+	return pkt
+}
+
+// Send a login packet on a new connection and wait for the security packet.
+func (s *Session) bbLogin(addr string, pkt *LoginPkt) (*Conn, error) {
+	c, err := DialBB(addr, s.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	if err = c.Send(pkt); err == nil {
+		err = s.expectSecurity(c)
+	}
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (s *Session) expectSecurity(c *Conn) error {
+	var security SecurityPacket
+	if _, err := c.ExpectParse(LoginSecurityType, &security); err != nil {
+		return err
+	}
+	if security.ErrorCode != 0 {
+		return fmt.Errorf("login failed with error code %d", security.ErrorCode)
+	}
+	s.Guildcard = security.Guildcard
+	s.TeamId = security.TeamId
+	s.Config = security.Config
+	return nil
+}
+
+func (s *Session) expectRedirect(c *Conn) error {
+	var redirect RedirectPacket
+	if _, err := c.ExpectParse(RedirectType, &redirect); err != nil {
+		return err
+	}
+	s.Next = redirectAddr(redirect.IPAddr, redirect.Port)
+	return nil
+}
+
+func redirectAddr(ip [4]byte, port uint16) string {
+	return net.JoinHostPort(net.IP(ip[:]).String(), strconv.Itoa(int(port)))
+}
+
+// Log in to the login server, which redirects us to the character server.
+func (s *Session) Login(addr string) error {
+	pkt := s.loginPacket(-1, 0)
+	copy(pkt.Security[:], ClientVersionString)
+	c, err := s.bbLogin(addr, pkt)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	return s.expectRedirect(c)
+}
+
+// Connect to the character server for selecting or creating a character.
+// The returned connection is used with the Character* methods.
+func (s *Session) CharacterLogin() (*Conn, error) {
+	return s.bbLogin(s.Next, s.loginPacket(-1, 0))
+}
+
+// Request the key config and load the previews for each of the slots.
+func (s *Session) LoadCharacters(c *Conn) error {
+	if err := c.SendHeader(LoginOptionsRequestType, 0); err != nil {
+		return err
+	}
+	var options OptionsPacket
+	if _, err := c.ExpectParse(LoginOptionsType, &options); err != nil {
+		return err
+	}
+	if options.Guildcard != s.Guildcard {
+		return fmt.Errorf("options are for guildcard %d, expected %d", options.Guildcard, s.Guildcard)
+	}
+
+	for slot := range s.Characters {
+		s.Characters[slot] = nil
+		req := &CharSelectionPacket{Header: BBHeader{Type: LoginCharPreviewReqType}, Slot: uint32(slot)}
+		if err := c.Send(req); err != nil {
+			return err
+		}
+		pkt, err := c.Recv()
+		if err != nil {
+			return err
+		}
+		switch pkt.Type {
+		case LoginCharPreviewType:
+			var preview CharPreviewPacket
+			if err = pkt.Parse(&preview); err != nil {
+				return err
+			}
+			s.Characters[slot] = &preview.Character
+		case LoginCharAckType:
+			var ack CharAckPacket
+			if err = pkt.Parse(&ack); err != nil {
+				return err
+			}
+			if ack.Flag != 2 {
+				return fmt.Errorf("unexpected ack flag %d for empty slot %d", ack.Flag, slot)
+			}
+		default:
+			return fmt.Errorf("unexpected packet %#x for preview of slot %d", pkt.Type, slot)
+		}
+	}
+	return nil
+}
+
+// Create a new character in slot, replacing whatever was there.
+func (s *Session) CreateCharacter(c *Conn, slot int, char *CharacterPreview) error {
+	return s.updateCharacter(c, slot, char, 0, 0)
+}
+
+// Change the appearance of the character in slot with the dressing room.
+func (s *Session) ModifyCharacter(c *Conn, slot int, char *CharacterPreview) error {
+	return s.updateCharacter(c, slot, char, 2, 0)
+}
+
+// Delete the character in slot. The server only deletes it if the preview
+// we send matches the character that's there.
+func (s *Session) DeleteCharacter(c *Conn, slot int) error {
+	char := s.Characters[slot]
+	if char == nil {
+		return fmt.Errorf("no character in slot %d to delete", slot)
+	}
+	return s.updateCharacter(c, slot, char, 3, 2)
+}
+
+func (s *Session) updateCharacter(c *Conn, slot int, char *CharacterPreview, flag, ackFlag uint32) error {
+	err := c.Send(&SetFlagPacket{Header: BBHeader{Type: LoginSetFlagType}, Flag: flag})
+	if err != nil {
+		return err
+	}
+	pkt := &CharPreviewPacket{
+		Header:    BBHeader{Type: LoginCharPreviewType},
+		Slot:      uint32(slot),
+		Character: *char,
+	}
+	if err = c.Send(pkt); err != nil {
+		return err
+	}
+	// The server re-signs our session along with any change to the slots.
+	if err = s.expectSecurity(c); err != nil {
+		return err
+	}
+	var ack CharAckPacket
+	if _, err = c.ExpectParse(LoginCharAckType, &ack); err != nil {
+		return err
+	}
+	if ack.Slot != uint32(slot) || ack.Flag != ackFlag {
+		return fmt.Errorf("character update rejected (slot %d, flag %d)", ack.Slot, ack.Flag)
+	}
+	if flag == 3 {
+		s.Characters[slot] = nil
+		return nil
+	}
+	copied := *char
+	s.Characters[slot] = &copied
+	return nil
+}
+
+// Select the character in slot and download the guildcard and parameter
+// data, as the client does before moving on to the ship select screen.
+func (s *Session) SelectCharacter(c *Conn, slot int) error {
+	req := &CharSelectionPacket{
+		Header:    BBHeader{Type: LoginCharPreviewReqType},
+		Slot:      uint32(slot),
+		Selecting: 1,
+	}
+	if err := c.Send(req); err != nil {
+		return err
+	}
+	if err := s.expectSecurity(c); err != nil {
+		return err
+	}
+	var ack CharAckPacket
+	if _, err := c.ExpectParse(LoginCharAckType, &ack); err != nil {
+		return err
+	}
+	if ack.Slot != uint32(slot) || ack.Flag != 1 {
+		return fmt.Errorf("character selection rejected (slot %d, flag %d)", ack.Slot, ack.Flag)
+	}
+
+	if err := c.Send(&ChecksumPacket{Header: BBHeader{Type: LoginChecksumType}}); err != nil {
+		return err
+	}
+	if _, err := c.Expect(LoginChecksumAckType); err != nil {
+		return err
+	}
+	if err := s.loadGuildcards(c); err != nil {
+		return err
+	}
+	return s.loadParameters(c)
+}
+
+func (s *Session) loadGuildcards(c *Conn) error {
+	if err := c.SendHeader(LoginGuildcardReqType, 0); err != nil {
+		return err
+	}
+	var hdr GuildcardHeaderPacket
+	if _, err := c.ExpectParse(LoginGuildcardHeaderType, &hdr); err != nil {
+		return err
+	}
+	s.GuildcardData = nil
+	for chunk := uint32(0); len(s.GuildcardData) < int(hdr.Length); chunk++ {
+		req := &GuildcardChunkReqPacket{
+			Header:         BBHeader{Type: LoginGuildcardChunkReqType},
+			ChunkRequested: chunk,
+			Continue:       1,
+		}
+		if err := c.Send(req); err != nil {
+			return err
+		}
+		pkt, err := c.Expect(LoginGuildcardChunkType)
+		if err != nil {
+			return err
+		}
+		// Trim the padding from the end of the chunk.
+		data := pkt.Data[BBHeaderSize+8:]
+		if n := chunkLength(int(hdr.Length) - len(s.GuildcardData)); len(data) > n {
+			data = data[:n]
+		}
+		s.GuildcardData = append(s.GuildcardData, data...)
+	}
+	if crc32.ChecksumIEEE(s.GuildcardData) != hdr.Checksum {
+		return errors.New("guildcard data checksum mismatch")
+	}
+	return nil
+}
+
+func (s *Session) loadParameters(c *Conn) error {
+	if err := c.SendHeader(LoginParameterHeaderReqType, 0); err != nil {
+		return err
+	}
+	pkt, err := c.Expect(LoginParameterHeaderType)
+	if err != nil {
+		return err
+	}
+	s.ParamEntries = make([]ParameterEntry, pkt.Flags)
+	total := 0
+	for i := range s.ParamEntries {
+		if err = util.StructFromBytes(pkt.Data[BBHeaderSize+i*0x4C:], &s.ParamEntries[i]); err != nil {
+			return err
+		}
+		total += int(s.ParamEntries[i].Size)
+	}
+
+	s.Parameters = nil
+	for chunk := uint32(0); len(s.Parameters) < total; chunk++ {
+		if err = c.SendHeader(LoginParameterChunkReqType, chunk); err != nil {
+			return err
+		}
+		if pkt, err = c.Expect(LoginParameterChunkType); err != nil {
+			return err
+		}
+		// Trim the padding from the end of the chunk.
+		data := pkt.Data[BBHeaderSize+4:]
+		if n := chunkLength(total - len(s.Parameters)); len(data) > n {
+			data = data[:n]
+		}
+		s.Parameters = append(s.Parameters, data...)
+	}
+	for _, entry := range s.ParamEntries {
+		data := s.Parameters[entry.Offset : entry.Offset+entry.Size]
+		if crc32.ChecksumIEEE(data) != entry.Checksum {
+			return fmt.Errorf("checksum mismatch for %s", util.StripPadding(entry.Filename[:]))
+		}
+	}
+	return nil
+}
+
+// Expected length of the next chunk given the amount of data remaining.
+func chunkLength(remaining int) int {
+	if remaining > MaxChunkSize {
+		return MaxChunkSize
+	}
+	return remaining
+}
+
+// Reconnect to the character server with a character selected, which
+// takes us to the ship select screen.
+func (s *Session) ShipSelect(slot int) error {
+	c, err := s.bbLogin(s.Next, s.loginPacket(int8(slot), 4))
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if _, err = c.Expect(LoginTimestampType); err != nil {
+		return err
+	}
+	if s.Ships, err = expectShipList(c); err != nil {
+		return err
+	}
+	if _, err = c.Expect(LoginScrollMessageType); err != nil {
+		return err
+	}
+	if len(s.Ships) == 0 {
+		return errors.New("no ships in the ship list")
+	}
+	return s.selectShip(c, s.Ships[0].ShipId)
+}
+
+func expectShipList(c *Conn) ([]ShipMenuEntry, error) {
+	pkt, err := c.Expect(LoginShipListType)
+	if err != nil {
+		return nil, err
+	}
+	var ships []ShipMenuEntry
+	offset := BBHeaderSize + 46
+	for ; offset+31 <= len(pkt.Data); offset += 31 {
+		var ship ShipMenuEntry
+		if err = util.StructFromBytes(pkt.Data[offset:], &ship); err != nil {
+			return nil, err
+		}
+		if ship.MenuId != ShipSelectionMenuId {
+			break
+		}
+		ships = append(ships, ship)
+	}
+	return ships, nil
+}
+
+func (s *Session) selectShip(c *Conn, shipId uint32) error {
+	pkt := &MenuSelectionPacket{
+		Header: BBHeader{Type: MenuSelectType},
+		MenuId: ShipSelectionMenuId,
+		ItemId: shipId,
+	}
+	if err := c.Send(pkt); err != nil {
+		return err
+	}
+	return s.expectRedirect(c)
+}
+
+// Log in to the ship and pick a block from the block list.
+func (s *Session) SelectBlock(slot int, blockId uint32) error {
+	c, err := s.bbLogin(s.Next, s.loginPacket(int8(slot), 4))
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	pkt, err := c.Expect(BlockListType)
+	if err != nil {
+		return err
+	}
+	s.Blocks = make([]Block, 0, pkt.Flags)
+	for i, offset := 0, BBHeaderSize+46; i < int(pkt.Flags); i, offset = i+1, offset+44 {
+		var block Block
+		if err = util.StructFromBytes(pkt.Data[offset:], &block); err != nil {
+			return err
+		}
+		s.Blocks = append(s.Blocks, block)
+	}
+
+	sel := &MenuSelectionPacket{Header: BBHeader{Type: MenuSelectType}, ItemId: blockId}
+	if err = c.Send(sel); err != nil {
+		return err
+	}
+	return s.expectRedirect(c)
+}
+
+// Log in to the block we were redirected to and receive the lobby list.
+// The connection is returned so that callers can continue from the lobby.
+func (s *Session) JoinBlock(slot int) (*Conn, error) {
+	c, err := s.bbLogin(s.Next, s.loginPacket(int8(slot), 4))
+	if err != nil {
+		return nil, err
+	}
+	pkt, err := c.Expect(LobbyListType)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	s.Lobbies = nil
+	for offset := BBHeaderSize; offset+12 <= len(pkt.Data); offset += 12 {
+		var lobby Lobby
+		util.StructFromBytes(pkt.Data[offset:], &lobby)
+		s.Lobbies = append(s.Lobbies, lobby)
+	}
+	// The server puts us in the first lobby with room.
+	if err = s.expectLobbyJoin(c); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
@@ -0,0 +1,340 @@
+/*
+* Archon PSO Server
+* Copyright (C) 2014 Andrew Rodman
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+* ---------------------------------------------------------------------
+* Lua scripting hooks for server-specific rules. Every .lua file in the
+* scripts directory is run at startup (and again on reload) and can use
+* the archon table to register handlers:
+*
+*     archon.on("login", function(ev)
+*         if ev.username == "guest" then return false, "No guests" end
+*     end)
+*     archon.command("online", function(player, args) return "..." end)
+*
+* Hook handlers get a table describing the action. They can change its
+* fields to modify the action or return false (and optionally a message)
+* to veto it.
+ */
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	lua "github.com/yuin/gopher-lua"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Hooks that scripts can register handlers for.
+const (
+	HookLogin           = "login"
+	HookCharacterCreate = "character_create"
+	HookLobbyJoin       = "lobby_join"
+	HookChat            = "chat"
+	HookItemDrop        = "item_drop"
+	HookGameCreate      = "game_create"
+)
+
+var scriptHooks = []string{
+	HookLogin, HookCharacterCreate, HookLobbyJoin, HookChat, HookItemDrop, HookGameCreate,
+}
+
+// Longest a single hook or command is allowed to run before it's stopped.
+const scriptTimeout = time.Second
+
+// Returned by RunHook when a script vetoes the action.
+type VetoError struct {
+	Hook    string
+	Message string
+}
+
+func (e *VetoError) Error() string {
+	if e.Message == "" {
+		return "Vetoed by " + e.Hook + " hook"
+	}
+	return "Vetoed by " + e.Hook + " hook: " + e.Message
+}
+
+// Returns the message to show the player for an action that was vetoed.
+func vetoMessage(err error) string {
+	if veto, ok := err.(*VetoError); ok && veto.Message != "" {
+		return veto.Message
+	}
+	return "The server refused your request."
+}
+
+// Loaded scripts and the handlers they registered. A nil *ScriptEngine is
+// valid and does nothing, which is what's used when scripting is disabled.
+type ScriptEngine struct {
+	dir string
+
+	// The Lua state isn't safe for concurrent use, so only one hook runs at a time.
+	mu       sync.Mutex
+	state    *lua.LState
+	hooks    map[string][]*lua.LFunction
+	commands map[string]*lua.LFunction
+}
+
+// Scripts loaded from config.ScriptsDir, if it's set.
+var scripts *ScriptEngine
+
+// Load all of the scripts in dir.
+func LoadScripts(dir string) (*ScriptEngine, error) {
+	e := &ScriptEngine{dir: dir}
+	if err := e.Reload(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Re-run the scripts in the engine's directory, replacing all of the registered
+// hooks and commands. If any of the scripts fail then the old ones stay loaded.
+func (e *ScriptEngine) Reload() error {
+	files, err := filepath.Glob(filepath.Join(e.dir, "*.lua"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+
+	state := lua.NewState(lua.Options{SkipOpenLibs: true})
+	// Scripts don't get the io and os libraries.
+	libs := map[string]lua.LGFunction{
+		lua.BaseLibName:   lua.OpenBase,
+		lua.TabLibName:    lua.OpenTable,
+		lua.StringLibName: lua.OpenString,
+		lua.MathLibName:   lua.OpenMath,
+	}
+	for name, open := range libs {
+		state.Push(state.NewFunction(open))
+		state.Push(lua.LString(name))
+		state.Call(1, 0)
+	}
+	hooks := make(map[string][]*lua.LFunction)
+	commands := make(map[string]*lua.LFunction)
+
+	api := state.NewTable()
+	state.SetField(api, "on", state.NewFunction(func(L *lua.LState) int {
+		hook := L.CheckString(1)
+		if !isScriptHook(hook) {
+			L.ArgError(1, "unknown hook "+hook)
+		}
+		hooks[hook] = append(hooks[hook], L.CheckFunction(2))
+		return 0
+	}))
+	state.SetField(api, "command", state.NewFunction(func(L *lua.LState) int {
+		commands[strings.ToLower(L.CheckString(1))] = L.CheckFunction(2)
+		return 0
+	}))
+	state.SetField(api, "log", state.NewFunction(func(L *lua.LState) int {
+		log.Infof("Script: %s", L.CheckString(1))
+		return 0
+	}))
+	state.SetGlobal("archon", api)
+
+	for _, file := range files {
+		src, err := ioutil.ReadFile(file)
+		if err == nil {
+			err = e.runWithTimeout(state, func() error { return state.DoString(string(src)) })
+		}
+		if err != nil {
+			state.Close()
+			return fmt.Errorf("Error loading script %s: %s", file, err)
+		}
+	}
+
+	e.mu.Lock()
+	old := e.state
+	e.state, e.hooks, e.commands = state, hooks, commands
+	e.mu.Unlock()
+	if old != nil {
+		old.Close()
+	}
+	return nil
+}
+
+// Reload the scripts whenever the server receives SIGHUP.
+func reloadScriptsOnSignal() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP)
+	for range sig {
+		if err := scripts.Reload(); err != nil {
+			log.Error(err.Error())
+		} else {
+			log.Infof("Reloaded scripts from %s", scripts.dir)
+		}
+	}
+}
+
+func isScriptHook(hook string) bool {
+	for _, h := range scriptHooks {
+		if h == hook {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *ScriptEngine) runWithTimeout(state *lua.LState, fn func() error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), scriptTimeout)
+	defer cancel()
+	state.SetContext(ctx)
+	defer state.RemoveContext()
+	return fn()
+}
+
+// Run the handlers for hook with a table built from args. Any changes the
+// handlers make to fields in args are copied back, so long as the values
+// are of the same type. Returns a *VetoError if one of them vetoes the action;
+// scripts that fail are logged and otherwise ignored so that a broken script
+// doesn't stop players from doing anything.
+func (e *ScriptEngine) RunHook(hook string, args map[string]interface{}) error {
+	if e == nil {
+		return nil
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	handlers := e.hooks[hook]
+	if len(handlers) == 0 {
+		return nil
+	}
+
+	L := e.state
+	table := L.NewTable()
+	for k, v := range args {
+		L.SetField(table, k, toLuaValue(v))
+	}
+	for _, fn := range handlers {
+		var ret, msg lua.LValue
+		err := e.runWithTimeout(L, func() error {
+			if err := L.CallByParam(lua.P{Fn: fn, NRet: 2, Protect: true}, table); err != nil {
+				return err
+			}
+			ret, msg = L.Get(-2), L.Get(-1)
+			L.Pop(2)
+			return nil
+		})
+		if err != nil {
+			log.Errorf("Error in %s hook: %s", hook, err)
+			return nil
+		}
+		if ret == lua.LFalse {
+			veto := &VetoError{Hook: hook}
+			if msg != lua.LNil {
+				veto.Message = msg.String()
+			}
+			return veto
+		}
+	}
+
+	for k, v := range args {
+		updated, err := fromLuaValue(L.GetField(table, k), v)
+		if err != nil {
+			log.Errorf("Error in %s hook: field %s: %s", hook, k, err)
+			continue
+		}
+		args[k] = updated
+	}
+	return nil
+}
+
+// Run the chat command registered as name, if there is one. Returns the
+// command's reply and whether a command was found.
+func (e *ScriptEngine) RunCommand(name string, player map[string]interface{}, args string) (string, bool, error) {
+	if e == nil {
+		return "", false, nil
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	fn, ok := e.commands[strings.ToLower(name)]
+	if !ok {
+		return "", false, nil
+	}
+
+	L := e.state
+	table := L.NewTable()
+	for k, v := range player {
+		L.SetField(table, k, toLuaValue(v))
+	}
+	var reply string
+	err := e.runWithTimeout(L, func() error {
+		if err := L.CallByParam(lua.P{Fn: fn, NRet: 1, Protect: true}, table, lua.LString(args)); err != nil {
+			return err
+		}
+		if ret := L.Get(-1); ret != lua.LNil {
+			reply = ret.String()
+		}
+		L.Pop(1)
+		return nil
+	})
+	if err != nil {
+		return "", true, fmt.Errorf("Error in command %s: %s", name, err)
+	}
+	return reply, true, nil
+}
+
+func toLuaValue(v interface{}) lua.LValue {
+	switch v := v.(type) {
+	case string:
+		return lua.LString(v)
+	case bool:
+		return lua.LBool(v)
+	case int:
+		return lua.LNumber(v)
+	case uint8:
+		return lua.LNumber(v)
+	case uint16:
+		return lua.LNumber(v)
+	case uint32:
+		return lua.LNumber(v)
+	}
+	return lua.LNil
+}
+
+// Convert a value set by a script to the type of the original value.
+func fromLuaValue(lv lua.LValue, orig interface{}) (interface{}, error) {
+	if s, ok := orig.(string); ok {
+		if str, ok := lv.(lua.LString); ok {
+			return string(str), nil
+		}
+		return s, errors.New("expected a string")
+	}
+	if _, ok := orig.(bool); ok {
+		return lua.LVAsBool(lv), nil
+	}
+	n, ok := lv.(lua.LNumber)
+	if !ok {
+		return orig, errors.New("expected a number")
+	}
+	switch orig.(type) {
+	case int:
+		return int(n), nil
+	case uint8:
+		return uint8(n), nil
+	case uint16:
+		return uint16(n), nil
+	case uint32:
+		return uint32(n), nil
+	}
+	return orig, errors.New("unsupported type")
+}
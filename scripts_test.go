@@ -0,0 +1,240 @@
+/*
+* Archon PSO Server
+* Copyright (C) 2014 Andrew Rodman
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"github.com/dcrodman/archon/util"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+const testScript = `
+archon.on("login", function(ev)
+	if ev.username == "banned" then
+		return false, "Go away, " .. ev.username
+	end
+end)
+
+archon.on("character_create", function(ev)
+	ev.name = string.upper(ev.name)
+	ev.section_id = 3
+end)
+
+archon.command("echo", function(player, args)
+	return player.guildcard .. ": " .. args
+end)
+`
+
+func writeScript(t *testing.T, dir, src string) {
+	if err := ioutil.WriteFile(filepath.Join(dir, "test.lua"), []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestScriptHooks(t *testing.T) {
+	dir := t.TempDir()
+	writeScript(t, dir, testScript)
+	engine, err := LoadScripts(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = engine.RunHook(HookLogin, map[string]interface{}{"username": "banned"})
+	if veto, ok := err.(*VetoError); !ok || veto.Message != "Go away, banned" {
+		t.Errorf("expected a veto, got %v", err)
+	}
+	if err = engine.RunHook(HookLogin, map[string]interface{}{"username": "player"}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	args := map[string]interface{}{"name": "sonic", "section_id": uint8(1), "slot": uint32(2)}
+	if err = engine.RunHook(HookCharacterCreate, args); err != nil {
+		t.Fatal(err)
+	}
+	if args["name"] != "SONIC" || args["section_id"] != uint8(3) || args["slot"] != uint32(2) {
+		t.Errorf("hook didn't modify the arguments: %v", args)
+	}
+
+	reply, ok, err := engine.RunCommand("ECHO", map[string]interface{}{"guildcard": uint32(42)}, "hi")
+	if !ok || err != nil || reply != "42: hi" {
+		t.Errorf("got %q, %v, %v from command", reply, ok, err)
+	}
+	if _, ok, _ = engine.RunCommand("missing", nil, ""); ok {
+		t.Error("ran a command that wasn't registered")
+	}
+
+	// A script that fails to load shouldn't replace the working ones.
+	writeScript(t, dir, `archon.on("nonexistent", function() end)`)
+	if err = engine.Reload(); err == nil {
+		t.Error("expected an error registering an unknown hook")
+	}
+	if err = engine.RunHook(HookLogin, map[string]interface{}{"username": "banned"}); err == nil {
+		t.Error("hooks were unloaded by a failed reload")
+	}
+}
+
+func TestScriptTimeout(t *testing.T) {
+	dir := t.TempDir()
+	writeScript(t, dir, `archon.on("chat", function(ev) while true do end end)`)
+	engine, err := LoadScripts(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Scripts that fail, including by running too long, don't veto anything.
+	if err = engine.RunHook(HookChat, map[string]interface{}{"text": "hi"}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestNilScriptEngine(t *testing.T) {
+	var engine *ScriptEngine
+	if err := engine.RunHook(HookLogin, nil); err != nil {
+		t.Error(err)
+	}
+	if _, ok, _ := engine.RunCommand("echo", nil, ""); ok {
+		t.Error("nil engine ran a command")
+	}
+}
+
+const blockScript = `
+archon.on("lobby_join", function(ev)
+	if ev.lobby == 1 and not ev.gm then
+		return false, "GMs only"
+	end
+end)
+
+archon.on("chat", function(ev)
+	if ev.text == "spam" then
+		return false, "No spam"
+	end
+	ev.text = string.gsub(ev.text, "darn", "****")
+end)
+
+archon.on("game_create", function(ev)
+	ev.game = ev.name .. "'s game"
+	ev.episode = 2
+	ev.challenge = true
+	ev.solo = true
+end)
+
+archon.on("item_drop", function(ev)
+	return false, "No littering"
+end)
+
+archon.command("echo", function(player, args)
+	return player.name .. ": " .. args
+end)
+`
+
+// The block's handlers should run the scripts' hooks and chat commands.
+func TestBlockHooks(t *testing.T) {
+	dir := t.TempDir()
+	writeScript(t, dir, blockScript)
+	engine, err := LoadScripts(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(saved *ScriptEngine) { scripts = saved }(scripts)
+	scripts = engine
+
+	server := &BlockServer{name: "BLOCK1", num: 1, lobbies: []*Lobby{newLobby(0), newLobby(1)}}
+	c, conn := newPipeClient(8)
+	defer c.Close()
+	packets := readPackets(c, conn)
+	c.guildcard = 42
+	c.character = new(CharacterPreview)
+	copy(c.character.Name[:], util.ConvertToUtf16("\tEAlice"))
+
+	// The first lobby is only for GMs.
+	if err := server.returnToLobby(c, nil); err != nil {
+		t.Fatal(err)
+	}
+	var joined LobbyJoinPacket
+	nextPacket(t, packets, &joined)
+	if c.lobby != server.lobbies[1] || joined.LobbyNum != 1 {
+		t.Fatalf("joined lobby %d, want the second one", joined.LobbyNum)
+	}
+
+	chat := func(text string) string {
+		handlePacket(t, c, server.handleChat, &ChatPacket{
+			Header:  BBHeader{Type: ChatType},
+			Message: util.ConvertToUtf16("\tE" + text),
+		})
+		var reply ChatPacket
+		nextPacket(t, packets, &reply)
+		return util.ConvertFromUtf16(reply.Message)
+	}
+	if reply := chat("/echo hi"); reply != "\tEAlice: hi" {
+		t.Errorf("command replied %q", reply)
+	}
+	if reply := chat("/missing"); reply != "\tEUnknown command: missing" {
+		t.Errorf("unknown command replied %q", reply)
+	}
+	if msg := chat("darn it"); msg != "Alice\t\tE**** it" {
+		t.Errorf("chat hook didn't change the message: %q", msg)
+	}
+	if reply := chat("spam"); reply != "\tENo spam" {
+		t.Errorf("chat hook didn't veto the message: %q", reply)
+	}
+
+	create := &CreateGamePacket{Header: BBHeader{Type: CreateGameType}, Episode: 1}
+	copy(create.Name[:], util.ConvertToUtf16("Fun"))
+	handlePacket(t, c, server.handleCreateGame, create)
+	nextPacket(t, packets, new(RareEnemyListPacket))
+	nextPacket(t, packets, new(GameJoinPacket))
+	if c.game == nil || c.game.name != "Alice's game" {
+		t.Fatalf("game create hook didn't rename the game: %+v", c.game)
+	}
+	if g := c.game; g.episode != Episode2 || g.challenge != 1 || g.battle != 0 || !g.solo {
+		t.Errorf("game create hook didn't change the game's settings: %+v", g)
+	}
+
+	handlePacket(t, c, handleGameCommand, &DropItemPacket{
+		Header:     BBHeader{Type: GameCommandType},
+		Subcommand: SubDropItemType,
+		Size:       5,
+		ItemId:     0x10000,
+	})
+	var message LoginClientMessagePacket
+	nextPacket(t, packets, &message)
+	if text := util.ConvertFromUtf16(message.Message); text != "No littering" {
+		t.Errorf("item drop hook didn't veto the drop: %q", text)
+	}
+}
+
+func TestLoginHookServers(t *testing.T) {
+	c, _ := newPipeClient(1)
+	defer c.Close()
+	for _, test := range []struct {
+		server Server
+		login  bool
+	}{
+		{new(LoginServer), true},
+		{&ClassicLoginServer{version: VersionGC}, true},
+		{new(CharacterServer), false},
+		{new(ShipServer), false},
+		{&BlockServer{name: "BLOCK1"}, false},
+		{&ClassicShipServer{version: VersionGC}, false},
+	} {
+		c.server = test.server
+		if c.onLoginServer() != test.login {
+			t.Errorf("%s: expected onLoginServer to be %v", test.server.Name(), test.login)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+/*
+* Archon PSO Server
+* Copyright (C) 2014 Andrew Rodman
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+* ---------------------------------------------------------------------
+* Login sessions. The client config sent in the security packet is handed
+* back to us in the login packet on each server the client is redirected
+* to, so the servers sign it when it's sent and refuse to trust one that
+* they didn't sign (or that has expired). The phase tracks where a client
+* is in the login flow on the current connection so that handlers can
+* reject packets that don't belong.
+ */
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Value newserv sets in the config once the client has made it through the LOGIN server.
+const clientConfigMagic = 0x48615467
+
+type loginPhase uint8
+
+const (
+	phaseNone loginPhase = iota
+	// Account verified by the LOGIN server.
+	phaseAuthenticated
+	// Choosing or creating a character on the CHARACTER server.
+	phaseCharacterSelect
+	// A character has been picked and they're choosing a ship or block.
+	phaseShipSelect
+	// Connected to a block.
+	phaseLobby
+)
+
+var phaseNames = map[loginPhase]string{
+	phaseNone:            "none",
+	phaseAuthenticated:   "authenticated",
+	phaseCharacterSelect: "character select",
+	phaseShipSelect:      "ship select",
+	phaseLobby:           "lobby",
+}
+
+func (p loginPhase) String() string { return phaseNames[p] }
+
+var (
+	errSessionInvalid = errors.New("session token is invalid")
+	errSessionExpired = errors.New("session token has expired")
+)
+
+// Key used to sign session tokens, from config.SessionSecret or generated
+// at startup if there isn't one.
+var sessionKey []byte
+
+func initSessionKey(secret string) error {
+	if secret != "" {
+		sessionKey = []byte(secret)
+		return nil
+	}
+	sessionKey = make([]byte, 32)
+	_, err := rand.Read(sessionKey)
+	return err
+}
+
+// Compute the signature over the client's config and guildcard.
+func sessionSignature(guildcard uint32, cfg *ClientConfig) [20]byte {
+	data := make([]byte, 4+cfg.BinarySize())
+	binary.LittleEndian.PutUint32(data, guildcard)
+	cfg.MarshalTo(data[4:])
+
+	mac := hmac.New(sha256.New, sessionKey)
+	// Everything but the signature itself.
+	mac.Write(data[:len(data)-len(cfg.Signature)])
+	var sig [20]byte
+	copy(sig[:], mac.Sum(nil))
+	return sig
+}
+
+// Sign the client's config so that the next server can trust it.
+func (client *Client) signSession() {
+	cfg := &client.config
+	cfg.Expires = 0
+	if timeout := seconds(config.SessionTimeout); timeout > 0 {
+		cfg.Expires = uint32(time.Now().Add(timeout).Unix())
+	}
+	cfg.Signature = sessionSignature(client.guildcard, cfg)
+}
+
+// Restore the config the client was sent by the previous server from the
+// security data in their login packet, so long as it's one that we signed
+// for their account.
+func (client *Client) restoreSession(security []byte) error {
+	var cfg ClientConfig
+	if err := cfg.Unmarshal(security); err != nil {
+		return errSessionInvalid
+	}
+	sig := sessionSignature(client.guildcard, &cfg)
+	if cfg.Magic != clientConfigMagic || !hmac.Equal(sig[:], cfg.Signature[:]) {
+		return errSessionInvalid
+	}
+	if cfg.Expires != 0 && time.Now().Unix() > int64(cfg.Expires) {
+		return errSessionExpired
+	}
+	client.config = cfg
+	return nil
+}
+
+// Returns middleware that drops packets from clients that aren't in one of
+// the given phases of the login flow.
+func RequirePhase(phases ...loginPhase) Middleware {
+	return func(pktType uint16, next PacketHandler) PacketHandler {
+		return func(c *Client) error {
+			for _, p := range phases {
+				if c.phase == p {
+					return next(c)
+				}
+			}
+			return c.Misbehave(fmt.Sprintf("packet %02x sent during %v", pktType, c.phase))
+		}
+	}
+}
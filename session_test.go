@@ -0,0 +1,103 @@
+/*
+* Archon PSO Server
+* Copyright (C) 2014 Andrew Rodman
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"github.com/dcrodman/archon/util"
+	"testing"
+	"time"
+)
+
+// Sign a session for guildcard and return the security data the client
+// would send back to the next server.
+func signedSecurity(guildcard uint32, cfg ClientConfig) []byte {
+	c := &Client{guildcard: guildcard, config: cfg}
+	c.signSession()
+	data, _ := util.BytesFromStruct(&c.config)
+	return data
+}
+
+func TestRestoreSession(t *testing.T) {
+	cfg := ClientConfig{Magic: clientConfigMagic, CharSelected: 1, SlotNum: 2}
+	security := signedSecurity(1234, cfg)
+
+	c := &Client{guildcard: 1234}
+	if err := c.restoreSession(security); err != nil {
+		t.Fatal(err)
+	}
+	if c.config.CharSelected != 1 || c.config.SlotNum != 2 {
+		t.Errorf("restored config doesn't match: %+v", c.config)
+	}
+
+	// Someone else's session.
+	c = &Client{guildcard: 5678}
+	if err := c.restoreSession(security); err != errSessionInvalid {
+		t.Errorf("expected another account's session to be rejected, got %v", err)
+	}
+
+	// Changing the slot without re-signing.
+	security[5] = 3
+	c = &Client{guildcard: 1234}
+	if err := c.restoreSession(security); err != errSessionInvalid {
+		t.Errorf("expected tampered session to be rejected, got %v", err)
+	}
+
+	// The version string the client sends to the LOGIN server.
+	if err := c.restoreSession([]byte(ClientVersionString)); err != errSessionInvalid {
+		t.Errorf("expected unsigned config to be rejected, got %v", err)
+	}
+}
+
+func TestSessionExpiry(t *testing.T) {
+	defer func(timeout int) { config.SessionTimeout = timeout }(config.SessionTimeout)
+	config.SessionTimeout = 60
+
+	c := &Client{guildcard: 1234, config: ClientConfig{Magic: clientConfigMagic}}
+	c.config.Expires = uint32(time.Now().Add(-time.Minute).Unix())
+	c.config.Signature = sessionSignature(c.guildcard, &c.config)
+	security, _ := util.BytesFromStruct(&c.config)
+	if err := c.restoreSession(security); err != errSessionExpired {
+		t.Errorf("expected expired session to be rejected, got %v", err)
+	}
+
+	if err := c.restoreSession(signedSecurity(1234, ClientConfig{Magic: clientConfigMagic})); err != nil {
+		t.Errorf("expected new session to be accepted, got %v", err)
+	}
+}
+
+func TestRequirePhase(t *testing.T) {
+	c, _ := newPipeClient(1)
+	defer c.Close()
+
+	called := false
+	r := NewHandlerRegistry("TEST")
+	r.Handle(MenuSelectType, func(c *Client) error {
+		called = true
+		return nil
+	}, RequirePhase(phaseShipSelect))
+
+	setPacket(c, MenuSelectType)
+	c.phase = phaseCharacterSelect
+	if _, ok := r.Dispatch(c).(*droppedPacketError); !ok || called {
+		t.Error("expected packet sent during character select to be dropped")
+	}
+	c.phase = phaseShipSelect
+	if err := r.Dispatch(c); err != nil || !called {
+		t.Errorf("expected packet to be handled during ship select; got %v", err)
+	}
+}
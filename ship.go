@@ -25,24 +25,38 @@ import (
 	crypto "github.com/dcrodman/archon/encryption"
 	"github.com/dcrodman/archon/util"
 	"net"
+	"os"
 	"strconv"
 )
 
 // Block ID reserved for returning to the ship select menu.
 const BackMenuItem = 0xFF
 
-// Info about the available block servers.
-type Block struct {
-	Unknown   uint16
-	BlockId   uint32
-	Padding   uint16
-	BlockName [36]byte
-}
-
-func handleShipLogin(sc *Client) error {
-	if _, err := VerifyAccount(sc); err != nil {
+// Log in a player who's picked a character, putting them in phase.
+func handleShipLogin(sc *Client, phase loginPhase) error {
+	if _, err := VerifyAccount(sc, true); err != nil {
 		return err
 	}
+	if sc.config.CharSelected == 0 {
+		sc.SendSecurity(BBLoginErrorUnknown, 0, 0)
+		return errors.New("Ship login without a character selected: " + sc.IPAddr())
+	}
+	if phase == phaseLobby {
+		// Blocks need the character for anything that happens in a game.
+		character, err := loadCharacterPreview(sc.guildcard, uint32(sc.config.SlotNum))
+		if err != nil {
+			sc.SendSecurity(BBLoginErrorUnknown, 0, 0)
+			return err
+		}
+		sc.character = character
+		inventory, err := loadInventory(config.DB(), sc.guildcard, uint32(sc.config.SlotNum))
+		if err != nil {
+			sc.SendSecurity(BBLoginErrorUnknown, 0, 0)
+			return err
+		}
+		sc.inventory = inventory
+	}
+	sc.phase = phase
 	sc.SendSecurity(BBLoginErrorNone, sc.guildcard, sc.teamId)
 	return nil
 }
@@ -54,15 +68,15 @@ func handleBlockSelection(sc *Client, pkt MenuSelectionPacket) error {
 	selectedBlock := pkt.ItemId
 	if selectedBlock == BackMenuItem {
 		sc.SendShipList(shipList)
-	} else if int(selectedBlock) > config.NumBlocks {
-		return errors.New(fmt.Sprintf("Block selection %v out of range %v", selectedBlock, config.NumBlocks))
+	} else if selectedBlock < 1 || int(selectedBlock) > config.NumBlocks {
+		return sc.Misbehave(fmt.Sprintf("block selection %v out of range %v", selectedBlock, config.NumBlocks))
 	} else {
-		sc.SendRedirect(uint16(uint32(port)+selectedBlock), config.HostnameBytes())
+		sc.SendRedirect(uint16(uint32(port)+selectedBlock), sc.RedirectAddr())
 	}
 	return nil
 }
 
-func NewShipClient(conn *net.TCPConn) (*Client, error) {
+func NewShipClient(conn net.Conn) (*Client, error) {
 	cCrypt := crypto.NewBBCrypt()
 	sCrypt := crypto.NewBBCrypt()
 	sc := NewClient(conn, BBHeaderSize, cCrypt, sCrypt)
@@ -79,6 +93,7 @@ func NewShipClient(conn *net.TCPConn) (*Client, error) {
 type ShipServer struct {
 	// Precomputed block packet.
 	blockPkt *BlockListPacket
+	handlers *HandlerRegistry
 }
 
 func (server ShipServer) Name() string { return "SHIP" }
@@ -86,6 +101,28 @@ func (server ShipServer) Name() string { return "SHIP" }
 func (server ShipServer) Port() string { return config.ShipPort }
 
 func (server *ShipServer) Init() {
+	// Enemy stats used by the games on all of the blocks.
+	if err := loadBattleParams(config.ParametersDir); err != nil {
+		fmt.Println("Error reading battle parameters: " + err.Error())
+		os.Exit(1)
+	}
+	// Enemies on the maps, which the games need to track experience.
+	if config.MapsDir != "" {
+		maps, err := loadGameMaps(config.MapsDir)
+		if err != nil {
+			fmt.Println("Error reading maps: " + err.Error())
+			os.Exit(1)
+		}
+		gameMaps = maps
+	}
+	// Item definitions used for the shops and item validation.
+	t, err := loadItemTable(config.ParametersDir)
+	if err != nil {
+		fmt.Println("Error reading item parameters: " + err.Error())
+		os.Exit(1)
+	}
+	itemTable = t
+
 	// Precompute the block list packet since it's not going to change.
 	numBlocks := config.NumBlocks
 	ship := shipList[0]
@@ -110,48 +147,57 @@ func (server *ShipServer) Init() {
 	b.Unknown = 0x12
 	b.BlockId = BackMenuItem
 	copy(b.BlockName[:], util.ConvertToUtf16("Ship Selection"))
+
+	server.handlers = newServerHandlers(server.Name())
+	server.handlers.Handle(LoginType, func(c *Client) error {
+		if err := handleShipLogin(c, phaseShipSelect); err != nil {
+			return err
+		}
+		c.SendBlockList(server.blockPkt)
+		return nil
+	})
+	server.handlers.Handle(MenuSelectType, handleShipMenuSelection, RequirePhase(phaseShipSelect))
+	// Keepalive response; receiving it is enough to reset the idle timer.
+	server.handlers.Handle(PingType, ignorePacket)
 }
 
-func (server ShipServer) NewClient(conn *net.TCPConn) (*Client, error) {
+func (server ShipServer) NewClient(conn net.Conn) (*Client, error) {
 	return NewShipClient(conn)
 }
 
 func (server ShipServer) Handle(c *Client) error {
-	var err error = nil
-	var hdr BBHeader
-	util.StructFromBytes(c.Data()[:BBHeaderSize], &hdr)
+	return server.handlers.Dispatch(c)
+}
 
-	switch hdr.Type {
-	case LoginType:
-		err = handleShipLogin(c)
-		c.SendBlockList(server.blockPkt)
-	case MenuSelectType:
-		var pkt MenuSelectionPacket
-		util.StructFromBytes(c.Data(), &pkt)
-		// They can be at either the ship or block selection menu, so make sure we have the right one.
-		if pkt.MenuId == ShipSelectionMenuId {
-			// TODO: Hack for now, but this coupling on the login server logic needs to go away.
-			err = handleShipSelection(c)
-		} else {
-			err = handleBlockSelection(c, pkt)
-		}
-	default:
-		log.Infof("Received unknown packet %02x from %s", hdr.Type, c.IPAddr())
+func handleShipMenuSelection(c *Client) error {
+	var pkt MenuSelectionPacket
+	if err := c.ReadPacket(&pkt); err != nil {
+		return err
+	}
+	// They can be at either the ship or block selection menu, so make sure we have the right one.
+	if pkt.MenuId == ShipSelectionMenuId {
+		// TODO: Hack for now, but this coupling on the login server logic needs to go away.
+		return handleShipSelection(c)
 	}
-	return err
+	return handleBlockSelection(c, pkt)
 }
 
 // Block sub-server definition.
 type BlockServer struct {
 	name string
 	port string
+	// Block number, starting from 1.
+	num uint16
 
 	lobbyPkt LobbyListPacket
+	handlers *HandlerRegistry
+	lobbies  []*Lobby
+	games    gameList
 }
 
-func (server BlockServer) Name() string { return server.name }
+func (server *BlockServer) Name() string { return server.name }
 
-func (server BlockServer) Port() string { return server.port }
+func (server *BlockServer) Port() string { return server.port }
 
 func (server *BlockServer) Init() {
 	// Precompute our lobby list since this won't change once the server has started.
@@ -159,34 +205,39 @@ func (server *BlockServer) Init() {
 	server.lobbyPkt.Header.Type = LobbyListType
 	server.lobbyPkt.Header.Flags = uint32(config.NumLobbies)
 	for i := 0; i <= config.NumLobbies; i++ {
-		server.lobbyPkt.Lobbies = append(server.lobbyPkt.Lobbies, struct {
-			MenuId  uint32
-			LobbyId uint32
-			Padding uint32
-		}{
+		server.lobbyPkt.Lobbies = append(server.lobbyPkt.Lobbies, LobbyListEntry{
 			MenuId:  0x1A0001,
 			LobbyId: uint32(i),
 			Padding: 0,
 		})
 		server.lobbyPkt.Header.Size += 12
 	}
+	server.lobbies = newLobbies(config.NumLobbies)
+
+	server.handlers = newServerHandlers(server.Name())
+	server.handlers.Handle(LoginType, func(c *Client) error {
+		if err := handleShipLogin(c, phaseLobby); err != nil {
+			return err
+		}
+		c.SendLobbyList(&server.lobbyPkt)
+		return server.returnToLobby(c, nil)
+	})
+	inLobby := RequirePhase(phaseLobby)
+	server.handlers.Handle(GameCommandType, handleGameCommand, inLobby)
+	server.handlers.Handle(GameCommandTargetType, handleGameCommand, inLobby)
+	server.handlers.Handle(ChatType, server.handleChat, inLobby)
+	server.handlers.Handle(LobbyChangeType, server.handleLobbyChange, inLobby)
+	server.handlers.Handle(CreateGameType, server.handleCreateGame, inLobby)
+	server.handlers.Handle(GameListType, server.handleGameList, inLobby)
+	server.handlers.Handle(MenuSelectType, server.handleGameSelection, inLobby)
+	server.handlers.Handle(LeaveGameType, server.handleLeaveGame, inLobby)
+	server.handlers.Handle(PingType, ignorePacket)
 }
 
-func (server BlockServer) NewClient(conn *net.TCPConn) (*Client, error) {
+func (server *BlockServer) NewClient(conn net.Conn) (*Client, error) {
 	return NewShipClient(conn)
 }
 
-func (server BlockServer) Handle(c *Client) error {
-	var err error = nil
-	var hdr BBHeader
-	util.StructFromBytes(c.Data()[:BBHeaderSize], &hdr)
-
-	switch hdr.Type {
-	case LoginType:
-		err = handleShipLogin(c)
-		c.SendLobbyList(&server.lobbyPkt)
-	default:
-		log.Infof("Received unknown packet %02x from %s", hdr.Type, c.IPAddr())
-	}
-	return err
+func (server *BlockServer) Handle(c *Client) error {
+	return server.handlers.Dispatch(c)
 }
@@ -32,9 +32,12 @@ import (
 	// 	"runtime/debug"
 	// 	"strings"
 	// 	"sync"
-	// 	"time"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
 	"github.com/dcrodman/archon/util"
 	"strconv"
+	"time"
 )
 
 type Ship struct {
@@ -43,6 +46,9 @@ type Ship struct {
 
 	ipAddr [4]byte
 	port   uint16
+	// Ships hosted by this server don't have a fixed address since
+	// the one we advertise depends on where the client is.
+	local bool
 
 	// conn   net.Conn
 	// recvSize   int
@@ -329,7 +335,12 @@ type Ship struct {
 // }
 //
 // Shipgate sub-server definition.
-type ShipgateServer struct{}
+type ShipgateServer struct {
+	// All of the dispatcher's connections, if events should be forwarded to
+	// the ships connected to the shipgate.
+	conns    *ConnList
+	handlers *HandlerRegistry
+}
 
 func (server ShipgateServer) Name() string { return "Shipgate" }
 
@@ -340,25 +351,103 @@ func (server *ShipgateServer) Init() {
 	// ships will be added to this list by the shipgate, if it's enabled.
 	s := &shipList[0]
 	s.id = 1
-	s.ipAddr = config.HostnameBytes()
+	s.local = true
 	port, _ := strconv.ParseUint(config.ShipPort, 10, 16)
 	s.port = uint16(port)
 	copy(s.name[:], config.ShipName)
+
+	server.handlers = newServerHandlers(server.Name())
+	server.handlers.Handle(ShipgateAuthType, server.handleAuth)
+	server.handlers.Handle(ShipgateEventType, server.handleEvent, RequireLogin)
+	if server.conns != nil {
+		events.Subscribe(server.forwardEvent)
+	}
+	events.Publish(Event{Type: EventShipRegistered, Server: server.Name(),
+		Ship: s.id, Id: s.id, Text: config.ShipName})
 }
 
-func (server ShipgateServer) NewClient(conn *net.TCPConn) (*Client, error) {
-	return NewLoginClient(conn)
+// Returns true if c is a ship that has authenticated with the shipgate.
+func isShipgateClient(c *Client) bool {
+	_, ok := c.server.(*ShipgateServer)
+	return ok && c.handshakeDone
+}
+
+// Signature a ship sends over the connection's encryption vectors, which
+// are new for each connection so that it can't be replayed.
+func shipgateSignature(secret string, c *Client) [32]byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(c.ServerVector())
+	mac.Write(c.ClientVector())
+	var sig [32]byte
+	copy(sig[:], mac.Sum(nil))
+	return sig
 }
 
-// Basically a no-op at this point since we only have one ship.
-func (server ShipgateServer) Handle(c *Client) error {
-	var err error = nil
-	var hdr BBHeader
-	util.StructFromBytes(c.Data()[:BBHeaderSize], &hdr)
+// Accept a ship that signed its connection with the shipgate secret. Ships
+// can't send or receive events until they have.
+func (server *ShipgateServer) handleAuth(c *Client) error {
+	var pkt ShipgateAuthPacket
+	if err := c.ReadPacket(&pkt); err != nil {
+		return err
+	}
+	sig := shipgateSignature(config.ShipgateSecret, c)
+	if config.ShipgateSecret == "" || !hmac.Equal(sig[:], pkt.Signature[:]) {
+		return errors.New("Rejected ship with an invalid signature from " + c.IPAddr())
+	}
+	c.CompleteHandshake()
+	log.Infof("Authenticated ship at %s", c.IPAddr())
+	return nil
+}
 
-	switch hdr.Type {
-	default:
-		log.Infof("Received unknown packet %x from %s", hdr.Type, c.IPAddr())
+// Send an event that happened on this server to the connected ships.
+func (server *ShipgateServer) forwardEvent(e Event) {
+	if e.Remote {
+		return
+	}
+	pkt := &ShipgateEventPacket{
+		Header:    BBHeader{Type: ShipgateEventType},
+		EventType: uint32(e.Type),
+		Ship:      e.Ship,
+		Guildcard: e.Guildcard,
+		Id:        e.Id,
+		Timestamp: e.Time.Unix(),
 	}
-	return err
+	copy(pkt.Text[:], e.Text)
+	data, _ := util.BytesFromStruct(pkt)
+	server.conns.Broadcast(data, isShipgateClient)
+}
+
+// Publish an event forwarded by one of the connected ships and pass it on
+// to the others.
+func (server *ShipgateServer) handleEvent(c *Client) error {
+	var pkt ShipgateEventPacket
+	if err := c.ReadPacket(&pkt); err != nil {
+		return err
+	}
+	events.Publish(Event{
+		Type:      EventType(pkt.EventType),
+		Time:      time.Unix(pkt.Timestamp, 0),
+		Server:    server.Name(),
+		Ship:      pkt.Ship,
+		Guildcard: pkt.Guildcard,
+		Id:        pkt.Id,
+		Text:      string(util.StripPadding(pkt.Text[:])),
+		Remote:    true,
+	})
+	if server.conns != nil {
+		server.conns.Broadcast(c.Data(), func(other *Client) bool {
+			return other != c && isShipgateClient(other)
+		})
+	}
+	return nil
+}
+
+func (server ShipgateServer) NewClient(conn net.Conn) (*Client, error) {
+	return NewLoginClient(conn)
+}
+
+// Basically a no-op at this point aside from forwarding events since we
+// only have one ship.
+func (server *ShipgateServer) Handle(c *Client) error {
+	return server.handlers.Dispatch(c)
 }
@@ -0,0 +1,357 @@
+/*
+* Archon PSO Server
+* Copyright (C) 2014 Andrew Rodman
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+* ---------------------------------------------------------------------
+* NPC shops. What each shop sells is generated by the server from the item
+* definitions when a player opens it, based on their level and the game's
+* difficulty, and purchases and sales are checked against (and saved to)
+* the player's stored meseta and inventory rather than trusting the client.
+ */
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/dcrodman/archon/util"
+	"math/rand"
+	"time"
+)
+
+const (
+	ShopTypeTool   = 0
+	ShopTypeWeapon = 1
+	ShopTypeArmor  = 2
+
+	weaponShopSize = 12
+	armorShopSize  = 10
+	// Number of common weapons in each weapon group, after which they're rare.
+	commonWeaponVariants = 5
+	// Weapon groups from Saber through Wand.
+	firstShopWeaponGroup = 0x01
+	lastShopWeaponGroup  = 0x0C
+	numWeaponSpecials    = 0x28
+	// Shops pay this fraction of an item's price for it.
+	sellPriceDivisor = 8
+)
+
+// Tools the tool shop sells and the level a player needs to see them.
+var shopTools = []struct {
+	group, index uint8
+	level        uint32
+}{
+	{0x00, 0x00, 0},  // Monomate
+	{0x00, 0x01, 10}, // Dimate
+	{0x00, 0x02, 30}, // Trimate
+	{0x01, 0x00, 0},  // Monofluid
+	{0x01, 0x01, 10}, // Difluid
+	{0x01, 0x02, 30}, // Trifluid
+	{0x03, 0x00, 0},  // Sol Atomizer
+	{0x04, 0x00, 0},  // Moon Atomizer
+	{0x06, 0x00, 0},  // Antidote
+	{0x06, 0x01, 0},  // Antiparalysis
+	{0x07, 0x00, 0},  // Telepipe
+	{0x08, 0x00, 0},  // Trap Vision
+}
+
+// Techniques sold as disks: Foie, Barta, Zonde, Deband, Jellen, Zalure,
+// Shifta, Ryuker, Resta, and Anti.
+var shopTechniques = []uint8{0, 3, 6, 10, 11, 12, 13, 14, 15, 16}
+
+// Generate the items for one of the shops for a player of level (zero
+// based) in a game on difficulty. Prices are the base prices and aren't
+// filled in.
+func (t *ItemTable) generateShop(shopType uint8, level uint32, difficulty uint8, rng *rand.Rand) ([]ItemData, error) {
+	switch shopType {
+	case ShopTypeTool:
+		return t.generateToolShop(level, difficulty, rng), nil
+	case ShopTypeWeapon:
+		return t.generateWeaponShop(level, difficulty, rng), nil
+	case ShopTypeArmor:
+		return t.generateArmorShop(level, difficulty, rng), nil
+	}
+	return nil, fmt.Errorf("invalid shop type %d", shopType)
+}
+
+func (t *ItemTable) generateToolShop(level uint32, difficulty uint8, rng *rand.Rand) []ItemData {
+	var items []ItemData
+	for _, tool := range shopTools {
+		if level >= tool.level {
+			item := ItemData{}
+			item.Data[0], item.Data[1], item.Data[2], item.Data[5] = ItemClassTool, tool.group, tool.index, 1
+			items = append(items, item)
+		}
+	}
+	// Fill the rest with technique disks at around the player's level.
+	base := int(level)/10 + int(difficulty)*3
+	for _, i := range rng.Perm(len(shopTechniques)) {
+		if len(items) >= MaxShopItems {
+			break
+		}
+		techLevel := base + rng.Intn(3)
+		if techLevel >= MaxTechLevel/2 {
+			techLevel = MaxTechLevel/2 - 1
+		}
+		item := ItemData{}
+		item.Data[0], item.Data[1], item.Data[2], item.Data[4] =
+			ItemClassTool, ToolGroupTechDisk, uint8(techLevel), shopTechniques[i]
+		items = append(items, item)
+	}
+	return items
+}
+
+func (t *ItemTable) generateWeaponShop(level uint32, difficulty uint8, rng *rand.Rand) []ItemData {
+	// Better weapons show up as the player levels and on higher difficulties.
+	tier := int(difficulty) + int(level)/40
+	if tier >= commonWeaponVariants {
+		tier = commonWeaponVariants - 1
+	}
+	var specials []uint8
+	for s := uint8(1); s <= numWeaponSpecials; s++ {
+		if stars := t.Stars(uint32(s) + specialStarsOffset); stars > 0 && stars <= difficulty+1 {
+			specials = append(specials, s)
+		}
+	}
+
+	var items []ItemData
+	for attempts := 0; len(items) < weaponShopSize && attempts < 10*weaponShopSize; attempts++ {
+		group := uint8(firstShopWeaponGroup + rng.Intn(lastShopWeaponGroup-firstShopWeaponGroup+1))
+		index := uint8(tier - rng.Intn(2))
+		if tier == 0 {
+			index = 0
+		}
+		def, err := t.Weapon(group, index)
+		if err != nil || t.Stars(def.Id) >= rareItemStars {
+			continue
+		}
+		item := ItemData{}
+		item.Data[0], item.Data[1], item.Data[2] = ItemClassWeapon, group, index
+		if maxGrind := int(level)/10 + int(difficulty)*2; maxGrind > 0 {
+			if maxGrind > int(def.MaxGrind) {
+				maxGrind = int(def.MaxGrind)
+			}
+			item.Data[3] = uint8(rng.Intn(maxGrind + 1))
+		}
+		if len(specials) > 0 && rng.Intn(3) == 0 {
+			item.Data[4] = specials[rng.Intn(len(specials))]
+		}
+		// Up to two attributes of at most 10% per difficulty.
+		attrs := rng.Perm(NumWeaponAttributes)
+		for i := 0; i < 2 && rng.Intn(2) == 0; i++ {
+			item.Data[6+i*2] = uint8(attrs[i] + 1)
+			item.Data[7+i*2] = uint8(5 * (1 + rng.Intn(2*(int(difficulty)+1))))
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+func (t *ItemTable) generateArmorShop(level uint32, difficulty uint8, rng *rand.Rand) []ItemData {
+	// Frames and shields the player can use or nearly use.
+	var frames, shields []uint8
+	maxLevel := level + uint32(difficulty)*10
+	for i := range t.Frames {
+		if uint32(t.Frames[i].RequiredLevel) <= maxLevel && t.Stars(t.Frames[i].Id) < rareItemStars {
+			frames = append(frames, uint8(i))
+		}
+	}
+	for i := range t.Shields {
+		if uint32(t.Shields[i].RequiredLevel) <= maxLevel && t.Stars(t.Shields[i].Id) < rareItemStars {
+			shields = append(shields, uint8(i))
+		}
+	}
+	// Favor the best of them.
+	pick := func(indexes []uint8) uint8 {
+		n := len(indexes)
+		if n > 4 {
+			return indexes[n-4+rng.Intn(4)]
+		}
+		return indexes[rng.Intn(n)]
+	}
+
+	var items []ItemData
+	for len(items) < armorShopSize && (len(frames) > 0 || len(shields) > 0) {
+		item := ItemData{}
+		item.Data[0] = ItemClassArmor
+		if len(shields) == 0 || len(frames) > 0 && len(items)%2 == 0 {
+			item.Data[1], item.Data[2] = ArmorGroupFrame, pick(frames)
+			item.Data[5] = uint8(rng.Intn(int(difficulty) + 2))
+		} else {
+			item.Data[1], item.Data[2] = ArmorGroupShield, pick(shields)
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+// What the shops on this ship charge for an item.
+func shopBuyPrice(price uint32) uint32 {
+	price = uint32(uint64(price) * uint64(config.ShopPricePercent) / 100)
+	if price == 0 {
+		price = 1
+	}
+	return price
+}
+
+// What the shops on this ship pay for an item.
+func shopSellPrice(price uint32) uint32 {
+	return uint32(uint64(price/sellPriceDivisor) * uint64(config.ShopSellPricePercent) / 100)
+}
+
+// Returns the inventory after buying amount of the item at index in shop and
+// the item as it ends up in the inventory. It's given the new id unless it's
+// added to a stack the player already has.
+func buyShopItem(inv *Inventory, shop []ItemData, index, amount uint8, id uint32) (*Inventory, ItemData, error) {
+	if int(index) >= len(shop) {
+		return nil, ItemData{}, fmt.Errorf("no item %d in the shop", index)
+	}
+	item := shop[index]
+	price := binary.LittleEndian.Uint32(item.Data2[:])
+	if isStackable(&item) {
+		if amount == 0 || amount > MaxToolStack {
+			return nil, ItemData{}, fmt.Errorf("can't buy %d of an item", amount)
+		}
+		item.Data[5] = amount
+	} else if amount != 1 {
+		return nil, ItemData{}, fmt.Errorf("can't buy %d of an item that doesn't stack", amount)
+	}
+	item.Id = id
+	item.Data2 = [4]uint8{}
+
+	updated := inv.clone()
+	if err := updated.spendMeseta(price * uint32(amount)); err != nil {
+		return nil, ItemData{}, err
+	}
+	added, err := updated.addItem(item)
+	if err != nil {
+		return nil, ItemData{}, err
+	}
+	return updated, added, nil
+}
+
+// Returns the inventory after selling amount of the item with id and the
+// meseta they got for it.
+func sellItem(inv *Inventory, id, amount uint32) (*Inventory, uint32, error) {
+	updated := inv.clone()
+	item, err := updated.removeItem(id, amount)
+	if err != nil {
+		return nil, 0, err
+	}
+	price, err := itemTable.Price(&item)
+	if err != nil {
+		return nil, 0, err
+	}
+	earned := shopSellPrice(price) * stackCount(&item)
+	updated.addMeseta(earned)
+	return updated, earned, nil
+}
+
+func handleShopRequest(c *Client) error {
+	var pkt ShopRequestPacket
+	if err := c.ReadPacket(&pkt); err != nil {
+		return err
+	}
+	if c.game == nil || c.character == nil || c.inventory == nil {
+		return c.Misbehave("shop opened outside of a game")
+	}
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	items, err := itemTable.generateShop(uint8(pkt.ShopType), c.character.Level, c.game.difficulty, rng)
+	if err != nil {
+		return c.Misbehave(err.Error())
+	}
+	for i := range items {
+		price, err := itemTable.Price(&items[i])
+		if err != nil {
+			return err
+		}
+		binary.LittleEndian.PutUint32(items[i].Data2[:], shopBuyPrice(price))
+	}
+	c.shop, c.shopType = items, uint8(pkt.ShopType)
+	c.SendShopContents(c.shopType, items)
+	return nil
+}
+
+func handleShopBuy(c *Client) error {
+	var pkt ShopBuyPacket
+	if err := c.ReadPacket(&pkt); err != nil {
+		return err
+	}
+	if c.game == nil || c.inventory == nil {
+		return c.Misbehave("item bought outside of a game")
+	}
+	if c.shop == nil || pkt.ShopType != c.shopType {
+		return c.Misbehave(fmt.Sprintf("bought from shop %d without opening it", pkt.ShopType))
+	}
+	inv, item, err := buyShopItem(c.inventory, c.shop, pkt.ItemIndex, pkt.Amount, pkt.ItemId)
+	if err != nil {
+		return c.Misbehave(err.Error())
+	}
+	if err := saveInventory(config.DB(), c.guildcard, uint32(c.config.SlotNum), inv); err != nil {
+		log.Error(err.Error())
+		return err
+	}
+	c.inventory = inv
+	// Everyone, including the buyer, is told about the new item or the
+	// stack that it was added to.
+	data := createItemPacket(c, item)
+	c.game.broadcast(c, data)
+	sendEncrypted(c, data, uint16(len(data)))
+	return nil
+}
+
+func handleShopSell(c *Client) error {
+	var pkt ShopSellPacket
+	if err := c.ReadPacket(&pkt); err != nil {
+		return err
+	}
+	if c.game == nil || c.inventory == nil {
+		return c.Misbehave("item sold outside of a game")
+	}
+	inv, _, err := sellItem(c.inventory, pkt.ItemId, pkt.Amount)
+	if err != nil {
+		return c.Misbehave(err.Error())
+	}
+	if err := saveInventory(config.DB(), c.guildcard, uint32(c.config.SlotNum), inv); err != nil {
+		log.Error(err.Error())
+		return err
+	}
+	c.inventory = inv
+	// The seller's client already knows.
+	c.game.broadcast(c, destroyItemPacket(c, pkt.ItemId, pkt.Amount))
+	return nil
+}
+
+func createItemPacket(c *Client, item ItemData) []byte {
+	data, _ := util.BytesFromStruct(&CreateItemPacket{
+		Header:     BBHeader{Type: GameCommandType},
+		Subcommand: SubCreateItemType,
+		Size:       7,
+		ClientId:   uint16(c.clientId),
+		Item:       item,
+	})
+	return data
+}
+
+func destroyItemPacket(c *Client, id, amount uint32) []byte {
+	data, _ := util.BytesFromStruct(&DestroyItemPacket{
+		Header:     BBHeader{Type: GameCommandType},
+		Subcommand: SubDestroyItemType,
+		Size:       3,
+		ClientId:   uint16(c.clientId),
+		ItemId:     id,
+		Amount:     amount,
+	})
+	return data
+}
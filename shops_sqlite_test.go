@@ -0,0 +1,246 @@
+//go:build sqlite
+// +build sqlite
+
+/*
+* Archon PSO Server
+* Copyright (C) 2014 Andrew Rodman
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"encoding/binary"
+	"github.com/dcrodman/archon/util"
+	"testing"
+)
+
+// Have c send a game command to the server.
+func sendGameCommand(t *testing.T, c *Client, pkt interface{}) {
+	t.Helper()
+	handlePacket(t, c, handleGameCommand, pkt)
+}
+
+func savedInventory(t *testing.T, c *Client) *Inventory {
+	t.Helper()
+	inv, err := loadInventory(config.DB(), c.guildcard, uint32(c.config.SlotNum))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return inv
+}
+
+func TestShopBuyAndSell(t *testing.T) {
+	db := newTestDB(t)
+	saved := itemTable
+	itemTable = loadTestItemTable(t)
+	defer func() { itemTable = saved }()
+
+	buyer, buyerConn := newPipeClient(8)
+	defer buyer.Close()
+	other, otherConn := newPipeClient(8)
+	defer other.Close()
+	buyerPackets, otherPackets := readPackets(buyer, buyerConn), readPackets(other, otherConn)
+
+	insertTestCharacter(t, db, 1, 0, "buyer", 0, 0)
+	buyer.guildcard = 1
+	buyer.character = new(CharacterPreview)
+	// Two Monomates to add to.
+	buyer.inventory = &Inventory{Meseta: 1000, Items: []ItemData{newStack(0x00, 0x00, 2, 0x10000)}}
+	g, _ := NewGame(Episode1, 0, false, nil)
+	g.addPlayer(buyer)
+	g.addPlayer(other)
+
+	sendGameCommand(t, buyer, &ShopRequestPacket{
+		Header:     BBHeader{Type: GameCommandType},
+		Subcommand: SubShopRequestType,
+		Size:       2,
+		ShopType:   ShopTypeTool,
+	})
+	var contents ShopContentsPacket
+	nextPacket(t, buyerPackets, &contents)
+	monomate := contents.Items[0]
+	if contents.NumItems == 0 || itemKind(&monomate) != itemKind(&buyer.inventory.Items[0]) {
+		t.Fatalf("expected the tool shop to start with Monomates, got %x", monomate.Data)
+	}
+	price := binary.LittleEndian.Uint32(monomate.Data2[:])
+
+	sendGameCommand(t, buyer, &ShopBuyPacket{
+		Header:     BBHeader{Type: GameCommandType},
+		Subcommand: SubShopBuyType,
+		Size:       3,
+		ItemId:     0x10001,
+		ShopType:   ShopTypeTool,
+		Amount:     3,
+	})
+	// Both players should be told about the stack the Monomates went on.
+	var created, seen CreateItemPacket
+	nextPacket(t, buyerPackets, &created)
+	nextPacket(t, otherPackets, &seen)
+	if created.Item.Id != 0x10000 || stackCount(&created.Item) != 5 || seen.Item != created.Item {
+		t.Errorf("told the buyer about %+v and the other player about %+v, want the stack of 5",
+			created.Item, seen.Item)
+	}
+	inv := savedInventory(t, buyer)
+	if inv.Meseta != 1000-3*price || len(inv.Items) != 1 || inv.Items[0] != created.Item {
+		t.Errorf("saved %d meseta and %v after buying", inv.Meseta, inv.Items)
+	}
+
+	sendGameCommand(t, buyer, &ShopSellPacket{
+		Header:     BBHeader{Type: GameCommandType},
+		Subcommand: SubShopSellType,
+		Size:       3,
+		ItemId:     0x10000,
+		Amount:     2,
+	})
+	var destroyed DestroyItemPacket
+	nextPacket(t, otherPackets, &destroyed)
+	if destroyed.ItemId != 0x10000 || destroyed.Amount != 2 {
+		t.Errorf("other player was told %+v was sold", destroyed)
+	}
+	base, _ := itemTable.Price(&monomate)
+	earned := 2 * shopSellPrice(base)
+	inv = savedInventory(t, buyer)
+	if inv.Meseta != 1000-3*price+earned || stackCount(&inv.Items[0]) != 3 {
+		t.Errorf("saved %d meseta and %v after selling", inv.Meseta, inv.Items)
+	}
+}
+
+func TestTekker(t *testing.T) {
+	db := newTestDB(t)
+	saved := itemTable
+	itemTable = loadTestItemTable(t)
+	defer func() { itemTable = saved }()
+
+	c, conn := newPipeClient(8)
+	defer c.Close()
+	packets := readPackets(c, conn)
+	insertTestCharacter(t, db, 1, 0, "tekked", 0, 0)
+	c.guildcard = 1
+	c.character = new(CharacterPreview)
+	c.inventory = &Inventory{Meseta: 500, Items: []ItemData{untekkedWeapon(0x10000)}}
+	g, _ := NewGame(Episode1, 0, false, nil)
+	g.addPlayer(c)
+
+	sendGameCommand(t, c, &IdentifyItemPacket{
+		Header:     BBHeader{Type: GameCommandType},
+		Subcommand: SubIdentifyItemType,
+		Size:       2,
+		ItemId:     0x10000,
+	})
+	var result IdentifyResultPacket
+	nextPacket(t, packets, &result)
+	if result.Item.Id != 0x10000 || result.Item.Data[4]&WeaponUntekked != 0 {
+		t.Fatalf("unexpected identify result %+v", result.Item)
+	}
+	if inv := savedInventory(t, c); inv.Meseta != 500-tekkerCost || inv.Items[0] != untekkedWeapon(0x10000) {
+		t.Errorf("saved %d meseta and %v before accepting", inv.Meseta, inv.Items)
+	}
+
+	sendGameCommand(t, c, &IdentifyItemPacket{
+		Header:     BBHeader{Type: GameCommandType},
+		Subcommand: SubAcceptIdentifyType,
+		Size:       2,
+		ItemId:     0x10000,
+	})
+	var created CreateItemPacket
+	nextPacket(t, packets, &created)
+	if created.Item != result.Item {
+		t.Errorf("created %+v after accepting %+v", created.Item, result.Item)
+	}
+	if inv := savedInventory(t, c); inv.Items[0] != result.Item {
+		t.Errorf("saved %v after accepting", inv.Items)
+	}
+}
+
+// A weapon the tekker identified can't be accepted after leaving the game.
+func TestTekkerAfterLeaving(t *testing.T) {
+	db := newTestDB(t)
+	saved := itemTable
+	itemTable = loadTestItemTable(t)
+	defer func() { itemTable = saved }()
+
+	c, conn := newPipeClient(8)
+	defer c.Close()
+	packets := readPackets(c, conn)
+	insertTestCharacter(t, db, 1, 0, "tekked", 0, 0)
+	c.guildcard = 1
+	c.character = new(CharacterPreview)
+	c.inventory = &Inventory{Meseta: 500, Items: []ItemData{untekkedWeapon(0x10000)}}
+	server := &BlockServer{name: "BLOCK1", num: 1}
+	g, _ := NewGame(Episode1, 0, false, nil)
+	server.games.add(g)
+	g.addPlayer(c)
+
+	sendGameCommand(t, c, &IdentifyItemPacket{
+		Header:     BBHeader{Type: GameCommandType},
+		Subcommand: SubIdentifyItemType,
+		Size:       2,
+		ItemId:     0x10000,
+	})
+	nextPacket(t, packets, new(IdentifyResultPacket))
+	server.leaveGame(c)
+	if c.identified != nil {
+		t.Error("expected the identified weapon to be forgotten after leaving the game")
+	}
+}
+
+// Leaving the game closes the shop, and nothing can be bought outside of one.
+func TestShopBuyAfterLeaving(t *testing.T) {
+	newTestDB(t)
+	saved := itemTable
+	itemTable = loadTestItemTable(t)
+	defer func() { itemTable = saved }()
+
+	c, conn := newPipeClient(8)
+	defer c.Close()
+	packets := readPackets(c, conn)
+	c.guildcard = 1
+	c.character = new(CharacterPreview)
+	c.inventory = &Inventory{Meseta: 1000}
+	server := &BlockServer{name: "BLOCK1", num: 1}
+	g, _ := NewGame(Episode1, 0, false, nil)
+	server.games.add(g)
+	g.addPlayer(c)
+
+	sendGameCommand(t, c, &ShopRequestPacket{
+		Header:     BBHeader{Type: GameCommandType},
+		Subcommand: SubShopRequestType,
+		Size:       2,
+		ShopType:   ShopTypeTool,
+	})
+	nextPacket(t, packets, new(ShopContentsPacket))
+	server.leaveGame(c)
+	if c.shop != nil {
+		t.Error("expected the shop to be closed after leaving the game")
+	}
+
+	// Even with a shop still open, buying needs a game.
+	c.shop, c.shopType = []ItemData{newStack(0x00, 0x00, 1, 0)}, ShopTypeTool
+	data, _ := util.BytesFromStruct(&ShopBuyPacket{
+		Header:     BBHeader{Type: GameCommandType},
+		Subcommand: SubShopBuyType,
+		Size:       3,
+		ItemId:     0x10000,
+		ShopType:   ShopTypeTool,
+		Amount:     1,
+	})
+	c.packetSize = uint16(copy(c.buffer, data))
+	if _, ok := handleShopBuy(c).(*droppedPacketError); !ok {
+		t.Error("expected buying outside of a game to be refused")
+	}
+	if c.inventory.Meseta != 1000 || len(c.inventory.Items) != 0 {
+		t.Errorf("inventory changed to %+v", c.inventory)
+	}
+}
@@ -0,0 +1,146 @@
+/*
+* Archon PSO Server
+* Copyright (C) 2014 Andrew Rodman
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"testing"
+)
+
+func TestGenerateShops(t *testing.T) {
+	table := loadTestItemTable(t)
+	rng := rand.New(rand.NewSource(1))
+	for _, shopType := range []uint8{ShopTypeTool, ShopTypeWeapon, ShopTypeArmor} {
+		for difficulty := uint8(0); difficulty < NumDifficulties; difficulty++ {
+			for _, level := range []uint32{0, 40, 120, MaxLevel - 1} {
+				items, err := table.generateShop(shopType, level, difficulty, rng)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(items) == 0 || len(items) > MaxShopItems {
+					t.Fatalf("shop %d has %d items", shopType, len(items))
+				}
+				for i := range items {
+					if err := table.ValidateItem(&items[i]); err != nil {
+						t.Errorf("shop %d sells invalid item %x: %s", shopType, items[i].Data, err)
+					}
+					if table.IsRare(&items[i]) {
+						t.Errorf("shop %d sells rare item %x", shopType, items[i].Data)
+					}
+				}
+			}
+		}
+	}
+	if _, err := table.generateShop(3, 0, 0, rng); err == nil {
+		t.Error("expected an invalid shop type to fail")
+	}
+}
+
+func TestToolShopLevels(t *testing.T) {
+	table := loadTestItemTable(t)
+	rng := rand.New(rand.NewSource(1))
+	sells := func(level uint32, group, index uint8) bool {
+		items, _ := table.generateShop(ShopTypeTool, level, 0, rng)
+		for _, item := range items {
+			if item.Data[1] == group && item.Data[2] == index {
+				return true
+			}
+		}
+		return false
+	}
+	if !sells(0, 0x00, 0x00) || sells(0, 0x00, 0x02) {
+		t.Error("expected new players to be sold Monomates but not Trimates")
+	}
+	if !sells(30, 0x00, 0x02) {
+		t.Error("expected Trimates to be sold at level 31")
+	}
+}
+
+func shopItem(data []uint8, price uint32) ItemData {
+	item := *newItem(data...)
+	binary.LittleEndian.PutUint32(item.Data2[:], price)
+	return item
+}
+
+func TestBuyShopItem(t *testing.T) {
+	shop := []ItemData{
+		shopItem([]uint8{0x03, 0x00, 0x00, 0x00, 0x00, 0x01}, 50),
+		shopItem([]uint8{0x00, 0x01, 0x00}, 200),
+	}
+	inv := &Inventory{Meseta: 500}
+	updated, item, err := buyShopItem(inv, shop, 0, 4, 0x10001)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Meseta != 300 || stackCount(&item) != 4 || item.Id != 0x10001 || item.Data2 != [4]uint8{} {
+		t.Errorf("unexpected purchase %+v leaving %d meseta", item, updated.Meseta)
+	}
+	if inv.Meseta != 500 || len(inv.Items) != 0 {
+		t.Error("buying changed the original inventory")
+	}
+	// More of the same tool goes on the stack, which keeps its id.
+	stacked, item, err := buyShopItem(updated, shop, 0, 2, 0x10002)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.Id != 0x10001 || stackCount(&item) != 6 || len(stacked.Items) != 1 || stacked.Items[0] != item {
+		t.Errorf("bought %+v into the stack, leaving %v", item, stacked.Items)
+	}
+	if _, _, err := buyShopItem(updated, shop, 1, 1, 0x10002); err != nil {
+		t.Error(err)
+	}
+
+	bad := []struct {
+		index, amount uint8
+		meseta        uint32
+	}{
+		{2, 1, 500},  // Not in the shop.
+		{1, 2, 500},  // More than one of an item that doesn't stack.
+		{0, 11, 999}, // More than a stack.
+		{1, 1, 199},  // Not enough meseta.
+	}
+	for _, b := range bad {
+		if _, _, err := buyShopItem(&Inventory{Meseta: b.meseta}, shop, b.index, b.amount, 1); err == nil {
+			t.Errorf("expected buying %d of item %d with %d meseta to fail", b.amount, b.index, b.meseta)
+		}
+	}
+}
+
+func TestSellItem(t *testing.T) {
+	inv := &Inventory{Meseta: 100}
+	inv.addItem(newStack(0x00, 0x01, 5, 1))
+	updated, earned, err := sellItem(inv, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Dimates cost 300 and shops pay an eighth of that.
+	if earned != 2*(300/8) || updated.Meseta != 100+earned || stackCount(&updated.Items[0]) != 3 {
+		t.Errorf("earned %d leaving %d meseta and %v", earned, updated.Meseta, updated.Items)
+	}
+	if _, _, err := sellItem(inv, 2, 1); err == nil {
+		t.Error("expected selling an item the player doesn't have to fail")
+	}
+
+	old := config.ShopSellPricePercent
+	defer func() { config.ShopSellPricePercent = old }()
+	config.ShopSellPricePercent = 50
+	if _, earned, _ := sellItem(inv, 1, 2); earned != 2*(300/8/2) {
+		t.Errorf("earned %d at half price", earned)
+	}
+}
@@ -0,0 +1,170 @@
+/*
+* Archon PSO Server
+* Copyright (C) 2014 Andrew Rodman
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+* ---------------------------------------------------------------------
+* Tekker, who identifies weapons found with their special hidden. The
+* server rolls the changes to the weapon's grind and attributes and charges
+* the player for it, but the weapon in their saved inventory only changes
+* once they accept the result. Anything else leaves it unidentified.
+ */
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// Meseta charged to identify a weapon.
+const tekkerCost = 100
+
+// Changes the tekker can make to a weapon's attribute percentages and grind
+// and the relative chance of each.
+var tekkerRolls = []struct {
+	percent, grind, weight int
+}{
+	{-10, -2, 1},
+	{-5, -1, 2},
+	{0, 0, 4},
+	{5, 1, 2},
+	{10, 2, 1},
+}
+
+// Returns the identified version of the weapon item, with its special
+// revealed and its grind and attributes adjusted.
+func rollTekker(item ItemData, def *WeaponDef, rng *rand.Rand) ItemData {
+	total := 0
+	for _, r := range tekkerRolls {
+		total += r.weight
+	}
+	n := rng.Intn(total)
+	roll := tekkerRolls[0]
+	for _, r := range tekkerRolls {
+		if n < r.weight {
+			roll = r
+			break
+		}
+		n -= r.weight
+	}
+
+	clamp := func(v, min, max int) int {
+		if v < min {
+			return min
+		} else if v > max {
+			return max
+		}
+		return v
+	}
+	d := &item.Data
+	d[3] = uint8(clamp(int(d[3])+roll.grind, 0, int(def.MaxGrind)))
+	for i := 6; i < 12; i += 2 {
+		if d[i] != 0 {
+			// Percentages are signed, and can be anywhere from -100 to 100.
+			d[i+1] = uint8(int8(clamp(int(int8(d[i+1]))+roll.percent, -100, 100)))
+		}
+	}
+	d[4] &^= WeaponUntekked
+	return item
+}
+
+// Returns the inventory after paying the tekker to identify the weapon with
+// id and what the weapon would become.
+func identifyItem(inv *Inventory, id uint32, rng *rand.Rand) (*Inventory, ItemData, error) {
+	i := inv.find(id)
+	if i < 0 {
+		return nil, ItemData{}, fmt.Errorf("no item with id %08x", id)
+	}
+	item := inv.Items[i]
+	if item.Data[0] != ItemClassWeapon || item.Data[4]&WeaponUntekked == 0 {
+		return nil, ItemData{}, fmt.Errorf("item %08x doesn't need to be identified", id)
+	}
+	def, err := itemTable.Weapon(item.Data[1], item.Data[2])
+	if err != nil {
+		return nil, ItemData{}, err
+	}
+	updated := inv.clone()
+	if err := updated.spendMeseta(tekkerCost); err != nil {
+		return nil, ItemData{}, err
+	}
+	return updated, rollTekker(item, def, rng), nil
+}
+
+// Returns the inventory with the unidentified weapon replaced by result.
+func acceptIdentifiedItem(inv *Inventory, result ItemData) (*Inventory, error) {
+	i := inv.find(result.Id)
+	if i < 0 {
+		return nil, fmt.Errorf("no item with id %08x", result.Id)
+	}
+	item := &inv.Items[i]
+	if item.Data[4]&WeaponUntekked == 0 || itemKind(item) != itemKind(&result) {
+		return nil, fmt.Errorf("item %08x isn't the weapon that was identified", result.Id)
+	}
+	updated := inv.clone()
+	updated.Items[i] = result
+	return updated, nil
+}
+
+func handleIdentifyItem(c *Client) error {
+	var pkt IdentifyItemPacket
+	if err := c.ReadPacket(&pkt); err != nil {
+		return err
+	}
+	if c.game == nil || c.inventory == nil {
+		return c.Misbehave("item identified outside of a game")
+	}
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	inv, result, err := identifyItem(c.inventory, pkt.ItemId, rng)
+	if err != nil {
+		return c.Misbehave(err.Error())
+	}
+	if err := saveInventory(config.DB(), c.guildcard, uint32(c.config.SlotNum), inv); err != nil {
+		log.Error(err.Error())
+		return err
+	}
+	c.inventory = inv
+	c.identified = &result
+	c.SendIdentifyResult(result)
+	return nil
+}
+
+func handleAcceptIdentify(c *Client) error {
+	var pkt IdentifyItemPacket
+	if err := c.ReadPacket(&pkt); err != nil {
+		return err
+	}
+	if c.identified == nil || c.identified.Id != pkt.ItemId {
+		return c.Misbehave(fmt.Sprintf("accepted unidentified item %08x", pkt.ItemId))
+	}
+	result := *c.identified
+	c.identified = nil
+	inv, err := acceptIdentifiedItem(c.inventory, result)
+	if err != nil {
+		return c.Misbehave(err.Error())
+	}
+	if err := saveInventory(config.DB(), c.guildcard, uint32(c.config.SlotNum), inv); err != nil {
+		log.Error(err.Error())
+		return err
+	}
+	c.inventory = inv
+	// The client expects the identified weapon to be created like any other
+	// item the server gives it.
+	data := createItemPacket(c, result)
+	if c.game != nil {
+		c.game.broadcast(c, data)
+	}
+	sendEncrypted(c, data, uint16(len(data)))
+	return nil
+}
@@ -0,0 +1,120 @@
+/*
+* Archon PSO Server
+* Copyright (C) 2014 Andrew Rodman
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func untekkedWeapon(id uint32) ItemData {
+	item := *newItem(0x00, 0x01, 0x00, 10, WeaponUntekked|0x01, 0x00, 0x01, 20, 0x03, 5)
+	item.Id = id
+	return item
+}
+
+func TestRollTekker(t *testing.T) {
+	table := loadTestItemTable(t)
+	saber, _ := table.Weapon(0x01, 0x00)
+	rng := rand.New(rand.NewSource(1))
+	item := untekkedWeapon(1)
+	seen := make(map[uint8]bool)
+	for i := 0; i < 200; i++ {
+		result := rollTekker(item, saber, rng)
+		if result.Data[4] != 0x01 {
+			t.Fatalf("special is %02x after identifying, want 01", result.Data[4])
+		}
+		if err := table.ValidateItem(&result); err != nil {
+			t.Fatalf("identified invalid weapon %x: %s", result.Data, err)
+		}
+		// The grind and attributes move together.
+		grindDelta := int(result.Data[3]) - 10
+		if int(result.Data[7])-20 != grindDelta*5 {
+			t.Errorf("grind changed by %d but percentage by %d", grindDelta, int(result.Data[7])-20)
+		}
+		if percent := int8(result.Data[9]); percent < -5 || percent > 15 {
+			t.Errorf("percentage went from 5 to %d", percent)
+		}
+		seen[result.Data[3]] = true
+	}
+	if len(seen) != len(tekkerRolls) {
+		t.Errorf("saw %d different grinds in 200 rolls, want %d", len(seen), len(tekkerRolls))
+	}
+
+	// Negative percentages stay negative rather than being raised to zero,
+	// and stay within -100 to 100.
+	for _, percent := range []int8{-5, -100, 100} {
+		item := untekkedWeapon(1)
+		item.Data[9] = uint8(percent)
+		for i := 0; i < 50; i++ {
+			result := rollTekker(item, saber, rng)
+			got := int(int8(result.Data[9]))
+			if got < int(percent)-10 || got > int(percent)+10 || got < -100 || got > 100 {
+				t.Fatalf("percentage went from %d to %d", percent, got)
+			}
+			if err := table.ValidateItem(&result); err != nil {
+				t.Fatalf("identified invalid weapon %x: %s", result.Data, err)
+			}
+		}
+	}
+
+	// Grinds stay within the weapon's limits.
+	item.Data[3] = saber.MaxGrind
+	for i := 0; i < 50; i++ {
+		if result := rollTekker(item, saber, rng); result.Data[3] > saber.MaxGrind {
+			t.Fatalf("ground past the maximum to %d", result.Data[3])
+		}
+	}
+}
+
+func TestIdentifyItem(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	inv := &Inventory{Meseta: 150, Items: []ItemData{untekkedWeapon(1), *newItem(0x00, 0x01, 0x00)}}
+	inv.Items[1].Id = 2
+
+	updated, result, err := identifyItem(inv, 1, rng)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Meseta != 150-tekkerCost || inv.Meseta != 150 {
+		t.Errorf("have %d meseta after identifying, want %d", updated.Meseta, 150-tekkerCost)
+	}
+	if updated.Items[0] != inv.Items[0] {
+		t.Error("weapon changed before the result was accepted")
+	}
+	accepted, err := acceptIdentifiedItem(updated, result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if accepted.Items[0] != result || updated.Items[0] == result {
+		t.Errorf("unexpected inventory after accepting %v", accepted.Items)
+	}
+	if _, err := acceptIdentifiedItem(accepted, result); err == nil {
+		t.Error("expected accepting an identified weapon twice to fail")
+	}
+
+	if _, _, err := identifyItem(inv, 2, rng); err == nil {
+		t.Error("expected identifying an identified weapon to fail")
+	}
+	if _, _, err := identifyItem(inv, 3, rng); err == nil {
+		t.Error("expected identifying a missing item to fail")
+	}
+	if _, _, err := identifyItem(&Inventory{Meseta: tekkerCost - 1, Items: inv.Items}, 1, rng); err == nil {
+		t.Error("expected identifying without enough meseta to fail")
+	}
+}
@@ -0,0 +1,30 @@
+#!/bin/sh
+# Starts a server backed by a throwaway SQLite database and runs the
+# end-to-end tests in psoclient against it. Run from the repository root;
+# requires the sqlite3 command line tool.
+set -e
+
+workdir=$(mktemp -d)
+trap 'kill $server 2>/dev/null; rm -rf "$workdir"' EXIT
+
+sqlite3 "$workdir/archon.db" < config/archondb_sqlite.sql
+hash=$(printf '%s' e2etest | sha256sum | cut -d' ' -f1)
+sqlite3 "$workdir/archon.db" "INSERT INTO account_data (username, password, is_active) \
+    VALUES ('e2etest', '$hash', 1);"
+
+mkdir -p "$workdir/patches/data"
+echo "patch file" > "$workdir/patches/readme.txt"
+echo "nested patch file" > "$workdir/patches/data/nested.txt"
+
+go build -tags sqlite -o "$workdir/archon" .
+# Every test connects from the same address, so don't rate limit it.
+"$workdir/archon" --config config/server_config.json \
+    --db-driver sqlite3 --db-name "$workdir/archon.db" --ip-connection-rate 0 \
+    --patch-dir "$workdir/patches" --parameters-dir config/parameters \
+    --logfile "$workdir/server.log" > "$workdir/stdout.log" 2>&1 &
+server=$!
+
+ARCHON_E2E_USERNAME=e2etest ARCHON_E2E_PASSWORD=e2etest go test -tags e2e -count 1 ./psoclient || {
+    cat "$workdir/stdout.log" "$workdir/server.log"
+    exit 1
+}
@@ -0,0 +1,539 @@
+/*
+* Archon PSOBB Server
+* Copyright (C) 2014 Andrew Rodman
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+* ---------------------------------------------------------------------
+* Generates BinarySize, MarshalTo and Unmarshal methods for the packet structs
+* so that util.BytesFromStruct and util.StructFromBytes don't have to
+* fall back to reflection. Every struct type in the input files gets the
+* methods. Fields are laid out in order with no padding, the same as
+* encoding/binary; the only variable length fields allowed are slices
+* tagged with `pkt:"rest"`, which must be last and take up the rest of
+* the packet.
+*
+* Run via go generate in the project root (see pkt_defs.go).
+ */
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"log"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	output     = flag.String("o", "pkt_defs_gen.go", "File to write the generated code to")
+	testOutput = flag.String("t", "", "File to write the list of generated types for the tests to")
+)
+
+// Sizes of the fixed size types encoding/binary supports.
+var basicSizes = map[string]int{
+	"uint8": 1, "byte": 1, "int8": 1,
+	"uint16": 2, "int16": 2,
+	"uint32": 4, "int32": 4, "float32": 4,
+	"uint64": 8, "int64": 8, "float64": 8,
+}
+
+// Layout of a field type.
+type fieldType struct {
+	// Name of the named type used in the declaration, if any.
+	named string
+	// Underlying basic type, or the struct name for structs.
+	basic  string
+	strct  string
+	ptr    bool
+	arrLen int
+	slice  bool
+	elem   *fieldType
+}
+
+type field struct {
+	name string
+	typ  *fieldType
+	rest bool
+}
+
+type structDef struct {
+	name   string
+	fields []field
+}
+
+// Everything parsed from the input files.
+type generator struct {
+	pkg     string
+	structs map[string]*structDef
+	order   []string
+	// Non-struct named types mapped to their underlying basic type.
+	aliases map[string]string
+	consts  map[string]int
+	sizes   map[string]int
+	buf     bytes.Buffer
+}
+
+func (g *generator) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&g.buf, format, args...)
+}
+
+func (g *generator) parse(filenames []string) {
+	fset := token.NewFileSet()
+	var typeSpecs []*ast.TypeSpec
+	for _, filename := range filenames {
+		f, err := parser.ParseFile(fset, filename, nil, 0)
+		if err != nil {
+			log.Fatal(err)
+		}
+		g.pkg = f.Name.Name
+		for _, decl := range f.Decls {
+			gen, ok := decl.(*ast.GenDecl)
+			if !ok {
+				continue
+			}
+			for _, spec := range gen.Specs {
+				switch s := spec.(type) {
+				case *ast.TypeSpec:
+					typeSpecs = append(typeSpecs, s)
+				case *ast.ValueSpec:
+					if gen.Tok != token.CONST {
+						continue
+					}
+					for i, name := range s.Names {
+						if i < len(s.Values) {
+							if lit, ok := s.Values[i].(*ast.BasicLit); ok && lit.Kind == token.INT {
+								v, _ := strconv.ParseInt(lit.Value, 0, 64)
+								g.consts[name.Name] = int(v)
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+	for _, spec := range typeSpecs {
+		if ident, ok := spec.Type.(*ast.Ident); ok {
+			g.aliases[spec.Name.Name] = ident.Name
+		}
+	}
+	for _, spec := range typeSpecs {
+		st, ok := spec.Type.(*ast.StructType)
+		if !ok {
+			continue
+		}
+		def := &structDef{name: spec.Name.Name}
+		for i, f := range st.Fields.List {
+			if len(f.Names) == 0 {
+				log.Fatalf("%s: embedded fields aren't supported", def.name)
+			}
+			typ := g.fieldType(def.name, f.Type)
+			rest := false
+			if f.Tag != nil {
+				tag, _ := strconv.Unquote(f.Tag.Value)
+				rest = reflect.StructTag(tag).Get("pkt") == "rest"
+			}
+			if typ.slice && (!rest || i != len(st.Fields.List)-1 || len(f.Names) > 1) {
+				log.Fatalf("%s: slices must be the last field and tagged `pkt:\"rest\"`", def.name)
+			}
+			for _, name := range f.Names {
+				def.fields = append(def.fields, field{name: name.Name, typ: typ, rest: rest})
+			}
+		}
+		g.structs[def.name] = def
+		g.order = append(g.order, def.name)
+	}
+}
+
+func (g *generator) fieldType(structName string, expr ast.Expr) *fieldType {
+	switch t := expr.(type) {
+	case *ast.Ident:
+		if _, ok := basicSizes[t.Name]; ok {
+			return &fieldType{basic: t.Name}
+		}
+		if basic, ok := g.aliases[t.Name]; ok {
+			return &fieldType{named: t.Name, basic: basic}
+		}
+		return &fieldType{strct: t.Name}
+	case *ast.StarExpr:
+		elem := g.fieldType(structName, t.X)
+		if elem.strct == "" {
+			log.Fatalf("%s: only pointers to structs are supported", structName)
+		}
+		elem.ptr = true
+		return elem
+	case *ast.ArrayType:
+		elem := g.fieldType(structName, t.Elt)
+		if elem.ptr || elem.arrLen > 0 || elem.slice {
+			log.Fatalf("%s: unsupported array element type", structName)
+		}
+		if t.Len == nil {
+			return &fieldType{slice: true, elem: elem}
+		}
+		return &fieldType{arrLen: g.arrayLen(structName, t.Len), elem: elem}
+	}
+	log.Fatalf("%s: unsupported field type %T", structName, expr)
+	return nil
+}
+
+func (g *generator) arrayLen(structName string, expr ast.Expr) int {
+	switch l := expr.(type) {
+	case *ast.BasicLit:
+		if v, err := strconv.ParseInt(l.Value, 0, 64); err == nil {
+			return int(v)
+		}
+	case *ast.Ident:
+		if v, ok := g.consts[l.Name]; ok {
+			return v
+		}
+	}
+	log.Fatalf("%s: array lengths must be integer literals or constants", structName)
+	return 0
+}
+
+// Fixed size of a struct, excluding any trailing slice.
+func (g *generator) structSize(name string, seen map[string]bool) int {
+	if size, ok := g.sizes[name]; ok {
+		return size
+	}
+	def, ok := g.structs[name]
+	if !ok {
+		log.Fatalf("unknown type %s", name)
+	}
+	if seen[name] {
+		log.Fatalf("%s is recursive", name)
+	}
+	seen[name] = true
+	size := 0
+	for _, f := range def.fields {
+		if !f.typ.slice {
+			size += g.typeSize(f.typ, seen)
+		}
+	}
+	g.sizes[name] = size
+	return size
+}
+
+func (g *generator) typeSize(t *fieldType, seen map[string]bool) int {
+	switch {
+	case t.arrLen > 0:
+		return t.arrLen * g.typeSize(t.elem, seen)
+	case t.slice:
+		return g.typeSize(t.elem, seen)
+	case t.strct != "":
+		if g.hasSlice(t.strct) {
+			log.Fatalf("%s has a variable length and can't be nested", t.strct)
+		}
+		return g.structSize(t.strct, seen)
+	}
+	return basicSizes[t.basic]
+}
+
+func (g *generator) hasSlice(name string) bool {
+	fields := g.structs[name].fields
+	return len(fields) > 0 && fields[len(fields)-1].typ.slice
+}
+
+// Expression for writing a basic value v at b[off:].
+func putExpr(t *fieldType, v, off string) string {
+	if t.named != "" || t.basic == "int8" || t.basic == "int16" ||
+		t.basic == "int32" || t.basic == "int64" {
+		v = unsignedType(t.basic) + "(" + v + ")"
+	}
+	switch t.basic {
+	case "uint8", "byte", "int8":
+		return fmt.Sprintf("b[%s] = %s", off, v)
+	case "float32":
+		return fmt.Sprintf("binary.LittleEndian.PutUint32(b[%s:], math.Float32bits(%s))", off, v)
+	case "float64":
+		return fmt.Sprintf("binary.LittleEndian.PutUint64(b[%s:], math.Float64bits(%s))", off, v)
+	}
+	return fmt.Sprintf("binary.LittleEndian.Put%s(b[%s:], %s)", strings.Title(unsignedType(t.basic)), off, v)
+}
+
+// Expression for reading a basic value of type t from b[off:].
+func getExpr(t *fieldType, off string) string {
+	var expr string
+	switch t.basic {
+	case "uint8", "byte", "int8":
+		expr = fmt.Sprintf("b[%s]", off)
+	case "float32":
+		return fmt.Sprintf("math.Float32frombits(binary.LittleEndian.Uint32(b[%s:]))", off)
+	case "float64":
+		return fmt.Sprintf("math.Float64frombits(binary.LittleEndian.Uint64(b[%s:]))", off)
+	default:
+		expr = fmt.Sprintf("binary.LittleEndian.%s(b[%s:])", strings.Title(unsignedType(t.basic)), off)
+	}
+	if t.named != "" {
+		return t.named + "(" + expr + ")"
+	} else if t.basic != unsignedType(t.basic) && t.basic != "byte" {
+		return t.basic + "(" + expr + ")"
+	}
+	return expr
+}
+
+func unsignedType(basic string) string {
+	switch basic {
+	case "byte", "int8":
+		return "uint8"
+	case "int16":
+		return "uint16"
+	case "int32":
+		return "uint32"
+	case "int64":
+		return "uint64"
+	}
+	return basic
+}
+
+func isByte(t *fieldType) bool {
+	return t.named == "" && (t.basic == "uint8" || t.basic == "byte")
+}
+
+// Offset expression for element i of an array or slice starting at off.
+func elemOffset(off, size int) string {
+	if off == 0 {
+		return fmt.Sprintf("%d*i", size)
+	}
+	return fmt.Sprintf("%d+%d*i", off, size)
+}
+
+func (g *generator) genStruct(def *structDef) {
+	name := def.name
+	fixed := g.structSize(name, map[string]bool{})
+	var rest *field
+	if g.hasSlice(name) {
+		rest = &def.fields[len(def.fields)-1]
+	}
+	elemSize := 0
+	if rest != nil {
+		elemSize = g.typeSize(rest.typ.elem, map[string]bool{})
+	}
+
+	// Size
+	g.printf("\n// BinarySize returns the number of bytes in the serialized %s.\n", name)
+	g.printf("func (p *%s) BinarySize() int {\n", name)
+	switch {
+	case rest == nil:
+		g.printf("return %d\n", fixed)
+	case elemSize == 1:
+		g.printf("return %d + len(p.%s)\n", fixed, rest.name)
+	default:
+		g.printf("return %d + %d*len(p.%s)\n", fixed, elemSize, rest.name)
+	}
+	g.printf("}\n")
+
+	// MarshalTo
+	g.printf("\n// MarshalTo serializes the %s into b, which must be at least BinarySize()\n", name)
+	g.printf("// bytes long, and returns the number of bytes written.\n")
+	g.printf("func (p *%s) MarshalTo(b []byte) int {\n", name)
+	off := 0
+	for _, f := range def.fields {
+		g.genMarshalField(f, off, elemSize)
+		if !f.typ.slice {
+			off += g.typeSize(f.typ, map[string]bool{})
+		}
+	}
+	if rest == nil {
+		g.printf("return %d\n", fixed)
+	} else {
+		g.printf("return p.BinarySize()\n")
+	}
+	g.printf("}\n")
+
+	// Unmarshal
+	g.printf("\n// Unmarshal populates the %s from b", name)
+	if rest != nil {
+		g.printf(", with %s taking up any bytes\n// after the fixed size fields", rest.name)
+	}
+	g.printf(".\n")
+	g.printf("func (p *%s) Unmarshal(b []byte) error {\n", name)
+	g.printf("if len(b) < %d {\n", fixed)
+	g.printf("return &util.ShortDataError{Size: len(b), Type: %q}\n", name)
+	g.printf("}\n")
+	g.printf("p.unmarshalFrom(b)\n")
+	g.printf("return nil\n")
+	g.printf("}\n")
+
+	g.printf("\nfunc (p *%s) unmarshalFrom(b []byte) {\n", name)
+	off = 0
+	for _, f := range def.fields {
+		g.genUnmarshalField(f, off, fixed, elemSize)
+		if !f.typ.slice {
+			off += g.typeSize(f.typ, map[string]bool{})
+		}
+	}
+	g.printf("}\n")
+}
+
+func (g *generator) genMarshalField(f field, off, restElemSize int) {
+	t := f.typ
+	v := "p." + f.name
+	switch {
+	case t.slice && isByte(t.elem):
+		g.printf("copy(b[%d:], %s)\n", off, v)
+	case t.slice:
+		g.printf("for i := range %s {\n", v)
+		g.genMarshalValue(t.elem, v+"[i]", elemOffset(off, restElemSize))
+		g.printf("}\n")
+	case t.arrLen > 0 && isByte(t.elem):
+		g.printf("copy(b[%d:%d], %s[:])\n", off, off+t.arrLen, v)
+	case t.arrLen > 0:
+		g.printf("for i := range %s {\n", v)
+		g.genMarshalValue(t.elem, v+"[i]", elemOffset(off, g.typeSize(t.elem, map[string]bool{})))
+		g.printf("}\n")
+	default:
+		g.genMarshalValue(t, v, strconv.Itoa(off))
+	}
+}
+
+func (g *generator) genMarshalValue(t *fieldType, v, off string) {
+	if t.strct != "" {
+		g.printf("%s.MarshalTo(b[%s:])\n", v, off)
+	} else {
+		g.printf("%s\n", putExpr(t, v, off))
+	}
+}
+
+func (g *generator) genUnmarshalField(f field, off, fixed, restElemSize int) {
+	t := f.typ
+	v := "p." + f.name
+	switch {
+	case t.slice && isByte(t.elem):
+		g.printf("%s = append(%s[:0], b[%d:]...)\n", v, v, off)
+	case t.slice:
+		g.printf("n := (len(b) - %d) / %d\n", fixed, restElemSize)
+		g.printf("if cap(%s) < n {\n", v)
+		g.printf("%s = make(%s, n)\n", v, g.typeName(t))
+		g.printf("} else {\n")
+		g.printf("%s = %s[:n]\n", v, v)
+		g.printf("}\n")
+		g.printf("for i := range %s {\n", v)
+		g.genUnmarshalValue(t.elem, v+"[i]", elemOffset(off, restElemSize))
+		g.printf("}\n")
+	case t.arrLen > 0 && isByte(t.elem):
+		g.printf("copy(%s[:], b[%d:%d])\n", v, off, off+t.arrLen)
+	case t.arrLen > 0:
+		g.printf("for i := range %s {\n", v)
+		g.genUnmarshalValue(t.elem, v+"[i]", elemOffset(off, g.typeSize(t.elem, map[string]bool{})))
+		g.printf("}\n")
+	case t.ptr:
+		g.printf("if %s == nil {\n", v)
+		g.printf("%s = new(%s)\n", v, t.strct)
+		g.printf("}\n")
+		g.genUnmarshalValue(t, v, strconv.Itoa(off))
+	default:
+		g.genUnmarshalValue(t, v, strconv.Itoa(off))
+	}
+}
+
+func (g *generator) genUnmarshalValue(t *fieldType, v, off string) {
+	if t.strct != "" {
+		g.printf("%s.unmarshalFrom(b[%s:])\n", v, off)
+	} else {
+		g.printf("%s = %s\n", v, getExpr(t, off))
+	}
+}
+
+func (g *generator) typeName(t *fieldType) string {
+	switch {
+	case t.slice:
+		return "[]" + g.typeName(t.elem)
+	case t.strct != "":
+		return t.strct
+	case t.named != "":
+		return t.named
+	}
+	return t.basic
+}
+
+func (g *generator) usesFloats() bool {
+	for _, def := range g.structs {
+		for _, f := range def.fields {
+			t := f.typ
+			if t.elem != nil {
+				t = t.elem
+			}
+			if strings.HasPrefix(t.basic, "float") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func main() {
+	flag.Parse()
+	if flag.NArg() == 0 {
+		fmt.Println("Usage: pktgen.go [-o output] [-t test_output] file.go...")
+		os.Exit(1)
+	}
+	g := &generator{
+		structs: make(map[string]*structDef),
+		aliases: make(map[string]string),
+		consts:  make(map[string]int),
+		sizes:   make(map[string]int),
+	}
+	files := flag.Args()
+	g.parse(files)
+
+	sort.Strings(files)
+	g.printf("// Code generated by tools/pktgen.go from %s; DO NOT EDIT.\n\n", strings.Join(files, ", "))
+	g.printf("package %s\n\n", g.pkg)
+	g.printf("import (\n\"encoding/binary\"\n\"github.com/dcrodman/archon/util\"\n")
+	if g.usesFloats() {
+		g.printf("\"math\"\n")
+	}
+	g.printf(")\n")
+	for _, name := range g.order {
+		g.genStruct(g.structs[name])
+	}
+
+	g.write(*output)
+
+	if *testOutput != "" {
+		// The round trip tests need one of each type, and generating the
+		// list means a new packet can't be left out of them.
+		g.buf.Reset()
+		g.printf("// Code generated by tools/pktgen.go from %s; DO NOT EDIT.\n\n", strings.Join(files, ", "))
+		g.printf("package %s\n\n", g.pkg)
+		g.printf("// One of each of the types in %s.\n", *output)
+		g.printf("func generatedPackets() []generatedPacket {\n")
+		g.printf("return []generatedPacket{\n")
+		for _, name := range g.order {
+			g.printf("new(%s),\n", name)
+		}
+		g.printf("}\n}\n")
+		g.write(*testOutput)
+	}
+}
+
+// Format the generated code and write it to filename.
+func (g *generator) write(filename string) {
+	src, err := format.Source(g.buf.Bytes())
+	if err != nil {
+		log.Fatalf("Generated invalid code: %v\n%s", err, g.buf.String())
+	}
+	if err = ioutil.WriteFile(filename, src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,190 @@
+/*
+* Archon PSOBB Server
+* Copyright (C) 2014 Andrew Rodman
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+* ---------------------------------------------------------------------
+* Replays the client side of packet captures (see capture.go) against a
+* server and reports where the server's responses differ from the ones
+* that were captured. Passwords are removed from captures, so pass the
+* account's password with -password to replay a login.
+*
+* Example: go run tools/replay.go -password pass captures/*_LOGIN_*.jsonl
+ */
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/dcrodman/archon/psoclient"
+	"log"
+	"net"
+	"os"
+	"time"
+)
+
+var (
+	host     = flag.String("host", "127.0.0.1", "Address of the server to replay against")
+	password = flag.String("password", "", "Password to put back into login packets")
+	timeout  = flag.Duration("timeout", 5*time.Second, "Time to wait for each response")
+	verbose  = flag.Bool("v", false, "Print every packet and report differences in contents")
+)
+
+// Subset of the server's CaptureRecord needed for replaying.
+type captureRecord struct {
+	Dir        string `json:"dir"`
+	Server     string `json:"server"`
+	Port       string `json:"port"`
+	HeaderSize int    `json:"header_size"`
+	Version    string `json:"version"`
+	Type       uint16 `json:"type"`
+	Data       string `json:"data"`
+	// Set for the welcome packet, which is handled when connecting.
+	Unencrypted bool `json:"unencrypted"`
+}
+
+func readCapture(filename string) ([]captureRecord, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var records []captureRecord
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0x10000), 0x20000)
+	for scanner.Scan() {
+		var rec captureRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("line %d: %v", len(records)+1, err)
+		}
+		records = append(records, rec)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(records) == 0 || records[0].Dir != "open" {
+		return nil, fmt.Errorf("%s is missing the connection record", filename)
+	}
+	return records, nil
+}
+
+// Put the password back into a login packet; see redactCredentials.
+func restorePassword(data []byte, hdrSize int, pktType uint16) {
+	offset := -1
+	if hdrSize == psoclient.PCHeaderSize && pktType == psoclient.PatchLoginType {
+		offset = 0x20
+	} else if hdrSize == psoclient.BBHeaderSize && pktType == psoclient.LoginType {
+		offset = 0x4C
+	}
+	if offset >= 0 && len(data) >= offset+16 {
+		copy(data[offset:offset+16], make([]byte, 16))
+		copy(data[offset:offset+16], *password)
+	}
+}
+
+// Replay one capture file, returning the number of responses that didn't
+// match what was captured.
+func replay(filename string) (int, error) {
+	records, err := readCapture(filename)
+	if err != nil {
+		return 0, err
+	}
+	open := records[0]
+	if open.Version != "" {
+		return 0, fmt.Errorf("%s: replaying %s captures isn't supported", filename, open.Version)
+	}
+	addr := net.JoinHostPort(*host, open.Port)
+	log.Printf("Replaying %s against %s (%s)", filename, open.Server, addr)
+
+	var c *psoclient.Conn
+	if open.HeaderSize == psoclient.PCHeaderSize {
+		c, err = psoclient.DialPatch(addr, *timeout)
+	} else {
+		c, err = psoclient.DialBB(addr, *timeout)
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer c.Close()
+
+	mismatches := 0
+	for i, rec := range records[1:] {
+		data, err := hex.DecodeString(rec.Data)
+		if err != nil {
+			return mismatches, fmt.Errorf("record %d: %v", i+1, err)
+		}
+		switch rec.Dir {
+		case "in":
+			restorePassword(data, open.HeaderSize, rec.Type)
+			if *verbose {
+				log.Printf("-> %#04x (%d bytes)", rec.Type, len(data))
+			}
+			if err = c.SendBytes(data); err != nil {
+				return mismatches, err
+			}
+		case "out":
+			// Keepalives depend on timing, so don't expect them to line up.
+			if rec.Type == psoclient.PingType || rec.Unencrypted {
+				continue
+			}
+			pkt, err := c.Recv()
+			for err == nil && pkt.Type == psoclient.PingType {
+				pkt, err = c.Recv()
+			}
+			if err != nil {
+				log.Printf("Expected %#04x, got error: %v", rec.Type, err)
+				return mismatches + 1, nil
+			}
+			if *verbose {
+				log.Printf("<- %#04x (%d bytes)", pkt.Type, len(pkt.Data))
+			}
+			if pkt.Type != rec.Type {
+				log.Printf("Expected %#04x, got %#04x", rec.Type, pkt.Type)
+				mismatches++
+			} else if *verbose && !bytes.Equal(pkt.Data, data) {
+				log.Printf("Contents of %#04x differ:\ncaptured: %x\nreceived: %x",
+					pkt.Type, data, pkt.Data)
+			}
+		}
+	}
+	return mismatches, nil
+}
+
+func main() {
+	flag.Parse()
+	if flag.NArg() == 0 {
+		fmt.Println("Usage: replay.go [flags] capture_file...")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+	failed := false
+	for _, filename := range flag.Args() {
+		mismatches, err := replay(filename)
+		if err != nil {
+			log.Printf("Error replaying %s: %v", filename, err)
+			failed = true
+		} else if mismatches > 0 {
+			log.Printf("%s: %d responses differed from the capture", filename, mismatches)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
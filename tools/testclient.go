@@ -0,0 +1,118 @@
+/*
+* Archon PSOBB Server
+* Copyright (C) 2014 Andrew Rodman
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+* ---------------------------------------------------------------------
+* Headless client that runs through the same flow as the game client:
+* patch check, login, character selection (creating one if needed),
+* ship and block selection. Exits non-zero if any step fails.
+*
+* Example: go run tools/testclient.go -username test -password pass
+ */
+package main
+
+import (
+	"flag"
+	"github.com/dcrodman/archon/psoclient"
+	"github.com/dcrodman/archon/util"
+	"log"
+	"net"
+	"time"
+)
+
+var (
+	host      = flag.String("host", "127.0.0.1", "Address of the server")
+	patchPort = flag.String("patch-port", "11000", "Patch server port")
+	loginPort = flag.String("login-port", "12000", "Login server port")
+	username  = flag.String("username", "", "Account username")
+	password  = flag.String("password", "", "Account password")
+	slot      = flag.Int("slot", 0, "Character slot to select (0-3)")
+	create    = flag.Bool("create", false, "Create a new character in the slot even if one exists")
+	name      = flag.String("name", "Tester", "Name for a created character")
+	class     = flag.Int("class", 0, "Class for a created character (0-11)")
+	block     = flag.Int("block", 1, "Block to join")
+	skipPatch = flag.Bool("skip-patch", false, "Skip the patch check")
+	timeout   = flag.Duration("timeout", psoclient.DefaultTimeout, "Time to wait for each response")
+)
+
+func main() {
+	flag.Parse()
+	if *username == "" || *password == "" {
+		log.Fatalf("Missing required --username and --password parameters")
+	}
+	if *slot < 0 || *slot > 3 {
+		log.Fatalf("Slot must be between 0 and 3")
+	}
+	s := psoclient.NewSession(*username, *password)
+	s.Timeout = *timeout
+
+	if !*skipPatch {
+		result, err := s.Patch(net.JoinHostPort(*host, *patchPort))
+		if err != nil {
+			log.Fatalf("Patch check failed: %v", err)
+		}
+		log.Printf("Patch check: %d files checked, %d updated; message: %q",
+			len(result.Checked), len(result.Updated), result.Message)
+	}
+
+	if err := s.Login(net.JoinHostPort(*host, *loginPort)); err != nil {
+		log.Fatalf("Login failed: %v", err)
+	}
+	log.Printf("Logged in with guildcard %d, redirected to %s", s.Guildcard, s.Next)
+
+	c, err := s.CharacterLogin()
+	if err != nil {
+		log.Fatalf("Character server login failed: %v", err)
+	}
+	if err = s.LoadCharacters(c); err != nil {
+		log.Fatalf("Loading characters failed: %v", err)
+	}
+	for i, char := range s.Characters {
+		if char != nil {
+			log.Printf("Slot %d: %s (class %d, level %d)", i,
+				char.DisplayName(), char.Class, char.Level+1)
+		}
+	}
+	if *create || s.Characters[*slot] == nil {
+		char := &psoclient.CharacterPreview{Class: byte(*class), PropX: 0.5, PropY: 0.5}
+		copy(char.Name[:], util.ConvertToUtf16("\tE"+*name))
+		if err = s.CreateCharacter(c, *slot, char); err != nil {
+			log.Fatalf("Creating character failed: %v", err)
+		}
+		log.Printf("Created character %s in slot %d", *name, *slot)
+	}
+	if err = s.SelectCharacter(c, *slot); err != nil {
+		log.Fatalf("Selecting character failed: %v", err)
+	}
+	c.Close()
+	log.Printf("Selected slot %d; received %d parameter files", *slot, len(s.ParamEntries))
+
+	start := time.Now()
+	if err = s.ShipSelect(*slot); err != nil {
+		log.Fatalf("Ship selection failed: %v", err)
+	}
+	log.Printf("Selected ship %d of %d, redirected to %s", s.Ships[0].ShipId, len(s.Ships), s.Next)
+	if err = s.SelectBlock(*slot, uint32(*block)); err != nil {
+		log.Fatalf("Block selection failed: %v", err)
+	}
+	log.Printf("Selected block %d, redirected to %s", *block, s.Next)
+	c, err = s.JoinBlock(*slot)
+	if err != nil {
+		log.Fatalf("Joining block failed: %v", err)
+	}
+	c.Close()
+	log.Printf("Joined block with %d lobbies available (%v from ship select)",
+		len(s.Lobbies), time.Since(start))
+}
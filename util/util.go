@@ -32,6 +32,31 @@ import (
 
 const displayWidth = 16
 
+// Implemented by packet structs that can serialize themselves without
+// going through reflection (see tools/pktgen.go).
+type Marshaler interface {
+	// Number of bytes the serialized struct takes up.
+	BinarySize() int
+	// Serialize the struct into b and return the number of bytes written.
+	MarshalTo(b []byte) int
+}
+
+// Implemented by packet structs that can populate themselves from a
+// stream of bytes without going through reflection.
+type Unmarshaler interface {
+	Unmarshal(b []byte) error
+}
+
+// Returned when there isn't enough data to fill a struct.
+type ShortDataError struct {
+	Size int
+	Type string
+}
+
+func (e *ShortDataError) Error() string {
+	return fmt.Sprintf("StructFromBytes(): %d bytes is too short for %s", e.Size, e.Type)
+}
+
 // Extract the packet length from the first two bytes of data.
 func GetPacketSize(data []byte) (uint16, error) {
 	if len(data) < 2 {
@@ -61,6 +86,20 @@ func ConvertToUtf16(str string) []byte {
 	return ExpandUtf16(utf16.Encode(strRunes))
 }
 
+// Convert UTF-16 LE bytes to a UTF-8 string, stopping at the first null
+// character if there is one.
+func ConvertFromUtf16(b []byte) string {
+	chars := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		c := uint16(b[i]) | uint16(b[i+1])<<8
+		if c == 0 {
+			break
+		}
+		chars = append(chars, c)
+	}
+	return string(utf16.Decode(chars))
+}
+
 // Returns a slice of b without the trailing 0s.
 func StripPadding(b []byte) []byte {
 	for i := len(b) - 1; i >= 0; i-- {
@@ -83,8 +122,13 @@ func ZeroSlice(arr []byte, length int) {
 
 // Serializes the fields of a struct to an array of bytes in the order in
 // which the fields are declared. Calls panic() if data is not a struct or
-// pointer to struct, or if there was an error writing a field.
+// pointer to struct, or if there was an error writing a field. Structs that
+// implement Marshaler serialize themselves.
 func BytesFromStruct(data interface{}) ([]byte, int) {
+	if m, ok := data.(Marshaler); ok {
+		b := make([]byte, m.BinarySize())
+		return b, m.MarshalTo(b)
+	}
 	val := reflect.ValueOf(data)
 	valKind := val.Kind()
 	if valKind == reflect.Ptr {
@@ -119,8 +163,13 @@ func BytesFromStruct(data interface{}) ([]byte, int) {
 }
 
 // Populates the struct pointed to by targetStruct by reading in a stream of
-// bytes and filling the values in sequential order.
-func StructFromBytes(data []byte, targetStruct interface{}) {
+// bytes and filling the values in sequential order. Returns an error if data
+// isn't long enough to fill all of the fixed-size fields. Structs that
+// implement Unmarshaler populate themselves.
+func StructFromBytes(data []byte, targetStruct interface{}) error {
+	if u, ok := targetStruct.(Unmarshaler); ok {
+		return u.Unmarshal(data)
+	}
 	targetVal := reflect.ValueOf(targetStruct)
 	if valKind := targetVal.Kind(); valKind != reflect.Ptr {
 		panic("StructFromBytes(): targetStruct must be a " +
@@ -139,9 +188,10 @@ func StructFromBytes(data []byte, targetStruct interface{}) {
 			err = binary.Read(reader, binary.LittleEndian, field.Addr().Interface())
 		}
 		if err != nil {
-			panic(err.Error())
+			return &ShortDataError{Size: len(data), Type: val.Type().Name()}
 		}
 	}
+	return nil
 }
 
 // Write one line of data to stdout.
@@ -0,0 +1,113 @@
+/*
+* Archon PSO Server
+* Copyright (C) 2014 Andrew Rodman
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package util
+
+import (
+	"bytes"
+	"testing"
+)
+
+type testHeader struct {
+	Size  uint16
+	Type  uint16
+	Flags uint32
+}
+
+type testInner struct {
+	A uint8
+	B [3]uint16
+}
+
+// Mix of the kinds of fields found in the packet definitions.
+type testPacket struct {
+	Header  testHeader
+	Int     int8
+	Float   float32
+	Array   [5]byte
+	Inner   testInner
+	Pointer *testInner
+	Data    []byte
+}
+
+func TestStructRoundTrip(t *testing.T) {
+	pkt := &testPacket{
+		Header:  testHeader{Size: 0x20, Type: 0x93, Flags: 7},
+		Int:     -3,
+		Float:   1.5,
+		Array:   [5]byte{1, 2, 3, 4, 5},
+		Inner:   testInner{A: 9, B: [3]uint16{10, 11, 12}},
+		Pointer: &testInner{A: 13, B: [3]uint16{14, 15, 16}},
+	}
+	data, size := BytesFromStruct(pkt)
+	if size != len(data) {
+		t.Fatalf("size %d doesn't match length %d", size, len(data))
+	}
+	out := &testPacket{Pointer: new(testInner)}
+	if err := StructFromBytes(data, out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Header != pkt.Header || out.Int != pkt.Int || out.Float != pkt.Float ||
+		out.Array != pkt.Array || out.Inner != pkt.Inner || *out.Pointer != *pkt.Pointer {
+		t.Errorf("round trip mismatch: got %+v, expected %+v", out, pkt)
+	}
+}
+
+func TestStructFromBytesShort(t *testing.T) {
+	var hdr testHeader
+	if err := StructFromBytes([]byte{1, 2, 3}, &hdr); err == nil {
+		t.Error("expected an error for a truncated struct")
+	}
+}
+
+func TestUtf16RoundTrip(t *testing.T) {
+	for _, str := range []string{"", "Archon", "\tEキャラ"} {
+		b := append(ConvertToUtf16(str), 0, 0, 'x', 0)
+		if out := ConvertFromUtf16(b); out != str {
+			t.Errorf("got %q, expected %q", out, str)
+		}
+	}
+}
+
+func FuzzStructFromBytes(f *testing.F) {
+	f.Add([]byte{})
+	f.Add(bytes.Repeat([]byte{0xFF}, 64))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var hdr testHeader
+		if err := StructFromBytes(data, &hdr); err == nil && len(data) < 8 {
+			t.Errorf("parsed an 8 byte header from %d bytes", len(data))
+		}
+		pkt := &testPacket{Pointer: new(testInner)}
+		if err := StructFromBytes(data, pkt); err == nil {
+			// Anything we parsed successfully should serialize to what we read.
+			out, size := BytesFromStruct(pkt)
+			if size > len(data) || !bytes.Equal(out, data[:size]) {
+				t.Errorf("re-serialized packet doesn't match input")
+			}
+		}
+	})
+}
+
+func FuzzGetPacketSize(f *testing.F) {
+	f.Add([]byte{0x04, 0x00})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		size, err := GetPacketSize(data)
+		if err == nil && size != uint16(data[0])|uint16(data[1])<<8 {
+			t.Errorf("got size %d from %v", size, data[:2])
+		}
+	})
+}
@@ -0,0 +1,84 @@
+/*
+* Archon PSO Server
+* Copyright (C) 2014 Andrew Rodman
+*
+* This program is free software: you can redistribute it and/or modify
+* it under the terms of the GNU General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* This program is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+* GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License
+* along with this program.  If not, see <http://www.gnu.org/licenses/>.
+* ---------------------------------------------------------------------
+* Versions of the game client that can connect to the server and the
+* differences between them that the connection handling cares about.
+ */
+package main
+
+import (
+	"errors"
+	"strings"
+)
+
+type ClientVersion uint8
+
+const (
+	VersionBB ClientVersion = iota
+	VersionPC
+	VersionDC
+	VersionGC
+	VersionEp3
+)
+
+var versionNames = [...]string{"BB", "PC", "DC", "GC", "EP3"}
+
+// Gamecube clients report a sub-version of at least this for Episode III.
+const ep3SubVersion = 0x40
+
+func (v ClientVersion) String() string {
+	if int(v) < len(versionNames) {
+		return versionNames[v]
+	}
+	return "UNKNOWN"
+}
+
+// Returns the version named by name, ignoring case.
+func parseClientVersion(name string) (ClientVersion, error) {
+	for i, n := range versionNames {
+		if strings.EqualFold(n, strings.TrimSpace(name)) {
+			return ClientVersion(i), nil
+		}
+	}
+	return 0, errors.New("Unknown client version: " + name)
+}
+
+// Returns the offset of the packet size in the version's header. Dreamcast
+// and Gamecube headers put it after the type and flags.
+func (v ClientVersion) sizeOffset() int {
+	if v.isGC() || v == VersionDC {
+		return 2
+	}
+	return 0
+}
+
+// Gamecube and Episode III clients share servers, headers, and encryption.
+func (v ClientVersion) isGC() bool {
+	return v == VersionGC || v == VersionEp3
+}
+
+// Returns the version the client is actually running based on the sub-version
+// it sent in its login packet, given the version of the server it's on.
+func detectVersion(serverVersion ClientVersion, subVersion uint32) ClientVersion {
+	if serverVersion.isGC() {
+		if subVersion >= ep3SubVersion {
+			return VersionEp3
+		}
+		return VersionGC
+	}
+	return serverVersion
+}